@@ -1,28 +1,315 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
+	_ "modernc.org/sqlite"
+	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"receipt-processor/internal/domain/receipt"
+	"receipt-processor/internal/infrastructure/database/boltdb"
+	boltrepository "receipt-processor/internal/infrastructure/database/boltdb/repository"
 	"receipt-processor/internal/infrastructure/database/memdb"
 	"receipt-processor/internal/infrastructure/database/memdb/repository"
+	"receipt-processor/internal/infrastructure/database/sqldb"
+	sqlrepository "receipt-processor/internal/infrastructure/database/sqldb/repository"
 	"receipt-processor/internal/infrastructure/httpserver"
 	"receipt-processor/internal/infrastructure/httpserver/handler"
+	"strconv"
+	"syscall"
+	"time"
 )
 
 func main() {
-	db := memdb.New()
-	receiptRepo := repository.NewReceiptRepository(db)
-	receiptService := receipt.NewService(receiptRepo)
+	dbs := openDBs()
+	receiptRepo := dbs.receiptRepository()
+	idempotencyRepo := repository.NewIdempotencyRepository(dbs.idempotency, durationFromEnv("IDEMPOTENCY_RETENTION", 24*time.Hour))
+	rescoreJobRepo := repository.NewRescoreJobRepository(dbs.rescoreJobs)
+	campaignRepo := repository.NewCampaignRepository(dbs.campaigns)
+	loadHolidayCalendars()
+	rulesets := loadRulesets()
+	defaultZone, retailerZones := loadTimeZones()
+
+	receiptService := receipt.NewService(
+		receiptRepo,
+		receipt.WithRulesets(rulesets),
+		receipt.WithBatchLimits(intFromEnv("BATCH_MAX_SIZE"), intFromEnv("BATCH_WORKERS")),
+		receipt.WithIdempotencyStore(idempotencyRepo),
+		receipt.WithRescoreJobs(rescoreJobRepo),
+		receipt.WithCampaigns(campaignRepo),
+		receipt.WithTimeZones(defaultZone, retailerZones),
+	)
+	if err := receiptService.ResumeRescoreJobs(context.Background()); err != nil {
+		log.Fatalf("resuming rescore jobs: %v", err)
+	}
 	receiptHandler := handler.NewReceiptHandler(receiptService)
+	adminHandler := handler.NewAdminHandler(receiptService)
+	campaignHandler := handler.NewCampaignHandler(receiptService)
 
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8084"
 	}
 
-	server := httpserver.NewServer(receiptHandler)
+	server := httpserver.NewServer(receiptHandler, adminHandler, campaignHandler).HTTPServer(":" + port)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		log.Printf("Server starting on %s...", port)
+		if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+
+	<-ctx.Done()
+	stop()
+	log.Printf("Shutting down")
+
+	// Stop taking requests and let those in flight finish, then stop the
+	// rescore jobs, and only then close the databases they write to.
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), durationFromEnv("SHUTDOWN_TIMEOUT", 30*time.Second))
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Stopping the server: %v", err)
+	}
+	if err := receiptService.StopRescoreJobs(shutdownCtx); err != nil {
+		log.Printf("Stopping rescore jobs: %v", err)
+	}
+	if err := dbs.close(); err != nil {
+		log.Fatalf("closing databases: %v", err)
+	}
+}
+
+type databases struct {
+	receipts    *memdb.DB[string, *receipt.Receipt]
+	sql         *sqldb.DB
+	bolt        *boltdb.DB
+	idempotency *memdb.DB[string, *receipt.IdempotencyRecord]
+	rescoreJobs *memdb.DB[string, *receipt.RescoreJob]
+	campaigns   *memdb.DB[string, *receipt.Campaign]
+}
+
+// openDBs opens the databases, which are kept in subdirectories of
+// DATA_DIR if it is set, or else in memory only. With STORAGE=sqlite the
+// receipts are kept in the SQLite database at SQLITE_PATH instead, and with
+// STORAGE=bolt in the data file at BOLT_PATH; the other databases then
+// need DATA_DIR, since receipts that outlive a restart would otherwise
+// lose the campaigns, rescore jobs and idempotency keys they go with.
+func openDBs() *databases {
+	storage := os.Getenv("STORAGE")
+	if (storage == "sqlite" || storage == "bolt") && os.Getenv("DATA_DIR") == "" {
+		log.Fatalf("DATA_DIR: must be set with STORAGE=%s, to keep idempotency keys, rescore jobs and campaigns", storage)
+	}
+
+	dbs := &databases{
+		idempotency: openMemDB("idempotency", repository.IdempotencyCodec),
+		rescoreJobs: openMemDB("rescore-jobs", repository.RescoreJobCodec),
+		campaigns:   openMemDB("campaigns", repository.CampaignCodec),
+	}
+	switch storage {
+	case "", "memory":
+		dbs.receipts = openMemDB("receipts", repository.ReceiptCodec)
+	case "sqlite":
+		dbs.sql = openSQLite()
+	case "bolt":
+		dbs.bolt = openBolt()
+	default:
+		log.Fatalf("STORAGE: unknown storage %q, expected memory, sqlite or bolt", storage)
+	}
+	return dbs
+}
+
+// openMemDB opens the memdb database kept in the name subdirectory of
+// DATA_DIR, or an in-memory one if DATA_DIR is not set.
+func openMemDB[V any](name string, codec memdb.Codec[V]) *memdb.DB[string, V] {
+	dir := os.Getenv("DATA_DIR")
+	if dir == "" {
+		db, _ := memdb.New[string, V]()
+		return db
+	}
+
+	opts := []memdb.Option{memdb.WithPersistence(filepath.Join(dir, name), codec)}
+	if mode := os.Getenv("MEMDB_SYNC"); mode != "" {
+		syncMode, err := memdb.ParseSyncMode(mode)
+		if err != nil {
+			log.Fatalf("MEMDB_SYNC: %v", err)
+		}
+		opts = append(opts, memdb.WithSync(syncMode, durationFromEnv("MEMDB_SYNC_INTERVAL", memdb.DefaultSyncInterval)))
+	}
+	if os.Getenv("MEMDB_SNAPSHOT_EVERY") != "" {
+		opts = append(opts, memdb.WithSnapshotEvery(intFromEnv("MEMDB_SNAPSHOT_EVERY")))
+	}
+
+	db, err := memdb.New[string, V](opts...)
+	if err != nil {
+		log.Fatalf("opening %s database: %v", name, err)
+	}
+	recovery := db.Recovery()
+	if recovery.SnapshotEntries+recovery.LogRecords > 0 {
+		log.Printf("Opened %s database: %d snapshot entries, %d log records, closed cleanly: %t", name, recovery.SnapshotEntries, recovery.LogRecords, recovery.Sealed)
+	}
+	if recovery.TruncatedBytes > 0 {
+		log.Printf("Truncated %d bytes of torn records from the %s log", recovery.TruncatedBytes, name)
+	}
+	return db
+}
 
-	log.Printf("Server starting on %s...", port)
-	log.Fatal(server.Start(":" + port))
+// openSQLite opens the SQLite database at SQLITE_PATH, or receipts.db, and
+// brings its schema up to date.
+func openSQLite() *sqldb.DB {
+	path := os.Getenv("SQLITE_PATH")
+	if path == "" {
+		path = "receipts.db"
+	}
+	db, err := sqldb.Open("sqlite", "file:"+path+"?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		log.Fatalf("opening SQLite database %s: %v", path, err)
+	}
+	applied, err := db.Migrate(context.Background())
+	if err != nil {
+		log.Fatalf("migrating SQLite database %s: %v", path, err)
+	}
+	for _, name := range applied {
+		log.Printf("Applied migration %s", name)
+	}
+	return db
+}
+
+// openBolt opens the data file at BOLT_PATH, or receipts.bolt.
+func openBolt() *boltdb.DB {
+	path := os.Getenv("BOLT_PATH")
+	if path == "" {
+		path = "receipts.bolt"
+	}
+	db, err := boltdb.Open(path)
+	if err != nil {
+		log.Fatalf("opening data file %s: %v", path, err)
+	}
+	return db
+}
+
+// receiptRepository returns the repository of the database that keeps the
+// receipts.
+func (d *databases) receiptRepository() receipt.Repository {
+	switch {
+	case d.sql != nil:
+		return sqlrepository.NewReceiptRepository(d.sql)
+	case d.bolt != nil:
+		repo, err := boltrepository.NewReceiptRepository(d.bolt)
+		if err != nil {
+			log.Fatalf("preparing data file: %v", err)
+		}
+		return repo
+	}
+	return repository.NewReceiptRepository(d.receipts)
+}
+
+// close syncs and seals the databases. The idempotency keys close last, so
+// that a request still running after the shutdown timeout cannot create a
+// receipt and then fail to record it under its key: creating the receipt
+// fails instead, and the key is released.
+func (d *databases) close() error {
+	var errs []error
+	switch {
+	case d.sql != nil:
+		errs = append(errs, d.sql.Close())
+	case d.bolt != nil:
+		errs = append(errs, d.bolt.Close())
+	default:
+		errs = append(errs, d.receipts.Close())
+	}
+	errs = append(errs, d.campaigns.Close(), d.rescoreJobs.Close(), d.idempotency.Close())
+	return errors.Join(errs...)
+}
+
+// loadHolidayCalendars makes the holiday calendars in HOLIDAYS_FILE
+// available to the rules, if it is set.
+func loadHolidayCalendars() {
+	path := os.Getenv("HOLIDAYS_FILE")
+	if path == "" {
+		return
+	}
+	calendars, err := receipt.LoadHolidayCalendars(path)
+	if err != nil {
+		log.Fatalf("loading holiday calendars: %v", err)
+	}
+	receipt.SetHolidayCalendars(calendars)
+	log.Printf("Loaded holiday calendars %v", calendars.Names())
+}
+
+// loadTimeZones returns the time zone of receipts that do not give their
+// own, TIME_ZONE or UTC, and the zones of the retailers in
+// RETAILER_TIME_ZONES.
+func loadTimeZones() (*time.Location, map[string]*time.Location) {
+	defaultZone := time.UTC
+	if name := os.Getenv("TIME_ZONE"); name != "" {
+		zone, err := receipt.ParseTimeZone(name)
+		if err != nil {
+			log.Fatalf("TIME_ZONE: %v", err)
+		}
+		defaultZone = zone
+	}
+	retailerZones, err := receipt.ParseRetailerTimeZones(os.Getenv("RETAILER_TIME_ZONES"))
+	if err != nil {
+		log.Fatalf("RETAILER_TIME_ZONES: %v", err)
+	}
+	return defaultZone, retailerZones
+}
+
+// loadRulesets loads the ruleset versions from RULESET_DIR, or the single
+// version in RULESET_FILE, falling back to the built-in rules.
+func loadRulesets() *receipt.RulesetRegistry {
+	var rulesets *receipt.RulesetRegistry
+	var err error
+	switch {
+	case os.Getenv("RULESET_DIR") != "":
+		rulesets, err = receipt.LoadRulesetRegistry(os.Getenv("RULESET_DIR"))
+	case os.Getenv("RULESET_FILE") != "":
+		var ruleset *receipt.Ruleset
+		if ruleset, err = receipt.LoadRuleset(os.Getenv("RULESET_FILE")); err == nil {
+			rulesets, err = receipt.NewRulesetRegistry(ruleset)
+		}
+	default:
+		rulesets, err = receipt.NewRulesetRegistry(receipt.DefaultRulesets()...)
+	}
+	if err != nil {
+		log.Fatalf("loading rulesets: %v", err)
+	}
+
+	for _, ruleset := range rulesets.List() {
+		log.Printf("Loaded ruleset %s with %d rules, effective from %s", ruleset.Version, len(ruleset.Rules), ruleset.EffectiveFrom.Format(time.RFC3339))
+	}
+	return rulesets
+}
+
+// intFromEnv returns the integer value of an environment variable, or 0 if
+// it is not set.
+func intFromEnv(name string) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return 0
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		log.Fatalf("%s: %v", name, err)
+	}
+	return i
+}
+
+// durationFromEnv returns the duration value of an environment variable, or
+// fallback if it is not set.
+func durationFromEnv(name string, fallback time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Fatalf("%s: %v", name, err)
+	}
+	return d
 }
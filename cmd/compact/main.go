@@ -0,0 +1,35 @@
+// Command compact rewrites a receipt data file kept with STORAGE=bolt to
+// give back the space that deleted and updated receipts leave free. The
+// service must be stopped first.
+//
+//	compact [-tx-size bytes] <data file>
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"receipt-processor/internal/infrastructure/database/boltdb"
+)
+
+func main() {
+	log.SetFlags(0)
+	txSize := flag.Int64("tx-size", boltdb.DefaultCompactTxSize, "how many bytes to copy per transaction")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [-tx-size bytes] <data file>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 || *txSize <= 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	path := flag.Arg(0)
+	compaction, err := boltdb.Compact(path, *txSize)
+	if err != nil {
+		log.Fatalf("compact: %v", err)
+	}
+	log.Printf("Compacted %s from %d to %d bytes", path, compaction.SizeBefore, compaction.SizeAfter)
+}
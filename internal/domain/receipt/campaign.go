@@ -0,0 +1,208 @@
+package receipt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Campaign is a promotion awarding extra points to receipts purchased
+// between StartsAt (inclusive) and EndsAt (exclusive), optionally only at
+// some retailers. A campaign awards the points of its bonus rules and, when
+// Multiplier is set, multiplies the points awarded by the ruleset's rules.
+type Campaign struct {
+	Id uuid.UUID
+	// Revision counts the versions of the campaign, starting at 1, so that
+	// a receipt records which one applied to it.
+	Revision int64
+	Name     string
+	StartsAt time.Time
+	EndsAt   time.Time
+	// Retailers limits the campaign to receipts from these retailers,
+	// compared ignoring case. An empty list targets every retailer.
+	Retailers  []string
+	Rules      []RuleSpec
+	Multiplier decimal.Decimal
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+// CampaignRepository stores campaigns.
+type CampaignRepository interface {
+	Create(campaign *Campaign) error
+	Get(id string) (*Campaign, error)
+	// Update replaces the stored campaign, which must still be at
+	// revision, or Update fails with ErrConflict.
+	Update(campaign *Campaign, revision int64) error
+	Delete(id string) error
+	// List returns every campaign, ordered by start time.
+	List() ([]*Campaign, error)
+}
+
+// WithCampaigns enables campaigns, which are stored in campaigns.
+func WithCampaigns(campaigns CampaignRepository) Option {
+	return func(s *Service) {
+		s.campaigns = campaigns
+	}
+}
+
+// Validate checks the campaign and its bonus rules.
+func (c *Campaign) Validate() error {
+	var errs []error
+	if strings.TrimSpace(c.Name) == "" {
+		errs = append(errs, errors.New("name is required"))
+	}
+	if !c.StartsAt.Before(c.EndsAt) {
+		errs = append(errs, errors.New("startsAt must be earlier than endsAt"))
+	}
+	if len(c.Rules) == 0 && c.Multiplier.IsZero() {
+		errs = append(errs, errors.New("a campaign needs at least one rule or a multiplier"))
+	}
+	if c.Multiplier.IsNegative() {
+		errs = append(errs, fmt.Errorf("multiplier must be positive, got %s", c.Multiplier))
+	}
+	if _, err := c.PointRules(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("%w: campaign: %w", ErrInvalidInput, err)
+	}
+	return nil
+}
+
+// PointRules builds the campaign's bonus rules.
+func (c *Campaign) PointRules() ([]PointRule, error) {
+	rules := make([]PointRule, len(c.Rules))
+	for i, spec := range c.Rules {
+		rule, err := NewRule(spec)
+		if err != nil {
+			return nil, fmt.Errorf("rules[%d]: %w", i, err)
+		}
+		rules[i] = rule
+	}
+	if err := validateStacking(c.Rules, rules); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// AppliesTo reports whether the receipt was purchased during the campaign
+// at a retailer it targets.
+func (c *Campaign) AppliesTo(receipt *Receipt) bool {
+	if receipt.PurchaseDateTime.Before(c.StartsAt) || !receipt.PurchaseDateTime.Before(c.EndsAt) {
+		return false
+	}
+	if len(c.Retailers) == 0 {
+		return true
+	}
+	for _, retailer := range c.Retailers {
+		if strings.EqualFold(strings.TrimSpace(retailer), strings.TrimSpace(receipt.Retailer)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Service) CreateCampaign(ctx context.Context, campaignDTO CampaignDTO) (*Campaign, error) {
+	if s.campaigns == nil {
+		return nil, fmt.Errorf("%w: campaigns are not enabled", ErrUnavailable)
+	}
+
+	campaign, err := campaignDTO.ToCampaign()
+	if err != nil {
+		return nil, err
+	}
+	if err := campaign.Validate(); err != nil {
+		return nil, err
+	}
+
+	campaign.Id = uuid.New()
+	campaign.Revision = 1
+	campaign.CreatedAt = time.Now().UTC()
+	campaign.UpdatedAt = campaign.CreatedAt
+	if err := s.campaigns.Create(campaign); err != nil {
+		return nil, err
+	}
+	return campaign, nil
+}
+
+// UpdateCampaign replaces a campaign with its next revision. Receipts
+// already scored keep their points, and the revision that scored them;
+// rescore them with a ruleset version to apply the change.
+func (s *Service) UpdateCampaign(ctx context.Context, id string, campaignDTO CampaignDTO) (*Campaign, error) {
+	existing, err := s.GetCampaign(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	campaign, err := campaignDTO.ToCampaign()
+	if err != nil {
+		return nil, err
+	}
+	if err := campaign.Validate(); err != nil {
+		return nil, err
+	}
+
+	campaign.Id = existing.Id
+	campaign.Revision = existing.Revision + 1
+	campaign.CreatedAt = existing.CreatedAt
+	campaign.UpdatedAt = time.Now().UTC()
+	if err := s.campaigns.Update(campaign, existing.Revision); err != nil {
+		return nil, err
+	}
+	return campaign, nil
+}
+
+func (s *Service) DeleteCampaign(ctx context.Context, id string) error {
+	if s.campaigns == nil {
+		return fmt.Errorf("campaign %s: %w", id, ErrNotFound)
+	}
+	return s.campaigns.Delete(id)
+}
+
+func (s *Service) GetCampaign(ctx context.Context, id string) (*Campaign, error) {
+	if s.campaigns == nil {
+		return nil, fmt.Errorf("campaign %s: %w", id, ErrNotFound)
+	}
+	return s.campaigns.Get(id)
+}
+
+func (s *Service) ListCampaigns(ctx context.Context) ([]*Campaign, error) {
+	if s.campaigns == nil {
+		return nil, nil
+	}
+	return s.campaigns.List()
+}
+
+// campaignsFor returns the campaigns that apply to the receipt.
+func (s *Service) campaignsFor(receipt *Receipt) ([]*Campaign, error) {
+	if s.campaigns == nil {
+		return nil, nil
+	}
+
+	campaigns, err := s.campaigns.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var applicable []*Campaign
+	for _, campaign := range campaigns {
+		if campaign.AppliesTo(receipt) {
+			applicable = append(applicable, campaign)
+		}
+	}
+	return applicable, nil
+}
+
+// sameRevisions reports whether two lists of campaigns hold the same
+// revisions of the same campaigns.
+func sameRevisions(a, b []Campaign) bool {
+	return slices.EqualFunc(a, b, func(a, b Campaign) bool {
+		return a.Id == b.Id && a.Revision == b.Revision
+	})
+}
@@ -0,0 +1,369 @@
+package receipt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/shopspring/decimal"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeCampaignRepository is a minimal in-memory CampaignRepository.
+type fakeCampaignRepository struct {
+	mu        sync.Mutex
+	campaigns map[string]*Campaign
+}
+
+func newFakeCampaignRepository() *fakeCampaignRepository {
+	return &fakeCampaignRepository{campaigns: make(map[string]*Campaign)}
+}
+
+func (f *fakeCampaignRepository) Create(campaign *Campaign) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.campaigns[campaign.Id.String()] = campaign
+	return nil
+}
+
+func (f *fakeCampaignRepository) Get(id string) (*Campaign, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	campaign, ok := f.campaigns[id]
+	if !ok {
+		return nil, fmt.Errorf("campaign %s: %w", id, ErrNotFound)
+	}
+	return campaign, nil
+}
+
+func (f *fakeCampaignRepository) Update(campaign *Campaign, revision int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	id := campaign.Id.String()
+	stored, ok := f.campaigns[id]
+	if !ok {
+		return fmt.Errorf("campaign %s: %w", id, ErrNotFound)
+	}
+	if stored.Revision != revision {
+		return fmt.Errorf("campaign %s: %w", id, ErrConflict)
+	}
+	f.campaigns[id] = campaign
+	return nil
+}
+
+func (f *fakeCampaignRepository) Delete(id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.campaigns, id)
+	return nil
+}
+
+func (f *fakeCampaignRepository) List() ([]*Campaign, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	campaigns := make([]*Campaign, 0, len(f.campaigns))
+	for _, campaign := range f.campaigns {
+		campaigns = append(campaigns, campaign)
+	}
+	return campaigns, nil
+}
+
+// newTestCampaign returns a campaign running through January 2022.
+func newTestCampaign() *Campaign {
+	return &Campaign{
+		Name:     "New Year",
+		StartsAt: time.Date(2022, time.January, 1, 0, 0, 0, 0, time.Local),
+		EndsAt:   time.Date(2022, time.February, 1, 0, 0, 0, 0, time.Local),
+		Rules: []RuleSpec{
+			{Type: "wholeNumberTotalBonus", Params: json.RawMessage(`{"points": 5}`)},
+		},
+	}
+}
+
+func TestCampaign_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(c *Campaign)
+		wantErr string
+	}{
+		{
+			name:   "valid",
+			modify: func(c *Campaign) {},
+		},
+		{
+			name:   "multiplier only",
+			modify: func(c *Campaign) { c.Rules = nil; c.Multiplier = decimal.NewFromInt(2) },
+		},
+		{
+			name:    "missing name",
+			modify:  func(c *Campaign) { c.Name = " " },
+			wantErr: "name is required",
+		},
+		{
+			name:    "ends before it starts",
+			modify:  func(c *Campaign) { c.EndsAt = c.StartsAt },
+			wantErr: "startsAt must be earlier than endsAt",
+		},
+		{
+			name:    "nothing to award",
+			modify:  func(c *Campaign) { c.Rules = nil },
+			wantErr: "at least one rule or a multiplier",
+		},
+		{
+			name:    "negative multiplier",
+			modify:  func(c *Campaign) { c.Multiplier = decimal.NewFromInt(-2) },
+			wantErr: "multiplier must be positive",
+		},
+		{
+			name:    "invalid rule",
+			modify:  func(c *Campaign) { c.Rules = []RuleSpec{{Type: "blah"}} },
+			wantErr: `rules[0]: unknown rule type "blah"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			campaign := newTestCampaign()
+			tt.modify(campaign)
+			err := campaign.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Validate() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Validate() error = %v, want %q", err, tt.wantErr)
+			}
+			if !errors.Is(err, ErrInvalidInput) {
+				t.Errorf("Validate() error = %v, want ErrInvalidInput", err)
+			}
+		})
+	}
+}
+
+func TestCampaign_AppliesTo(t *testing.T) {
+	tests := []struct {
+		name      string
+		retailers []string
+		purchased time.Time
+		want      bool
+	}{
+		{
+			name:      "during the campaign",
+			purchased: time.Date(2022, time.January, 1, 13, 1, 0, 0, time.Local),
+			want:      true,
+		},
+		{
+			name:      "at the start",
+			purchased: time.Date(2022, time.January, 1, 0, 0, 0, 0, time.Local),
+			want:      true,
+		},
+		{
+			name:      "at the end",
+			purchased: time.Date(2022, time.February, 1, 0, 0, 0, 0, time.Local),
+			want:      false,
+		},
+		{
+			name:      "targeted retailer",
+			retailers: []string{"Walgreens", "target"},
+			purchased: time.Date(2022, time.January, 1, 13, 1, 0, 0, time.Local),
+			want:      true,
+		},
+		{
+			name:      "other retailer",
+			retailers: []string{"Walgreens"},
+			purchased: time.Date(2022, time.January, 1, 13, 1, 0, 0, time.Local),
+			want:      false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			campaign := newTestCampaign()
+			campaign.Retailers = tt.retailers
+			receipt := newCalculatorTestReceipt()
+			receipt.PurchaseDateTime = tt.purchased
+			if got := campaign.AppliesTo(receipt); got != tt.want {
+				t.Errorf("AppliesTo() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPointCalculator_CalculateWithCampaigns(t *testing.T) {
+	calculator := DefaultRuleset().Calculator()
+
+	bonus := newTestCampaign()
+	double := newTestCampaign()
+	double.Name = "Double points"
+	double.Rules = nil
+	double.Multiplier = decimal.NewFromInt(2)
+	expired := newTestCampaign()
+	expired.Name = "Expired"
+	expired.EndsAt = expired.StartsAt.Add(time.Hour)
+
+	for _, campaign := range []*Campaign{bonus, double, expired} {
+		if err := calculator.AddCampaign(campaign); err != nil {
+			t.Fatalf("AddCampaign() error = %v", err)
+		}
+	}
+
+	receipt := newCalculatorTestReceipt()
+	receipt.Total = decimal.RequireFromString("35.00")
+	base := DefaultRuleset().Calculator().Calculate(receipt).Total
+
+	result := calculator.Calculate(receipt)
+	if want := base + 5 + base; result.Total != want {
+		t.Errorf("Calculate() total = %v, want %v", result.Total, want)
+	}
+
+	campaigns := map[string]int64{}
+	for _, rule := range result.Rules {
+		if rule.Campaign != "" {
+			campaigns[rule.Campaign] += rule.Points
+		}
+	}
+	if campaigns["New Year"] != 5 || campaigns["Double points"] != base || len(campaigns) != 2 {
+		t.Errorf("Calculate() campaign points = %v, want New Year 5 and Double points %v", campaigns, base)
+	}
+}
+
+func TestService_CampaignsApplyWhenScoring(t *testing.T) {
+	service := NewService(newFakeRepository(), WithCampaigns(newFakeCampaignRepository()))
+
+	campaign, err := service.CreateCampaign(context.Background(), CampaignDTO{
+		Name:      "Target week",
+		StartsAt:  "2022-01-01T00:00:00Z",
+		EndsAt:    "2022-01-08T00:00:00Z",
+		Retailers: []string{"Target"},
+		Rules:     []RuleSpec{{Type: "expression", Params: json.RawMessage(`{"expression": "points = 7"}`)}},
+	})
+	if err != nil {
+		t.Fatalf("CreateCampaign() error = %v", err)
+	}
+
+	dto := newServiceTestDTO()
+	created, err := service.Create(context.Background(), dto)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	last := created.PointsBreakdown[len(created.PointsBreakdown)-1]
+	if last.Campaign != "Target week" || last.Points != 7 {
+		t.Errorf("Create() last rule = %+v, want 7 points from Target week", last)
+	}
+
+	if err := service.DeleteCampaign(context.Background(), campaign.Id.String()); err != nil {
+		t.Fatalf("DeleteCampaign() error = %v", err)
+	}
+	withoutCampaign, err := service.Create(context.Background(), dto)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if withoutCampaign.Points != created.Points-7 {
+		t.Errorf("Create() points after deleting the campaign = %v, want %v", withoutCampaign.Points, created.Points-7)
+	}
+
+	_, err = service.CreateCampaign(context.Background(), CampaignDTO{Name: "Bad", StartsAt: "2022-01-01", EndsAt: "2022-01-08T00:00:00Z", Multiplier: "2"})
+	if !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("CreateCampaign() error = %v, want ErrInvalidInput", err)
+	}
+}
+
+// interleavingCampaignRepository calls beforeUpdate once, just before the
+// first Update, as if another request updated the campaign meanwhile.
+type interleavingCampaignRepository struct {
+	*fakeCampaignRepository
+	beforeUpdate func()
+}
+
+func (r *interleavingCampaignRepository) Update(campaign *Campaign, revision int64) error {
+	if beforeUpdate := r.beforeUpdate; beforeUpdate != nil {
+		r.beforeUpdate = nil
+		beforeUpdate()
+	}
+	return r.fakeCampaignRepository.Update(campaign, revision)
+}
+
+func TestService_UpdateCampaignConflict(t *testing.T) {
+	ctx := context.Background()
+	campaigns := &interleavingCampaignRepository{fakeCampaignRepository: newFakeCampaignRepository()}
+	service := NewService(newFakeRepository(), WithCampaigns(campaigns))
+
+	dto := CampaignDTO{Name: "Target week", StartsAt: "2022-01-01T00:00:00Z", EndsAt: "2022-01-08T00:00:00Z", Multiplier: "2"}
+	campaign, err := service.CreateCampaign(ctx, dto)
+	if err != nil {
+		t.Fatalf("CreateCampaign() error = %v", err)
+	}
+	id := campaign.Id.String()
+
+	other := dto
+	other.Name = "Other"
+	campaigns.beforeUpdate = func() {
+		if _, err := service.UpdateCampaign(ctx, id, other); err != nil {
+			t.Errorf("UpdateCampaign() in between error = %v", err)
+		}
+	}
+	dto.Name = "Renamed"
+	if _, err := service.UpdateCampaign(ctx, id, dto); !errors.Is(err, ErrConflict) {
+		t.Errorf("UpdateCampaign() of a campaign updated meanwhile error = %v, want ErrConflict", err)
+	}
+	if stored, err := service.GetCampaign(ctx, id); err != nil || stored.Name != "Other" || stored.Revision != 2 {
+		t.Errorf("GetCampaign() = %+v, %v, want revision 2 named Other", stored, err)
+	}
+}
+
+// TestService_RescoreReproducesCampaigns rescores a receipt after the
+// campaign that scored it was changed and then deleted.
+func TestService_RescoreReproducesCampaigns(t *testing.T) {
+	ctx := context.Background()
+	service := NewService(newFakeRepository(), WithCampaigns(newFakeCampaignRepository()))
+
+	dto := CampaignDTO{
+		Name:     "Target week",
+		StartsAt: "2022-01-01T00:00:00Z",
+		EndsAt:   "2022-01-08T00:00:00Z",
+		Rules:    []RuleSpec{{Type: "expression", Params: json.RawMessage(`{"expression": "points = 7"}`)}},
+	}
+	campaign, err := service.CreateCampaign(ctx, dto)
+	if err != nil {
+		t.Fatalf("CreateCampaign() error = %v", err)
+	}
+	created, err := service.Create(ctx, newServiceTestDTO())
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if len(created.Campaigns) != 1 || created.Campaigns[0].Revision != 1 {
+		t.Fatalf("Create() campaigns = %+v, want revision 1 of the campaign", created.Campaigns)
+	}
+
+	dto.Rules = []RuleSpec{{Type: "expression", Params: json.RawMessage(`{"expression": "points = 20"}`)}}
+	if _, err := service.UpdateCampaign(ctx, campaign.Id.String(), dto); err != nil {
+		t.Fatalf("UpdateCampaign() error = %v", err)
+	}
+	id := created.Id.String()
+	reproduced, err := service.Rescore(ctx, id, "")
+	if err != nil {
+		t.Fatalf("Rescore() error = %v", err)
+	}
+	if reproduced.Points != created.Points || reproduced.Campaigns[0].Revision != 1 {
+		t.Errorf("Rescore() with the receipt's version = %d points by revision %d, want %d by 1", reproduced.Points, reproduced.Campaigns[0].Revision, created.Points)
+	}
+
+	// Rescoring with a version applies the campaign as it is now.
+	rescored, err := service.Rescore(ctx, id, DefaultRulesetVersion)
+	if err != nil {
+		t.Fatalf("Rescore() error = %v", err)
+	}
+	if rescored.Points != created.Points+13 || rescored.Campaigns[0].Revision != 2 {
+		t.Errorf("Rescore(%q) = %d points by revision %d, want %d by 2", DefaultRulesetVersion, rescored.Points, rescored.Campaigns[0].Revision, created.Points+13)
+	}
+
+	if err := service.DeleteCampaign(ctx, campaign.Id.String()); err != nil {
+		t.Fatalf("DeleteCampaign() error = %v", err)
+	}
+	if reproduced, err := service.Rescore(ctx, id, ""); err != nil || reproduced.Points != rescored.Points {
+		t.Errorf("Rescore() after DeleteCampaign() = %+v, %v, want %d points", reproduced, err, rescored.Points)
+	}
+}
@@ -1,11 +1,17 @@
 package receipt
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	validator "github.com/go-playground/validator/v10"
 	"github.com/google/uuid"
 	"github.com/shopspring/decimal"
+	"reflect"
 	"regexp"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -14,16 +20,22 @@ type CreateReceiptDTO struct {
 	PurchaseDate string          `json:"purchaseDate" validate:"required,datetime=2006-01-02"`
 	PurchaseTime string          `json:"purchaseTime" validate:"required,datetime=15:04"`
 	Items        []CreateItemDTO `json:"items" validate:"required,min=1,dive"`
-	Total        string          `json:"total" validate:"required,regexp=^\\d+\\.\\d{2}$,gt=0"`
+	Total        string          `json:"total" validate:"required,regexp=^\\d+\\.\\d{2}$,positive"`
+	// TimeZone is the store's time zone, an IANA name or a UTC offset as
+	// ParseTimeZone accepts them. It is optional.
+	TimeZone string `json:"timeZone,omitempty"`
 }
 
 type CreateItemDTO struct {
 	ShortDescription string `json:"shortDescription" validate:"required,regexp=^[\\w\\s\\-]+$"`
-	Price            string `json:"price" validate:"required,regexp=^\\d+\\.\\d{2}$,gt=0"`
+	Price            string `json:"price" validate:"required,regexp=^\\d+\\.\\d{2}$,positive"`
 }
 
 func (r *CreateReceiptDTO) Validate() error {
 	validate := validator.New()
+	validate.RegisterTagNameFunc(func(field reflect.StructField) string {
+		return strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+	})
 	err := validate.RegisterValidation("regexp", func(fl validator.FieldLevel) bool {
 		pattern := fl.Param()
 		regex := regexp.MustCompile(pattern)
@@ -32,60 +44,169 @@ func (r *CreateReceiptDTO) Validate() error {
 	if err != nil {
 		return err
 	}
+	// gt=0 would compare the length of an amount's string, so that "0.00"
+	// passed it.
+	err = validate.RegisterValidation("positive", func(fl validator.FieldLevel) bool {
+		amount, err := decimal.NewFromString(fl.Field().String())
+		return err == nil && amount.IsPositive()
+	})
+	if err != nil {
+		return err
+	}
 
 	if err := validate.Struct(r); err != nil {
-		return err
+		var validationErrors validator.ValidationErrors
+		if errors.As(err, &validationErrors) {
+			return newValidationError(validationErrors)
+		}
+		return fmt.Errorf("%w: %w", ErrInvalidInput, err)
 	}
 
 	var sum decimal.Decimal
 	total, err := decimal.NewFromString(r.Total)
 	if err != nil {
-		return err
+		return fmt.Errorf("%w: %w", ErrInvalidInput, err)
 	}
 
 	for _, item := range r.Items {
 		price, err := decimal.NewFromString(item.Price)
 		if err != nil {
-			return err
+			return fmt.Errorf("%w: %w", ErrInvalidInput, err)
 		}
 		sum = sum.Add(price)
 	}
 
+	// Without a time zone of its own, the receipt is placed in its
+	// retailer's, which ToReceipt is given.
+	if r.TimeZone != "" {
+		if _, _, err := r.purchaseDateTime(time.UTC); err != nil {
+			return err
+		}
+	}
+
 	if !sum.Equal(total) {
-		return fmt.Errorf("sum of items (%s) does not match total (%s)", sum, total)
+		return &ValidationError{
+			Violations: []FieldViolation{
+				{
+					Field:   "total",
+					Rule:    "sum",
+					Message: fmt.Sprintf("does not match the sum of items (%s)", sum.StringFixed(2)),
+				},
+			},
+		}
 	}
 
 	return nil
 }
 
-func (r *CreateReceiptDTO) ToReceipt() (*Receipt, error) {
-	// Parse purchase date
+// purchaseDateTime returns the instant of the purchase in the receipt's
+// time zone, or in zone when it has none, and that time zone. An unknown
+// time zone, or a purchase time its clocks skipped, is a ValidationError.
+func (r *CreateReceiptDTO) purchaseDateTime(zone *time.Location) (time.Time, *time.Location, error) {
+	if r.TimeZone != "" {
+		var err error
+		if zone, err = ParseTimeZone(r.TimeZone); err != nil {
+			return time.Time{}, nil, &ValidationError{
+				Violations: []FieldViolation{
+					{
+						Field:   "timeZone",
+						Rule:    "timezone",
+						Message: fmt.Sprintf("must be an IANA time zone name or a UTC offset of at most %d hours", int(maxUTCOffset.Hours())),
+					},
+				},
+			}
+		}
+	}
+
 	purchaseDate, err := time.Parse("2006-01-02", r.PurchaseDate)
 	if err != nil {
-		return nil, err
+		return time.Time{}, nil, fmt.Errorf("%w: %w", ErrInvalidInput, err)
 	}
-
-	// Parse purchase time
 	purchaseTime, err := time.Parse("15:04", r.PurchaseTime)
 	if err != nil {
-		return nil, err
+		return time.Time{}, nil, fmt.Errorf("%w: %w", ErrInvalidInput, err)
 	}
 
-	// Combine date and time
-	fullPurchaseTime := time.Date(
+	// Combine date and time in the store's time zone
+	purchased, err := localTime(
 		purchaseDate.Year(),
 		purchaseDate.Month(),
 		purchaseDate.Day(),
 		purchaseTime.Hour(),
 		purchaseTime.Minute(),
-		0, 0,
-		time.Local,
+		zone,
 	)
+	if err != nil {
+		return time.Time{}, nil, &ValidationError{
+			Violations: []FieldViolation{
+				{
+					Field:   "purchaseTime",
+					Rule:    "exists",
+					Message: fmt.Sprintf("does not exist on %s in %s, where clocks were turned forward", r.PurchaseDate, zone),
+				},
+			},
+		}
+	}
+	return purchased, zone, nil
+}
+
+func newValidationError(validationErrors validator.ValidationErrors) *ValidationError {
+	violations := make([]FieldViolation, len(validationErrors))
+	for i, fe := range validationErrors {
+		// Drop the root struct name from the namespace, which is built from
+		// the json tag names registered above.
+		field := fe.Namespace()
+		if _, rest, ok := strings.Cut(field, "."); ok {
+			field = rest
+		}
+
+		violations[i] = FieldViolation{
+			Field:   field,
+			Rule:    fe.Tag(),
+			Message: violationMessage(fe),
+		}
+	}
+	return &ValidationError{Violations: violations}
+}
+
+func violationMessage(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "regexp":
+		return fmt.Sprintf("must match the pattern %s", fe.Param())
+	case "datetime":
+		return fmt.Sprintf("must be formatted as %s", fe.Param())
+	case "min":
+		return fmt.Sprintf("must contain at least %s entries", fe.Param())
+	case "positive":
+		return "must be greater than 0"
+	case "gt":
+		switch fe.Kind() {
+		case reflect.String:
+			return fmt.Sprintf("must be longer than %s characters", fe.Param())
+		case reflect.Slice, reflect.Map, reflect.Array:
+			return fmt.Sprintf("must contain more than %s entries", fe.Param())
+		default:
+			return fmt.Sprintf("must be greater than %s", fe.Param())
+		}
+	default:
+		return fmt.Sprintf("failed the %s check", fe.Tag())
+	}
+}
+
+// ToReceipt converts the DTO to a receipt purchased in its time zone, or
+// in zone when it has none.
+func (r *CreateReceiptDTO) ToReceipt(zone *time.Location) (*Receipt, error) {
+	fullPurchaseTime, zone, err := r.purchaseDateTime(zone)
+	if err != nil {
+		return nil, err
+	}
 
 	// Parse total
 	total, err := decimal.NewFromString(r.Total)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%w: %w", ErrInvalidInput, err)
 	}
 
 	// Convert items
@@ -93,7 +214,7 @@ func (r *CreateReceiptDTO) ToReceipt() (*Receipt, error) {
 	for i, item := range r.Items {
 		price, err := decimal.NewFromString(item.Price)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("%w: %w", ErrInvalidInput, err)
 		}
 		items[i] = Item{
 			Id:               uuid.New(),
@@ -106,7 +227,608 @@ func (r *CreateReceiptDTO) ToReceipt() (*Receipt, error) {
 		Id:               uuid.New(),
 		Retailer:         r.Retailer,
 		PurchaseDateTime: fullPurchaseTime,
+		TimeZone:         zone.String(),
 		Items:            items,
 		Total:            total,
 	}, nil
 }
+
+type ReceiptResponseDTO struct {
+	Id             string            `json:"id"`
+	Retailer       string            `json:"retailer"`
+	PurchaseDate   string            `json:"purchaseDate"`
+	PurchaseTime   string            `json:"purchaseTime"`
+	TimeZone       string            `json:"timeZone"`
+	PurchasedAt    time.Time         `json:"purchasedAt"`
+	Items          []ItemResponseDTO `json:"items"`
+	Total          string            `json:"total"`
+	Points         int64             `json:"points"`
+	RulesetVersion string            `json:"rulesetVersion"`
+	CreatedAt      time.Time         `json:"createdAt"`
+	Version        int64             `json:"version"`
+}
+
+type ItemResponseDTO struct {
+	Id               string `json:"id"`
+	ShortDescription string `json:"shortDescription"`
+	Price            string `json:"price"`
+}
+
+func NewReceiptResponseDTO(receipt *Receipt) ReceiptResponseDTO {
+	items := make([]ItemResponseDTO, len(receipt.Items))
+	for i, item := range receipt.Items {
+		items[i] = ItemResponseDTO{
+			Id:               item.Id.String(),
+			ShortDescription: item.ShortDescription,
+			Price:            item.Price.StringFixed(2),
+		}
+	}
+
+	return ReceiptResponseDTO{
+		Id:             receipt.Id.String(),
+		Retailer:       receipt.Retailer,
+		PurchaseDate:   receipt.PurchaseDateTime.Format("2006-01-02"),
+		PurchaseTime:   receipt.PurchaseDateTime.Format("15:04"),
+		TimeZone:       receipt.TimeZone,
+		PurchasedAt:    receipt.PurchaseDateTime,
+		Items:          items,
+		Total:          receipt.Total.StringFixed(2),
+		Points:         receipt.Points,
+		RulesetVersion: receipt.RulesetVersion,
+		CreatedAt:      receipt.CreatedAt,
+		Version:        receipt.Version,
+	}
+}
+
+type ListReceiptsDTO struct {
+	Retailer         string
+	PurchaseDateFrom string
+	PurchaseDateTo   string
+	TotalMin         string
+	TotalMax         string
+	PointsMin        string
+	PointsMax        string
+	Sort             string
+	Order            string
+	Cursor           string
+	Limit            string
+}
+
+// ToListQuery parses the filters. Both ends of the purchase date range are
+// inclusive, and are compared with each receipt's purchaseDate, the date
+// where the purchase was made.
+func (l *ListReceiptsDTO) ToListQuery() (ListQuery, error) {
+	query := ListQuery{
+		Retailer: l.Retailer,
+		SortBy:   SortField(l.Sort),
+		Order:    SortOrder(l.Order),
+		Cursor:   l.Cursor,
+	}
+
+	for _, date := range []struct {
+		name, value string
+		to          *string
+	}{
+		{"purchaseDateFrom", l.PurchaseDateFrom, &query.PurchaseDateFrom},
+		{"purchaseDateTo", l.PurchaseDateTo, &query.PurchaseDateTo},
+	} {
+		if date.value == "" {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", date.value); err != nil {
+			return ListQuery{}, fmt.Errorf("%w: invalid %s: %w", ErrInvalidInput, date.name, err)
+		}
+		*date.to = date.value
+	}
+
+	var err error
+	if query.MinTotal, err = parseOptionalDecimal("totalMin", l.TotalMin); err != nil {
+		return ListQuery{}, err
+	}
+	if query.MaxTotal, err = parseOptionalDecimal("totalMax", l.TotalMax); err != nil {
+		return ListQuery{}, err
+	}
+	if query.MinPoints, err = parseOptionalInt("pointsMin", l.PointsMin); err != nil {
+		return ListQuery{}, err
+	}
+	if query.MaxPoints, err = parseOptionalInt("pointsMax", l.PointsMax); err != nil {
+		return ListQuery{}, err
+	}
+
+	if l.Limit != "" {
+		limit, err := strconv.Atoi(l.Limit)
+		if err != nil || limit < 1 {
+			return ListQuery{}, fmt.Errorf("%w: invalid limit %q", ErrInvalidInput, l.Limit)
+		}
+		query.Limit = limit
+	}
+
+	if err := query.Normalize(); err != nil {
+		return ListQuery{}, err
+	}
+
+	return query, nil
+}
+
+func parseOptionalDecimal(name, value string) (*decimal.Decimal, error) {
+	if value == "" {
+		return nil, nil
+	}
+	d, err := decimal.NewFromString(value)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid %s %q", ErrInvalidInput, name, value)
+	}
+	return &d, nil
+}
+
+func parseOptionalInt(name, value string) (*int64, error) {
+	if value == "" {
+		return nil, nil
+	}
+	i, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid %s %q", ErrInvalidInput, name, value)
+	}
+	return &i, nil
+}
+
+type ReceiptListResponseDTO struct {
+	Receipts   []ReceiptResponseDTO `json:"receipts"`
+	NextCursor string               `json:"nextCursor,omitempty"`
+}
+
+func NewReceiptListResponseDTO(page *Page) ReceiptListResponseDTO {
+	receipts := make([]ReceiptResponseDTO, len(page.Receipts))
+	for i, receipt := range page.Receipts {
+		receipts[i] = NewReceiptResponseDTO(receipt)
+	}
+
+	return ReceiptListResponseDTO{
+		Receipts:   receipts,
+		NextCursor: page.NextCursor,
+	}
+}
+
+type PointsBreakdownDTO struct {
+	RulesetVersion string             `json:"rulesetVersion"`
+	Points         int64              `json:"points"`
+	Rules          []RuleBreakdownDTO `json:"rules"`
+}
+
+type RuleBreakdownDTO struct {
+	Description string            `json:"description"`
+	Points      int64             `json:"points"`
+	ExactPoints string            `json:"exactPoints,omitempty"`
+	Inputs      map[string]string `json:"inputs"`
+	Campaign    string            `json:"campaign,omitempty"`
+	Group       string            `json:"group,omitempty"`
+	Excluded    bool              `json:"excluded,omitempty"`
+}
+
+func NewPointsBreakdownDTO(result *PointsResult) PointsBreakdownDTO {
+	rules := make([]RuleBreakdownDTO, len(result.Rules))
+	for i, rule := range result.Rules {
+		rules[i] = RuleBreakdownDTO{
+			Description: rule.Description,
+			Points:      rule.Points,
+			Inputs:      rule.Inputs,
+			Campaign:    rule.Campaign,
+			Group:       rule.Group,
+			Excluded:    rule.Excluded,
+		}
+		// Receipts scored before exact points were recorded have none.
+		if !rule.ExactPoints.IsZero() && !rule.ExactPoints.Equal(decimal.NewFromInt(rule.Points)) {
+			rules[i].ExactPoints = rule.ExactPoints.String()
+		}
+	}
+
+	return PointsBreakdownDTO{
+		RulesetVersion: result.RulesetVersion,
+		Points:         result.Total,
+		Rules:          rules,
+	}
+}
+
+type RulesetResponseDTO struct {
+	Version       string                `json:"version"`
+	EffectiveFrom time.Time             `json:"effectiveFrom"`
+	Active        bool                  `json:"active"`
+	MinPoints     *int64                `json:"minPoints,omitempty"`
+	MaxPoints     *int64                `json:"maxPoints,omitempty"`
+	Rounding      Rounding              `json:"rounding,omitempty"`
+	Accumulate    bool                  `json:"accumulateFractions,omitempty"`
+	Rules         []RuleSpecResponseDTO `json:"rules"`
+}
+
+type RuleSpecResponseDTO struct {
+	Type        string          `json:"type"`
+	Params      json.RawMessage `json:"params,omitempty"`
+	Description string          `json:"description"`
+	RuleStacking
+}
+
+func newRuleSpecResponseDTO(spec RuleSpec, rule PointRule) RuleSpecResponseDTO {
+	dto := RuleSpecResponseDTO{
+		Type:         spec.Type,
+		Params:       spec.Params,
+		RuleStacking: spec.RuleStacking,
+	}
+	if rule != nil {
+		dto.Description = rule.Description()
+	}
+	return dto
+}
+
+func NewRulesetResponseDTO(ruleset *Ruleset, active bool) RulesetResponseDTO {
+	rules := make([]RuleSpecResponseDTO, len(ruleset.Rules))
+	for i, rule := range ruleset.Rules {
+		rules[i] = newRuleSpecResponseDTO(ruleset.Specs[i], rule)
+	}
+
+	return RulesetResponseDTO{
+		Version:       ruleset.Version,
+		EffectiveFrom: ruleset.EffectiveFrom,
+		Active:        active,
+		MinPoints:     ruleset.MinPoints,
+		MaxPoints:     ruleset.MaxPoints,
+		Rounding:      ruleset.Rounding,
+		Accumulate:    ruleset.AccumulateFractions,
+		Rules:         rules,
+	}
+}
+
+type RulesetListResponseDTO struct {
+	Rulesets []RulesetResponseDTO `json:"rulesets"`
+}
+
+func NewRulesetListResponseDTO(rulesets []*Ruleset, active *Ruleset) RulesetListResponseDTO {
+	dtos := make([]RulesetResponseDTO, len(rulesets))
+	for i, ruleset := range rulesets {
+		dtos[i] = NewRulesetResponseDTO(ruleset, ruleset == active)
+	}
+	return RulesetListResponseDTO{Rulesets: dtos}
+}
+
+type CreateRescoreJobDTO struct {
+	RulesetVersion   string `json:"rulesetVersion"`
+	Retailer         string `json:"retailer"`
+	PurchaseDateFrom string `json:"purchaseDateFrom"`
+	PurchaseDateTo   string `json:"purchaseDateTo"`
+	TotalMin         string `json:"totalMin"`
+	TotalMax         string `json:"totalMax"`
+	PointsMin        string `json:"pointsMin"`
+	PointsMax        string `json:"pointsMax"`
+}
+
+// ToListQuery parses the filters selecting the receipts to rescore, which
+// are the same as those of ListReceiptsDTO.
+func (d *CreateRescoreJobDTO) ToListQuery() (ListQuery, error) {
+	filters := ListReceiptsDTO{
+		Retailer:         d.Retailer,
+		PurchaseDateFrom: d.PurchaseDateFrom,
+		PurchaseDateTo:   d.PurchaseDateTo,
+		TotalMin:         d.TotalMin,
+		TotalMax:         d.TotalMax,
+		PointsMin:        d.PointsMin,
+		PointsMax:        d.PointsMax,
+	}
+	return filters.ToListQuery()
+}
+
+type RescoreJobResponseDTO struct {
+	Id             string            `json:"id"`
+	RulesetVersion string            `json:"rulesetVersion"`
+	Status         JobStatus         `json:"status"`
+	Error          string            `json:"error,omitempty"`
+	Total          int               `json:"total"`
+	Processed      int               `json:"processed"`
+	Changed        int               `json:"changed"`
+	Failed         int               `json:"failed"`
+	PointsBefore   int64             `json:"pointsBefore"`
+	PointsAfter    int64             `json:"pointsAfter"`
+	PointsDelta    int64             `json:"pointsDelta"`
+	Changes        []PointsChangeDTO `json:"changes"`
+	Errors         []ReceiptErrorDTO `json:"errors"`
+	CreatedAt      time.Time         `json:"createdAt"`
+	UpdatedAt      time.Time         `json:"updatedAt"`
+	FinishedAt     *time.Time        `json:"finishedAt,omitempty"`
+}
+
+type PointsChangeDTO struct {
+	ReceiptId string `json:"receiptId"`
+	Before    int64  `json:"before"`
+	After     int64  `json:"after"`
+}
+
+type ReceiptErrorDTO struct {
+	ReceiptId string `json:"receiptId"`
+	Error     string `json:"error"`
+}
+
+func NewRescoreJobResponseDTO(job *RescoreJob) RescoreJobResponseDTO {
+	errs := make([]ReceiptErrorDTO, len(job.Errors))
+	for i, err := range job.Errors {
+		errs[i] = ReceiptErrorDTO{
+			ReceiptId: err.ReceiptId,
+			Error:     err.Error,
+		}
+	}
+
+	var finishedAt *time.Time
+	if !job.FinishedAt.IsZero() {
+		finishedAt = &job.FinishedAt
+	}
+
+	return RescoreJobResponseDTO{
+		Id:             job.Id,
+		RulesetVersion: job.RulesetVersion,
+		Status:         job.Status,
+		Error:          job.Error,
+		Total:          job.Total,
+		Processed:      job.Processed,
+		Changed:        job.Changed,
+		Failed:         job.Failed,
+		PointsBefore:   job.PointsBefore,
+		PointsAfter:    job.PointsAfter,
+		PointsDelta:    job.PointsAfter - job.PointsBefore,
+		Changes:        newPointsChangeDTOs(job.Changes),
+		Errors:         errs,
+		CreatedAt:      job.CreatedAt,
+		UpdatedAt:      job.UpdatedAt,
+		FinishedAt:     finishedAt,
+	}
+}
+
+type RescoreJobListResponseDTO struct {
+	Jobs []RescoreJobResponseDTO `json:"jobs"`
+}
+
+func NewRescoreJobListResponseDTO(jobs []*RescoreJob) RescoreJobListResponseDTO {
+	dtos := make([]RescoreJobResponseDTO, len(jobs))
+	for i, job := range jobs {
+		dtos[i] = NewRescoreJobResponseDTO(job)
+	}
+	return RescoreJobListResponseDTO{Jobs: dtos}
+}
+
+type SimulateRulesetDTO struct {
+	// Ruleset is the candidate ruleset, written like a ruleset file.
+	Ruleset          json.RawMessage `json:"ruleset"`
+	Retailer         string          `json:"retailer"`
+	PurchaseDateFrom string          `json:"purchaseDateFrom"`
+	PurchaseDateTo   string          `json:"purchaseDateTo"`
+	TotalMin         string          `json:"totalMin"`
+	TotalMax         string          `json:"totalMax"`
+	PointsMin        string          `json:"pointsMin"`
+	PointsMax        string          `json:"pointsMax"`
+}
+
+// ToRuleset parses the candidate ruleset.
+func (d *SimulateRulesetDTO) ToRuleset() (*Ruleset, error) {
+	if len(d.Ruleset) == 0 {
+		return nil, fmt.Errorf("%w: ruleset is required", ErrInvalidInput)
+	}
+	return ParseRuleset(bytes.NewReader(d.Ruleset))
+}
+
+// ToListQuery parses the filters selecting the receipts to simulate, which
+// are the same as those of ListReceiptsDTO.
+func (d *SimulateRulesetDTO) ToListQuery() (ListQuery, error) {
+	filters := ListReceiptsDTO{
+		Retailer:         d.Retailer,
+		PurchaseDateFrom: d.PurchaseDateFrom,
+		PurchaseDateTo:   d.PurchaseDateTo,
+		TotalMin:         d.TotalMin,
+		TotalMax:         d.TotalMax,
+		PointsMin:        d.PointsMin,
+		PointsMax:        d.PointsMax,
+	}
+	return filters.ToListQuery()
+}
+
+type SimulationResponseDTO struct {
+	RulesetVersion string                  `json:"rulesetVersion"`
+	Receipts       int                     `json:"receipts"`
+	Changed        int                     `json:"changed"`
+	Failed         int                     `json:"failed"`
+	PointsBefore   int64                   `json:"pointsBefore"`
+	PointsAfter    int64                   `json:"pointsAfter"`
+	PointsDelta    int64                   `json:"pointsDelta"`
+	Histograms     SimulationHistogramsDTO `json:"histograms"`
+	TopGainers     []PointsChangeDTO       `json:"topGainers"`
+	TopLosers      []PointsChangeDTO       `json:"topLosers"`
+	Rules          []RuleContributionDTO   `json:"rules"`
+	Errors         []ReceiptErrorDTO       `json:"errors"`
+}
+
+type SimulationHistogramsDTO struct {
+	PointsBefore []HistogramBucketDTO `json:"pointsBefore"`
+	PointsAfter  []HistogramBucketDTO `json:"pointsAfter"`
+	PointsDelta  []HistogramBucketDTO `json:"pointsDelta"`
+}
+
+// HistogramBucketDTO counts the values from Min to Max, both inclusive. The
+// first bucket has no Min and the last no Max.
+type HistogramBucketDTO struct {
+	Min   *int64 `json:"min,omitempty"`
+	Max   *int64 `json:"max,omitempty"`
+	Count int    `json:"count"`
+}
+
+type RuleContributionDTO struct {
+	Description    string `json:"description"`
+	Campaign       string `json:"campaign,omitempty"`
+	PointsBefore   int64  `json:"pointsBefore"`
+	PointsAfter    int64  `json:"pointsAfter"`
+	PointsDelta    int64  `json:"pointsDelta"`
+	ReceiptsBefore int    `json:"receiptsBefore"`
+	ReceiptsAfter  int    `json:"receiptsAfter"`
+}
+
+func NewSimulationResponseDTO(simulation *Simulation) SimulationResponseDTO {
+	rules := make([]RuleContributionDTO, len(simulation.Rules))
+	for i, rule := range simulation.Rules {
+		rules[i] = RuleContributionDTO{
+			Description:    rule.Description,
+			Campaign:       rule.Campaign,
+			PointsBefore:   rule.PointsBefore,
+			PointsAfter:    rule.PointsAfter,
+			PointsDelta:    rule.PointsAfter - rule.PointsBefore,
+			ReceiptsBefore: rule.ReceiptsBefore,
+			ReceiptsAfter:  rule.ReceiptsAfter,
+		}
+	}
+
+	errs := make([]ReceiptErrorDTO, len(simulation.Errors))
+	for i, err := range simulation.Errors {
+		errs[i] = ReceiptErrorDTO{
+			ReceiptId: err.ReceiptId,
+			Error:     err.Error,
+		}
+	}
+
+	return SimulationResponseDTO{
+		RulesetVersion: simulation.RulesetVersion,
+		Receipts:       simulation.Receipts,
+		Changed:        simulation.Changed,
+		Failed:         simulation.Failed,
+		PointsBefore:   simulation.PointsBefore,
+		PointsAfter:    simulation.PointsAfter,
+		PointsDelta:    simulation.PointsAfter - simulation.PointsBefore,
+		Histograms: SimulationHistogramsDTO{
+			PointsBefore: newHistogramDTO(simulation.PointsBeforeHistogram),
+			PointsAfter:  newHistogramDTO(simulation.PointsAfterHistogram),
+			PointsDelta:  newHistogramDTO(simulation.DeltaHistogram),
+		},
+		TopGainers: newPointsChangeDTOs(simulation.TopGainers),
+		TopLosers:  newPointsChangeDTOs(simulation.TopLosers),
+		Rules:      rules,
+		Errors:     errs,
+	}
+}
+
+func newHistogramDTO(histogram Histogram) []HistogramBucketDTO {
+	buckets := make([]HistogramBucketDTO, len(histogram.Counts))
+	for i, count := range histogram.Counts {
+		buckets[i].Count = count
+		if i > 0 {
+			min := histogram.Bounds[i-1]
+			buckets[i].Min = &min
+		}
+		if i < len(histogram.Bounds) {
+			max := histogram.Bounds[i] - 1
+			buckets[i].Max = &max
+		}
+	}
+	return buckets
+}
+
+func newPointsChangeDTOs(changes []PointsChange) []PointsChangeDTO {
+	dtos := make([]PointsChangeDTO, len(changes))
+	for i, change := range changes {
+		dtos[i] = PointsChangeDTO{
+			ReceiptId: change.ReceiptId,
+			Before:    change.Before,
+			After:     change.After,
+		}
+	}
+	return dtos
+}
+
+type CampaignDTO struct {
+	Name       string     `json:"name"`
+	StartsAt   string     `json:"startsAt"`
+	EndsAt     string     `json:"endsAt"`
+	Retailers  []string   `json:"retailers"`
+	Rules      []RuleSpec `json:"rules"`
+	Multiplier string     `json:"multiplier"`
+}
+
+// ToCampaign parses the campaign. StartsAt and EndsAt are RFC 3339 times.
+func (c *CampaignDTO) ToCampaign() (*Campaign, error) {
+	startsAt, err := time.Parse(time.RFC3339, c.StartsAt)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid startsAt %q", ErrInvalidInput, c.StartsAt)
+	}
+	endsAt, err := time.Parse(time.RFC3339, c.EndsAt)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid endsAt %q", ErrInvalidInput, c.EndsAt)
+	}
+
+	multiplier := decimal.Zero
+	if c.Multiplier != "" {
+		if multiplier, err = decimal.NewFromString(c.Multiplier); err != nil {
+			return nil, fmt.Errorf("%w: invalid multiplier %q", ErrInvalidInput, c.Multiplier)
+		}
+	}
+
+	return &Campaign{
+		Name:       c.Name,
+		StartsAt:   startsAt.UTC(),
+		EndsAt:     endsAt.UTC(),
+		Retailers:  c.Retailers,
+		Rules:      c.Rules,
+		Multiplier: multiplier,
+	}, nil
+}
+
+type CampaignResponseDTO struct {
+	Id         string                `json:"id"`
+	Revision   int64                 `json:"revision"`
+	Name       string                `json:"name"`
+	StartsAt   time.Time             `json:"startsAt"`
+	EndsAt     time.Time             `json:"endsAt"`
+	Retailers  []string              `json:"retailers"`
+	Rules      []RuleSpecResponseDTO `json:"rules"`
+	Multiplier string                `json:"multiplier,omitempty"`
+	CreatedAt  time.Time             `json:"createdAt"`
+	UpdatedAt  time.Time             `json:"updatedAt"`
+}
+
+func NewCampaignResponseDTO(campaign *Campaign) CampaignResponseDTO {
+	// The rules were validated when the campaign was stored.
+	built, _ := campaign.PointRules()
+	rules := make([]RuleSpecResponseDTO, len(campaign.Rules))
+	for i, spec := range campaign.Rules {
+		var rule PointRule
+		if i < len(built) {
+			rule = built[i]
+		}
+		rules[i] = newRuleSpecResponseDTO(spec, rule)
+	}
+
+	retailers := campaign.Retailers
+	if retailers == nil {
+		retailers = []string{}
+	}
+
+	var multiplier string
+	if !campaign.Multiplier.IsZero() {
+		multiplier = campaign.Multiplier.String()
+	}
+
+	return CampaignResponseDTO{
+		Id:         campaign.Id.String(),
+		Revision:   campaign.Revision,
+		Name:       campaign.Name,
+		StartsAt:   campaign.StartsAt,
+		EndsAt:     campaign.EndsAt,
+		Retailers:  retailers,
+		Rules:      rules,
+		Multiplier: multiplier,
+		CreatedAt:  campaign.CreatedAt,
+		UpdatedAt:  campaign.UpdatedAt,
+	}
+}
+
+type CampaignListResponseDTO struct {
+	Campaigns []CampaignResponseDTO `json:"campaigns"`
+}
+
+func NewCampaignListResponseDTO(campaigns []*Campaign) CampaignListResponseDTO {
+	dtos := make([]CampaignResponseDTO, len(campaigns))
+	for i, campaign := range campaigns {
+		dtos[i] = NewCampaignResponseDTO(campaign)
+	}
+	return CampaignListResponseDTO{Campaigns: dtos}
+}
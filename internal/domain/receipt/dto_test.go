@@ -1,6 +1,9 @@
 package receipt
 
 import (
+	"errors"
+	validator "github.com/go-playground/validator/v10"
+	"reflect"
 	"testing"
 	"time"
 )
@@ -23,7 +26,7 @@ func TestCreateReceiptDTO_ToReceipt(t *testing.T) {
 		Total: "30.00",
 	}
 
-	gotReceipt, err := validDTO.ToReceipt()
+	gotReceipt, err := validDTO.ToReceipt(time.Local)
 	if err != nil {
 		t.Errorf("ToReceipt() error = %v", err)
 		return
@@ -54,6 +57,18 @@ func TestCreateReceiptDTO_ToReceipt(t *testing.T) {
 			t.Errorf("ToReceipt() item %d price = %v, want %v", i, item.Price.StringFixed(2), validDTO.Items[i].Price)
 		}
 	}
+	// A time skipped in the zone the receipt is placed in, for want of one
+	// of its own, is a violation of the purchase time.
+	newYork, err := ParseTimeZone("America/New_York")
+	if err != nil {
+		t.Fatalf("ParseTimeZone() error = %v", err)
+	}
+	skipped := validDTO
+	skipped.PurchaseDate, skipped.PurchaseTime = "2024-03-10", "02:30"
+	var validationErr *ValidationError
+	if _, err := skipped.ToReceipt(newYork); !errors.As(err, &validationErr) || validationErr.Violations[0].Field != "purchaseTime" {
+		t.Errorf("ToReceipt() of a skipped time error = %v, want a violation of purchaseTime", err)
+	}
 }
 
 func TestCreateReceiptDTO_Validate(t *testing.T) {
@@ -151,9 +166,205 @@ func TestCreateReceiptDTO_Validate(t *testing.T) {
 				Items:        tt.fields.Items,
 				Total:        tt.fields.Total,
 			}
-			if err := r.Validate(); (err != nil) != tt.wantErr {
+			err := r.Validate()
+			if (err != nil) != tt.wantErr {
 				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
 			}
+			if err != nil && !errors.Is(err, ErrInvalidInput) {
+				t.Errorf("Validate() error = %v, want ErrInvalidInput", err)
+			}
+		})
+	}
+}
+
+func TestNewReceiptResponseDTO(t *testing.T) {
+	dto := CreateReceiptDTO{
+		Retailer:     "Test Retailer",
+		PurchaseDate: "2023-01-01",
+		PurchaseTime: "15:04",
+		Items: []CreateItemDTO{
+			{
+				ShortDescription: "Test Item 1",
+				Price:            "10.00",
+			},
+			{
+				ShortDescription: "Test Item 2",
+				Price:            "20.50",
+			},
+		},
+		Total: "30.50",
+	}
+
+	rec, err := dto.ToReceipt(time.Local)
+	if err != nil {
+		t.Fatalf("ToReceipt() error = %v", err)
+	}
+
+	got := NewReceiptResponseDTO(rec)
+	if got.Id != rec.Id.String() {
+		t.Errorf("NewReceiptResponseDTO() id = %v, want %v", got.Id, rec.Id.String())
+	}
+
+	if got.PurchaseDate != dto.PurchaseDate || got.PurchaseTime != dto.PurchaseTime {
+		t.Errorf("NewReceiptResponseDTO() purchase = %v %v, want %v %v", got.PurchaseDate, got.PurchaseTime, dto.PurchaseDate, dto.PurchaseTime)
+	}
+
+	if got.Total != dto.Total {
+		t.Errorf("NewReceiptResponseDTO() total = %v, want %v", got.Total, dto.Total)
+	}
+
+	for i, item := range got.Items {
+		if item.Id != rec.Items[i].Id.String() {
+			t.Errorf("NewReceiptResponseDTO() item %d id = %v, want %v", i, item.Id, rec.Items[i].Id.String())
+		}
+
+		if item.Price != dto.Items[i].Price {
+			t.Errorf("NewReceiptResponseDTO() item %d price = %v, want %v", i, item.Price, dto.Items[i].Price)
+		}
+	}
+}
+
+func TestCreateReceiptDTO_ValidateViolations(t *testing.T) {
+	tests := []struct {
+		name   string
+		fields CreateReceiptDTO
+		want   []FieldViolation
+	}{
+		{
+			name: "bad item price",
+			fields: CreateReceiptDTO{
+				Retailer:     "Test Retailer",
+				PurchaseDate: "2023-01-01",
+				PurchaseTime: "15:04",
+				Items: []CreateItemDTO{
+					{
+						ShortDescription: "Test Item 1",
+						Price:            "10.00",
+					},
+					{
+						ShortDescription: "Test Item 2",
+						Price:            "blah",
+					},
+				},
+				Total: "30.00",
+			},
+			want: []FieldViolation{{Field: "items[1].price", Rule: "regexp"}},
+		},
+		{
+			name: "missing retailer and date",
+			fields: CreateReceiptDTO{
+				PurchaseTime: "15:04",
+				Items: []CreateItemDTO{
+					{
+						ShortDescription: "Test Item 1",
+						Price:            "10.00",
+					},
+				},
+				Total: "10.00",
+			},
+			want: []FieldViolation{{Field: "retailer", Rule: "required"}, {Field: "purchaseDate", Rule: "required"}},
+		},
+		{
+			name: "total mismatch",
+			fields: CreateReceiptDTO{
+				Retailer:     "Test Retailer",
+				PurchaseDate: "2023-01-01",
+				PurchaseTime: "15:04",
+				Items: []CreateItemDTO{
+					{
+						ShortDescription: "Test Item 1",
+						Price:            "10.00",
+					},
+				},
+				Total: "30.00",
+			},
+			want: []FieldViolation{{Field: "total", Rule: "sum"}},
+		},
+		{
+			name: "zero amounts",
+			fields: CreateReceiptDTO{
+				Retailer:     "Test Retailer",
+				PurchaseDate: "2023-01-01",
+				PurchaseTime: "15:04",
+				Items:        []CreateItemDTO{{ShortDescription: "Test Item 1", Price: "0.00"}},
+				Total:        "0.00",
+			},
+			want: []FieldViolation{{Field: "items[0].price", Rule: "positive"}, {Field: "total", Rule: "positive"}},
+		},
+		{
+			name: "unknown time zone",
+			fields: CreateReceiptDTO{
+				Retailer:     "Test Retailer",
+				PurchaseDate: "2023-01-01",
+				PurchaseTime: "15:04",
+				Items:        []CreateItemDTO{{ShortDescription: "Test Item 1", Price: "10.00"}},
+				Total:        "10.00",
+				TimeZone:     "Nowhere/Special",
+			},
+			want: []FieldViolation{{Field: "timeZone", Rule: "timezone"}},
+		},
+		{
+			name: "purchase time skipped by daylight saving",
+			fields: CreateReceiptDTO{
+				Retailer:     "Test Retailer",
+				PurchaseDate: "2024-03-10",
+				PurchaseTime: "02:30",
+				Items:        []CreateItemDTO{{ShortDescription: "Test Item 1", Price: "10.00"}},
+				Total:        "10.00",
+				TimeZone:     "America/New_York",
+			},
+			want: []FieldViolation{{Field: "purchaseTime", Rule: "exists"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.fields.Validate()
+			var validationErr *ValidationError
+			if !errors.As(err, &validationErr) {
+				t.Fatalf("Validate() error = %v, want *ValidationError", err)
+			}
+			if len(validationErr.Violations) != len(tt.want) {
+				t.Fatalf("Validate() violations = %v, want %v", validationErr.Violations, tt.want)
+			}
+			for i, want := range tt.want {
+				got := validationErr.Violations[i]
+				if got.Field != want.Field || got.Rule != want.Rule {
+					t.Errorf("Validate() violation %d = %v/%v, want %v/%v", i, got.Field, got.Rule, want.Field, want.Rule)
+				}
+				if got.Message == "" {
+					t.Errorf("Validate() violation %d has no message", i)
+				}
+			}
 		})
 	}
 }
+
+func TestViolationMessage_Gt(t *testing.T) {
+	var fields struct {
+		Name   string   `json:"name" validate:"gt=4"`
+		Count  int      `json:"count" validate:"gt=4"`
+		Labels []string `json:"labels" validate:"gt=4"`
+	}
+	validate := validator.New()
+	validate.RegisterTagNameFunc(func(field reflect.StructField) string {
+		return field.Tag.Get("json")
+	})
+	var validationErrors validator.ValidationErrors
+	if err := validate.Struct(fields); !errors.As(err, &validationErrors) {
+		t.Fatalf("Struct() error = %v, want ValidationErrors", err)
+	}
+
+	want := map[string]string{
+		"name":   "must be longer than 4 characters",
+		"count":  "must be greater than 4",
+		"labels": "must contain more than 4 entries",
+	}
+	for _, fe := range validationErrors {
+		if got := violationMessage(fe); got != want[fe.Field()] {
+			t.Errorf("violationMessage(%s) = %q, want %q", fe.Field(), got, want[fe.Field()])
+		}
+	}
+	if len(validationErrors) != len(want) {
+		t.Errorf("Struct() reported %d violations, want %d", len(validationErrors), len(want))
+	}
+}
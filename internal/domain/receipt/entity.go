@@ -10,9 +10,28 @@ type Receipt struct {
 	Id               uuid.UUID
 	Retailer         string
 	PurchaseDateTime time.Time
-	Items            []Item
-	Total            decimal.Decimal
-	Points           int64
+	// TimeZone names the store's time zone, as ParseTimeZone accepts it.
+	// PurchaseDateTime is in that zone, whose wall clock the time-based
+	// rules read; storage that keeps only the instant restores it from
+	// TimeZone.
+	TimeZone string
+	Items    []Item
+	Total    decimal.Decimal
+	Points   int64
+	// RulesetVersion is the version of the ruleset that calculated Points.
+	RulesetVersion string
+	// PointsBreakdown is the per-rule result captured when Points was
+	// calculated, so it reflects the rules in effect at scoring time.
+	PointsBreakdown []RuleResult
+	// Campaigns are the campaigns that applied when Points was calculated,
+	// as they were then, so that the points can be reproduced after a
+	// campaign is changed or deleted.
+	Campaigns []Campaign
+	CreatedAt time.Time
+	// Version counts the writes of the stored receipt, starting at 1. The
+	// repository sets it; Update only replaces a receipt still at the
+	// Version it is given, unless it is 0.
+	Version int64
 }
 
 type Item struct {
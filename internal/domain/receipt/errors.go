@@ -0,0 +1,73 @@
+package receipt
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Errors returned by the receipt service and by every Repository
+// implementation. Callers should match them with errors.Is; the returned
+// errors wrap them with more detail.
+var (
+	// ErrNotFound means the requested receipt, or another resource it
+	// refers to, does not exist.
+	ErrNotFound = errors.New("not found")
+	// ErrInvalidInput means the request was rejected because it is malformed
+	// or fails validation.
+	ErrInvalidInput = errors.New("invalid input")
+	// ErrConflict means the request conflicts with the current state of the
+	// receipt, such as creating a receipt whose ID is already taken.
+	ErrConflict = errors.New("conflict")
+	// ErrUnavailable means the storage backend cannot serve the request right
+	// now and the request may be retried.
+	ErrUnavailable = errors.New("unavailable")
+)
+
+// VersionConflictError reports that a receipt was written by someone else
+// since the version an update was based on. It matches ErrConflict.
+type VersionConflictError struct {
+	Id string
+	// Expected is the version the update was based on, and Actual the
+	// version stored, or 0 if it is not known.
+	Expected int64
+	Actual   int64
+}
+
+func (e *VersionConflictError) Error() string {
+	if e.Actual == 0 {
+		return fmt.Sprintf("receipt %s: %s: it changed since version %d", e.Id, ErrConflict, e.Expected)
+	}
+	return fmt.Sprintf("receipt %s: %s: it is at version %d, not %d", e.Id, ErrConflict, e.Actual, e.Expected)
+}
+
+func (e *VersionConflictError) Unwrap() error {
+	return ErrConflict
+}
+
+// FieldViolation describes why a single field failed validation. Field is
+// the JSON path of the field, such as items[0].price, and Rule names the
+// check that failed.
+type FieldViolation struct {
+	Field   string
+	Rule    string
+	Message string
+}
+
+// ValidationError lists every field that failed validation. It matches
+// ErrInvalidInput.
+type ValidationError struct {
+	Violations []FieldViolation
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		messages[i] = v.Field + " " + v.Message
+	}
+	return ErrInvalidInput.Error() + ": " + strings.Join(messages, "; ")
+}
+
+func (e *ValidationError) Unwrap() error {
+	return ErrInvalidInput
+}
@@ -0,0 +1,357 @@
+package expr
+
+import (
+	"fmt"
+	"github.com/shopspring/decimal"
+	"strings"
+)
+
+// env is what an expression is evaluated against: the program's scope and,
+// inside the items functions, the current item.
+type env struct {
+	scope Scope
+	item  Scope
+}
+
+type node interface {
+	typ() Type
+	pos() Pos
+	eval(e env) (Value, error)
+}
+
+type literal struct {
+	v Value
+	p Pos
+}
+
+func (n *literal) typ() Type { return n.v.typ }
+func (n *literal) pos() Pos  { return n.p }
+
+func (n *literal) eval(env) (Value, error) {
+	return n.v, nil
+}
+
+type variable struct {
+	name string
+	t    Type
+	item bool
+	p    Pos
+}
+
+func (n *variable) typ() Type { return n.t }
+func (n *variable) pos() Pos  { return n.p }
+
+func (n *variable) eval(e env) (Value, error) {
+	scope := e.scope
+	if n.item {
+		scope = e.item
+	}
+	v := scope.Lookup(n.name)
+	if v.typ != n.t {
+		return Value{}, &Error{Pos: n.p, Msg: fmt.Sprintf("%s is a %s, the scope supplied a %s", n.name, n.t, v.typ)}
+	}
+	return v, nil
+}
+
+type unary struct {
+	op string
+	x  node
+	p  Pos
+}
+
+func newUnary(op token, x node) (node, error) {
+	want := Number
+	if op.text == "not" {
+		want = Bool
+	}
+	if x.typ() != want {
+		return nil, &Error{Pos: op.pos, Msg: fmt.Sprintf("%s needs a %s, found a %s", op.text, want, x.typ())}
+	}
+	return &unary{op: op.text, x: x, p: op.pos}, nil
+}
+
+func (n *unary) typ() Type { return n.x.typ() }
+func (n *unary) pos() Pos  { return n.p }
+
+func (n *unary) eval(e env) (Value, error) {
+	x, err := n.x.eval(e)
+	if err != nil {
+		return Value{}, err
+	}
+	if n.op == "not" {
+		return BoolValue(!x.b), nil
+	}
+	return NumberValue(x.num.Neg()), nil
+}
+
+type binary struct {
+	op    string
+	left  node
+	right node
+	t     Type
+	p     Pos
+}
+
+func newBinary(op token, left, right node) (node, error) {
+	mismatch := func(want string) error {
+		return &Error{Pos: op.pos, Msg: fmt.Sprintf("%s needs %s, found a %s and a %s", op.text, want, left.typ(), right.typ())}
+	}
+
+	var t Type
+	switch op.text {
+	case "and", "or":
+		if left.typ() != Bool || right.typ() != Bool {
+			return nil, mismatch("two bools")
+		}
+		t = Bool
+	case "+":
+		if left.typ() != right.typ() || left.typ() == Bool {
+			return nil, mismatch("two numbers or two strings")
+		}
+		t = left.typ()
+	case "-", "*", "/", "%":
+		if left.typ() != Number || right.typ() != Number {
+			return nil, mismatch("two numbers")
+		}
+		t = Number
+	case "<", "<=", ">", ">=":
+		if left.typ() != right.typ() || left.typ() == Bool {
+			return nil, mismatch("two numbers or two strings")
+		}
+		t = Bool
+	case "==", "!=":
+		if left.typ() != right.typ() {
+			return nil, mismatch("two values of the same type")
+		}
+		t = Bool
+	case "contains", "startswith", "endswith":
+		if left.typ() != String || right.typ() != String {
+			return nil, mismatch("two strings")
+		}
+		t = Bool
+	default:
+		return nil, &Error{Pos: op.pos, Msg: fmt.Sprintf("unknown operator %s", op.text)}
+	}
+	return &binary{op: op.text, left: left, right: right, t: t, p: op.pos}, nil
+}
+
+func (n *binary) typ() Type { return n.t }
+func (n *binary) pos() Pos  { return n.left.pos() }
+
+func (n *binary) eval(e env) (Value, error) {
+	left, err := n.left.eval(e)
+	if err != nil {
+		return Value{}, err
+	}
+
+	// and and or short-circuit.
+	switch {
+	case n.op == "and" && !left.b:
+		return BoolValue(false), nil
+	case n.op == "or" && left.b:
+		return BoolValue(true), nil
+	}
+
+	right, err := n.right.eval(e)
+	if err != nil {
+		return Value{}, err
+	}
+
+	switch n.op {
+	case "and", "or":
+		return right, nil
+	case "+":
+		if left.typ == String {
+			return StringValue(left.str + right.str), nil
+		}
+		return NumberValue(left.num.Add(right.num)), nil
+	case "-":
+		return NumberValue(left.num.Sub(right.num)), nil
+	case "*":
+		return NumberValue(left.num.Mul(right.num)), nil
+	case "/":
+		if right.num.IsZero() {
+			return Value{}, &Error{Pos: n.p, Msg: "division by zero"}
+		}
+		return NumberValue(left.num.DivRound(right.num, divisionPrecision)), nil
+	case "%":
+		if right.num.IsZero() {
+			return Value{}, &Error{Pos: n.p, Msg: "modulo by zero"}
+		}
+		return NumberValue(left.num.Mod(right.num)), nil
+	case "==":
+		return BoolValue(equal(left, right)), nil
+	case "!=":
+		return BoolValue(!equal(left, right)), nil
+	case "<", "<=", ">", ">=":
+		c := compare(left, right)
+		switch n.op {
+		case "<":
+			return BoolValue(c < 0), nil
+		case "<=":
+			return BoolValue(c <= 0), nil
+		case ">":
+			return BoolValue(c > 0), nil
+		default:
+			return BoolValue(c >= 0), nil
+		}
+	case "contains":
+		return BoolValue(strings.Contains(left.str, right.str)), nil
+	case "startswith":
+		return BoolValue(strings.HasPrefix(left.str, right.str)), nil
+	default:
+		return BoolValue(strings.HasSuffix(left.str, right.str)), nil
+	}
+}
+
+func equal(a, b Value) bool {
+	switch a.typ {
+	case Number:
+		return a.num.Equal(b.num)
+	case String:
+		return a.str == b.str
+	default:
+		return a.b == b.b
+	}
+}
+
+func compare(a, b Value) int {
+	if a.typ == String {
+		return strings.Compare(a.str, b.str)
+	}
+	return a.num.Cmp(b.num)
+}
+
+type function struct {
+	params []Type
+	result Type
+	apply  func(args []Value) Value
+}
+
+var functions = map[string]function{
+	"round": numberFunction(func(d decimal.Decimal) decimal.Decimal { return d.Round(0) }),
+	"floor": numberFunction(decimal.Decimal.Floor),
+	"ceil":  numberFunction(decimal.Decimal.Ceil),
+	"abs":   numberFunction(decimal.Decimal.Abs),
+	"min": {
+		params: []Type{Number, Number},
+		result: Number,
+		apply:  func(args []Value) Value { return NumberValue(decimal.Min(args[0].num, args[1].num)) },
+	},
+	"max": {
+		params: []Type{Number, Number},
+		result: Number,
+		apply:  func(args []Value) Value { return NumberValue(decimal.Max(args[0].num, args[1].num)) },
+	},
+	"len": {
+		params: []Type{String},
+		result: Number,
+		apply:  func(args []Value) Value { return NumberValue(decimal.NewFromInt(int64(len([]rune(args[0].str))))) },
+	},
+	"lower": stringFunction(strings.ToLower),
+	"upper": stringFunction(strings.ToUpper),
+	"trim":  stringFunction(strings.TrimSpace),
+}
+
+func numberFunction(f func(decimal.Decimal) decimal.Decimal) function {
+	return function{
+		params: []Type{Number},
+		result: Number,
+		apply:  func(args []Value) Value { return NumberValue(f(args[0].num)) },
+	}
+}
+
+func stringFunction(f func(string) string) function {
+	return function{
+		params: []Type{String},
+		result: String,
+		apply:  func(args []Value) Value { return StringValue(f(args[0].str)) },
+	}
+}
+
+type call struct {
+	name string
+	fn   function
+	args []node
+	p    Pos
+}
+
+func (n *call) typ() Type { return n.fn.result }
+func (n *call) pos() Pos  { return n.p }
+
+func (n *call) eval(e env) (Value, error) {
+	args := make([]Value, len(n.args))
+	for i, arg := range n.args {
+		v, err := arg.eval(e)
+		if err != nil {
+			return Value{}, err
+		}
+		args[i] = v
+	}
+	return n.fn.apply(args), nil
+}
+
+type aggregateKind struct {
+	arg         Type
+	argOptional bool
+	result      Type
+}
+
+var aggregates = map[string]aggregateKind{
+	"count": {arg: Bool, argOptional: true, result: Number},
+	"sum":   {arg: Number, result: Number},
+	"any":   {arg: Bool, result: Bool},
+	"all":   {arg: Bool, result: Bool},
+}
+
+type aggregate struct {
+	name string
+	arg  node
+	t    Type
+	p    Pos
+}
+
+func (n *aggregate) typ() Type { return n.t }
+func (n *aggregate) pos() Pos  { return n.p }
+
+func (n *aggregate) eval(e env) (Value, error) {
+	items := e.scope.Items()
+	if n.arg == nil {
+		return NumberValue(decimal.NewFromInt(int64(len(items)))), nil
+	}
+
+	count, sum := int64(0), decimal.Zero
+	for _, item := range items {
+		v, err := n.arg.eval(env{scope: e.scope, item: item})
+		if err != nil {
+			return Value{}, err
+		}
+		switch n.name {
+		case "sum":
+			sum = sum.Add(v.num)
+		case "any":
+			if v.b {
+				return BoolValue(true), nil
+			}
+		case "all":
+			if !v.b {
+				return BoolValue(false), nil
+			}
+		default:
+			if v.b {
+				count++
+			}
+		}
+	}
+
+	switch n.name {
+	case "sum":
+		return NumberValue(sum), nil
+	case "any":
+		return BoolValue(false), nil
+	case "all":
+		return BoolValue(true), nil
+	default:
+		return NumberValue(decimal.NewFromInt(count)), nil
+	}
+}
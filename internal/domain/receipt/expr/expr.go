@@ -0,0 +1,231 @@
+// Package expr implements a small expression language for point rules.
+//
+// A program assigns points, optionally under a condition:
+//
+//	points = 15 if total > 50 and retailer contains "Target"
+//	points = floor(sum(items, price) * 0.1)
+//
+// Programs are parsed and type checked once by Compile against a Schema
+// declaring the variables they may read. They cannot loop, define
+// functions or reach anything but those variables, and all arithmetic is
+// done with decimals, so evaluation is deterministic and always
+// terminates.
+//
+// The language has numbers, strings and booleans. Operators, from lowest
+// to highest precedence, are: or; and; not; the comparisons ==, !=, <,
+// <=, >, >=, contains, startswith and endswith; + and -; *, / and %; and
+// unary minus. The built-in functions are:
+//
+//	round(n) floor(n) ceil(n) abs(n) min(a, b) max(a, b)
+//	len(s) lower(s) upper(s) trim(s)
+//	count(items) count(items, cond) sum(items, n) any(items, cond) all(items, cond)
+//
+// The second argument of the items functions is evaluated once per item,
+// where the schema's item variables are also in scope.
+package expr
+
+import (
+	"fmt"
+	"github.com/shopspring/decimal"
+	"sort"
+)
+
+const (
+	// MaxLength bounds the length of a program's source.
+	MaxLength = 4096
+	// maxDepth bounds the nesting of expressions.
+	maxDepth = 64
+	// divisionPrecision is the number of decimal places kept by division.
+	divisionPrecision = 16
+)
+
+type Type int
+
+const (
+	Number Type = iota + 1
+	String
+	Bool
+)
+
+func (t Type) String() string {
+	switch t {
+	case Number:
+		return "number"
+	case String:
+		return "string"
+	case Bool:
+		return "bool"
+	default:
+		return "unknown"
+	}
+}
+
+// Value is a typed value of the language.
+type Value struct {
+	typ Type
+	num decimal.Decimal
+	str string
+	b   bool
+}
+
+func NumberValue(d decimal.Decimal) Value {
+	return Value{typ: Number, num: d}
+}
+
+func StringValue(s string) Value {
+	return Value{typ: String, str: s}
+}
+
+func BoolValue(b bool) Value {
+	return Value{typ: Bool, b: b}
+}
+
+func (v Value) Type() Type {
+	return v.typ
+}
+
+func (v Value) String() string {
+	switch v.typ {
+	case Number:
+		return v.num.String()
+	case String:
+		return v.str
+	case Bool:
+		return fmt.Sprint(v.b)
+	default:
+		return ""
+	}
+}
+
+// Schema declares the variables a program may read and their types.
+// ItemVars are only in scope inside the items functions.
+type Schema struct {
+	Vars     map[string]Type
+	ItemVars map[string]Type
+}
+
+// Scope supplies the values of the schema's variables during evaluation.
+type Scope interface {
+	Lookup(name string) Value
+	// Items returns one scope per item, each supplying the item variables.
+	Items() []Scope
+}
+
+// Pos is a position in the source, counting from 1.
+type Pos struct {
+	Line int
+	Col  int
+}
+
+func (p Pos) String() string {
+	return fmt.Sprintf("%d:%d", p.Line, p.Col)
+}
+
+// Error is a compile or evaluation error at a position in the source.
+type Error struct {
+	Pos Pos
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Pos, e.Msg)
+}
+
+// Program is a compiled, type checked program.
+type Program struct {
+	source    string
+	value     node
+	condition node
+	vars      map[string]bool
+	itemVars  map[string]bool
+}
+
+// Compile parses and type checks src against the schema.
+func Compile(src string, schema Schema) (*Program, error) {
+	if len(src) > MaxLength {
+		return nil, &Error{Pos: Pos{Line: 1, Col: 1}, Msg: fmt.Sprintf("program is longer than %d characters", MaxLength)}
+	}
+
+	p, err := newParser(src, schema)
+	if err != nil {
+		return nil, err
+	}
+	return p.parseProgram(src)
+}
+
+// CompileCondition parses and type checks src as a bare condition, such as
+//
+//	retailer == "Target" and total > 100
+//
+// The resulting program's Eval returns 1 when the condition holds and 0
+// when it does not.
+func CompileCondition(src string, schema Schema) (*Program, error) {
+	if len(src) > MaxLength {
+		return nil, &Error{Pos: Pos{Line: 1, Col: 1}, Msg: fmt.Sprintf("condition is longer than %d characters", MaxLength)}
+	}
+
+	p, err := newParser(src, schema)
+	if err != nil {
+		return nil, err
+	}
+	return p.parseCondition(src)
+}
+
+func newParser(src string, schema Schema) (*parser, error) {
+	tokens, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+
+	return &parser{
+		tokens:   tokens,
+		schema:   schema,
+		vars:     make(map[string]bool),
+		itemVars: make(map[string]bool),
+	}, nil
+}
+
+// Eval returns the points the program assigns, which are zero when its
+// condition does not hold.
+func (p *Program) Eval(scope Scope) (decimal.Decimal, error) {
+	e := env{scope: scope}
+	if p.condition != nil {
+		ok, err := p.condition.eval(e)
+		if err != nil {
+			return decimal.Zero, err
+		}
+		if !ok.b {
+			return decimal.Zero, nil
+		}
+	}
+
+	v, err := p.value.eval(e)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	return v.num, nil
+}
+
+// Vars returns the names of the variables the program reads, sorted.
+func (p *Program) Vars() []string {
+	return sortedKeys(p.vars)
+}
+
+// ItemVars returns the names of the item variables the program reads,
+// sorted.
+func (p *Program) ItemVars() []string {
+	return sortedKeys(p.itemVars)
+}
+
+func (p *Program) String() string {
+	return p.source
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
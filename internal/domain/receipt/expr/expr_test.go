@@ -0,0 +1,317 @@
+package expr
+
+import (
+	"errors"
+	"github.com/shopspring/decimal"
+	"strings"
+	"testing"
+)
+
+type testScope struct {
+	vars  map[string]Value
+	items []Scope
+}
+
+func (s *testScope) Lookup(name string) Value {
+	return s.vars[name]
+}
+
+func (s *testScope) Items() []Scope {
+	return s.items
+}
+
+var testSchema = Schema{
+	Vars: map[string]Type{
+		"retailer": String,
+		"total":    Number,
+		"hour":     Number,
+	},
+	ItemVars: map[string]Type{
+		"description": String,
+		"price":       Number,
+	},
+}
+
+func newTestScope() *testScope {
+	item := func(description, price string) Scope {
+		return &testScope{vars: map[string]Value{
+			"description": StringValue(description),
+			"price":       NumberValue(decimal.RequireFromString(price)),
+		}}
+	}
+	return &testScope{
+		vars: map[string]Value{
+			"retailer": StringValue("Target Store"),
+			"total":    NumberValue(decimal.RequireFromString("55.50")),
+			"hour":     NumberValue(decimal.NewFromInt(15)),
+		},
+		items: []Scope{
+			item("Mountain Dew 12PK", "6.49"),
+			item("Emils Cheese Pizza", "12.25"),
+			item("Gatorade", "36.76"),
+		},
+	}
+}
+
+func TestProgram_Eval(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{
+			name: "condition holds",
+			src:  `points = 15 if total > 50 and retailer contains "Target"`,
+			want: "15",
+		},
+		{
+			name: "condition does not hold",
+			src:  `points = 15 if total > 50 and retailer startswith "Walgreens"`,
+			want: "0",
+		},
+		{
+			name: "precedence",
+			src:  `points = 1 + 2 * 3 - -4 % 3`,
+			want: "8",
+		},
+		{
+			name: "parentheses",
+			src:  `points = (1 + 2) * 3`,
+			want: "9",
+		},
+		{
+			name: "decimal math",
+			src:  `points = total * 0.1`,
+			want: "5.55",
+		},
+		{
+			name: "division",
+			src:  `points = 1 / 3 * 3`,
+			want: "0.9999999999999999",
+		},
+		{
+			name: "functions",
+			src:  `points = ceil(total / 10) + len(upper(trim("  ab  "))) + max(1, min(5, 3))`,
+			want: "11",
+		},
+		{
+			name: "sum and count",
+			src:  `points = floor(sum(items, price)) + count(items) + count(items, description contains "Pizza")`,
+			want: "59",
+		},
+		{
+			name: "any and all",
+			src:  `points = 10 if any(items, price > 30) and not all(items, lower(description) contains "dew")`,
+			want: "10",
+		},
+		{
+			name: "item scope sees receipt variables",
+			src:  `points = count(items, price * 5 > total)`,
+			want: "2",
+		},
+		{
+			name: "or short-circuits",
+			src:  `points = 1 if hour == 15 or 1 / 0 > 0`,
+			want: "1",
+		},
+		{
+			name: "string concatenation and comparison",
+			src:  `points = 2 if retailer + "!" == "Target Store!" and "a" < "b"`,
+			want: "2",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			program, err := Compile(tt.src, testSchema)
+			if err != nil {
+				t.Fatalf("Compile() error = %v", err)
+			}
+			got, err := program.Eval(newTestScope())
+			if err != nil {
+				t.Fatalf("Eval() error = %v", err)
+			}
+			if !got.Equal(decimal.RequireFromString(tt.want)) {
+				t.Errorf("Eval() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompile_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		wantPos Pos
+		wantMsg string
+	}{
+		{
+			name:    "missing points",
+			src:     `15 if total > 50`,
+			wantPos: Pos{Line: 1, Col: 1},
+			wantMsg: `expected "points"`,
+		},
+		{
+			name:    "unknown variable",
+			src:     `points = 15 if totl > 50`,
+			wantPos: Pos{Line: 1, Col: 16},
+			wantMsg: "unknown variable totl",
+		},
+		{
+			name:    "type mismatch",
+			src:     `points = 15 if total > "50"`,
+			wantPos: Pos{Line: 1, Col: 22},
+			wantMsg: "> needs two numbers or two strings",
+		},
+		{
+			name:    "condition not bool",
+			src:     `points = 15 if total`,
+			wantPos: Pos{Line: 1, Col: 16},
+			wantMsg: "the condition must be a bool",
+		},
+		{
+			name:    "points not number",
+			src:     `points = retailer`,
+			wantPos: Pos{Line: 1, Col: 10},
+			wantMsg: "points must be a number",
+		},
+		{
+			name:    "item variable outside items",
+			src:     "points = 1\n  if price > 5",
+			wantPos: Pos{Line: 2, Col: 6},
+			wantMsg: "price is an item variable",
+		},
+		{
+			name:    "nested items function",
+			src:     `points = sum(items, count(items))`,
+			wantPos: Pos{Line: 1, Col: 21},
+			wantMsg: "cannot be used inside another items function",
+		},
+		{
+			name:    "unknown function",
+			src:     `points = sqrt(total)`,
+			wantPos: Pos{Line: 1, Col: 10},
+			wantMsg: "unknown function sqrt",
+		},
+		{
+			name:    "wrong argument count",
+			src:     `points = min(total)`,
+			wantPos: Pos{Line: 1, Col: 10},
+			wantMsg: "min takes 2 arguments",
+		},
+		{
+			name:    "unterminated string",
+			src:     `points = 1 if retailer == "Target`,
+			wantPos: Pos{Line: 1, Col: 27},
+			wantMsg: "unterminated string",
+		},
+		{
+			name:    "trailing tokens",
+			src:     `points = 1 1`,
+			wantPos: Pos{Line: 1, Col: 12},
+			wantMsg: `unexpected "1"`,
+		},
+		{
+			name:    "unexpected character",
+			src:     `points = 1 if total > 5 && hour > 3`,
+			wantPos: Pos{Line: 1, Col: 25},
+			wantMsg: `unexpected character '&'`,
+		},
+		{
+			name:    "non-ASCII digit",
+			src:     `points = ٣ if total > 1`,
+			wantPos: Pos{Line: 1, Col: 10},
+			wantMsg: `unexpected character '٣'`,
+		},
+		{
+			name:    "non-ASCII digit in a number",
+			src:     `points = 1٣`,
+			wantPos: Pos{Line: 1, Col: 11},
+			wantMsg: `unexpected character '٣'`,
+		},
+		{
+			name:    "too deep",
+			src:     "points = " + strings.Repeat("(", maxDepth+1) + "1" + strings.Repeat(")", maxDepth+1),
+			wantMsg: "nested too deeply",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Compile(tt.src, testSchema)
+			var exprErr *Error
+			if !errors.As(err, &exprErr) {
+				t.Fatalf("Compile() error = %v, want *Error", err)
+			}
+			if !strings.Contains(exprErr.Msg, tt.wantMsg) {
+				t.Errorf("Compile() error = %v, want %q", exprErr, tt.wantMsg)
+			}
+			if tt.wantPos != (Pos{}) && exprErr.Pos != tt.wantPos {
+				t.Errorf("Compile() error position = %v, want %v", exprErr.Pos, tt.wantPos)
+			}
+		})
+	}
+}
+
+func TestProgram_EvalErrors(t *testing.T) {
+	program, err := Compile(`points = total / (hour - 15)`, testSchema)
+	if err != nil {
+		t.Fatalf("Compile() error = %v", err)
+	}
+
+	_, err = program.Eval(newTestScope())
+	var exprErr *Error
+	if !errors.As(err, &exprErr) || exprErr.Msg != "division by zero" {
+		t.Errorf("Eval() error = %v, want division by zero", err)
+	}
+	if exprErr != nil && exprErr.Pos != (Pos{Line: 1, Col: 16}) {
+		t.Errorf("Eval() error position = %v, want 1:16", exprErr.Pos)
+	}
+}
+
+func TestProgram_Vars(t *testing.T) {
+	program, err := Compile(`points = sum(items, price) if total > 5 and retailer != ""`, testSchema)
+	if err != nil {
+		t.Fatalf("Compile() error = %v", err)
+	}
+
+	if got := strings.Join(program.Vars(), ","); got != "retailer,total" {
+		t.Errorf("Vars() = %v, want retailer,total", got)
+	}
+	if got := strings.Join(program.ItemVars(), ","); got != "price" {
+		t.Errorf("ItemVars() = %v, want price", got)
+	}
+}
+
+func TestCompileCondition(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		want    string
+		wantErr string
+	}{
+		{name: "holds", src: `retailer contains "Target" and total > 50`, want: "1"},
+		{name: "does not hold", src: `any(items, price > 40)`, want: "0"},
+		{name: "not a condition", src: `total * 2`, wantErr: "1:1: the condition must be a bool, found a number"},
+		{name: "a program", src: `points = 5`, wantErr: `1:1: unexpected "points"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			program, err := CompileCondition(tt.src, testSchema)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Errorf("CompileCondition() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("CompileCondition() error = %v", err)
+			}
+			got, err := program.Eval(newTestScope())
+			if err != nil {
+				t.Fatalf("Eval() error = %v", err)
+			}
+			if got.String() != tt.want {
+				t.Errorf("Eval() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package expr
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenNumber
+	tokenString
+	tokenIdent
+	tokenKeyword
+	tokenOperator
+)
+
+var keywords = map[string]bool{
+	"points":     true,
+	"if":         true,
+	"and":        true,
+	"or":         true,
+	"not":        true,
+	"true":       true,
+	"false":      true,
+	"contains":   true,
+	"startswith": true,
+	"endswith":   true,
+}
+
+// operators are matched longest first.
+var operators = []string{"==", "!=", "<=", ">=", "<", ">", "+", "-", "*", "/", "%", "(", ")", ",", "="}
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  Pos
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokenEOF:
+		return "end of expression"
+	case tokenString:
+		return fmt.Sprintf("string %q", t.text)
+	default:
+		return fmt.Sprintf("%q", t.text)
+	}
+}
+
+// lex splits src into tokens, ending with a tokenEOF.
+func lex(src string) ([]token, error) {
+	var tokens []token
+	runes := []rune(src)
+	line, col := 1, 1
+	i := 0
+
+	advance := func() {
+		if runes[i] == '\n' {
+			line++
+			col = 1
+		} else {
+			col++
+		}
+		i++
+	}
+
+	for {
+		for i < len(runes) && unicode.IsSpace(runes[i]) {
+			advance()
+		}
+		pos := Pos{Line: line, Col: col}
+		if i >= len(runes) {
+			return append(tokens, token{kind: tokenEOF, pos: pos}), nil
+		}
+
+		r := runes[i]
+		switch {
+		case isDigit(r):
+			start := i
+			for i < len(runes) && isDigit(runes[i]) {
+				advance()
+			}
+			if i < len(runes) && runes[i] == '.' {
+				advance()
+				if i >= len(runes) || !isDigit(runes[i]) {
+					return nil, &Error{Pos: pos, Msg: "a decimal point must be followed by digits"}
+				}
+				for i < len(runes) && isDigit(runes[i]) {
+					advance()
+				}
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: string(runes[start:i]), pos: pos})
+
+		case r == '_' || unicode.IsLetter(r):
+			start := i
+			for i < len(runes) && (runes[i] == '_' || unicode.IsLetter(runes[i]) || isDigit(runes[i])) {
+				advance()
+			}
+			text := string(runes[start:i])
+			kind := tokenIdent
+			if keywords[text] {
+				kind = tokenKeyword
+			}
+			tokens = append(tokens, token{kind: kind, text: text, pos: pos})
+
+		case r == '"':
+			advance()
+			var sb strings.Builder
+			for {
+				if i >= len(runes) || runes[i] == '\n' {
+					return nil, &Error{Pos: pos, Msg: "unterminated string"}
+				}
+				c := runes[i]
+				advance()
+				if c == '"' {
+					break
+				}
+				if c == '\\' {
+					if i >= len(runes) {
+						return nil, &Error{Pos: pos, Msg: "unterminated string"}
+					}
+					escapePos := Pos{Line: line, Col: col - 1}
+					switch runes[i] {
+					case '"', '\\':
+						sb.WriteRune(runes[i])
+					case 'n':
+						sb.WriteRune('\n')
+					case 't':
+						sb.WriteRune('\t')
+					default:
+						return nil, &Error{Pos: escapePos, Msg: fmt.Sprintf("unknown escape sequence \\%c", runes[i])}
+					}
+					advance()
+					continue
+				}
+				sb.WriteRune(c)
+			}
+			tokens = append(tokens, token{kind: tokenString, text: sb.String(), pos: pos})
+
+		default:
+			matched := false
+			for _, op := range operators {
+				if strings.HasPrefix(string(runes[i:min(i+len(op), len(runes))]), op) {
+					for range op {
+						advance()
+					}
+					tokens = append(tokens, token{kind: tokenOperator, text: op, pos: pos})
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, &Error{Pos: pos, Msg: fmt.Sprintf("unexpected character %q", r)}
+			}
+		}
+	}
+}
+
+// isDigit reports whether r is an ASCII digit. Numbers are decimals, which
+// other scripts' digits are not.
+func isDigit(r rune) bool {
+	return '0' <= r && r <= '9'
+}
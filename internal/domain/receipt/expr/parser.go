@@ -0,0 +1,387 @@
+package expr
+
+import (
+	"fmt"
+	"github.com/shopspring/decimal"
+)
+
+type parser struct {
+	tokens   []token
+	i        int
+	depth    int
+	inItems  bool
+	schema   Schema
+	vars     map[string]bool
+	itemVars map[string]bool
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.i]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.i]
+	if t.kind != tokenEOF {
+		p.i++
+	}
+	return t
+}
+
+// accept consumes the next token if it is the keyword or operator text.
+func (p *parser) accept(text string) (token, bool) {
+	t := p.peek()
+	if (t.kind == tokenKeyword || t.kind == tokenOperator) && t.text == text {
+		return p.next(), true
+	}
+	return t, false
+}
+
+func (p *parser) expect(text string) (token, error) {
+	t, ok := p.accept(text)
+	if !ok {
+		return t, &Error{Pos: t.pos, Msg: fmt.Sprintf("expected %q, found %s", text, t)}
+	}
+	return t, nil
+}
+
+func (p *parser) parseProgram(src string) (*Program, error) {
+	if _, err := p.expect("points"); err != nil {
+		return nil, err
+	}
+	if _, err := p.expect("="); err != nil {
+		return nil, err
+	}
+
+	value, err := p.parseTyped(Number, "points")
+	if err != nil {
+		return nil, err
+	}
+
+	var condition node
+	if _, ok := p.accept("if"); ok {
+		if condition, err = p.parseTyped(Bool, "the condition"); err != nil {
+			return nil, err
+		}
+	}
+
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, &Error{Pos: t.pos, Msg: fmt.Sprintf("unexpected %s", t)}
+	}
+
+	return &Program{
+		source:    src,
+		value:     value,
+		condition: condition,
+		vars:      p.vars,
+		itemVars:  p.itemVars,
+	}, nil
+}
+
+func (p *parser) parseCondition(src string) (*Program, error) {
+	condition, err := p.parseTyped(Bool, "the condition")
+	if err != nil {
+		return nil, err
+	}
+
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, &Error{Pos: t.pos, Msg: fmt.Sprintf("unexpected %s", t)}
+	}
+
+	return &Program{
+		source:    src,
+		value:     &literal{v: NumberValue(decimal.NewFromInt(1)), p: condition.pos()},
+		condition: condition,
+		vars:      p.vars,
+		itemVars:  p.itemVars,
+	}, nil
+}
+
+// parseTyped parses an expression that must have type want.
+func (p *parser) parseTyped(want Type, what string) (node, error) {
+	n, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if n.typ() != want {
+		return nil, &Error{Pos: n.pos(), Msg: fmt.Sprintf("%s must be a %s, found a %s", what, want, n.typ())}
+	}
+	return n, nil
+}
+
+func (p *parser) parseOr() (node, error) {
+	p.depth++
+	defer func() { p.depth-- }()
+	if p.depth > maxDepth {
+		return nil, &Error{Pos: p.peek().pos, Msg: "expression is nested too deeply"}
+	}
+
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.accept("or")
+		if !ok {
+			return left, nil
+		}
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		if left, err = newBinary(op, left, right); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.accept("and")
+		if !ok {
+			return left, nil
+		}
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		if left, err = newBinary(op, left, right); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *parser) parseNot() (node, error) {
+	op, ok := p.accept("not")
+	if !ok {
+		return p.parseComparison()
+	}
+
+	p.depth++
+	defer func() { p.depth-- }()
+	if p.depth > maxDepth {
+		return nil, &Error{Pos: op.pos, Msg: "expression is nested too deeply"}
+	}
+
+	x, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	return newUnary(op, x)
+}
+
+var comparisonOperators = []string{"==", "!=", "<=", ">=", "<", ">", "contains", "startswith", "endswith"}
+
+func (p *parser) parseComparison() (node, error) {
+	left, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	for _, text := range comparisonOperators {
+		if op, ok := p.accept(text); ok {
+			right, err := p.parseAdditive()
+			if err != nil {
+				return nil, err
+			}
+			return newBinary(op, left, right)
+		}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAdditive() (node, error) {
+	left, err := p.parseMultiplicative()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.accept("+")
+		if !ok {
+			op, ok = p.accept("-")
+		}
+		if !ok {
+			return left, nil
+		}
+		right, err := p.parseMultiplicative()
+		if err != nil {
+			return nil, err
+		}
+		if left, err = newBinary(op, left, right); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *parser) parseMultiplicative() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		var op token
+		var ok bool
+		for _, text := range []string{"*", "/", "%"} {
+			if op, ok = p.accept(text); ok {
+				break
+			}
+		}
+		if !ok {
+			return left, nil
+		}
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if left, err = newBinary(op, left, right); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *parser) parseUnary() (node, error) {
+	op, ok := p.accept("-")
+	if !ok {
+		return p.parsePrimary()
+	}
+
+	p.depth++
+	defer func() { p.depth-- }()
+	if p.depth > maxDepth {
+		return nil, &Error{Pos: op.pos, Msg: "expression is nested too deeply"}
+	}
+
+	x, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	return newUnary(op, x)
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenNumber:
+		n, err := decimal.NewFromString(t.text)
+		if err != nil {
+			return nil, &Error{Pos: t.pos, Msg: fmt.Sprintf("invalid number %s", t.text)}
+		}
+		return &literal{v: NumberValue(n), p: t.pos}, nil
+	case tokenString:
+		return &literal{v: StringValue(t.text), p: t.pos}, nil
+	case tokenKeyword:
+		switch t.text {
+		case "true":
+			return &literal{v: BoolValue(true), p: t.pos}, nil
+		case "false":
+			return &literal{v: BoolValue(false), p: t.pos}, nil
+		}
+	case tokenOperator:
+		if t.text == "(" {
+			n, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if _, err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			return n, nil
+		}
+	case tokenIdent:
+		if _, ok := p.accept("("); ok {
+			return p.parseCall(t)
+		}
+		return p.variable(t)
+	}
+	return nil, &Error{Pos: t.pos, Msg: fmt.Sprintf("unexpected %s", t)}
+}
+
+func (p *parser) variable(t token) (node, error) {
+	if p.inItems {
+		if typ, ok := p.schema.ItemVars[t.text]; ok {
+			p.itemVars[t.text] = true
+			return &variable{name: t.text, t: typ, item: true, p: t.pos}, nil
+		}
+	}
+	if typ, ok := p.schema.Vars[t.text]; ok {
+		p.vars[t.text] = true
+		return &variable{name: t.text, t: typ, p: t.pos}, nil
+	}
+	if _, ok := p.schema.ItemVars[t.text]; ok {
+		return nil, &Error{Pos: t.pos, Msg: fmt.Sprintf("%s is an item variable and can only be used inside count, sum, any or all", t.text)}
+	}
+	return nil, &Error{Pos: t.pos, Msg: fmt.Sprintf("unknown variable %s", t.text)}
+}
+
+func (p *parser) parseCall(name token) (node, error) {
+	if _, ok := aggregates[name.text]; ok {
+		return p.parseAggregate(name)
+	}
+
+	fn, ok := functions[name.text]
+	if !ok {
+		return nil, &Error{Pos: name.pos, Msg: fmt.Sprintf("unknown function %s", name.text)}
+	}
+
+	var args []node
+	if _, ok := p.accept(")"); !ok {
+		for {
+			arg, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+			if _, ok := p.accept(","); !ok {
+				break
+			}
+		}
+		if _, err := p.expect(")"); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(args) != len(fn.params) {
+		return nil, &Error{Pos: name.pos, Msg: fmt.Sprintf("%s takes %d arguments, found %d", name.text, len(fn.params), len(args))}
+	}
+	for i, arg := range args {
+		if arg.typ() != fn.params[i] {
+			return nil, &Error{Pos: arg.pos(), Msg: fmt.Sprintf("argument %d of %s must be a %s, found a %s", i+1, name.text, fn.params[i], arg.typ())}
+		}
+	}
+	return &call{name: name.text, fn: fn, args: args, p: name.pos}, nil
+}
+
+func (p *parser) parseAggregate(name token) (node, error) {
+	agg := aggregates[name.text]
+	if p.inItems {
+		return nil, &Error{Pos: name.pos, Msg: fmt.Sprintf("%s cannot be used inside another items function", name.text)}
+	}
+
+	items := p.next()
+	if items.kind != tokenIdent || items.text != "items" {
+		return nil, &Error{Pos: items.pos, Msg: fmt.Sprintf("the first argument of %s must be items, found %s", name.text, items)}
+	}
+
+	n := &aggregate{name: name.text, t: agg.result, p: name.pos}
+	if _, ok := p.accept(","); ok {
+		p.inItems = true
+		arg, err := p.parseOr()
+		p.inItems = false
+		if err != nil {
+			return nil, err
+		}
+		if arg.typ() != agg.arg {
+			return nil, &Error{Pos: arg.pos(), Msg: fmt.Sprintf("the second argument of %s must be a %s, found a %s", name.text, agg.arg, arg.typ())}
+		}
+		n.arg = arg
+	} else if !agg.argOptional {
+		return nil, &Error{Pos: p.peek().pos, Msg: fmt.Sprintf("%s takes a second argument", name.text)}
+	}
+
+	if _, err := p.expect(")"); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
@@ -0,0 +1,186 @@
+package receipt
+
+import (
+	"errors"
+	"fmt"
+	"github.com/shopspring/decimal"
+	"receipt-processor/internal/domain/receipt/expr"
+)
+
+// expressionSchema declares the receipt values an expression rule may read.
+var expressionSchema = expr.Schema{
+	Vars: map[string]expr.Type{
+		"retailer":     expr.String,
+		"total":        expr.Number,
+		"itemCount":    expr.Number,
+		"purchaseDate": expr.String,
+		"purchaseTime": expr.String,
+		"year":         expr.Number,
+		"month":        expr.Number,
+		"day":          expr.Number,
+		"hour":         expr.Number,
+		"minute":       expr.Number,
+		"weekday":      expr.String,
+	},
+	ItemVars: map[string]expr.Type{
+		"description": expr.String,
+		"price":       expr.Number,
+	},
+}
+
+// ExpressionRule awards the points computed by a program in the expression
+// language of package expr, such as
+//
+//	points = 15 if total > 50 and retailer contains "Target"
+//
+// Fractional points are rounded down unless the rule's rounding says
+// otherwise, and negative points count as zero. A
+// program that fails while scoring a receipt, for example by dividing by
+// zero, awards no points.
+type ExpressionRule struct {
+	Name       string `json:"description"`
+	Expression string `json:"expression"`
+	RoundingParam
+
+	program *expr.Program
+}
+
+// NewExpressionRule compiles expression into a rule. An empty description
+// describes the rule by its expression.
+func NewExpressionRule(description, expression string) (*ExpressionRule, error) {
+	rule := &ExpressionRule{Name: description, Expression: expression}
+	if err := rule.Validate(); err != nil {
+		return nil, err
+	}
+	return rule, nil
+}
+
+func (r *ExpressionRule) Calculate(receipt *Receipt) int64 {
+	return r.Rounding.or(RoundFloor).Round(r.ExactPoints(receipt))
+}
+
+func (r *ExpressionRule) ExactPoints(receipt *Receipt) decimal.Decimal {
+	points, err := r.eval(receipt)
+	if err != nil || points.IsNegative() {
+		return decimal.Zero
+	}
+	return points
+}
+
+func (r *ExpressionRule) Description() string {
+	if r.Name != "" {
+		return r.Name
+	}
+	return r.Expression
+}
+
+func (r *ExpressionRule) Inputs(receipt *Receipt) map[string]string {
+	if r.program == nil {
+		return nil
+	}
+
+	scope := newReceiptScope(receipt)
+	inputs := make(map[string]string)
+	for _, name := range r.program.Vars() {
+		inputs[name] = scope.Lookup(name).String()
+	}
+	for i, item := range scope.Items() {
+		for _, name := range r.program.ItemVars() {
+			inputs[fmt.Sprintf("items[%d].%s", i, name)] = item.Lookup(name).String()
+		}
+	}
+	if _, err := r.eval(receipt); err != nil {
+		inputs["error"] = err.Error()
+	}
+	return inputs
+}
+
+// Validate compiles the expression, which must be done before the rule
+// scores a receipt.
+func (r *ExpressionRule) Validate() error {
+	if r.Expression == "" {
+		return errors.New("expression is required")
+	}
+	if err := r.Rounding.Validate(); err != nil {
+		return err
+	}
+	program, err := expr.Compile(r.Expression, expressionSchema)
+	if err != nil {
+		return err
+	}
+	r.program = program
+	return nil
+}
+
+func (r *ExpressionRule) eval(receipt *Receipt) (decimal.Decimal, error) {
+	if r.program == nil {
+		return decimal.Zero, errors.New("expression has not been compiled")
+	}
+	return r.program.Eval(newReceiptScope(receipt))
+}
+
+// receiptScope supplies the values of expressionSchema from a receipt.
+type receiptScope struct {
+	receipt *Receipt
+}
+
+func newReceiptScope(receipt *Receipt) receiptScope {
+	return receiptScope{receipt: receipt}
+}
+
+func (s receiptScope) Lookup(name string) expr.Value {
+	t := s.receipt.PurchaseDateTime
+	switch name {
+	case "retailer":
+		return expr.StringValue(s.receipt.Retailer)
+	case "total":
+		return expr.NumberValue(s.receipt.Total)
+	case "itemCount":
+		return expr.NumberValue(decimal.NewFromInt(int64(len(s.receipt.Items))))
+	case "purchaseDate":
+		return expr.StringValue(t.Format("2006-01-02"))
+	case "purchaseTime":
+		return expr.StringValue(t.Format("15:04"))
+	case "year":
+		return expr.NumberValue(decimal.NewFromInt(int64(t.Year())))
+	case "month":
+		return expr.NumberValue(decimal.NewFromInt(int64(t.Month())))
+	case "day":
+		return expr.NumberValue(decimal.NewFromInt(int64(t.Day())))
+	case "hour":
+		return expr.NumberValue(decimal.NewFromInt(int64(t.Hour())))
+	case "minute":
+		return expr.NumberValue(decimal.NewFromInt(int64(t.Minute())))
+	case "weekday":
+		return expr.StringValue(t.Weekday().String())
+	default:
+		return expr.Value{}
+	}
+}
+
+func (s receiptScope) Items() []expr.Scope {
+	items := make([]expr.Scope, len(s.receipt.Items))
+	for i := range s.receipt.Items {
+		items[i] = itemScope{item: &s.receipt.Items[i]}
+	}
+	return items
+}
+
+type itemScope struct {
+	item *Item
+}
+
+func (s itemScope) Lookup(name string) expr.Value {
+	switch name {
+	case "description":
+		return expr.StringValue(s.item.ShortDescription)
+	case "price":
+		return expr.NumberValue(s.item.Price)
+	default:
+		return expr.Value{}
+	}
+}
+
+func (s itemScope) Items() []expr.Scope {
+	return nil
+}
@@ -0,0 +1,83 @@
+package receipt
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExpressionRule_Calculate(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+		want       int64
+	}{
+		{
+			name:       "condition holds",
+			expression: `points = 15 if total > 30 and retailer contains "Target"`,
+			want:       15,
+		},
+		{
+			name:       "condition does not hold",
+			expression: `points = 15 if total > 50`,
+			want:       0,
+		},
+		{
+			name:       "fractional points are dropped",
+			expression: `points = total / 10`,
+			want:       3,
+		},
+		{
+			name:       "negative points count as zero",
+			expression: `points = 10 - total`,
+			want:       0,
+		},
+		{
+			name:       "items",
+			expression: `points = count(items, trim(description) contains "12") * 5 + floor(sum(items, price))`,
+			want:       45,
+		},
+		{
+			name:       "purchase date and time",
+			expression: `points = 5 if purchaseDate == "2022-01-01" and purchaseTime < "14:00" and month == 1 and minute == 1`,
+			want:       5,
+		},
+		{
+			name:       "evaluation error",
+			expression: `points = total / (itemCount - 5)`,
+			want:       0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := NewExpressionRule("", tt.expression)
+			if err != nil {
+				t.Fatalf("NewExpressionRule() error = %v", err)
+			}
+			if got := rule.Calculate(newCalculatorTestReceipt()); got != tt.want {
+				t.Errorf("Calculate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpressionRule_Inputs(t *testing.T) {
+	rule, err := NewExpressionRule("Large Target receipt", `points = 15 if total > 30 and count(items, price > 10) > 1`)
+	if err != nil {
+		t.Fatalf("NewExpressionRule() error = %v", err)
+	}
+
+	want := map[string]string{
+		"total":          "35.35",
+		"items[0].price": "6.49",
+		"items[1].price": "12.25",
+		"items[2].price": "1.26",
+		"items[3].price": "3.35",
+		"items[4].price": "12",
+	}
+	if got := rule.Inputs(newCalculatorTestReceipt()); !reflect.DeepEqual(got, want) {
+		t.Errorf("Inputs() = %v, want %v", got, want)
+	}
+	if got := rule.Description(); got != "Large Target receipt" {
+		t.Errorf("Description() = %v, want Large Target receipt", got)
+	}
+}
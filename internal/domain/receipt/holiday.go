@@ -0,0 +1,115 @@
+package receipt
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// HolidayCalendar is a named list of holidays, each a calendar date.
+type HolidayCalendar struct {
+	Name string
+	// holidays maps dates written as 2006-01-02 to the holiday's name.
+	holidays map[string]string
+}
+
+// NewHolidayCalendar returns a calendar of holidays given as dates written
+// as 2006-01-02 mapped to their names.
+func NewHolidayCalendar(name string, holidays map[string]string) (*HolidayCalendar, error) {
+	calendar := &HolidayCalendar{Name: name, holidays: make(map[string]string, len(holidays))}
+	for date, holiday := range holidays {
+		if _, err := time.Parse("2006-01-02", date); err != nil {
+			return nil, fmt.Errorf("calendar %s: date %q must be written as YYYY-MM-DD", name, date)
+		}
+		calendar.holidays[date] = holiday
+	}
+	return calendar, nil
+}
+
+// Holiday returns the name of the holiday on the calendar date of t, in
+// t's location.
+func (c *HolidayCalendar) Holiday(t time.Time) (string, bool) {
+	name, ok := c.holidays[t.Format("2006-01-02")]
+	return name, ok
+}
+
+// HolidayCalendars are the holiday calendars rules may refer to, by name.
+type HolidayCalendars map[string]*HolidayCalendar
+
+// LoadHolidayCalendars reads a holiday calendars file.
+func LoadHolidayCalendars(path string) (HolidayCalendars, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	calendars, err := ParseHolidayCalendars(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return calendars, nil
+}
+
+// ParseHolidayCalendars reads JSON holiday calendars such as
+//
+//	{
+//	  "us": {"2025-07-04": "Independence Day", "2025-12-25": "Christmas Day"}
+//	}
+func ParseHolidayCalendars(r io.Reader) (HolidayCalendars, error) {
+	var file map[string]map[string]string
+	if err := json.NewDecoder(r).Decode(&file); err != nil {
+		return nil, fmt.Errorf("%w: holiday calendars: %w", ErrInvalidInput, err)
+	}
+
+	calendars := make(HolidayCalendars, len(file))
+	for name, holidays := range file {
+		calendar, err := NewHolidayCalendar(name, holidays)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidInput, err)
+		}
+		calendars[name] = calendar
+	}
+	return calendars, nil
+}
+
+// Names returns the names of the calendars, sorted.
+func (c HolidayCalendars) Names() []string {
+	names := make([]string, 0, len(c))
+	for name := range c {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+var (
+	holidayCalendarsMu sync.RWMutex
+	holidayCalendars   HolidayCalendars
+)
+
+// SetHolidayCalendars makes the calendars available to the rules built
+// afterwards. Like the rule types, they are shared by every ruleset and
+// campaign, so they must be set before any ruleset is loaded.
+func SetHolidayCalendars(calendars HolidayCalendars) {
+	holidayCalendarsMu.Lock()
+	defer holidayCalendarsMu.Unlock()
+	holidayCalendars = calendars
+}
+
+func lookupHolidayCalendar(name string) (*HolidayCalendar, error) {
+	holidayCalendarsMu.RLock()
+	defer holidayCalendarsMu.RUnlock()
+	calendar, ok := holidayCalendars[name]
+	if !ok {
+		if len(holidayCalendars) == 0 {
+			return nil, fmt.Errorf("unknown holiday calendar %q: no holiday calendars are loaded", name)
+		}
+		return nil, fmt.Errorf("unknown holiday calendar %q, expected one of %v", name, holidayCalendars.Names())
+	}
+	return calendar, nil
+}
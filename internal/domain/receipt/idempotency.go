@@ -0,0 +1,58 @@
+package receipt
+
+import (
+	"fmt"
+	"time"
+)
+
+const MaxIdempotencyKeyLength = 255
+
+// IdempotencyLease is how long a request holds the key it reserved. A
+// retry after the lease has run out takes the key over, since the request
+// that reserved it has crashed or failed to complete it.
+const IdempotencyLease = time.Minute
+
+var (
+	ErrIdempotencyKeyReused = fmt.Errorf("%w: idempotency key was already used for a different receipt", ErrConflict)
+	ErrIdempotencyKeyInUse  = fmt.Errorf("%w: a request with this idempotency key is still in progress", ErrConflict)
+)
+
+// IdempotencyRecord remembers the receipt created for an idempotency key.
+// ReceiptId is empty while the request that reserved the key is in
+// progress, and PendingId is the ID it creates the receipt under, so that
+// a request taking the key over can tell whether it got that far.
+type IdempotencyRecord struct {
+	Key         string
+	Fingerprint string
+	ReceiptId   string
+	PendingId   string
+	CreatedAt   time.Time
+}
+
+// leaseExpired reports whether the record is in progress and its lease
+// has run out.
+func (r *IdempotencyRecord) leaseExpired(now time.Time) bool {
+	return r.ReceiptId == "" && now.Sub(r.CreatedAt) > IdempotencyLease
+}
+
+// IdempotencyStore keeps idempotency records for a retention window, after
+// which a key may be reused.
+type IdempotencyStore interface {
+	// Reserve atomically stores record unless a live record with the same
+	// key exists, in which case it returns that record and stores nothing.
+	Reserve(record IdempotencyRecord) (*IdempotencyRecord, error)
+	// Takeover atomically replaces stale, an in-progress record whose lease
+	// has run out, with record. If the key's record is no longer stale, as
+	// when another request took it over first, it returns that record and
+	// stores nothing.
+	Takeover(stale IdempotencyRecord, record IdempotencyRecord) (*IdempotencyRecord, error)
+	// Complete records the receipt created for a reserved key. It fails
+	// with ErrConflict unless the key's record is pending receiptId.
+	Complete(key string, receiptId string) error
+	// Release atomically forgets record, the reservation of a request that
+	// failed, so that it can be retried. It does nothing if the key's
+	// record is no longer record, as when another request took it over:
+	// since a takeover keeps the pending ID, the reservation is told apart
+	// by the whole record rather than by its ID.
+	Release(record IdempotencyRecord) error
+}
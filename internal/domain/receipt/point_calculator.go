@@ -1,23 +1,284 @@
 package receipt
 
+import (
+	"fmt"
+	"github.com/shopspring/decimal"
+)
+
+// PointCalculator scores receipts with rules, then with the campaigns added
+// to it, and finally bounds the points of the receipt. Within the rules,
+// and within each campaign, the rules apply in the order of their
+// RuleStacking.
+//
+// Each rule rounds its own points unless the calculator accumulates
+// fractions, in which case the exact points of the rules are added up and
+// rounded once per receipt. The rules still report their rounded points,
+// and a rule of its own makes up the difference.
 type PointCalculator struct {
-	rules []PointRule
+	rules      []stackedRule
+	campaigns  []campaignRules
+	minPoints  *int64
+	maxPoints  *int64
+	rounding   Rounding
+	accumulate bool
+}
+
+// campaignRules is a campaign with its bonus rules built.
+type campaignRules struct {
+	campaign *Campaign
+	rules    []stackedRule
+}
+
+// RuleResult records what a single rule awarded for a receipt and the
+// receipt inputs it looked at. Campaign names the campaign the rule
+// belongs to, if any. Excluded is set on the rules of a group that award
+// no points because another rule of the group won. ExactPoints is the
+// points before rounding.
+type RuleResult struct {
+	Description string
+	Points      int64
+	ExactPoints decimal.Decimal
+	Inputs      map[string]string
+	Campaign    string
+	Group       string
+	Excluded    bool
+}
+
+// PointsResult is the outcome of running every rule against a receipt.
+// RulesetVersion names the ruleset the rules came from, when they came
+// from one.
+type PointsResult struct {
+	RulesetVersion string
+	Total          int64
+	Rules          []RuleResult
 }
 
 func NewPointCalculator(rules ...PointRule) *PointCalculator {
-	return &PointCalculator{
-		rules: rules,
+	calculator := &PointCalculator{}
+	for _, rule := range rules {
+		calculator.AddRule(rule)
 	}
+	return calculator
 }
 
 func (p *PointCalculator) AddRule(rule PointRule) {
-	p.rules = append(p.rules, rule)
+	p.AddStackedRule(rule, RuleStacking{})
+}
+
+// AddStackedRule adds a rule that combines with the others as stacking
+// says.
+func (p *PointCalculator) AddStackedRule(rule PointRule, stacking RuleStacking) {
+	p.rules = append(p.rules, stackedRule{PointRule: rule, RuleStacking: stacking})
+}
+
+// SetPointsBounds bounds the points of a receipt. A nil bound is no bound.
+func (p *PointCalculator) SetPointsBounds(min, max *int64) {
+	p.minPoints = min
+	p.maxPoints = max
+}
+
+// SetRounding sets the rounding of the campaign multipliers and, when
+// accumulate is set, makes the calculator round once per receipt. The
+// empty rounding rounds down.
+func (p *PointCalculator) SetRounding(rounding Rounding, accumulate bool) {
+	p.rounding = rounding
+	p.accumulate = accumulate
+}
+
+// AddCampaign makes the calculator apply the campaign to the receipts it
+// applies to, after the rules.
+func (p *PointCalculator) AddCampaign(campaign *Campaign) error {
+	rules, err := campaign.PointRules()
+	if err != nil {
+		return fmt.Errorf("campaign %s: %w", campaign.Name, err)
+	}
+	stacked := make([]stackedRule, len(rules))
+	for i, rule := range rules {
+		stacked[i] = stackedRule{PointRule: rule, RuleStacking: campaign.Rules[i].RuleStacking}
+	}
+	p.campaigns = append(p.campaigns, campaignRules{campaign: campaign, rules: stacked})
+	return nil
+}
+
+func (p *PointCalculator) Calculate(receipt *Receipt) PointsResult {
+	s := &scoring{
+		receipt:    receipt,
+		accumulate: p.accumulate,
+		result: PointsResult{
+			Rules: make([]RuleResult, 0, len(p.rules)),
+		},
+	}
+	s.apply(p.rules, "")
+
+	// Campaign multipliers scale the points of the rules above, not the
+	// bonuses of other campaigns.
+	base := s.running()
+	for _, c := range p.campaigns {
+		if !c.campaign.AppliesTo(receipt) {
+			continue
+		}
+		s.apply(c.rules, c.campaign.Name)
+		if !c.campaign.Multiplier.IsZero() {
+			exact := base.Mul(c.campaign.Multiplier.Sub(decimal.NewFromInt(1)))
+			s.add(RuleResult{
+				Description: fmt.Sprintf("%sx the points of the ruleset's rules", c.campaign.Multiplier),
+				Points:      p.rounding.or(RoundFloor).Round(exact),
+				ExactPoints: exact,
+				Inputs:      map[string]string{"points": base.String()},
+				Campaign:    c.campaign.Name,
+			})
+		}
+	}
+
+	if p.accumulate {
+		p.roundOnce(&s.result, s.exact)
+	}
+	p.bound(&s.result)
+	return s.result
+}
+
+// scoring is the state of a receipt being scored.
+type scoring struct {
+	receipt    *Receipt
+	accumulate bool
+	result     PointsResult
+	// exact is the sum of the exact points of the rules so far.
+	exact decimal.Decimal
+}
+
+// running returns the points awarded so far, which rules scale: their exact
+// sum when fractions accumulate, or else the rounded total.
+func (s *scoring) running() decimal.Decimal {
+	if s.accumulate {
+		return s.exact
+	}
+	return decimal.NewFromInt(s.result.Total)
+}
+
+func (s *scoring) add(rule RuleResult) {
+	s.result.Total += rule.Points
+	s.exact = s.exact.Add(rule.ExactPoints)
+	s.result.Rules = append(s.result.Rules, rule)
+}
+
+// apply adds the points of the rules. The rules of a group all award points
+// of their own, which depend on the receipt alone, so the groups are
+// settled before any rule scales the points of others.
+func (s *scoring) apply(rules []stackedRule, campaign string) {
+	ordered := orderRules(rules)
+
+	points := make([]int64, len(ordered))
+	exact := make([]decimal.Decimal, len(ordered))
+	winners := make(map[string]int)
+	for i, rule := range ordered {
+		if _, ok := rule.PointRule.(ScalingRule); ok {
+			continue
+		}
+		points[i] = rule.Calculate(s.receipt)
+		exact[i] = decimal.NewFromInt(points[i])
+		if fractional, ok := rule.PointRule.(FractionalRule); ok {
+			exact[i] = fractional.ExactPoints(s.receipt)
+		}
+		if rule.Group == "" {
+			continue
+		}
+		if winner, ok := winners[rule.Group]; !ok || s.beats(points[i], exact[i], points[winner], exact[winner]) {
+			winners[rule.Group] = i
+		}
+	}
+
+	byId := make(map[string]int)
+	for i, rule := range ordered {
+		ruleResult := RuleResult{
+			Description: rule.Description(),
+			Inputs:      rule.Inputs(s.receipt),
+			Campaign:    campaign,
+			Group:       rule.Group,
+		}
+		if scaling, ok := rule.PointRule.(ScalingRule); ok {
+			scaled := s.running()
+			if ids := scaling.Scales(); len(ids) > 0 {
+				scaled = decimal.Zero
+				for _, id := range ids {
+					if j, ok := byId[id]; ok {
+						scaled = scaled.Add(s.scaledPoints(points[j], exact[j]))
+					}
+				}
+			}
+			exact[i] = scaling.Scale(s.receipt, scaled)
+			points[i] = scaling.Round(exact[i])
+			if ruleResult.Inputs == nil {
+				ruleResult.Inputs = make(map[string]string)
+			}
+			ruleResult.Inputs["points"] = scaled.String()
+		} else if rule.Group != "" && winners[rule.Group] != i {
+			points[i] = 0
+			exact[i] = decimal.Zero
+			ruleResult.Excluded = true
+		}
+
+		ruleResult.Points = points[i]
+		ruleResult.ExactPoints = exact[i]
+		s.add(ruleResult)
+		if rule.Id != "" {
+			byId[rule.Id] = i
+		}
+	}
+}
+
+// beats reports whether a rule awarding points, or exact points when
+// fractions accumulate, beats another in a group.
+func (s *scoring) beats(points int64, exact decimal.Decimal, otherPoints int64, otherExact decimal.Decimal) bool {
+	if s.accumulate {
+		return exact.GreaterThan(otherExact)
+	}
+	return points > otherPoints
+}
+
+func (s *scoring) scaledPoints(points int64, exact decimal.Decimal) decimal.Decimal {
+	if s.accumulate {
+		return exact
+	}
+	return decimal.NewFromInt(points)
+}
+
+// roundOnce rounds the exact points of the receipt, recording the
+// difference with the points the rules reported as a rule of its own.
+func (p *PointCalculator) roundOnce(result *PointsResult, exact decimal.Decimal) {
+	rounding := p.rounding.or(RoundFloor)
+	total := rounding.Round(exact)
+	if total == result.Total {
+		return
+	}
+	result.Rules = append(result.Rules, RuleResult{
+		Description: fmt.Sprintf("Fractional points added up and rounded %s", rounding.describe()),
+		Points:      total - result.Total,
+		ExactPoints: decimal.NewFromInt(total - result.Total),
+		Inputs:      map[string]string{"points": exact.String()},
+	})
+	result.Total = total
 }
 
-func (p *PointCalculator) Calculate(receipt *Receipt) int64 {
-	points := int64(0)
-	for _, rule := range p.rules {
-		points += rule.Calculate(receipt)
+// bound brings the total within the calculator's bounds, recording the
+// adjustment as a rule of its own.
+func (p *PointCalculator) bound(result *PointsResult) {
+	total := result.Total
+	switch {
+	case p.maxPoints != nil && total > *p.maxPoints:
+		result.Rules = append(result.Rules, RuleResult{
+			Description: fmt.Sprintf("At most %d points per receipt", *p.maxPoints),
+			Points:      *p.maxPoints - total,
+			ExactPoints: decimal.NewFromInt(*p.maxPoints - total),
+			Inputs:      map[string]string{"points": fmt.Sprint(total)},
+		})
+		result.Total = *p.maxPoints
+	case p.minPoints != nil && total < *p.minPoints:
+		result.Rules = append(result.Rules, RuleResult{
+			Description: fmt.Sprintf("At least %d points per receipt", *p.minPoints),
+			Points:      *p.minPoints - total,
+			ExactPoints: decimal.NewFromInt(*p.minPoints - total),
+			Inputs:      map[string]string{"points": fmt.Sprint(total)},
+		})
+		result.Total = *p.minPoints
 	}
-	return points
 }
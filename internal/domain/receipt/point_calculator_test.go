@@ -0,0 +1,83 @@
+package receipt
+
+import (
+	"github.com/shopspring/decimal"
+	"testing"
+	"time"
+)
+
+func newCalculatorTestReceipt() *Receipt {
+	return &Receipt{
+		Retailer:         "Target",
+		PurchaseDateTime: time.Date(2022, time.January, 1, 13, 1, 0, 0, time.Local),
+		Items: []Item{
+			{
+				ShortDescription: "Mountain Dew 12PK",
+				Price:            decimal.RequireFromString("6.49"),
+			},
+			{
+				ShortDescription: "Emils Cheese Pizza",
+				Price:            decimal.RequireFromString("12.25"),
+			},
+			{
+				ShortDescription: "Knorr Creamy Chicken",
+				Price:            decimal.RequireFromString("1.26"),
+			},
+			{
+				ShortDescription: "Doritos Nacho Cheese",
+				Price:            decimal.RequireFromString("3.35"),
+			},
+			{
+				ShortDescription: "   Klarbrunn 12-PK 12 FL OZ  ",
+				Price:            decimal.RequireFromString("12.00"),
+			},
+		},
+		Total: decimal.RequireFromString("35.35"),
+	}
+}
+
+func TestPointCalculator_Calculate(t *testing.T) {
+	receipt := newCalculatorTestReceipt()
+	calculator := NewPointCalculator(
+		&RetailerCharacterBonusRule{},
+		&WholeNumberTotalBonusRule{},
+		&QuarterDollarBonusRule{},
+		&ItemPairBonusRule{},
+		&DescriptionLengthPriceBonusRule{},
+		&OddDayBonusRule{},
+		&AfternoonBonusRule{},
+	)
+
+	got := calculator.Calculate(receipt)
+	if got.Total != 26 {
+		t.Errorf("Calculate() total = %v, want %v", got.Total, 26)
+	}
+
+	if len(got.Rules) != 7 {
+		t.Fatalf("Calculate() rules = %v, want %v", len(got.Rules), 7)
+	}
+
+	wantPoints := []int64{6, 0, 0, 10, 4, 6, 0}
+	sum := int64(0)
+	for i, rule := range got.Rules {
+		if rule.Points != wantPoints[i] {
+			t.Errorf("Calculate() rule %d points = %v, want %v", i, rule.Points, wantPoints[i])
+		}
+		if rule.Description == "" {
+			t.Errorf("Calculate() rule %d has no description", i)
+		}
+		sum += rule.Points
+	}
+
+	if sum != got.Total {
+		t.Errorf("Calculate() rule points sum = %v, want %v", sum, got.Total)
+	}
+
+	if got.Rules[0].Inputs["retailer"] != "Target" {
+		t.Errorf("Calculate() retailer input = %v, want %v", got.Rules[0].Inputs["retailer"], "Target")
+	}
+
+	if got.Rules[1].Inputs["total"] != "35.35" {
+		t.Errorf("Calculate() total input = %v, want %v", got.Rules[1].Inputs["total"], "35.35")
+	}
+}
@@ -1,17 +1,29 @@
 package receipt
 
 import (
+	"errors"
+	"fmt"
 	"github.com/shopspring/decimal"
 	"strings"
+	"time"
 	"unicode"
 )
 
 type PointRule interface {
 	Calculate(*Receipt) int64
 	Description() string
+	// Inputs returns the receipt values the rule looked at, keyed by the
+	// field name used in the API.
+	Inputs(*Receipt) map[string]string
 }
 
-type RetailerCharacterBonusRule struct{}
+// The parameters of the rules below are optional: a zero parameter takes
+// the value from the original scoring rules. Validate rejects parameters
+// that cannot be scored.
+
+type RetailerCharacterBonusRule struct {
+	PointsPerCharacter int64 `json:"pointsPerCharacter"`
+}
 
 func (r *RetailerCharacterBonusRule) Calculate(receipt *Receipt) int64 {
 	count := int64(0)
@@ -20,89 +32,342 @@ func (r *RetailerCharacterBonusRule) Calculate(receipt *Receipt) int64 {
 			count++
 		}
 	}
-	return count
+	return count * r.pointsPerCharacter()
 }
+
 func (r *RetailerCharacterBonusRule) Description() string {
-	return "One point for every alphanumeric character in the retailer name"
+	if r.pointsPerCharacter() == 1 {
+		return "One point for every alphanumeric character in the retailer name"
+	}
+	return fmt.Sprintf("%d points for every alphanumeric character in the retailer name", r.pointsPerCharacter())
 }
 
-type WholeNumberTotalBonusRule struct{}
+func (r *RetailerCharacterBonusRule) Inputs(receipt *Receipt) map[string]string {
+	return map[string]string{"retailer": receipt.Retailer}
+}
+
+func (r *RetailerCharacterBonusRule) Validate() error {
+	return validateNonNegative("pointsPerCharacter", r.PointsPerCharacter)
+}
+
+func (r *RetailerCharacterBonusRule) pointsPerCharacter() int64 {
+	return defaultInt(r.PointsPerCharacter, 1)
+}
+
+type WholeNumberTotalBonusRule struct {
+	Points int64 `json:"points"`
+}
 
 func (r *WholeNumberTotalBonusRule) Calculate(receipt *Receipt) int64 {
 	if receipt.Total.Truncate(0).Equal(receipt.Total) {
-		return 50
+		return r.points()
 	}
 	return 0
 }
 
 func (r *WholeNumberTotalBonusRule) Description() string {
-	return "50 points if the total is a round dollar amount with no cents"
+	return fmt.Sprintf("%d points if the total is a round dollar amount with no cents", r.points())
+}
+
+func (r *WholeNumberTotalBonusRule) Inputs(receipt *Receipt) map[string]string {
+	return map[string]string{"total": receipt.Total.StringFixed(2)}
 }
 
-type QuarterDollarBonusRule struct{}
+func (r *WholeNumberTotalBonusRule) Validate() error {
+	return validateNonNegative("points", r.Points)
+}
+
+func (r *WholeNumberTotalBonusRule) points() int64 {
+	return defaultInt(r.Points, 50)
+}
+
+type QuarterDollarBonusRule struct {
+	Multiple decimal.Decimal `json:"multiple"`
+	Points   int64           `json:"points"`
+}
 
 func (r *QuarterDollarBonusRule) Calculate(receipt *Receipt) int64 {
-	quarter := decimal.NewFromFloat(0.25)
-	if receipt.Total.Mod(quarter).Equal(decimal.Zero) {
-		return 25
+	if receipt.Total.Mod(r.multiple()).Equal(decimal.Zero) {
+		return r.points()
 	}
 	return 0
 }
 
 func (r *QuarterDollarBonusRule) Description() string {
-	return "25 points if the total is a multiple of 0.25"
+	return fmt.Sprintf("%d points if the total is a multiple of %s", r.points(), r.multiple().StringFixed(2))
+}
+
+func (r *QuarterDollarBonusRule) Inputs(receipt *Receipt) map[string]string {
+	return map[string]string{"total": receipt.Total.StringFixed(2)}
+}
+
+func (r *QuarterDollarBonusRule) Validate() error {
+	return errors.Join(
+		validateNonNegativeDecimal("multiple", r.Multiple),
+		validateNonNegative("points", r.Points),
+	)
+}
+
+func (r *QuarterDollarBonusRule) multiple() decimal.Decimal {
+	return defaultDecimal(r.Multiple, decimal.RequireFromString("0.25"))
+}
+
+func (r *QuarterDollarBonusRule) points() int64 {
+	return defaultInt(r.Points, 25)
 }
 
-type ItemPairBonusRule struct{}
+type ItemPairBonusRule struct {
+	GroupSize      int   `json:"groupSize"`
+	PointsPerGroup int64 `json:"pointsPerGroup"`
+}
 
 func (r *ItemPairBonusRule) Calculate(receipt *Receipt) int64 {
-	return int64(len(receipt.Items) / 2 * 5)
+	return int64(len(receipt.Items)/r.groupSize()) * r.pointsPerGroup()
 }
 
 func (r *ItemPairBonusRule) Description() string {
-	return "5 points for every two items on the receipt"
+	if r.groupSize() == 2 {
+		return fmt.Sprintf("%d points for every two items on the receipt", r.pointsPerGroup())
+	}
+	return fmt.Sprintf("%d points for every %d items on the receipt", r.pointsPerGroup(), r.groupSize())
+}
+
+func (r *ItemPairBonusRule) Inputs(receipt *Receipt) map[string]string {
+	return map[string]string{"itemCount": fmt.Sprint(len(receipt.Items))}
 }
 
-type DescriptionLengthPriceBonusRule struct{}
+func (r *ItemPairBonusRule) Validate() error {
+	return errors.Join(
+		validateNonNegative("groupSize", int64(r.GroupSize)),
+		validateNonNegative("pointsPerGroup", r.PointsPerGroup),
+	)
+}
+
+func (r *ItemPairBonusRule) groupSize() int {
+	return int(defaultInt(int64(r.GroupSize), 2))
+}
+
+func (r *ItemPairBonusRule) pointsPerGroup() int64 {
+	return defaultInt(r.PointsPerGroup, 5)
+}
+
+// DescriptionLengthPriceBonusRule rounds the points of each item, to the
+// nearest integer with halves up by default, as v1 always has. Later
+// versions set the rounding to RoundCeil, which its description promised.
+type DescriptionLengthPriceBonusRule struct {
+	LengthMultiple  int             `json:"lengthMultiple"`
+	PriceMultiplier decimal.Decimal `json:"priceMultiplier"`
+	RoundingParam
+}
 
 func (r *DescriptionLengthPriceBonusRule) Calculate(receipt *Receipt) int64 {
 	points := int64(0)
 	for _, item := range receipt.Items {
-		itemDescriptionLength := len(strings.TrimSpace(item.ShortDescription))
-		if (itemDescriptionLength % 3) == 0 {
-			points += int64(item.Price.Mul(decimal.NewFromFloat(0.2)).Round(0).IntPart())
+		if r.matches(item) {
+			points += r.rounding().Round(item.Price.Mul(r.priceMultiplier()))
 		}
 	}
 
 	return points
 }
 
+func (r *DescriptionLengthPriceBonusRule) ExactPoints(receipt *Receipt) decimal.Decimal {
+	points := decimal.Zero
+	for _, item := range receipt.Items {
+		if r.matches(item) {
+			points = points.Add(item.Price.Mul(r.priceMultiplier()))
+		}
+	}
+	return points
+}
+
 func (r *DescriptionLengthPriceBonusRule) Description() string {
-	return "If the trimmed length of the item description is a multiple of 3, multiply the price by 0.2 and round up to the nearest integer. The result is the number of points earned"
+	return fmt.Sprintf("If the trimmed length of the item description is a multiple of %d, multiply the price by %s and round %s. The result is the number of points earned", r.lengthMultiple(), r.priceMultiplier(), r.rounding().describe())
 }
 
-type OddDayBonusRule struct{}
+func (r *DescriptionLengthPriceBonusRule) Inputs(receipt *Receipt) map[string]string {
+	inputs := make(map[string]string, len(receipt.Items)*2)
+	for i, item := range receipt.Items {
+		inputs[fmt.Sprintf("items[%d].shortDescription", i)] = item.ShortDescription
+		inputs[fmt.Sprintf("items[%d].price", i)] = item.Price.StringFixed(2)
+	}
+	return inputs
+}
+
+func (r *DescriptionLengthPriceBonusRule) Validate() error {
+	return errors.Join(
+		validateNonNegative("lengthMultiple", int64(r.LengthMultiple)),
+		validateNonNegativeDecimal("priceMultiplier", r.PriceMultiplier),
+		r.Rounding.Validate(),
+	)
+}
+
+func (r *DescriptionLengthPriceBonusRule) matches(item Item) bool {
+	return len(strings.TrimSpace(item.ShortDescription))%r.lengthMultiple() == 0
+}
+
+func (r *DescriptionLengthPriceBonusRule) lengthMultiple() int {
+	return int(defaultInt(int64(r.LengthMultiple), 3))
+}
+
+func (r *DescriptionLengthPriceBonusRule) priceMultiplier() decimal.Decimal {
+	return defaultDecimal(r.PriceMultiplier, decimal.RequireFromString("0.2"))
+}
+
+func (r *DescriptionLengthPriceBonusRule) rounding() Rounding {
+	return r.Rounding.or(RoundHalfUp)
+}
+
+type OddDayBonusRule struct {
+	Points int64 `json:"points"`
+}
 
 func (r *OddDayBonusRule) Calculate(receipt *Receipt) int64 {
 	if receipt.PurchaseDateTime.Day()%2 != 0 {
-		return 6
+		return r.points()
 	}
 	return 0
 }
 
 func (r *OddDayBonusRule) Description() string {
-	return "6 points if the day in the purchase date is odd"
+	return fmt.Sprintf("%d points if the day in the purchase date is odd", r.points())
 }
 
-type AfternoonBonusRule struct{}
+func (r *OddDayBonusRule) Inputs(receipt *Receipt) map[string]string {
+	return map[string]string{"purchaseDate": receipt.PurchaseDateTime.Format("2006-01-02")}
+}
+
+func (r *OddDayBonusRule) Validate() error {
+	return validateNonNegative("points", r.Points)
+}
+
+func (r *OddDayBonusRule) points() int64 {
+	return defaultInt(r.Points, 6)
+}
+
+// AfternoonBonusRule awards points when the time of purchase is strictly
+// after After and strictly before Before. By default, as in v1, it compares
+// whole hours, so After and Before are written as HH:00 and a purchase at
+// 14:30 is not after 14:00. With Precision "minute" it compares minutes,
+// and After and Before may be any HH:MM.
+type AfternoonBonusRule struct {
+	After     string `json:"after"`
+	Before    string `json:"before"`
+	Points    int64  `json:"points"`
+	Precision string `json:"precision"`
+}
 
 func (r *AfternoonBonusRule) Calculate(receipt *Receipt) int64 {
-	if receipt.PurchaseDateTime.Hour() > 14 && receipt.PurchaseDateTime.Hour() < 16 {
-		return 10
+	after, before := r.minutes()
+	minute := receipt.PurchaseDateTime.Hour()*60 + receipt.PurchaseDateTime.Minute()
+	if !r.toTheMinute() {
+		minute -= minute % 60
+	}
+	if minute > after && minute < before {
+		return r.points()
 	}
 	return 0
 }
 
 func (r *AfternoonBonusRule) Description() string {
-	return "10 points if the time of purchase is after 2:00pm and before 4:00pm"
+	return fmt.Sprintf("%d points if the time of purchase is after %s and before %s", r.points(), r.after(), r.before())
+}
+
+func (r *AfternoonBonusRule) Inputs(receipt *Receipt) map[string]string {
+	return map[string]string{"purchaseTime": receipt.PurchaseDateTime.Format("15:04")}
+}
+
+func (r *AfternoonBonusRule) Validate() error {
+	if r.Precision != "" && r.Precision != "hour" && r.Precision != "minute" {
+		return fmt.Errorf("precision must be hour or minute, got %q", r.Precision)
+	}
+	parse := parseClock
+	if !r.toTheMinute() {
+		parse = parseHour
+	}
+	after, afterErr := parse("after", r.after())
+	before, beforeErr := parse("before", r.before())
+	if afterErr == nil && beforeErr == nil && after >= before {
+		return errors.New("after must be earlier than before")
+	}
+	return errors.Join(afterErr, beforeErr, validateNonNegative("points", r.Points))
+}
+
+func (r *AfternoonBonusRule) after() string {
+	return defaultString(r.After, "14:00")
+}
+
+func (r *AfternoonBonusRule) before() string {
+	return defaultString(r.Before, "16:00")
+}
+
+func (r *AfternoonBonusRule) minutes() (int, int) {
+	after, _ := parseClock("after", r.after())
+	before, _ := parseClock("before", r.before())
+	return after, before
+}
+
+func (r *AfternoonBonusRule) points() int64 {
+	return defaultInt(r.Points, 10)
+}
+
+func (r *AfternoonBonusRule) toTheMinute() bool {
+	return r.Precision == "minute"
+}
+
+// parseHour parses a whole hour written as HH:00 into minutes since
+// midnight.
+func parseHour(name, value string) (int, error) {
+	minutes, err := parseClock(name, value)
+	if err != nil || minutes%60 != 0 {
+		return 0, fmt.Errorf("%s must be a whole hour written as HH:00, got %q", name, value)
+	}
+	return minutes, nil
+}
+
+// parseClock parses a time of day written as HH:MM into minutes since
+// midnight.
+func parseClock(name, value string) (int, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be a time written as HH:MM, got %q", name, value)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func defaultInt(value, fallback int64) int64 {
+	if value == 0 {
+		return fallback
+	}
+	return value
+}
+
+func defaultDecimal(value, fallback decimal.Decimal) decimal.Decimal {
+	if value.IsZero() {
+		return fallback
+	}
+	return value
+}
+
+func defaultString(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
+
+// validateNonNegative rejects a negative parameter. Zero is allowed, and
+// leaves the parameter at its default.
+func validateNonNegative(name string, value int64) error {
+	if value < 0 {
+		return fmt.Errorf("%s must not be negative, got %d", name, value)
+	}
+	return nil
+}
+
+func validateNonNegativeDecimal(name string, value decimal.Decimal) error {
+	if value.IsNegative() {
+		return fmt.Errorf("%s must not be negative, got %s", name, value)
+	}
+	return nil
 }
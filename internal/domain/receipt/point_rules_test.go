@@ -250,9 +250,10 @@ func TestDescriptionLengthPriceBonusRule_Calculate(t *testing.T) {
 		receipt *Receipt
 	}
 	tests := []struct {
-		name string
-		args args
-		want int64
+		name     string
+		args     args
+		rounding Rounding
+		want     int64
 	}{
 		{
 			name: "multiple of 3",
@@ -279,6 +280,32 @@ func TestDescriptionLengthPriceBonusRule_Calculate(t *testing.T) {
 			},
 			want: 3,
 		},
+		{
+			name: "multiple of 3, rounded up",
+			args: args{
+				receipt: &Receipt{
+					Id:               uuid.New(),
+					Retailer:         "Test Retailer",
+					PurchaseDateTime: time.Now(),
+					Items: []Item{
+						{
+							Id:               uuid.New(),
+							ShortDescription: "123 56789",
+							Price:            decimal.NewFromFloat(10.55),
+						},
+						{
+							Id:               uuid.New(),
+							ShortDescription: "123 56789",
+							Price:            decimal.NewFromFloat(4.75),
+						},
+					},
+					Total:  decimal.NewFromFloat(15.3),
+					Points: 0,
+				},
+			},
+			rounding: RoundCeil,
+			want:     4,
+		},
 		{
 			name: "one multiple of 3, one not",
 			args: args{
@@ -307,7 +334,7 @@ func TestDescriptionLengthPriceBonusRule_Calculate(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			r := &DescriptionLengthPriceBonusRule{}
+			r := &DescriptionLengthPriceBonusRule{RoundingParam: RoundingParam{Rounding: tt.rounding}}
 			if got := r.Calculate(tt.args.receipt); got != tt.want {
 				t.Errorf("Calculate() = %v, want %v", got, tt.want)
 			}
@@ -368,9 +395,10 @@ func TestAfternoonBonusRule_Calculate(t *testing.T) {
 		receipt *Receipt
 	}
 	tests := []struct {
-		name string
-		args args
-		want int64
+		name      string
+		args      args
+		precision string
+		want      int64
 	}{
 		{
 			name: "3pm purchase time",
@@ -400,10 +428,113 @@ func TestAfternoonBonusRule_Calculate(t *testing.T) {
 			},
 			want: 0,
 		},
+		{
+			name: "2:30pm purchase time, by the hour",
+			args: args{
+				receipt: &Receipt{
+					Id:               uuid.New(),
+					Retailer:         "Test Retailer",
+					PurchaseDateTime: time.Date(2023, time.January, 1, 14, 30, 0, 0, time.Local),
+					Items:            nil,
+					Total:            decimal.Zero,
+					Points:           0,
+				},
+			},
+			want: 0,
+		},
+		{
+			name: "3:59pm purchase time, by the hour",
+			args: args{
+				receipt: &Receipt{
+					Id:               uuid.New(),
+					Retailer:         "Test Retailer",
+					PurchaseDateTime: time.Date(2023, time.January, 1, 15, 59, 0, 0, time.Local),
+					Items:            nil,
+					Total:            decimal.Zero,
+					Points:           0,
+				},
+			},
+			want: 10,
+		},
+		{
+			name: "2:30pm purchase time, to the minute",
+			args: args{
+				receipt: &Receipt{
+					Id:               uuid.New(),
+					Retailer:         "Test Retailer",
+					PurchaseDateTime: time.Date(2023, time.January, 1, 14, 30, 0, 0, time.Local),
+					Items:            nil,
+					Total:            decimal.Zero,
+					Points:           0,
+				},
+			},
+			precision: "minute",
+			want:      10,
+		},
+		{
+			name: "2pm purchase time, to the minute",
+			args: args{
+				receipt: &Receipt{
+					Id:               uuid.New(),
+					Retailer:         "Test Retailer",
+					PurchaseDateTime: time.Date(2023, time.January, 1, 14, 0, 0, 0, time.Local),
+					Items:            nil,
+					Total:            decimal.Zero,
+					Points:           0,
+				},
+			},
+			precision: "minute",
+			want:      0,
+		},
+		{
+			name: "2:01pm purchase time, to the minute",
+			args: args{
+				receipt: &Receipt{
+					Id:               uuid.New(),
+					Retailer:         "Test Retailer",
+					PurchaseDateTime: time.Date(2023, time.January, 1, 14, 1, 0, 0, time.Local),
+					Items:            nil,
+					Total:            decimal.Zero,
+					Points:           0,
+				},
+			},
+			precision: "minute",
+			want:      10,
+		},
+		{
+			name: "3:59pm purchase time, to the minute",
+			args: args{
+				receipt: &Receipt{
+					Id:               uuid.New(),
+					Retailer:         "Test Retailer",
+					PurchaseDateTime: time.Date(2023, time.January, 1, 15, 59, 0, 0, time.Local),
+					Items:            nil,
+					Total:            decimal.Zero,
+					Points:           0,
+				},
+			},
+			precision: "minute",
+			want:      10,
+		},
+		{
+			name: "4pm purchase time, to the minute",
+			args: args{
+				receipt: &Receipt{
+					Id:               uuid.New(),
+					Retailer:         "Test Retailer",
+					PurchaseDateTime: time.Date(2023, time.January, 1, 16, 0, 0, 0, time.Local),
+					Items:            nil,
+					Total:            decimal.Zero,
+					Points:           0,
+				},
+			},
+			precision: "minute",
+			want:      0,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			r := &AfternoonBonusRule{}
+			r := &AfternoonBonusRule{Precision: tt.precision}
 			if got := r.Calculate(tt.args.receipt); got != tt.want {
 				t.Errorf("Calculate() = %v, want %v", got, tt.want)
 			}
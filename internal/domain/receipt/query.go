@@ -0,0 +1,339 @@
+package receipt
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultListLimit = 50
+	MaxListLimit     = 500
+)
+
+type SortField string
+
+const (
+	SortByCreatedAt        SortField = "createdAt"
+	SortByPurchaseDateTime SortField = "purchaseDateTime"
+	SortByRetailer         SortField = "retailer"
+	SortByTotal            SortField = "total"
+	SortByPoints           SortField = "points"
+)
+
+type SortOrder string
+
+const (
+	SortAscending  SortOrder = "asc"
+	SortDescending SortOrder = "desc"
+)
+
+var ErrInvalidCursor = fmt.Errorf("%w: invalid cursor", ErrInvalidInput)
+
+// ListQuery describes which receipts to list and in what order. Nil bounds
+// are not applied. PurchasedFrom and the Min bounds are inclusive,
+// PurchasedTo is exclusive and the Max bounds are inclusive.
+type ListQuery struct {
+	Retailer      string
+	PurchasedFrom *time.Time
+	PurchasedTo   *time.Time
+	// PurchaseDateFrom and PurchaseDateTo bound the date of the purchase
+	// where it was made, which is the purchaseDate of the receipt, written
+	// 2006-01-02. Both are inclusive, and empty is not applied.
+	PurchaseDateFrom string
+	PurchaseDateTo   string
+	MinTotal         *decimal.Decimal
+	MaxTotal         *decimal.Decimal
+	MinPoints        *int64
+	MaxPoints        *int64
+	SortBy           SortField
+	Order            SortOrder
+	Cursor           string
+	Limit            int
+}
+
+// Page is one page of a listing. NextCursor is empty on the last page.
+type Page struct {
+	Receipts   []*Receipt
+	NextCursor string
+}
+
+type cursor struct {
+	SortBy SortField `json:"s"`
+	Order  SortOrder `json:"o"`
+	Key    string    `json:"k"`
+	Id     string    `json:"i"`
+}
+
+// Normalize fills in defaults and validates the query. Storage backends
+// should call it before executing the query.
+func (q *ListQuery) Normalize() error {
+	if q.SortBy == "" {
+		q.SortBy = SortByCreatedAt
+	}
+	if q.Order == "" {
+		q.Order = SortAscending
+	}
+	if q.Limit == 0 {
+		q.Limit = DefaultListLimit
+	}
+
+	switch q.SortBy {
+	case SortByCreatedAt, SortByPurchaseDateTime, SortByRetailer, SortByTotal, SortByPoints:
+	default:
+		return fmt.Errorf("%w: unknown sort field %q", ErrInvalidInput, q.SortBy)
+	}
+
+	if q.Order != SortAscending && q.Order != SortDescending {
+		return fmt.Errorf("%w: unknown sort order %q", ErrInvalidInput, q.Order)
+	}
+
+	if q.Limit < 0 || q.Limit > MaxListLimit {
+		return fmt.Errorf("%w: limit must be between 1 and %d", ErrInvalidInput, MaxListLimit)
+	}
+
+	// Purchases on a range of local dates were made in the instants from
+	// the start of its first day at the earliest offset a receipt can have
+	// to the end of its last at the latest, which bound them for backends
+	// that index the instant; Matches checks the dates. Offsets accepted
+	// for receipts reach maxUTCOffset either way, wider than any zone's.
+	if q.PurchaseDateFrom != "" {
+		from, err := time.Parse("2006-01-02", q.PurchaseDateFrom)
+		if err != nil {
+			return fmt.Errorf("%w: invalid purchase date %q, expected YYYY-MM-DD", ErrInvalidInput, q.PurchaseDateFrom)
+		}
+		if from = from.Add(-maxUTCOffset); q.PurchasedFrom == nil || q.PurchasedFrom.Before(from) {
+			q.PurchasedFrom = &from
+		}
+	}
+	if q.PurchaseDateTo != "" {
+		to, err := time.Parse("2006-01-02", q.PurchaseDateTo)
+		if err != nil {
+			return fmt.Errorf("%w: invalid purchase date %q, expected YYYY-MM-DD", ErrInvalidInput, q.PurchaseDateTo)
+		}
+		if to = to.AddDate(0, 0, 1).Add(maxUTCOffset); q.PurchasedTo == nil || q.PurchasedTo.After(to) {
+			q.PurchasedTo = &to
+		}
+	}
+
+	if q.Cursor != "" {
+		if _, err := q.decodeCursor(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Matches reports whether the receipt satisfies the query filters.
+func (q *ListQuery) Matches(receipt *Receipt) bool {
+	if q.Retailer != "" && receipt.Retailer != q.Retailer {
+		return false
+	}
+	if q.PurchasedFrom != nil && receipt.PurchaseDateTime.Before(*q.PurchasedFrom) {
+		return false
+	}
+	if q.PurchasedTo != nil && !receipt.PurchaseDateTime.Before(*q.PurchasedTo) {
+		return false
+	}
+	if q.PurchaseDateFrom != "" || q.PurchaseDateTo != "" {
+		// Dates written 2006-01-02 sort as strings.
+		date := receipt.PurchaseDateTime.Format("2006-01-02")
+		if q.PurchaseDateFrom != "" && date < q.PurchaseDateFrom || q.PurchaseDateTo != "" && date > q.PurchaseDateTo {
+			return false
+		}
+	}
+	if q.MinTotal != nil && receipt.Total.LessThan(*q.MinTotal) {
+		return false
+	}
+	if q.MaxTotal != nil && receipt.Total.GreaterThan(*q.MaxTotal) {
+		return false
+	}
+	if q.MinPoints != nil && receipt.Points < *q.MinPoints {
+		return false
+	}
+	if q.MaxPoints != nil && receipt.Points > *q.MaxPoints {
+		return false
+	}
+	return true
+}
+
+// Compare orders two receipts by the query's sort field and order. Ties
+// are broken by ID so that the order is total and pagination is stable.
+func (q *ListQuery) Compare(a, b *Receipt) int {
+	c := compareBy(q.SortBy, a, b)
+	if c == 0 {
+		c = strings.Compare(a.Id.String(), b.Id.String())
+	}
+	if q.Order == SortDescending {
+		return -c
+	}
+	return c
+}
+
+// After reports whether the receipt sorts strictly after the query's
+// cursor. It is always true when the query has no cursor.
+func (q *ListQuery) After(receipt *Receipt) (bool, error) {
+	if q.Cursor == "" {
+		return true, nil
+	}
+
+	position, err := q.decodeCursor()
+	if err != nil {
+		return false, err
+	}
+
+	return q.Compare(receipt, position) > 0, nil
+}
+
+// Position returns a receipt holding only the sort key and ID the query's
+// cursor points at, or nil when the query has no cursor. It is meant for
+// storage backends that page natively.
+func (q *ListQuery) Position() (*Receipt, error) {
+	if q.Cursor == "" {
+		return nil, nil
+	}
+	return q.decodeCursor()
+}
+
+// CursorFor returns the cursor that continues a listing after the receipt.
+func (q *ListQuery) CursorFor(receipt *Receipt) string {
+	data, _ := json.Marshal(cursor{
+		SortBy: q.SortBy,
+		Order:  q.Order,
+		Key:    sortKey(q.SortBy, receipt),
+		Id:     receipt.Id.String(),
+	})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// Paginate applies the query to a set of receipts held in memory. It is
+// meant for storage backends that cannot filter and sort natively.
+func Paginate(receipts []*Receipt, query ListQuery) (*Page, error) {
+	if err := query.Normalize(); err != nil {
+		return nil, err
+	}
+
+	matched := make([]*Receipt, 0, len(receipts))
+	for _, receipt := range receipts {
+		if !query.Matches(receipt) {
+			continue
+		}
+		after, err := query.After(receipt)
+		if err != nil {
+			return nil, err
+		}
+		if after {
+			matched = append(matched, receipt)
+		}
+	}
+
+	sort.Slice(matched, func(i, j int) bool {
+		return query.Compare(matched[i], matched[j]) < 0
+	})
+	return query.Page(matched), nil
+}
+
+// Page returns the first page of receipts already filtered and sorted by
+// the query, with a cursor to the next page if there are more than the
+// limit. Storage backends that page natively need only read one receipt
+// past the limit.
+func (q *ListQuery) Page(receipts []*Receipt) *Page {
+	page := &Page{Receipts: receipts}
+	if len(receipts) > q.Limit {
+		page.Receipts = receipts[:q.Limit]
+		page.NextCursor = q.CursorFor(page.Receipts[q.Limit-1])
+	}
+	return page
+}
+
+func (q *ListQuery) decodeCursor() (*Receipt, error) {
+	data, err := base64.RawURLEncoding.DecodeString(q.Cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var c cursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	if c.SortBy != q.SortBy || c.Order != q.Order {
+		return nil, fmt.Errorf("%w: cursor was issued for a different sort order", ErrInvalidCursor)
+	}
+
+	position, err := receiptAtKey(c.SortBy, c.Key)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	if position.Id, err = uuid.Parse(c.Id); err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	return position, nil
+}
+
+func compareBy(field SortField, a, b *Receipt) int {
+	switch field {
+	case SortByPurchaseDateTime:
+		return a.PurchaseDateTime.Compare(b.PurchaseDateTime)
+	case SortByRetailer:
+		return strings.Compare(a.Retailer, b.Retailer)
+	case SortByTotal:
+		return a.Total.Cmp(b.Total)
+	case SortByPoints:
+		switch {
+		case a.Points < b.Points:
+			return -1
+		case a.Points > b.Points:
+			return 1
+		}
+		return 0
+	default:
+		return a.CreatedAt.Compare(b.CreatedAt)
+	}
+}
+
+func sortKey(field SortField, receipt *Receipt) string {
+	switch field {
+	case SortByPurchaseDateTime:
+		return receipt.PurchaseDateTime.Format(time.RFC3339Nano)
+	case SortByRetailer:
+		return receipt.Retailer
+	case SortByTotal:
+		return receipt.Total.String()
+	case SortByPoints:
+		return strconv.FormatInt(receipt.Points, 10)
+	default:
+		return receipt.CreatedAt.Format(time.RFC3339Nano)
+	}
+}
+
+// receiptAtKey builds a receipt holding only the sort key, so that cursor
+// positions can be compared with Compare.
+func receiptAtKey(field SortField, key string) (*Receipt, error) {
+	var (
+		position Receipt
+		err      error
+	)
+	switch field {
+	case SortByPurchaseDateTime:
+		position.PurchaseDateTime, err = time.Parse(time.RFC3339Nano, key)
+	case SortByRetailer:
+		position.Retailer = key
+	case SortByTotal:
+		position.Total, err = decimal.NewFromString(key)
+	case SortByPoints:
+		position.Points, err = strconv.ParseInt(key, 10, 64)
+	default:
+		position.CreatedAt, err = time.Parse(time.RFC3339Nano, key)
+	}
+	return &position, err
+}
@@ -0,0 +1,213 @@
+package receipt
+
+import (
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"testing"
+	"time"
+)
+
+func newQueryTestReceipts() []*Receipt {
+	base := time.Date(2023, time.January, 1, 12, 0, 0, 0, time.UTC)
+	receipts := make([]*Receipt, 0, 5)
+	for i, retailer := range []string{"Target", "Walgreens", "Target", "M&M Corner Market", "Target"} {
+		receipts = append(receipts, &Receipt{
+			Id:               uuid.New(),
+			Retailer:         retailer,
+			PurchaseDateTime: base.AddDate(0, 0, i),
+			Total:            decimal.NewFromInt(int64(10 * (i + 1))),
+			Points:           int64(100 - i*10),
+			CreatedAt:        base.Add(time.Duration(i) * time.Minute),
+		})
+	}
+	return receipts
+}
+
+func TestPaginate(t *testing.T) {
+	receipts := newQueryTestReceipts()
+	from := time.Date(2023, time.January, 2, 0, 0, 0, 0, time.UTC)
+	minTotal := decimal.NewFromInt(20)
+	maxPoints := int64(80)
+
+	tests := []struct {
+		name  string
+		query ListQuery
+		want  []*Receipt
+	}{
+		{
+			name:  "default order",
+			query: ListQuery{},
+			want:  receipts,
+		},
+		{
+			name:  "retailer filter",
+			query: ListQuery{Retailer: "Target"},
+			want:  []*Receipt{receipts[0], receipts[2], receipts[4]},
+		},
+		{
+			name:  "range filters",
+			query: ListQuery{PurchasedFrom: &from, MinTotal: &minTotal, MaxPoints: &maxPoints},
+			want:  []*Receipt{receipts[2], receipts[3], receipts[4]},
+		},
+		{
+			name:  "sort by points ascending",
+			query: ListQuery{SortBy: SortByPoints},
+			want:  []*Receipt{receipts[4], receipts[3], receipts[2], receipts[1], receipts[0]},
+		},
+		{
+			name:  "sort by total descending",
+			query: ListQuery{SortBy: SortByTotal, Order: SortDescending},
+			want:  []*Receipt{receipts[4], receipts[3], receipts[2], receipts[1], receipts[0]},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page, err := Paginate(receipts, tt.query)
+			if err != nil {
+				t.Fatalf("Paginate() error = %v", err)
+			}
+			if len(page.Receipts) != len(tt.want) {
+				t.Fatalf("Paginate() receipts = %v, want %v", len(page.Receipts), len(tt.want))
+			}
+			for i := range tt.want {
+				if page.Receipts[i] != tt.want[i] {
+					t.Errorf("Paginate() receipt %d = %v, want %v", i, page.Receipts[i].Retailer, tt.want[i].Retailer)
+				}
+			}
+		})
+	}
+}
+
+// TestPaginate_PurchaseDate filters on the date of each purchase where it
+// was made, which for stores far from UTC is not the date in UTC.
+func TestPaginate_PurchaseDate(t *testing.T) {
+	chicago, err := time.LoadLocation("America/Chicago")
+	if err != nil {
+		t.Fatalf("LoadLocation() error = %v", err)
+	}
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Fatalf("LoadLocation() error = %v", err)
+	}
+	receipts := []*Receipt{
+		// 2022-01-02 05:30 in UTC.
+		{Id: uuid.New(), PurchaseDateTime: time.Date(2022, time.January, 1, 23, 30, 0, 0, chicago)},
+		// 2022-01-01 15:30 in UTC.
+		{Id: uuid.New(), PurchaseDateTime: time.Date(2022, time.January, 2, 0, 30, 0, 0, tokyo)},
+		{Id: uuid.New(), PurchaseDateTime: time.Date(2022, time.January, 2, 12, 0, 0, 0, time.UTC)},
+		// 2022-01-02 12:30 in UTC, later than the day ends in any zone.
+		{Id: uuid.New(), PurchaseDateTime: time.Date(2022, time.January, 1, 23, 30, 0, 0, time.FixedZone("-13:00", -13*60*60))},
+	}
+
+	tests := []struct {
+		name     string
+		from, to string
+		want     []*Receipt
+	}{
+		{"one day", "2022-01-01", "2022-01-01", []*Receipt{receipts[0], receipts[3]}},
+		{"from", "2022-01-02", "", receipts[1:3]},
+		{"to", "", "2022-01-01", []*Receipt{receipts[0], receipts[3]}},
+		{"no purchases", "2022-01-03", "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page, err := Paginate(receipts, ListQuery{SortBy: SortByPurchaseDateTime, PurchaseDateFrom: tt.from, PurchaseDateTo: tt.to})
+			if err != nil {
+				t.Fatalf("Paginate() error = %v", err)
+			}
+			if len(page.Receipts) != len(tt.want) {
+				t.Fatalf("Paginate() receipts = %v, want %v", len(page.Receipts), len(tt.want))
+			}
+			for i := range tt.want {
+				if page.Receipts[i] != tt.want[i] {
+					t.Errorf("Paginate() receipt %d = %v, want %v", i, page.Receipts[i].PurchaseDateTime, tt.want[i].PurchaseDateTime)
+				}
+			}
+		})
+	}
+}
+
+func TestPaginate_Cursor(t *testing.T) {
+	receipts := newQueryTestReceipts()
+	query := ListQuery{SortBy: SortByRetailer, Order: SortDescending, Limit: 2}
+
+	var got []*Receipt
+	for pages := 0; ; pages++ {
+		if pages > len(receipts) {
+			t.Fatal("Paginate() did not terminate")
+		}
+		page, err := Paginate(receipts, query)
+		if err != nil {
+			t.Fatalf("Paginate() error = %v", err)
+		}
+		got = append(got, page.Receipts...)
+		if page.NextCursor == "" {
+			break
+		}
+		query.Cursor = page.NextCursor
+	}
+
+	if len(got) != len(receipts) {
+		t.Fatalf("Paginate() receipts = %v, want %v", len(got), len(receipts))
+	}
+
+	seen := make(map[uuid.UUID]bool)
+	for i, receipt := range got {
+		if seen[receipt.Id] {
+			t.Errorf("Paginate() returned receipt %v twice", receipt.Id)
+		}
+		seen[receipt.Id] = true
+		if i > 0 && got[i-1].Retailer < receipt.Retailer {
+			t.Errorf("Paginate() receipt %d is out of order", i)
+		}
+	}
+}
+
+func TestListQuery_Normalize(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   ListQuery
+		wantErr bool
+	}{
+		{
+			name:    "defaults",
+			query:   ListQuery{},
+			wantErr: false,
+		},
+		{
+			name:    "unknown sort",
+			query:   ListQuery{SortBy: "blah"},
+			wantErr: true,
+		},
+		{
+			name:    "limit too large",
+			query:   ListQuery{Limit: MaxListLimit + 1},
+			wantErr: true,
+		},
+		{
+			name:    "bad purchase date",
+			query:   ListQuery{PurchaseDateTo: "2022-13-01"},
+			wantErr: true,
+		},
+		{
+			name:    "garbage cursor",
+			query:   ListQuery{Cursor: "blah"},
+			wantErr: true,
+		},
+		{
+			name: "cursor for another order",
+			query: ListQuery{
+				Order:  SortDescending,
+				Cursor: (&ListQuery{SortBy: SortByCreatedAt, Order: SortAscending}).CursorFor(&Receipt{Id: uuid.New()}),
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.query.Normalize(); (err != nil) != tt.wantErr {
+				t.Errorf("Normalize() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -1,8 +1,16 @@
 package receipt
 
 type Repository interface {
+	// Create stores a new receipt at Version 1.
 	Create(receipt *Receipt) (*Receipt, error)
 	Get(id string) (*Receipt, error)
+	// Update replaces the receipt stored under id and advances its
+	// Version. If receipt.Version is not 0, the stored receipt must still be
+	// at that version, or Update fails with a *VersionConflictError.
 	Update(id string, receipt *Receipt) (*Receipt, error)
-	Delete(id string) error
+	// Delete removes the receipt stored under id. If version is not 0, the
+	// stored receipt must still be at that version, or Delete fails with a
+	// *VersionConflictError.
+	Delete(id string, version int64) error
+	List(query ListQuery) (*Page, error)
 }
@@ -0,0 +1,382 @@
+package receipt
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"log"
+	"time"
+)
+
+const (
+	// MaxRescoreJobChanges bounds how many points changes a job records.
+	MaxRescoreJobChanges = 1000
+	// MaxRescoreJobErrors bounds how many receipt errors a job records.
+	MaxRescoreJobErrors = 100
+	// rescorePageSize is how many receipts a job reads at a time.
+	rescorePageSize = 100
+)
+
+var ErrRescoreJobRunning = fmt.Errorf("%w: a rescore job is already running", ErrConflict)
+
+type JobStatus string
+
+const (
+	JobRunning   JobStatus = "running"
+	JobCompleted JobStatus = "completed"
+	JobFailed    JobStatus = "failed"
+	JobCancelled JobStatus = "cancelled"
+)
+
+// RescoreJob scores the stored receipts matching Query again with a
+// ruleset version and stores the new points. Its progress is saved after
+// every receipt, so that a job interrupted by a restart resumes after the
+// last receipt it saved. Only the receipt being rescored when the process
+// stopped can be scored again, and if its new points had been stored, it
+// is then counted as unchanged.
+type RescoreJob struct {
+	Id             string
+	RulesetVersion string
+	// Query holds the filters; the job walks the receipts in creation
+	// order.
+	Query  ListQuery
+	Status JobStatus
+	// Error is why a failed job stopped.
+	Error string
+	// Total is the number of receipts the job counted when it started.
+	Total     int
+	Processed int
+	Changed   int
+	Failed    int
+	// PointsBefore and PointsAfter sum the points of the receipts processed
+	// so far before and after rescoring.
+	PointsBefore int64
+	PointsAfter  int64
+	// Changes lists the first MaxRescoreJobChanges receipts whose points
+	// changed, and Errors the first MaxRescoreJobErrors that failed.
+	Changes    []PointsChange
+	Errors     []ReceiptError
+	Cursor     string
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	FinishedAt time.Time
+}
+
+// PointsChange is the points of a receipt before and after rescoring.
+type PointsChange struct {
+	ReceiptId string
+	Before    int64
+	After     int64
+}
+
+// ReceiptError is why a job could not rescore a receipt.
+type ReceiptError struct {
+	ReceiptId string
+	Error     string
+}
+
+// RescoreJobRepository stores rescore jobs.
+type RescoreJobRepository interface {
+	Create(job *RescoreJob) error
+	Get(id string) (*RescoreJob, error)
+	Update(job *RescoreJob) error
+	// List returns every job, newest first.
+	List() ([]*RescoreJob, error)
+}
+
+// WithRescoreJobs enables rescore jobs, which are stored in jobs.
+func WithRescoreJobs(jobs RescoreJobRepository) Option {
+	return func(s *Service) {
+		s.rescoreJobs = jobs
+	}
+}
+
+// StartRescoreJob starts a job in the background scoring the receipts
+// matching the query's filters with a ruleset version. Only one job runs
+// at a time.
+func (s *Service) StartRescoreJob(ctx context.Context, version string, query ListQuery) (*RescoreJob, error) {
+	if s.rescoreJobs == nil {
+		return nil, fmt.Errorf("%w: rescore jobs are not enabled", ErrUnavailable)
+	}
+	if _, err := s.rulesets.Get(version); err != nil {
+		return nil, fmt.Errorf("%w: unknown ruleset version %q", ErrInvalidInput, version)
+	}
+
+	query.SortBy = SortByCreatedAt
+	query.Order = SortAscending
+	query.Cursor = ""
+	query.Limit = rescorePageSize
+
+	now := time.Now().UTC()
+	job := &RescoreJob{
+		Id:             uuid.New().String(),
+		RulesetVersion: version,
+		Query:          query,
+		Status:         JobRunning,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+
+	s.jobsMu.Lock()
+	defer s.jobsMu.Unlock()
+	if s.jobsStopped {
+		return nil, fmt.Errorf("%w: the service is stopping", ErrUnavailable)
+	}
+	if len(s.runningJobs) > 0 {
+		return nil, ErrRescoreJobRunning
+	}
+	if err := s.rescoreJobs.Create(job); err != nil {
+		return nil, err
+	}
+	started := *job
+	s.runJob(job)
+	return &started, nil
+}
+
+// ResumeRescoreJobs restarts the jobs that were running when the service
+// last stopped.
+func (s *Service) ResumeRescoreJobs(ctx context.Context) error {
+	if s.rescoreJobs == nil {
+		return nil
+	}
+	jobs, err := s.rescoreJobs.List()
+	if err != nil {
+		return err
+	}
+
+	s.jobsMu.Lock()
+	defer s.jobsMu.Unlock()
+	if s.jobsStopped {
+		return nil
+	}
+	for _, job := range jobs {
+		if job.Status == JobRunning && s.runningJobs[job.Id] == nil {
+			log.Printf("Resuming rescore job %s at %d of %d receipts", job.Id, job.Processed, job.Total)
+			s.runJob(job)
+		}
+	}
+	return nil
+}
+
+// StopRescoreJobs stops the jobs running in this process without finishing
+// them, so that ResumeRescoreJobs picks them up again when the service next
+// starts, and waits until they have saved their progress or ctx is done. No
+// job starts afterwards.
+func (s *Service) StopRescoreJobs(ctx context.Context) error {
+	s.jobsMu.Lock()
+	s.jobsStopped = true
+	running := make([]*runningJob, 0, len(s.runningJobs))
+	for _, job := range s.runningJobs {
+		job.cancel()
+		running = append(running, job)
+	}
+	s.jobsMu.Unlock()
+
+	for _, job := range running {
+		select {
+		case <-job.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func (s *Service) GetRescoreJob(ctx context.Context, id string) (*RescoreJob, error) {
+	if s.rescoreJobs == nil {
+		return nil, fmt.Errorf("rescore job %s: %w", id, ErrNotFound)
+	}
+	return s.rescoreJobs.Get(id)
+}
+
+func (s *Service) ListRescoreJobs(ctx context.Context) ([]*RescoreJob, error) {
+	if s.rescoreJobs == nil {
+		return nil, nil
+	}
+	return s.rescoreJobs.List()
+}
+
+// CancelRescoreJob stops a running job. The receipts it already rescored
+// keep their new points.
+func (s *Service) CancelRescoreJob(ctx context.Context, id string) (*RescoreJob, error) {
+	s.jobsMu.Lock()
+	running := s.runningJobs[id]
+	if running == nil {
+		defer s.jobsMu.Unlock()
+
+		// A job that is not running in this process has finished, or was
+		// left running by a process that stopped; either way nothing else
+		// updates it.
+		job, err := s.GetRescoreJob(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if job.Status != JobRunning {
+			return nil, fmt.Errorf("%w: rescore job %s is %s", ErrConflict, id, job.Status)
+		}
+		job.Status = JobCancelled
+		job.FinishedAt = time.Now().UTC()
+		job.UpdatedAt = job.FinishedAt
+		if err := s.rescoreJobs.Update(job); err != nil {
+			return nil, err
+		}
+		return job, nil
+	}
+	s.jobsMu.Unlock()
+
+	running.cancel()
+	<-running.done
+	return s.rescoreJobs.Get(id)
+}
+
+type runningJob struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// runJob runs the job in a goroutine. The caller must hold jobsMu.
+func (s *Service) runJob(job *RescoreJob) {
+	ctx, cancel := context.WithCancel(context.Background())
+	running := &runningJob{cancel: cancel, done: make(chan struct{})}
+	if s.runningJobs == nil {
+		s.runningJobs = make(map[string]*runningJob)
+	}
+	s.runningJobs[job.Id] = running
+
+	go func() {
+		defer close(running.done)
+		defer func() {
+			s.jobsMu.Lock()
+			delete(s.runningJobs, job.Id)
+			s.jobsMu.Unlock()
+			cancel()
+		}()
+
+		err := s.rescore(ctx, job)
+		if err != nil && ctx.Err() != nil && s.stopped() {
+			// The job stays running, and resumes from the progress rescore
+			// saved.
+			return
+		}
+		if err != nil {
+			job.Status = JobFailed
+			job.Error = err.Error()
+			if ctx.Err() != nil {
+				job.Status = JobCancelled
+				job.Error = ""
+			}
+		} else {
+			job.Status = JobCompleted
+		}
+		job.FinishedAt = time.Now().UTC()
+		job.UpdatedAt = job.FinishedAt
+		if err := s.rescoreJobs.Update(job); err != nil {
+			log.Printf("rescore job %s: saving final status: %v", job.Id, err)
+		}
+	}()
+}
+
+// stopped reports whether StopRescoreJobs has been called.
+func (s *Service) stopped() bool {
+	s.jobsMu.Lock()
+	defer s.jobsMu.Unlock()
+	return s.jobsStopped
+}
+
+// rescore works through the job's receipts one page at a time, saving its
+// progress after each receipt.
+func (s *Service) rescore(ctx context.Context, job *RescoreJob) error {
+	ruleset, err := s.rulesets.Get(job.RulesetVersion)
+	if err != nil {
+		return err
+	}
+
+	if job.Cursor == "" && job.Processed == 0 {
+		if job.Total, err = s.count(ctx, job.Query); err != nil {
+			return err
+		}
+	}
+
+	query := job.Query
+	query.Cursor = job.Cursor
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		page, err := s.receiptRepository.List(query)
+		if err != nil {
+			return err
+		}
+
+		for _, receipt := range page.Receipts {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			s.rescoreOne(job, receipt, ruleset)
+			job.Cursor = query.CursorFor(receipt)
+			if err := s.saveJob(job); err != nil {
+				return err
+			}
+		}
+
+		if page.NextCursor == "" {
+			return nil
+		}
+		query.Cursor = page.NextCursor
+	}
+}
+
+// rescoreOne scores one receipt with the ruleset and records the outcome
+// on the job.
+func (s *Service) rescoreOne(job *RescoreJob, receipt *Receipt, ruleset *Ruleset) {
+	job.Processed++
+
+	job.PointsBefore += receipt.Points
+
+	rescored := *receipt
+	err := s.scoreWith(&rescored, ruleset)
+	if err == nil && rescored.Points == receipt.Points && rescored.RulesetVersion == receipt.RulesetVersion && sameRevisions(rescored.Campaigns, receipt.Campaigns) {
+		job.PointsAfter += receipt.Points
+		return
+	}
+	if err == nil {
+		_, err = s.receiptRepository.Update(receipt.Id.String(), &rescored)
+	}
+	if err != nil {
+		job.Failed++
+		job.PointsAfter += receipt.Points
+		if len(job.Errors) < MaxRescoreJobErrors {
+			job.Errors = append(job.Errors, ReceiptError{ReceiptId: receipt.Id.String(), Error: err.Error()})
+		}
+		return
+	}
+	job.PointsAfter += rescored.Points
+
+	if rescored.Points != receipt.Points {
+		job.Changed++
+		if len(job.Changes) < MaxRescoreJobChanges {
+			job.Changes = append(job.Changes, PointsChange{
+				ReceiptId: receipt.Id.String(),
+				Before:    receipt.Points,
+				After:     rescored.Points,
+			})
+		}
+	}
+}
+
+// saveJob saves the job's progress.
+func (s *Service) saveJob(job *RescoreJob) error {
+	job.UpdatedAt = time.Now().UTC()
+	return s.rescoreJobs.Update(job)
+}
+
+// count returns the number of receipts matching the query's filters.
+func (s *Service) count(ctx context.Context, query ListQuery) (int, error) {
+	total := 0
+	err := s.Export(ctx, query, func(*Receipt) error {
+		total++
+		return nil
+	})
+	return total, err
+}
@@ -0,0 +1,375 @@
+package receipt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRescoreJobRepository is a minimal in-memory RescoreJobRepository
+// that copies jobs in and out like a real store.
+type fakeRescoreJobRepository struct {
+	mu   sync.Mutex
+	jobs map[string]RescoreJob
+}
+
+func newFakeRescoreJobRepository() *fakeRescoreJobRepository {
+	return &fakeRescoreJobRepository{jobs: make(map[string]RescoreJob)}
+}
+
+func (f *fakeRescoreJobRepository) Create(job *RescoreJob) error {
+	return f.Update(job)
+}
+
+func (f *fakeRescoreJobRepository) Get(id string) (*RescoreJob, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	job, ok := f.jobs[id]
+	if !ok {
+		return nil, fmt.Errorf("rescore job %s: %w", id, ErrNotFound)
+	}
+	job.Changes = append([]PointsChange(nil), job.Changes...)
+	job.Errors = append([]ReceiptError(nil), job.Errors...)
+	return &job, nil
+}
+
+func (f *fakeRescoreJobRepository) Update(job *RescoreJob) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	stored := *job
+	stored.Changes = append([]PointsChange(nil), job.Changes...)
+	stored.Errors = append([]ReceiptError(nil), job.Errors...)
+	f.jobs[job.Id] = stored
+	return nil
+}
+
+func (f *fakeRescoreJobRepository) List() ([]*RescoreJob, error) {
+	f.mu.Lock()
+	ids := make([]string, 0, len(f.jobs))
+	for id := range f.jobs {
+		ids = append(ids, id)
+	}
+	f.mu.Unlock()
+
+	jobs := make([]*RescoreJob, 0, len(ids))
+	for _, id := range ids {
+		job, err := f.Get(id)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+// blockingRepository blocks the first Update until release is closed.
+type blockingRepository struct {
+	*fakeRepository
+	once    sync.Once
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingRepository) Update(id string, receipt *Receipt) (*Receipt, error) {
+	b.once.Do(func() {
+		close(b.entered)
+		<-b.release
+	})
+	return b.fakeRepository.Update(id, receipt)
+}
+
+// newRescoreTestService returns a service whose receipts are scored with
+// the default ruleset, and which also knows a ruleset v2 awarding 100
+// points to every receipt.
+func newRescoreTestService(t *testing.T, repo Repository, jobs RescoreJobRepository) *Service {
+	t.Helper()
+	v2, err := ParseRuleset(strings.NewReader(`{"version": "v2", "effectiveFrom": "2999-01-01T00:00:00Z", "rules": [{"type": "expression", "params": {"expression": "points = 100"}}]}`))
+	if err != nil {
+		t.Fatalf("ParseRuleset() error = %v", err)
+	}
+	registry, err := NewRulesetRegistry(DefaultRuleset(), v2)
+	if err != nil {
+		t.Fatalf("NewRulesetRegistry() error = %v", err)
+	}
+	return NewService(repo, WithRulesets(registry), WithRescoreJobs(jobs))
+}
+
+func createRescoreTestReceipts(t *testing.T, service *Service, retailers ...string) []*Receipt {
+	t.Helper()
+	var receipts []*Receipt
+	for _, retailer := range retailers {
+		dto := newServiceTestDTO()
+		dto.Retailer = retailer
+		receipt, err := service.Create(context.Background(), dto)
+		if err != nil {
+			t.Fatalf("Create() error = %v", err)
+		}
+		receipts = append(receipts, receipt)
+		time.Sleep(time.Millisecond)
+	}
+	return receipts
+}
+
+func waitForJob(t *testing.T, service *Service, id string) *RescoreJob {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		job, err := service.GetRescoreJob(context.Background(), id)
+		if err != nil {
+			t.Fatalf("GetRescoreJob() error = %v", err)
+		}
+		if job.Status != JobRunning {
+			return job
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("rescore job %s did not finish", id)
+	return nil
+}
+
+func TestService_RescoreJob(t *testing.T) {
+	repo := newFakeRepository()
+	service := newRescoreTestService(t, repo, newFakeRescoreJobRepository())
+	receipts := createRescoreTestReceipts(t, service, "Target", "Walgreens", "Target")
+
+	started, err := service.StartRescoreJob(context.Background(), "v2", ListQuery{Retailer: "Target"})
+	if err != nil {
+		t.Fatalf("StartRescoreJob() error = %v", err)
+	}
+
+	job := waitForJob(t, service, started.Id)
+	if job.Status != JobCompleted {
+		t.Fatalf("job status = %v (%s), want completed", job.Status, job.Error)
+	}
+	if job.Total != 2 || job.Processed != 2 || job.Changed != 2 || job.Failed != 0 {
+		t.Errorf("job progress = %d/%d, %d changed, %d failed, want 2/2, 2 changed, 0 failed", job.Processed, job.Total, job.Changed, job.Failed)
+	}
+	wantBefore := receipts[0].Points + receipts[2].Points
+	if job.PointsBefore != wantBefore || job.PointsAfter != 200 {
+		t.Errorf("job points = %d -> %d, want %d -> 200", job.PointsBefore, job.PointsAfter, wantBefore)
+	}
+	if len(job.Changes) != 2 || job.Changes[0].ReceiptId != receipts[0].Id.String() || job.Changes[0].After != 100 {
+		t.Errorf("job changes = %+v, want receipts 0 and 2 changed to 100", job.Changes)
+	}
+
+	for i, receipt := range receipts {
+		stored, _ := repo.Get(receipt.Id.String())
+		wantVersion := "v2"
+		if i == 1 {
+			wantVersion = DefaultRulesetVersion
+		}
+		if stored.RulesetVersion != wantVersion {
+			t.Errorf("receipt %d ruleset version = %q, want %q", i, stored.RulesetVersion, wantVersion)
+		}
+	}
+
+	if _, err := service.StartRescoreJob(context.Background(), "v9", ListQuery{}); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("StartRescoreJob() error = %v, want ErrInvalidInput", err)
+	}
+	if _, err := service.CancelRescoreJob(context.Background(), job.Id); !errors.Is(err, ErrConflict) {
+		t.Errorf("CancelRescoreJob() error = %v, want ErrConflict", err)
+	}
+}
+
+func TestService_RescoreJobCancel(t *testing.T) {
+	repo := &blockingRepository{
+		fakeRepository: newFakeRepository(),
+		entered:        make(chan struct{}),
+		release:        make(chan struct{}),
+	}
+	service := newRescoreTestService(t, repo, newFakeRescoreJobRepository())
+	createRescoreTestReceipts(t, service, "Target", "Target", "Target")
+
+	started, err := service.StartRescoreJob(context.Background(), "v2", ListQuery{})
+	if err != nil {
+		t.Fatalf("StartRescoreJob() error = %v", err)
+	}
+	<-repo.entered
+
+	if _, err := service.StartRescoreJob(context.Background(), "v2", ListQuery{}); !errors.Is(err, ErrRescoreJobRunning) {
+		t.Errorf("StartRescoreJob() error = %v, want ErrRescoreJobRunning", err)
+	}
+
+	// Cancel the job while its first update is in flight.
+	service.jobsMu.Lock()
+	running := service.runningJobs[started.Id]
+	service.jobsMu.Unlock()
+	running.cancel()
+	close(repo.release)
+
+	job := waitForJob(t, service, started.Id)
+	if job.Status != JobCancelled {
+		t.Errorf("job status = %v, want cancelled", job.Status)
+	}
+	if job.Processed != 1 {
+		t.Errorf("job processed = %d, want 1", job.Processed)
+	}
+}
+
+func TestService_StopRescoreJobs(t *testing.T) {
+	repo := &blockingRepository{
+		fakeRepository: newFakeRepository(),
+		entered:        make(chan struct{}),
+		release:        make(chan struct{}),
+	}
+	jobs := newFakeRescoreJobRepository()
+	service := newRescoreTestService(t, repo, jobs)
+	createRescoreTestReceipts(t, service, "Target", "Target", "Target")
+
+	started, err := service.StartRescoreJob(context.Background(), "v2", ListQuery{})
+	if err != nil {
+		t.Fatalf("StartRescoreJob() error = %v", err)
+	}
+	<-repo.entered
+
+	// Stop the service while the job's first update is in flight.
+	stopped := make(chan error)
+	go func() {
+		stopped <- service.StopRescoreJobs(context.Background())
+	}()
+	for !service.stopped() {
+		time.Sleep(time.Millisecond)
+	}
+	close(repo.release)
+	if err := <-stopped; err != nil {
+		t.Fatalf("StopRescoreJobs() error = %v", err)
+	}
+
+	job, err := jobs.Get(started.Id)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if job.Status != JobRunning || job.Processed != 1 {
+		t.Errorf("job = %v, %d processed, want running, 1 processed", job.Status, job.Processed)
+	}
+	if _, err := service.StartRescoreJob(context.Background(), "v2", ListQuery{}); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("StartRescoreJob() after StopRescoreJobs() error = %v, want ErrUnavailable", err)
+	}
+
+	restarted := newRescoreTestService(t, repo.fakeRepository, jobs)
+	if err := restarted.ResumeRescoreJobs(context.Background()); err != nil {
+		t.Fatalf("ResumeRescoreJobs() error = %v", err)
+	}
+	if job := waitForJob(t, restarted, started.Id); job.Status != JobCompleted || job.Processed != 3 {
+		t.Errorf("resumed job = %v, %d processed, want completed, 3 processed", job.Status, job.Processed)
+	}
+}
+
+func TestService_CancelRescoreJobNotRunningHere(t *testing.T) {
+	jobs := newFakeRescoreJobRepository()
+	service := newRescoreTestService(t, newFakeRepository(), jobs)
+
+	// A job left running by a process that stopped.
+	if err := jobs.Create(&RescoreJob{Id: "orphan", RulesetVersion: "v2", Status: JobRunning}); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	job, err := service.CancelRescoreJob(context.Background(), "orphan")
+	if err != nil {
+		t.Fatalf("CancelRescoreJob() error = %v", err)
+	}
+	if job.Status != JobCancelled {
+		t.Errorf("job status = %v, want cancelled", job.Status)
+	}
+	if _, err := service.CancelRescoreJob(context.Background(), "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("CancelRescoreJob() error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestService_ResumeRescoreJobs(t *testing.T) {
+	repo := newFakeRepository()
+	jobs := newFakeRescoreJobRepository()
+	service := newRescoreTestService(t, repo, jobs)
+	receipts := createRescoreTestReceipts(t, service, "Target", "Target", "Target")
+
+	// A job that had finished the first receipt when the service stopped.
+	query := ListQuery{SortBy: SortByCreatedAt, Order: SortAscending, Limit: rescorePageSize}
+	interrupted := &RescoreJob{
+		Id:             "interrupted",
+		RulesetVersion: "v2",
+		Query:          query,
+		Status:         JobRunning,
+		Total:          3,
+		Processed:      1,
+		Cursor:         query.CursorFor(receipts[0]),
+		CreatedAt:      time.Now().UTC(),
+	}
+	if err := jobs.Create(interrupted); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	restarted := newRescoreTestService(t, repo, jobs)
+	if err := restarted.ResumeRescoreJobs(context.Background()); err != nil {
+		t.Fatalf("ResumeRescoreJobs() error = %v", err)
+	}
+
+	job := waitForJob(t, restarted, "interrupted")
+	if job.Status != JobCompleted || job.Processed != 3 || job.Changed != 2 {
+		t.Errorf("job = %v, %d processed, %d changed, want completed, 3 processed, 2 changed", job.Status, job.Processed, job.Changed)
+	}
+
+	first, _ := repo.Get(receipts[0].Id.String())
+	if first.RulesetVersion != DefaultRulesetVersion {
+		t.Errorf("first receipt ruleset version = %q, want it not rescored again", first.RulesetVersion)
+	}
+}
+
+// recordingRescoreJobRepository records every job it saves.
+type recordingRescoreJobRepository struct {
+	*fakeRescoreJobRepository
+	saved []RescoreJob
+}
+
+func (r *recordingRescoreJobRepository) Update(job *RescoreJob) error {
+	r.mu.Lock()
+	r.saved = append(r.saved, *job)
+	r.mu.Unlock()
+	return r.fakeRescoreJobRepository.Update(job)
+}
+
+// TestService_RescoreJobCheckpoints checks that a job's progress is saved
+// after every receipt, so that a job interrupted at any point resumes with
+// the totals of the receipts it had rescored.
+func TestService_RescoreJobCheckpoints(t *testing.T) {
+	repo := newFakeRepository()
+	jobs := &recordingRescoreJobRepository{fakeRescoreJobRepository: newFakeRescoreJobRepository()}
+	service := newRescoreTestService(t, repo, jobs)
+	receipts := createRescoreTestReceipts(t, service, "Target", "Target", "Target")
+
+	started, err := service.StartRescoreJob(context.Background(), "v2", ListQuery{SortBy: SortByCreatedAt, Order: SortAscending})
+	if err != nil {
+		t.Fatalf("StartRescoreJob() error = %v", err)
+	}
+	if job := waitForJob(t, service, started.Id); job.Status != JobCompleted {
+		t.Fatalf("job status = %v (%s), want completed", job.Status, job.Error)
+	}
+
+	jobs.mu.Lock()
+	defer jobs.mu.Unlock()
+	processed := 0
+	var pointsBefore int64
+	for _, saved := range jobs.saved {
+		if saved.Status != JobRunning || saved.Processed == processed {
+			continue
+		}
+		if saved.Processed != processed+1 {
+			t.Fatalf("job saved with %d processed after %d, want every receipt saved", saved.Processed, processed)
+		}
+		pointsBefore += receipts[processed].Points
+		processed++
+		if saved.Changed != processed || len(saved.Changes) != processed || saved.PointsBefore != pointsBefore {
+			t.Errorf("job saved after %d receipts = %d changed, %d changes, %d points before, want %d, %d, %d",
+				processed, saved.Changed, len(saved.Changes), saved.PointsBefore, processed, processed, pointsBefore)
+		}
+		if want := saved.Query.CursorFor(receipts[processed-1]); saved.Cursor != want {
+			t.Errorf("job saved after %d receipts cursor = %q, want %q", processed, saved.Cursor, want)
+		}
+	}
+	if processed != len(receipts) {
+		t.Errorf("job saved progress for %d receipts, want %d", processed, len(receipts))
+	}
+}
@@ -0,0 +1,110 @@
+package receipt
+
+import (
+	"fmt"
+	"github.com/shopspring/decimal"
+	"strings"
+)
+
+// Rounding is how fractional points are rounded to whole points. Points are
+// a liability, so the rounding of every rule is explicit: a rule whose
+// points may have a fraction documents its default rounding, which its
+// "rounding" parameter or the ruleset's "rounding" replaces.
+type Rounding string
+
+const (
+	// RoundCeil rounds up, towards positive infinity: 2.1 is 3 and -2.9 is
+	// -2.
+	RoundCeil Rounding = "ceil"
+	// RoundFloor rounds down, towards negative infinity: 2.9 is 2 and -2.1
+	// is -3.
+	RoundFloor Rounding = "floor"
+	// RoundHalfUp rounds to the nearest integer, and halves up: 2.5 is 3
+	// and -2.5 is -2.
+	RoundHalfUp Rounding = "halfUp"
+	// RoundHalfEven rounds to the nearest integer, and halves to the even
+	// integer: 2.5 is 2, 3.5 is 4 and -2.5 is -2.
+	RoundHalfEven Rounding = "halfEven"
+	// RoundBankers is banker's rounding, another name for RoundHalfEven.
+	RoundBankers Rounding = "bankers"
+)
+
+var roundings = []Rounding{RoundCeil, RoundFloor, RoundHalfUp, RoundHalfEven, RoundBankers}
+
+// Round rounds points to a whole number of points.
+func (r Rounding) Round(points decimal.Decimal) int64 {
+	switch r {
+	case RoundCeil:
+		return points.Ceil().IntPart()
+	case RoundHalfUp:
+		return points.Add(decimal.NewFromFloat(0.5)).Floor().IntPart()
+	case RoundHalfEven, RoundBankers:
+		return points.RoundBank(0).IntPart()
+	default:
+		return points.Floor().IntPart()
+	}
+}
+
+// Validate rejects unknown roundings. The empty rounding is valid and means
+// the default.
+func (r Rounding) Validate() error {
+	if r == "" {
+		return nil
+	}
+	for _, rounding := range roundings {
+		if r == rounding {
+			return nil
+		}
+	}
+
+	names := make([]string, len(roundings))
+	for i, rounding := range roundings {
+		names[i] = string(rounding)
+	}
+	return fmt.Errorf("unknown rounding %q, expected one of %s", r, strings.Join(names, ", "))
+}
+
+// or returns the rounding, or def when it is empty.
+func (r Rounding) or(def Rounding) Rounding {
+	if r == "" {
+		return def
+	}
+	return r
+}
+
+// describe completes "round ..." in rule descriptions.
+func (r Rounding) describe() string {
+	switch r {
+	case RoundCeil:
+		return "up to the nearest integer"
+	case RoundHalfUp:
+		return "to the nearest integer, with halves rounded up"
+	case RoundHalfEven, RoundBankers:
+		return "to the nearest integer, with halves rounded to even"
+	default:
+		return "down to the nearest integer"
+	}
+}
+
+// RoundingParam is the "rounding" parameter of the rules whose points may
+// have a fraction.
+type RoundingParam struct {
+	Rounding Rounding `json:"rounding"`
+}
+
+// setDefaultRounding sets the rounding when the rule's parameters leave it
+// unset.
+func (p *RoundingParam) setDefaultRounding(rounding Rounding) {
+	if p.Rounding == "" {
+		p.Rounding = rounding
+	}
+}
+
+// FractionalRule is a rule whose points may have a fraction. Calculate
+// rounds the points by the rule's rounding, while ExactPoints returns them
+// unrounded, for rulesets that add up fractional points and round once per
+// receipt.
+type FractionalRule interface {
+	PointRule
+	ExactPoints(*Receipt) decimal.Decimal
+}
@@ -0,0 +1,115 @@
+package receipt
+
+import (
+	"github.com/shopspring/decimal"
+	"strings"
+	"testing"
+)
+
+func TestRounding_Round(t *testing.T) {
+	values := []string{"2.1", "2.5", "2.9", "3.5", "-2.1", "-2.5", "-2.9", "4"}
+	tests := []struct {
+		rounding Rounding
+		want     []int64
+	}{
+		{rounding: RoundCeil, want: []int64{3, 3, 3, 4, -2, -2, -2, 4}},
+		{rounding: RoundFloor, want: []int64{2, 2, 2, 3, -3, -3, -3, 4}},
+		{rounding: RoundHalfUp, want: []int64{2, 3, 3, 4, -2, -2, -3, 4}},
+		{rounding: RoundHalfEven, want: []int64{2, 2, 3, 4, -2, -2, -3, 4}},
+		{rounding: RoundBankers, want: []int64{2, 2, 3, 4, -2, -2, -3, 4}},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.rounding), func(t *testing.T) {
+			for i, value := range values {
+				if got := tt.rounding.Round(decimal.RequireFromString(value)); got != tt.want[i] {
+					t.Errorf("Round(%s) = %v, want %v", value, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestPointCalculator_Rounding(t *testing.T) {
+	// Two items of the calculator test receipt earn 2.45 and 2.4 points
+	// from descriptionLengthPriceBonus, 4.85 in all, and its total of 35.35
+	// earns 3.535 points from the expression rule.
+	tests := []struct {
+		name    string
+		input   string
+		want    int64
+		wantErr string
+	}{
+		{
+			name:  "each item rounded half up by default",
+			input: `{"version": "v1", "rules": [{"type": "descriptionLengthPriceBonus"}]}`,
+			want:  4,
+		},
+		{
+			name:  "rule rounding",
+			input: `{"version": "v1", "rules": [{"type": "descriptionLengthPriceBonus", "params": {"rounding": "halfEven"}}]}`,
+			want:  4,
+		},
+		{
+			name:  "ruleset rounding",
+			input: `{"version": "v1", "rounding": "ceil", "rules": [{"type": "expression", "params": {"expression": "points = total / 10"}}]}`,
+			want:  4,
+		},
+		{
+			name:  "rule rounding overrides the ruleset",
+			input: `{"version": "v1", "rounding": "floor", "rules": [{"type": "descriptionLengthPriceBonus", "params": {"rounding": "ceil"}}, {"type": "expression", "params": {"expression": "points = total / 10"}}]}`,
+			want:  9,
+		},
+		{
+			name:  "fractions accumulated and rounded down",
+			input: `{"version": "v1", "accumulateFractions": true, "rules": [{"type": "descriptionLengthPriceBonus"}, {"type": "expression", "params": {"expression": "points = total / 10"}}]}`,
+			want:  8,
+		},
+		{
+			name:  "fractions accumulated and rounded half up",
+			input: `{"version": "v1", "accumulateFractions": true, "rounding": "halfUp", "rules": [{"type": "descriptionLengthPriceBonus"}, {"type": "expression", "params": {"expression": "points = total / 10"}}]}`,
+			want:  8,
+		},
+		{
+			name:  "accumulated fractions scaled",
+			input: `{"version": "v1", "accumulateFractions": true, "rounding": "ceil", "rules": [{"type": "descriptionLengthPriceBonus"}, {"type": "multiplier", "params": {"factor": "1.5"}}]}`,
+			want:  8,
+		},
+		{
+			name:    "unknown rule rounding",
+			input:   `{"version": "v1", "rules": [{"type": "descriptionLengthPriceBonus", "params": {"rounding": "up"}}]}`,
+			wantErr: `unknown rounding "up"`,
+		},
+		{
+			name:    "unknown ruleset rounding",
+			input:   `{"version": "v1", "rounding": "nearest", "rules": [{"type": "oddDayBonus"}]}`,
+			wantErr: `unknown rounding "nearest"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ruleset, err := ParseRuleset(strings.NewReader(tt.input))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("ParseRuleset() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseRuleset() error = %v", err)
+			}
+
+			got := ruleset.Calculator().Calculate(newCalculatorTestReceipt())
+			if got.Total != tt.want {
+				t.Errorf("Calculate() total = %v, want %v", got.Total, tt.want)
+			}
+
+			sum := int64(0)
+			for _, rule := range got.Rules {
+				sum += rule.Points
+			}
+			if sum != got.Total {
+				t.Errorf("Calculate() rule points sum = %v, want %v", sum, got.Total)
+			}
+		})
+	}
+}
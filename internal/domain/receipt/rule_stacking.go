@@ -0,0 +1,207 @@
+package receipt
+
+import (
+	"errors"
+	"fmt"
+	"github.com/shopspring/decimal"
+	"receipt-processor/internal/domain/receipt/expr"
+	"sort"
+	"strings"
+)
+
+// RuleStacking says how a rule combines with the other rules of a ruleset
+// or campaign.
+type RuleStacking struct {
+	// Id names the rule so that multiplier rules can scale it.
+	Id string `json:"id,omitempty"`
+	// Priority orders the rules: lower priorities apply first, and rules
+	// of equal priority apply in the order they are declared.
+	Priority int `json:"priority,omitempty"`
+	// Group makes the rule mutually exclusive with the other rules of the
+	// group: only the one awarding the most points applies, or the first
+	// to apply when several award the same points.
+	Group string `json:"group,omitempty"`
+}
+
+// ScalingRule is a rule awarding points in proportion to the points
+// awarded by the rules applied before it, rather than from the receipt
+// alone.
+type ScalingRule interface {
+	PointRule
+	// Scale returns the points the rule awards, before rounding, given the
+	// points of the rules it scales.
+	Scale(receipt *Receipt, points decimal.Decimal) decimal.Decimal
+	// Round rounds the points Scale returns by the rule's rounding.
+	Round(points decimal.Decimal) int64
+	// Scales returns the ids of the rules the rule scales. None means all
+	// the rules applied before it, including those of the ruleset when the
+	// rule belongs to a campaign.
+	Scales() []string
+}
+
+// MultiplierRule multiplies the points awarded by the rules applied before
+// it, or by the rules listed in Rules, when the optional condition When
+// holds. The condition is written in the expression language of package
+// expr, such as
+//
+//	retailer == "Target" and total > 20
+//
+// The rule awards the extra points: a factor of 2 awards the scaled
+// points once more, and a factor of 0.5 takes half of them away.
+// Fractional points are rounded down unless the rule's rounding says
+// otherwise. Since the scaled points are whole, rounding the extra points
+// is the same as rounding the scaled points times the factor.
+type MultiplierRule struct {
+	Name   string          `json:"description"`
+	Factor decimal.Decimal `json:"factor"`
+	When   string          `json:"when"`
+	Rules  []string        `json:"rules"`
+	RoundingParam
+
+	condition *expr.Program
+}
+
+func (r *MultiplierRule) Calculate(receipt *Receipt) int64 {
+	return 0
+}
+
+func (r *MultiplierRule) Scale(receipt *Receipt, points decimal.Decimal) decimal.Decimal {
+	if !r.holds(receipt) {
+		return decimal.Zero
+	}
+	return points.Mul(r.Factor.Sub(decimal.NewFromInt(1)))
+}
+
+func (r *MultiplierRule) Round(points decimal.Decimal) int64 {
+	return r.Rounding.or(RoundFloor).Round(points)
+}
+
+func (r *MultiplierRule) Scales() []string {
+	return r.Rules
+}
+
+func (r *MultiplierRule) Description() string {
+	if r.Name != "" {
+		return r.Name
+	}
+
+	description := fmt.Sprintf("%sx the points of the rules before it", r.Factor)
+	if len(r.Rules) > 0 {
+		description = fmt.Sprintf("%sx the points of %s", r.Factor, strings.Join(r.Rules, ", "))
+	}
+	if r.When != "" {
+		description += " if " + r.When
+	}
+	return description
+}
+
+func (r *MultiplierRule) Inputs(receipt *Receipt) map[string]string {
+	inputs := make(map[string]string)
+	if r.condition == nil {
+		return inputs
+	}
+
+	scope := newReceiptScope(receipt)
+	for _, name := range r.condition.Vars() {
+		inputs[name] = scope.Lookup(name).String()
+	}
+	if _, err := r.condition.Eval(scope); err != nil {
+		inputs["error"] = err.Error()
+	}
+	return inputs
+}
+
+// Validate checks the factor and compiles the condition, which must be
+// done before the rule scores a receipt.
+func (r *MultiplierRule) Validate() error {
+	var errs []error
+	if !r.Factor.IsPositive() {
+		errs = append(errs, fmt.Errorf("factor must be positive, got %s", r.Factor))
+	}
+	for _, id := range r.Rules {
+		if id == "" {
+			errs = append(errs, errors.New("rules must not contain an empty id"))
+		}
+	}
+	if err := r.Rounding.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if r.When != "" {
+		condition, err := expr.CompileCondition(r.When, expressionSchema)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("when: %w", err))
+		}
+		r.condition = condition
+	}
+	return errors.Join(errs...)
+}
+
+// holds reports whether the condition holds for the receipt. A condition
+// that fails, for example by dividing by zero, does not hold.
+func (r *MultiplierRule) holds(receipt *Receipt) bool {
+	if r.When == "" {
+		return true
+	}
+	if r.condition == nil {
+		return false
+	}
+	result, err := r.condition.Eval(newReceiptScope(receipt))
+	return err == nil && result.IsPositive()
+}
+
+// stackedRule is a rule with its stacking options.
+type stackedRule struct {
+	PointRule
+	RuleStacking
+}
+
+// orderRules returns the rules in the order they apply.
+func orderRules(rules []stackedRule) []stackedRule {
+	ordered := append([]stackedRule(nil), rules...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return ordered[i].Priority < ordered[j].Priority
+	})
+	return ordered
+}
+
+// validateStacking checks the stacking options of built rules: ids are
+// unique, rules scaled by a multiplier exist and apply before it, and
+// multipliers are not in groups, since the points they award depend on the
+// rules before them.
+func validateStacking(specs []RuleSpec, rules []PointRule) error {
+	stacked := make([]stackedRule, len(rules))
+	index := make(map[PointRule]int, len(rules))
+	ids := make(map[string]int)
+	var errs []error
+	for i, rule := range rules {
+		stacked[i] = stackedRule{PointRule: rule, RuleStacking: specs[i].RuleStacking}
+		index[rule] = i
+		if id := specs[i].Id; id != "" {
+			if first, ok := ids[id]; ok {
+				errs = append(errs, fmt.Errorf("rules[%d]: id %q is already used by rules[%d]", i, id, first))
+			} else {
+				ids[id] = i
+			}
+		}
+		if _, ok := rule.(ScalingRule); ok && specs[i].Group != "" {
+			errs = append(errs, fmt.Errorf("rules[%d]: a %s rule cannot be in a group", i, specs[i].Type))
+		}
+	}
+
+	applied := make(map[string]bool)
+	for _, rule := range orderRules(stacked) {
+		if scaling, ok := rule.PointRule.(ScalingRule); ok {
+			for _, id := range scaling.Scales() {
+				if _, ok := ids[id]; !ok {
+					errs = append(errs, fmt.Errorf("rules[%d]: no rule has the id %q", index[rule.PointRule], id))
+				} else if !applied[id] {
+					errs = append(errs, fmt.Errorf("rules[%d]: rule %q must apply before the rules scaling it; give it a lower priority", index[rule.PointRule], id))
+				}
+			}
+		}
+		if rule.Id != "" {
+			applied[rule.Id] = true
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,158 @@
+package receipt
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestPointCalculator_Stacking(t *testing.T) {
+	// The calculator test receipt earns 6 points for its retailer, 10 for
+	// its items, 4 for its descriptions and 6 for its odd day: 26 in all.
+	tests := []struct {
+		name         string
+		input        string
+		want         int64
+		wantExcluded int
+	}{
+		{
+			name:         "highest scoring rule of a group",
+			input:        `{"version": "v1", "rules": [{"type": "retailerCharacterBonus", "group": "a"}, {"type": "itemPairBonus", "group": "a"}, {"type": "oddDayBonus", "group": "a"}, {"type": "descriptionLengthPriceBonus"}]}`,
+			want:         14,
+			wantExcluded: 2,
+		},
+		{
+			name:         "first rule of a group on a tie",
+			input:        `{"version": "v1", "rules": [{"type": "retailerCharacterBonus", "group": "a"}, {"type": "oddDayBonus", "group": "a"}]}`,
+			want:         6,
+			wantExcluded: 1,
+		},
+		{
+			name:  "multiplier scales the rules before it",
+			input: `{"version": "v1", "rules": [{"type": "retailerCharacterBonus"}, {"type": "multiplier", "params": {"factor": "2"}}, {"type": "oddDayBonus"}]}`,
+			want:  18,
+		},
+		{
+			name:  "priority moves a rule before a multiplier",
+			input: `{"version": "v1", "rules": [{"type": "retailerCharacterBonus"}, {"type": "multiplier", "params": {"factor": "2"}}, {"type": "oddDayBonus", "priority": -1}]}`,
+			want:  24,
+		},
+		{
+			name:  "multiplier scales a subset",
+			input: `{"version": "v1", "rules": [{"type": "retailerCharacterBonus", "id": "retailer"}, {"type": "oddDayBonus"}, {"type": "multiplier", "params": {"factor": "3", "rules": ["retailer"]}}]}`,
+			want:  24,
+		},
+		{
+			name:  "fractional multiplier",
+			input: `{"version": "v1", "rules": [{"type": "itemPairBonus"}, {"type": "multiplier", "params": {"factor": "0.5"}}]}`,
+			want:  5,
+		},
+		{
+			name:  "multiplier condition holds",
+			input: `{"version": "v1", "rules": [{"type": "retailerCharacterBonus"}, {"type": "multiplier", "params": {"factor": "2", "when": "retailer == \"Target\""}}]}`,
+			want:  12,
+		},
+		{
+			name:  "multiplier condition does not hold",
+			input: `{"version": "v1", "rules": [{"type": "retailerCharacterBonus"}, {"type": "multiplier", "params": {"factor": "2", "when": "retailer == \"Walgreens\""}}]}`,
+			want:  6,
+		},
+		{
+			name:  "maximum points",
+			input: `{"version": "v1", "maxPoints": 20, "rules": [{"type": "itemPairBonus"}, {"type": "multiplier", "params": {"factor": "5"}}]}`,
+			want:  20,
+		},
+		{
+			name:  "minimum points",
+			input: `{"version": "v1", "minPoints": 30, "maxPoints": 100, "rules": [{"type": "itemPairBonus"}]}`,
+			want:  30,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ruleset, err := ParseRuleset(strings.NewReader(tt.input))
+			if err != nil {
+				t.Fatalf("ParseRuleset() error = %v", err)
+			}
+
+			got := ruleset.Calculator().Calculate(newCalculatorTestReceipt())
+			if got.Total != tt.want {
+				t.Errorf("Calculate() total = %v, want %v", got.Total, tt.want)
+			}
+
+			sum, excluded := int64(0), 0
+			for _, rule := range got.Rules {
+				sum += rule.Points
+				if rule.Excluded {
+					excluded++
+				}
+			}
+			if sum != got.Total {
+				t.Errorf("Calculate() rule points sum = %v, want %v", sum, got.Total)
+			}
+			if excluded != tt.wantExcluded {
+				t.Errorf("Calculate() excluded rules = %v, want %v", excluded, tt.wantExcluded)
+			}
+		})
+	}
+}
+
+func TestParseRuleset_StackingErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		wantErr string
+	}{
+		{
+			name:    "duplicate id",
+			input:   `{"version": "v1", "rules": [{"type": "oddDayBonus", "id": "a"}, {"type": "itemPairBonus", "id": "a"}]}`,
+			wantErr: `rules[1]: id "a" is already used by rules[0]`,
+		},
+		{
+			name:    "unknown scaled rule",
+			input:   `{"version": "v1", "rules": [{"type": "multiplier", "params": {"factor": "2", "rules": ["a"]}}]}`,
+			wantErr: `rules[0]: no rule has the id "a"`,
+		},
+		{
+			name:    "scaled rule applies later",
+			input:   `{"version": "v1", "rules": [{"type": "multiplier", "params": {"factor": "2", "rules": ["a"]}}, {"type": "oddDayBonus", "id": "a"}]}`,
+			wantErr: `rules[0]: rule "a" must apply before the rules scaling it`,
+		},
+		{
+			name:    "multiplier in a group",
+			input:   `{"version": "v1", "rules": [{"type": "multiplier", "params": {"factor": "2"}, "group": "a"}]}`,
+			wantErr: "rules[0]: a multiplier rule cannot be in a group",
+		},
+		{
+			name:    "missing factor",
+			input:   `{"version": "v1", "rules": [{"type": "multiplier"}]}`,
+			wantErr: "factor must be positive",
+		},
+		{
+			name:    "invalid condition",
+			input:   `{"version": "v1", "rules": [{"type": "multiplier", "params": {"factor": "2", "when": "total + 1"}}]}`,
+			wantErr: "when: 1:1: the condition must be a bool, found a number",
+		},
+		{
+			name:    "minimum above maximum",
+			input:   `{"version": "v1", "minPoints": 10, "maxPoints": 5, "rules": [{"type": "oddDayBonus"}]}`,
+			wantErr: "minPoints 10 must not be greater than maxPoints 5",
+		},
+		{
+			name:    "negative maximum",
+			input:   `{"version": "v1", "maxPoints": -1, "rules": [{"type": "oddDayBonus"}]}`,
+			wantErr: "maxPoints must not be negative",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseRuleset(strings.NewReader(tt.input))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("ParseRuleset() error = %v, want %q", err, tt.wantErr)
+			}
+			if !errors.Is(err, ErrInvalidInput) {
+				t.Errorf("ParseRuleset() error = %v, want ErrInvalidInput", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,287 @@
+package receipt
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ruleTypes maps the rule type names used in ruleset files to constructors
+// of the rule they configure.
+var ruleTypes = map[string]func() PointRule{
+	"retailerCharacterBonus":      func() PointRule { return &RetailerCharacterBonusRule{} },
+	"wholeNumberTotalBonus":       func() PointRule { return &WholeNumberTotalBonusRule{} },
+	"quarterDollarBonus":          func() PointRule { return &QuarterDollarBonusRule{} },
+	"itemPairBonus":               func() PointRule { return &ItemPairBonusRule{} },
+	"descriptionLengthPriceBonus": func() PointRule { return &DescriptionLengthPriceBonusRule{} },
+	"oddDayBonus":                 func() PointRule { return &OddDayBonusRule{} },
+	"afternoonBonus":              func() PointRule { return &AfternoonBonusRule{} },
+	"expression":                  func() PointRule { return &ExpressionRule{} },
+	"multiplier":                  func() PointRule { return &MultiplierRule{} },
+	"timeWindow":                  func() PointRule { return &TimeWindowRule{} },
+}
+
+// RuleSpec is a rule as it is declared in a ruleset file.
+type RuleSpec struct {
+	Type   string          `json:"type"`
+	Params json.RawMessage `json:"params,omitempty"`
+	RuleStacking
+}
+
+// DefaultRulesetVersion names DefaultRuleset.
+const DefaultRulesetVersion = "v1"
+
+// maxRulesetVersionLength bounds the length of a ruleset version name.
+const maxRulesetVersionLength = 64
+
+// Ruleset is a validated list of point rules. A ruleset is identified by
+// its version, which is recorded on every receipt it scores, and takes
+// effect at EffectiveFrom. A ruleset must not be changed once it has been
+// used: a change to the rules is a new version.
+type Ruleset struct {
+	Version       string
+	EffectiveFrom time.Time
+	Specs         []RuleSpec
+	Rules         []PointRule
+	// MinPoints and MaxPoints, when set, bound the points of a receipt
+	// after every rule and campaign has applied.
+	MinPoints *int64
+	MaxPoints *int64
+	// Rounding is the rounding of the rules that do not set their own.
+	// With AccumulateFractions, the rules' fractional points are added up
+	// and the receipt's points are rounded once, by Rounding or down.
+	Rounding            Rounding
+	AccumulateFractions bool
+}
+
+type rulesetFile struct {
+	Version       string     `json:"version"`
+	EffectiveFrom time.Time  `json:"effectiveFrom"`
+	MinPoints     *int64     `json:"minPoints"`
+	MaxPoints     *int64     `json:"maxPoints"`
+	Rounding      Rounding   `json:"rounding"`
+	Accumulate    bool       `json:"accumulateFractions"`
+	Rules         []RuleSpec `json:"rules"`
+}
+
+// DefaultRuleset returns the original scoring rules with their original
+// parameters, effective since the beginning of time.
+func DefaultRuleset() *Ruleset {
+	types := []string{
+		"retailerCharacterBonus",
+		"wholeNumberTotalBonus",
+		"quarterDollarBonus",
+		"itemPairBonus",
+		"descriptionLengthPriceBonus",
+		"oddDayBonus",
+		"afternoonBonus",
+	}
+
+	ruleset := &Ruleset{Version: DefaultRulesetVersion}
+	for _, ruleType := range types {
+		ruleset.Specs = append(ruleset.Specs, RuleSpec{Type: ruleType})
+		ruleset.Rules = append(ruleset.Rules, ruleTypes[ruleType]())
+	}
+	return ruleset
+}
+
+// DefaultRulesets returns the built-in versions, which are DefaultRuleset
+// alone. Later versions, and when they take effect, are configured in
+// ruleset files, such as those in config/rulesets.
+func DefaultRulesets() []*Ruleset {
+	return []*Ruleset{DefaultRuleset()}
+}
+
+// LoadRuleset reads and validates a ruleset file.
+func LoadRuleset(path string) (*Ruleset, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	ruleset, err := ParseRuleset(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return ruleset, nil
+}
+
+// ParseRuleset reads and validates a JSON ruleset such as
+//
+//	{
+//	  "version": "v2",
+//	  "effectiveFrom": "2024-07-01T00:00:00Z",
+//	  "maxPoints": 500,
+//	  "rules": [
+//	    {"type": "wholeNumberTotalBonus", "params": {"points": 50}, "id": "roundTotal"},
+//	    {"type": "multiplier", "params": {"factor": "2", "when": "retailer == \"Target\""}, "priority": 10}
+//	  ]
+//	}
+//
+// The version is required and effectiveFrom defaults to the beginning of
+// time. See RuleStacking for how rules combine. Unknown rule types, unknown
+// parameters and parameters that cannot be scored are errors matching
+// ErrInvalidInput.
+func ParseRuleset(r io.Reader) (*Ruleset, error) {
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+
+	var file rulesetFile
+	if err := decoder.Decode(&file); err != nil {
+		return nil, fmt.Errorf("%w: ruleset: %w", ErrInvalidInput, err)
+	}
+
+	if err := validateRulesetVersion(file.Version); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidInput, err)
+	}
+
+	if len(file.Rules) == 0 {
+		return nil, fmt.Errorf("%w: ruleset has no rules", ErrInvalidInput)
+	}
+
+	if err := validatePointsBounds(file.MinPoints, file.MaxPoints); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidInput, err)
+	}
+
+	if err := file.Rounding.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: ruleset: %w", ErrInvalidInput, err)
+	}
+
+	ruleset := &Ruleset{
+		Version:             file.Version,
+		EffectiveFrom:       file.EffectiveFrom.UTC(),
+		MinPoints:           file.MinPoints,
+		MaxPoints:           file.MaxPoints,
+		Rounding:            file.Rounding,
+		AccumulateFractions: file.Accumulate,
+	}
+	for i, spec := range file.Rules {
+		rule, err := NewRule(spec)
+		if err != nil {
+			return nil, fmt.Errorf("%w: rules[%d]: %w", ErrInvalidInput, i, err)
+		}
+		if rounded, ok := rule.(interface{ setDefaultRounding(Rounding) }); ok && file.Rounding != "" {
+			rounded.setDefaultRounding(file.Rounding)
+		}
+		ruleset.Specs = append(ruleset.Specs, spec)
+		ruleset.Rules = append(ruleset.Rules, rule)
+	}
+	if err := validateStacking(ruleset.Specs, ruleset.Rules); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidInput, err)
+	}
+	return ruleset, nil
+}
+
+// NewRule builds and validates the rule a spec declares.
+func NewRule(spec RuleSpec) (PointRule, error) {
+	newRule, ok := ruleTypes[spec.Type]
+	if !ok {
+		return nil, fmt.Errorf("unknown rule type %q, expected one of %s", spec.Type, strings.Join(RuleTypes(), ", "))
+	}
+
+	rule := newRule()
+	if len(spec.Params) > 0 {
+		if err := checkExplicitZeros(spec.Params); err != nil {
+			return nil, fmt.Errorf("%s: %w", spec.Type, err)
+		}
+
+		decoder := json.NewDecoder(bytes.NewReader(spec.Params))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(rule); err != nil {
+			return nil, fmt.Errorf("%s: params: %w", spec.Type, err)
+		}
+	}
+
+	if v, ok := rule.(interface{ Validate() error }); ok {
+		if err := v.Validate(); err != nil {
+			return nil, fmt.Errorf("%s: %w", spec.Type, err)
+		}
+	}
+	return rule, nil
+}
+
+// RuleTypes returns the rule type names a ruleset may use.
+func RuleTypes() []string {
+	types := make([]string, 0, len(ruleTypes))
+	for ruleType := range ruleTypes {
+		types = append(types, ruleType)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// Calculator returns a calculator running the ruleset's rules.
+func (r *Ruleset) Calculator() *PointCalculator {
+	calculator := NewPointCalculator()
+	for i, rule := range r.Rules {
+		calculator.AddStackedRule(rule, r.Specs[i].RuleStacking)
+	}
+	calculator.SetPointsBounds(r.MinPoints, r.MaxPoints)
+	calculator.SetRounding(r.Rounding, r.AccumulateFractions)
+	return calculator
+}
+
+func validatePointsBounds(min, max *int64) error {
+	var errs []error
+	if min != nil && *min < 0 {
+		errs = append(errs, fmt.Errorf("minPoints must not be negative, got %d", *min))
+	}
+	if max != nil && *max < 0 {
+		errs = append(errs, fmt.Errorf("maxPoints must not be negative, got %d", *max))
+	}
+	if min != nil && max != nil && *min > *max {
+		errs = append(errs, fmt.Errorf("minPoints %d must not be greater than maxPoints %d", *min, *max))
+	}
+	return errors.Join(errs...)
+}
+
+func validateRulesetVersion(version string) error {
+	if version == "" {
+		return errors.New("ruleset version is required")
+	}
+	if len(version) > maxRulesetVersionLength {
+		return fmt.Errorf("ruleset version must be at most %d characters", maxRulesetVersionLength)
+	}
+	for _, r := range version {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '-' || r == '_') {
+			return fmt.Errorf("ruleset version %q may only contain letters, digits, '.', '-' and '_'", version)
+		}
+	}
+	return nil
+}
+
+// checkExplicitZeros rejects parameters set to zero or to an empty string.
+// A zero parameter would silently fall back to the rule's default, which is
+// never what the author of the file meant.
+func checkExplicitZeros(params json.RawMessage) error {
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal(params, &values); err != nil {
+		return fmt.Errorf("params: %w", err)
+	}
+
+	var errs []error
+	for name, value := range values {
+		var number json.Number
+		var text string
+		isZero := false
+		if json.Unmarshal(value, &number) == nil {
+			if f, err := number.Float64(); err == nil && f == 0 {
+				isZero = true
+			}
+		} else if json.Unmarshal(value, &text) == nil && strings.Trim(text, "0.") == "" {
+			isZero = true
+		}
+		if isZero {
+			errs = append(errs, fmt.Errorf("%s must not be zero; omit it to use the default or remove the rule to disable it", name))
+		}
+	}
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Error() < errs[j].Error() })
+	return errors.Join(errs...)
+}
@@ -0,0 +1,100 @@
+package receipt
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// RulesetRegistry holds every ruleset version, ordered by the time they
+// take effect. It is read-only once built.
+type RulesetRegistry struct {
+	rulesets []*Ruleset
+	versions map[string]*Ruleset
+}
+
+// NewRulesetRegistry builds a registry of the given versions. Versions must
+// have distinct names and distinct effective times.
+func NewRulesetRegistry(rulesets ...*Ruleset) (*RulesetRegistry, error) {
+	if len(rulesets) == 0 {
+		return nil, fmt.Errorf("%w: no ruleset versions", ErrInvalidInput)
+	}
+
+	registry := &RulesetRegistry{
+		rulesets: make([]*Ruleset, len(rulesets)),
+		versions: make(map[string]*Ruleset, len(rulesets)),
+	}
+	copy(registry.rulesets, rulesets)
+	sort.SliceStable(registry.rulesets, func(i, j int) bool {
+		return registry.rulesets[i].EffectiveFrom.Before(registry.rulesets[j].EffectiveFrom)
+	})
+
+	for i, ruleset := range registry.rulesets {
+		if _, ok := registry.versions[ruleset.Version]; ok {
+			return nil, fmt.Errorf("%w: ruleset version %q is defined twice", ErrInvalidInput, ruleset.Version)
+		}
+		if i > 0 && ruleset.EffectiveFrom.Equal(registry.rulesets[i-1].EffectiveFrom) {
+			return nil, fmt.Errorf("%w: ruleset versions %q and %q take effect at the same time", ErrInvalidInput, registry.rulesets[i-1].Version, ruleset.Version)
+		}
+		registry.versions[ruleset.Version] = ruleset
+	}
+	return registry, nil
+}
+
+// LoadRulesetRegistry reads every *.json ruleset file in dir.
+func LoadRulesetRegistry(dir string) (*RulesetRegistry, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("%s: %w", dir, os.ErrNotExist)
+	}
+
+	rulesets := make([]*Ruleset, 0, len(paths))
+	for _, path := range paths {
+		ruleset, err := LoadRuleset(path)
+		if err != nil {
+			return nil, err
+		}
+		rulesets = append(rulesets, ruleset)
+	}
+
+	registry, err := NewRulesetRegistry(rulesets...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", dir, err)
+	}
+	return registry, nil
+}
+
+// Get returns the ruleset with the given version.
+func (r *RulesetRegistry) Get(version string) (*Ruleset, error) {
+	ruleset, ok := r.versions[version]
+	if !ok {
+		return nil, fmt.Errorf("ruleset version %q: %w", version, ErrNotFound)
+	}
+	return ruleset, nil
+}
+
+// Active returns the ruleset in effect at t: the latest version whose
+// EffectiveFrom is not after t. Before the first version takes effect, the
+// first version is in effect.
+func (r *RulesetRegistry) Active(t time.Time) *Ruleset {
+	active := r.rulesets[0]
+	for _, ruleset := range r.rulesets[1:] {
+		if ruleset.EffectiveFrom.After(t) {
+			break
+		}
+		active = ruleset
+	}
+	return active
+}
+
+// List returns every version, ordered by the time they take effect.
+func (r *RulesetRegistry) List() []*Ruleset {
+	rulesets := make([]*Ruleset, len(r.rulesets))
+	copy(rulesets, r.rulesets)
+	return rulesets
+}
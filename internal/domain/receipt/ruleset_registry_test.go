@@ -0,0 +1,103 @@
+package receipt
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestRuleset(version string, effectiveFrom time.Time) *Ruleset {
+	ruleset := DefaultRuleset()
+	ruleset.Version = version
+	ruleset.EffectiveFrom = effectiveFrom
+	return ruleset
+}
+
+func TestRulesetRegistry_Active(t *testing.T) {
+	jan := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	jul := time.Date(2024, time.July, 1, 0, 0, 0, 0, time.UTC)
+	registry, err := NewRulesetRegistry(
+		newTestRuleset("v3", jul),
+		newTestRuleset("v1", time.Time{}),
+		newTestRuleset("v2", jan),
+	)
+	if err != nil {
+		t.Fatalf("NewRulesetRegistry() error = %v", err)
+	}
+
+	tests := []struct {
+		name string
+		at   time.Time
+		want string
+	}{
+		{name: "before every version", at: time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC), want: "v1"},
+		{name: "at an effective time", at: jan, want: "v2"},
+		{name: "just before an effective time", at: jul.Add(-time.Nanosecond), want: "v2"},
+		{name: "after the latest version", at: jul.AddDate(1, 0, 0), want: "v3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := registry.Active(tt.at).Version; got != tt.want {
+				t.Errorf("Active() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	var versions []string
+	for _, ruleset := range registry.List() {
+		versions = append(versions, ruleset.Version)
+	}
+	if got := strings.Join(versions, ","); got != "v1,v2,v3" {
+		t.Errorf("List() = %v, want v1,v2,v3", got)
+	}
+}
+
+func TestNewRulesetRegistry_Errors(t *testing.T) {
+	jan := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		rulesets []*Ruleset
+		wantErr  string
+	}{
+		{
+			name:    "no versions",
+			wantErr: "no ruleset versions",
+		},
+		{
+			name:     "duplicate version",
+			rulesets: []*Ruleset{newTestRuleset("v1", time.Time{}), newTestRuleset("v1", jan)},
+			wantErr:  `ruleset version "v1" is defined twice`,
+		},
+		{
+			name:     "same effective time",
+			rulesets: []*Ruleset{newTestRuleset("v1", jan), newTestRuleset("v2", jan)},
+			wantErr:  "take effect at the same time",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewRulesetRegistry(tt.rulesets...)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("NewRulesetRegistry() error = %v, want %q", err, tt.wantErr)
+			}
+			if !errors.Is(err, ErrInvalidInput) {
+				t.Errorf("NewRulesetRegistry() error = %v, want ErrInvalidInput", err)
+			}
+		})
+	}
+}
+
+func TestRulesetRegistry_Get(t *testing.T) {
+	registry, err := NewRulesetRegistry(DefaultRuleset())
+	if err != nil {
+		t.Fatalf("NewRulesetRegistry() error = %v", err)
+	}
+
+	if _, err := registry.Get(DefaultRulesetVersion); err != nil {
+		t.Errorf("Get() error = %v", err)
+	}
+	if _, err := registry.Get("v9"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() error = %v, want ErrNotFound", err)
+	}
+}
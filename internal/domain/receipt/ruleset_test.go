@@ -0,0 +1,176 @@
+package receipt
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadRulesetRegistry_Config(t *testing.T) {
+	registry, err := LoadRulesetRegistry("../../../config/rulesets")
+	if err != nil {
+		t.Fatalf("LoadRulesetRegistry() error = %v", err)
+	}
+	receipt := newCalculatorTestReceipt()
+
+	// The v1 file declares the built-in rules.
+	v1, err := registry.Get(DefaultRulesetVersion)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	got := v1.Calculator().Calculate(receipt)
+	want := DefaultRuleset().Calculator().Calculate(receipt)
+	if got.Total != want.Total {
+		t.Errorf("v1 Calculate() total = %v, want %v", got.Total, want.Total)
+	}
+	for i := range want.Rules {
+		if got.Rules[i].Description != want.Rules[i].Description {
+			t.Errorf("v1 Calculate() rule %d description = %q, want %q", i, got.Rules[i].Description, want.Rules[i].Description)
+		}
+	}
+
+	// v2 fixes the rules whose fixes change what they award, and takes
+	// effect when its file says.
+	v2, err := registry.Get("v2")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if after := v2.Calculator().Calculate(receipt); got.Total != 26 || after.Total != 28 {
+		t.Errorf("Calculate() totals = %d, %d, want 26 under v1 and 28 under v2", got.Total, after.Total)
+	}
+	if v2.EffectiveFrom.IsZero() {
+		t.Fatal("v2 has no effective time, want the one in its file")
+	}
+	if active := registry.Active(v2.EffectiveFrom.Add(-time.Second)); active != v1 {
+		t.Errorf("Active() before v2 takes effect = %s, want v1", active.Version)
+	}
+	if active := registry.Active(v2.EffectiveFrom); active != v2 {
+		t.Errorf("Active() once v2 takes effect = %s, want v2", active.Version)
+	}
+}
+
+func TestDefaultRulesets(t *testing.T) {
+	registry, err := NewRulesetRegistry(DefaultRulesets()...)
+	if err != nil {
+		t.Fatalf("NewRulesetRegistry() error = %v", err)
+	}
+	if active := registry.Active(time.Now()); active.Version != DefaultRulesetVersion {
+		t.Errorf("Active() = %s, want %s", active.Version, DefaultRulesetVersion)
+	}
+}
+
+func TestParseRuleset(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    int64
+		wantErr string
+	}{
+		{
+			name:  "custom parameters",
+			input: `{"version": "v1", "rules": [{"type": "wholeNumberTotalBonus", "params": {"points": 75}}, {"type": "itemPairBonus", "params": {"groupSize": 5, "pointsPerGroup": 20}}]}`,
+			want:  20,
+		},
+		{
+			name:  "default parameters",
+			input: `{"version": "v1", "rules": [{"type": "retailerCharacterBonus"}]}`,
+			want:  6,
+		},
+		{
+			name:    "unknown rule type",
+			input:   `{"version": "v1", "rules": [{"type": "blah"}]}`,
+			wantErr: `unknown rule type "blah"`,
+		},
+		{
+			name:    "unknown parameter",
+			input:   `{"version": "v1", "rules": [{"type": "oddDayBonus", "params": {"pionts": 6}}]}`,
+			wantErr: `unknown field "pionts"`,
+		},
+		{
+			name:    "negative parameter",
+			input:   `{"version": "v1", "rules": [{"type": "oddDayBonus", "params": {"points": -6}}]}`,
+			wantErr: "points must not be negative",
+		},
+		{
+			name:    "zero parameter",
+			input:   `{"version": "v1", "rules": [{"type": "quarterDollarBonus", "params": {"multiple": "0.00"}}]}`,
+			wantErr: "multiple must not be zero",
+		},
+		{
+			name:    "minutes in an hourly window",
+			input:   `{"version": "v1", "rules": [{"type": "afternoonBonus", "params": {"after": "14:30"}}]}`,
+			wantErr: `after must be a whole hour written as HH:00, got "14:30"`,
+		},
+		{
+			name:    "unknown precision",
+			input:   `{"version": "v1", "rules": [{"type": "afternoonBonus", "params": {"precision": "second"}}]}`,
+			wantErr: `precision must be hour or minute`,
+		},
+		{
+			name:    "bad time window",
+			input:   `{"version": "v1", "rules": [{"type": "afternoonBonus", "params": {"after": "16:00", "before": "14:00"}}]}`,
+			wantErr: "after must be earlier than before",
+		},
+		{
+			name:  "expression rule",
+			input: `{"version": "v1", "rules": [{"type": "expression", "params": {"description": "Target weekend", "expression": "points = 15 if retailer contains \"Target\" and weekday == \"Saturday\""}}]}`,
+			want:  15,
+		},
+		{
+			name:    "expression with a type error",
+			input:   `{"version": "v1", "rules": [{"type": "expression", "params": {"expression": "points = 15 if total > \"50\""}}]}`,
+			wantErr: "rules[0]: expression: 1:22: > needs two numbers or two strings",
+		},
+		{
+			name:    "expression missing",
+			input:   `{"version": "v1", "rules": [{"type": "expression"}]}`,
+			wantErr: "expression is required",
+		},
+		{
+			name:    "missing version",
+			input:   `{"rules": [{"type": "oddDayBonus"}]}`,
+			wantErr: "ruleset version is required",
+		},
+		{
+			name:    "invalid version",
+			input:   `{"version": "v 2", "rules": [{"type": "oddDayBonus"}]}`,
+			wantErr: "may only contain letters, digits",
+		},
+		{
+			name:    "invalid effective time",
+			input:   `{"version": "v2", "effectiveFrom": "2024-07-01", "rules": [{"type": "oddDayBonus"}]}`,
+			wantErr: "cannot parse",
+		},
+		{
+			name:    "no rules",
+			input:   `{"version": "v1", "rules": []}`,
+			wantErr: "no rules",
+		},
+		{
+			name:    "unknown top-level field",
+			input:   `{"rulez": []}`,
+			wantErr: `unknown field "rulez"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ruleset, err := ParseRuleset(strings.NewReader(tt.input))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ParseRuleset() error = %v, want %q", err, tt.wantErr)
+				}
+				if !errors.Is(err, ErrInvalidInput) {
+					t.Errorf("ParseRuleset() error = %v, want ErrInvalidInput", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseRuleset() error = %v", err)
+			}
+			if got := ruleset.Calculator().Calculate(newCalculatorTestReceipt()); got.Total != tt.want {
+				t.Errorf("Calculate() total = %v, want %v", got.Total, tt.want)
+			}
+		})
+	}
+}
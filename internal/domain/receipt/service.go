@@ -2,28 +2,383 @@ package receipt
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultMaxBatchSize = 1000
+	DefaultBatchWorkers = 8
 )
 
 type Service struct {
 	receiptRepository Repository
+	idempotencyStore  IdempotencyStore
+	rulesets          *RulesetRegistry
+	rescoreJobs       RescoreJobRepository
+	campaigns         CampaignRepository
+	maxBatchSize      int
+	batchWorkers      int
+	timeZone          *time.Location
+	retailerTimeZones map[string]*time.Location
+
+	// jobsMu guards runningJobs, the rescore jobs running in this process,
+	// and jobsStopped, which StopRescoreJobs sets.
+	jobsMu      sync.Mutex
+	runningJobs map[string]*runningJob
+	jobsStopped bool
+}
+
+type Option func(*Service)
+
+// WithBatchLimits sets the largest batch CreateBatch accepts and how many
+// receipts of a batch are processed concurrently. Values below 1 keep the
+// defaults.
+func WithBatchLimits(maxSize, workers int) Option {
+	return func(s *Service) {
+		if maxSize > 0 {
+			s.maxBatchSize = maxSize
+		}
+		if workers > 0 {
+			s.batchWorkers = workers
+		}
+	}
+}
+
+// WithRuleset makes the service score receipts with the ruleset's rules
+// instead of DefaultRuleset.
+func WithRuleset(ruleset *Ruleset) Option {
+	return func(s *Service) {
+		s.rulesets = &RulesetRegistry{
+			rulesets: []*Ruleset{ruleset},
+			versions: map[string]*Ruleset{ruleset.Version: ruleset},
+		}
+	}
 }
 
-func NewService(receiptRepository Repository) *Service {
-	return &Service{
+// WithRulesets makes the service score receipts with whichever version of
+// the registry is in effect when they are scored.
+func WithRulesets(rulesets *RulesetRegistry) Option {
+	return func(s *Service) {
+		s.rulesets = rulesets
+	}
+}
+
+// WithIdempotencyStore enables CreateIdempotent to remember keys.
+func WithIdempotencyStore(store IdempotencyStore) Option {
+	return func(s *Service) {
+		s.idempotencyStore = store
+	}
+}
+
+func NewService(receiptRepository Repository, opts ...Option) *Service {
+	s := &Service{
 		receiptRepository: receiptRepository,
+		maxBatchSize:      DefaultMaxBatchSize,
+		batchWorkers:      DefaultBatchWorkers,
+		timeZone:          time.UTC,
+	}
+	for _, opt := range opts {
+		opt(s)
 	}
+	if s.rulesets == nil {
+		WithRuleset(DefaultRuleset())(s)
+	}
+	return s
+}
+
+// BatchResult is the outcome of one entry of a batch. Exactly one of
+// Receipt and Err is set.
+type BatchResult struct {
+	Receipt *Receipt
+	Err     error
 }
 
 func (s *Service) Create(ctx context.Context, receiptDTO CreateReceiptDTO) (*Receipt, error) {
-	receipt, err := receiptDTO.ToReceipt()
+	receipt, err := s.prepare(receiptDTO)
 	if err != nil {
 		return nil, err
 	}
 
-	receipt.Points = s.calculatePoints(receipt)
+	receipt.CreatedAt = time.Now().UTC()
 	return s.receiptRepository.Create(receipt)
 }
 
+// CreateIdempotent creates a receipt at most once per idempotency key. A
+// retry with the same key and the same receipt returns the ID of the
+// receipt created the first time and replayed is true. Reusing a key for a
+// different receipt, or while the first request is still in progress,
+// fails with ErrConflict. A retry after the first request's lease has run
+// out takes the key over, and creates the receipt unless the first request
+// did.
+func (s *Service) CreateIdempotent(ctx context.Context, key string, receiptDTO CreateReceiptDTO) (id string, replayed bool, err error) {
+	if s.idempotencyStore == nil {
+		receipt, err := s.Create(ctx, receiptDTO)
+		if err != nil {
+			return "", false, err
+		}
+		return receipt.Id.String(), false, nil
+	}
+
+	if key == "" || len(key) > MaxIdempotencyKeyLength {
+		return "", false, fmt.Errorf("%w: idempotency key must be between 1 and %d characters", ErrInvalidInput, MaxIdempotencyKeyLength)
+	}
+
+	fingerprint, err := fingerprintOf(receiptDTO)
+	if err != nil {
+		return "", false, err
+	}
+
+	record := IdempotencyRecord{
+		Key:         key,
+		Fingerprint: fingerprint,
+		PendingId:   uuid.New().String(),
+		CreatedAt:   time.Now().UTC(),
+	}
+	existing, err := s.idempotencyStore.Reserve(record)
+	tookOver := false
+	for err == nil && existing != nil {
+		switch {
+		case existing.Fingerprint != fingerprint:
+			return "", false, ErrIdempotencyKeyReused
+		case existing.ReceiptId != "":
+			return existing.ReceiptId, true, nil
+		case !existing.leaseExpired(record.CreatedAt):
+			return "", false, ErrIdempotencyKeyInUse
+		}
+
+		// The request that reserved the key stopped before completing it,
+		// perhaps after creating its receipt. Keep its ID, so that if it is
+		// only slow, it and this request cannot both create one.
+		if existing.PendingId != "" {
+			if _, err := s.receiptRepository.Get(existing.PendingId); err == nil {
+				if err := s.idempotencyStore.Complete(key, existing.PendingId); err != nil {
+					return "", false, err
+				}
+				return existing.PendingId, true, nil
+			} else if !errors.Is(err, ErrNotFound) {
+				return "", false, err
+			}
+			record.PendingId = existing.PendingId
+		}
+		existing, err = s.idempotencyStore.Takeover(*existing, record)
+		tookOver = true
+	}
+	if err != nil {
+		return "", false, err
+	}
+
+	receipt, err := s.prepare(receiptDTO)
+	if err == nil {
+		receipt.Id = uuid.MustParse(record.PendingId)
+		receipt.CreatedAt = time.Now().UTC()
+		receipt, err = s.receiptRepository.Create(receipt)
+	}
+	if tookOver && errors.Is(err, ErrConflict) {
+		// The request this one took the key over from created the receipt
+		// after all.
+		if err := s.idempotencyStore.Complete(key, record.PendingId); err != nil {
+			return "", false, err
+		}
+		return record.PendingId, true, nil
+	}
+	if err != nil {
+		if releaseErr := s.idempotencyStore.Release(record); releaseErr != nil {
+			return "", false, fmt.Errorf("%w (releasing idempotency key: %w)", err, releaseErr)
+		}
+		return "", false, err
+	}
+
+	if err := s.idempotencyStore.Complete(key, record.PendingId); err != nil {
+		return "", false, err
+	}
+	return record.PendingId, false, nil
+}
+
+// MaxBatchSize returns the largest batch CreateBatch accepts, so that a
+// caller reading a batch can stop once it is exceeded.
+func (s *Service) MaxBatchSize() int {
+	return s.maxBatchSize
+}
+
+// CreateBatch creates every receipt of a batch independently, so some
+// entries may fail while others are stored. Results are returned in the
+// order of the batch.
+func (s *Service) CreateBatch(ctx context.Context, receiptDTOs []CreateReceiptDTO) ([]BatchResult, error) {
+	if len(receiptDTOs) == 0 {
+		return nil, fmt.Errorf("%w: batch is empty", ErrInvalidInput)
+	}
+	if len(receiptDTOs) > s.maxBatchSize {
+		return nil, fmt.Errorf("%w: batch of %d receipts exceeds the maximum of %d", ErrInvalidInput, len(receiptDTOs), s.maxBatchSize)
+	}
+
+	results := make([]BatchResult, len(receiptDTOs))
+	indexes := make(chan int)
+
+	var wg sync.WaitGroup
+	for range min(s.batchWorkers, len(receiptDTOs)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				receipt, err := s.Create(ctx, receiptDTOs[i])
+				results[i] = BatchResult{Receipt: receipt, Err: err}
+			}
+		}()
+	}
+
+feed:
+	for i := range receiptDTOs {
+		select {
+		case indexes <- i:
+		case <-ctx.Done():
+			for j := i; j < len(receiptDTOs); j++ {
+				results[j] = BatchResult{Err: ctx.Err()}
+			}
+			break feed
+		}
+	}
+	close(indexes)
+	wg.Wait()
+
+	return results, nil
+}
+
+// Update replaces the contents of a stored receipt and re-scores it. The
+// receipt keeps its ID and creation time, and items that are still present
+// keep their IDs. A version other than 0 is the version of the receipt the
+// caller read; if the receipt has been written since, Update fails with a
+// *VersionConflictError. Without one, Update still fails that way if the
+// receipt is written between Update reading and replacing it.
+func (s *Service) Update(ctx context.Context, id string, receiptDTO CreateReceiptDTO, version int64) (*Receipt, error) {
+	existing, err := s.receiptRepository.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if version != 0 && version != existing.Version {
+		return nil, &VersionConflictError{Id: id, Expected: version, Actual: existing.Version}
+	}
+
+	receipt, err := s.prepare(receiptDTO)
+	if err != nil {
+		return nil, err
+	}
+
+	receipt.Id = existing.Id
+	receipt.CreatedAt = existing.CreatedAt
+	receipt.Version = existing.Version
+	reuseItemIds(receipt.Items, existing.Items)
+
+	return s.receiptRepository.Update(id, receipt)
+}
+
+// Score runs the same validation and scoring as Create without storing
+// anything.
+func (s *Service) Score(ctx context.Context, receiptDTO CreateReceiptDTO) (*PointsResult, error) {
+	receipt, err := s.prepare(receiptDTO)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PointsResult{
+		RulesetVersion: receipt.RulesetVersion,
+		Total:          receipt.Points,
+		Rules:          receipt.PointsBreakdown,
+	}, nil
+}
+
+// Rescore scores a stored receipt again with the given ruleset version and
+// the campaigns in effect now, and stores the result. An empty version
+// re-runs the version and the campaign revisions that scored the receipt,
+// so that its points can be reproduced.
+func (s *Service) Rescore(ctx context.Context, id string, version string) (*Receipt, error) {
+	receipt, err := s.receiptRepository.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	reproduce := version == ""
+	if reproduce {
+		version = receipt.RulesetVersion
+	}
+	ruleset, err := s.rulesets.Get(version)
+	if err != nil {
+		return nil, err
+	}
+
+	rescored := *receipt
+	if reproduce {
+		err = s.reproduce(&rescored, ruleset)
+	} else {
+		err = s.scoreWith(&rescored, ruleset)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.receiptRepository.Update(id, &rescored)
+}
+
+// Rulesets returns every ruleset version and the version in effect now.
+func (s *Service) Rulesets(ctx context.Context) (rulesets []*Ruleset, active *Ruleset) {
+	return s.rulesets.List(), s.rulesets.Active(time.Now())
+}
+
+// GetRuleset returns a ruleset version.
+func (s *Service) GetRuleset(ctx context.Context, version string) (*Ruleset, error) {
+	return s.rulesets.Get(version)
+}
+
+// Delete deletes a stored receipt. A version other than 0 is the one the
+// caller read; if the receipt has been written since, Delete fails with a
+// *VersionConflictError.
+func (s *Service) Delete(ctx context.Context, id string, version int64) error {
+	return s.receiptRepository.Delete(id, version)
+}
+
+func (s *Service) Get(ctx context.Context, id string) (*Receipt, error) {
+	return s.receiptRepository.Get(id)
+}
+
+func (s *Service) List(ctx context.Context, query ListQuery) (*Page, error) {
+	return s.receiptRepository.List(query)
+}
+
+// Export calls fn for every receipt matching the query, one page at a
+// time, so that memory use does not grow with the number of receipts. The
+// query's cursor and limit are ignored.
+func (s *Service) Export(ctx context.Context, query ListQuery, fn func(*Receipt) error) error {
+	query.Cursor = ""
+	query.Limit = MaxListLimit
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		page, err := s.receiptRepository.List(query)
+		if err != nil {
+			return err
+		}
+
+		for _, receipt := range page.Receipts {
+			if err := fn(receipt); err != nil {
+				return err
+			}
+		}
+
+		if page.NextCursor == "" {
+			return nil
+		}
+		query.Cursor = page.NextCursor
+	}
+}
+
 func (s *Service) GetReceiptPoints(ctx context.Context, id string) (int64, error) {
 	receipt, err := s.receiptRepository.Get(id)
 	if err != nil {
@@ -33,17 +388,145 @@ func (s *Service) GetReceiptPoints(ctx context.Context, id string) (int64, error
 	return receipt.Points, nil
 }
 
-func (s *Service) calculatePoints(receipt *Receipt) int64 {
-	pointsCalculator := NewPointCalculator(
-		&RetailerCharacterBonusRule{},
-		&WholeNumberTotalBonusRule{},
-		&QuarterDollarBonusRule{},
-		&ItemPairBonusRule{},
-		&DescriptionLengthPriceBonusRule{},
-		&OddDayBonusRule{},
-		&AfternoonBonusRule{},
-	)
+func (s *Service) GetReceiptPointsBreakdown(ctx context.Context, id string) (*PointsResult, error) {
+	receipt, err := s.receiptRepository.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PointsResult{
+		RulesetVersion: receipt.RulesetVersion,
+		Total:          receipt.Points,
+		Rules:          receipt.PointsBreakdown,
+	}, nil
+}
 
-	points := pointsCalculator.Calculate(receipt)
-	return points
+// prepare validates a submitted receipt, builds it and scores it. Every
+// path that accepts a receipt goes through it so that they cannot diverge.
+func (s *Service) prepare(receiptDTO CreateReceiptDTO) (*Receipt, error) {
+	if err := receiptDTO.Validate(); err != nil {
+		return nil, err
+	}
+
+	receipt, err := receiptDTO.ToReceipt(s.timeZoneFor(receiptDTO.Retailer))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.score(receipt); err != nil {
+		return nil, err
+	}
+	return receipt, nil
+}
+
+// score scores a receipt with the ruleset version in effect now.
+func (s *Service) score(receipt *Receipt) error {
+	return s.scoreWith(receipt, s.rulesets.Active(time.Now()))
+}
+
+func (s *Service) scoreWith(receipt *Receipt, ruleset *Ruleset) error {
+	campaigns, err := s.campaignsFor(receipt)
+	if err != nil {
+		return err
+	}
+	return scoreWithCampaigns(receipt, ruleset, campaigns)
+}
+
+// reproduce scores a receipt with the ruleset and the campaigns recorded
+// on it when it was last scored.
+func (s *Service) reproduce(receipt *Receipt, ruleset *Ruleset) error {
+	campaigns, err := s.recordedCampaigns(receipt)
+	if err != nil {
+		return err
+	}
+	return scoreWithCampaigns(receipt, ruleset, campaigns)
+}
+
+// recordedCampaigns returns the campaigns recorded on a receipt when it
+// was last scored. A receipt scored by campaigns before they were recorded
+// gets the campaigns in effect now.
+func (s *Service) recordedCampaigns(receipt *Receipt) ([]*Campaign, error) {
+	if len(receipt.Campaigns) == 0 && slices.ContainsFunc(receipt.PointsBreakdown, func(r RuleResult) bool { return r.Campaign != "" }) {
+		return s.campaignsFor(receipt)
+	}
+	campaigns := make([]*Campaign, len(receipt.Campaigns))
+	for i := range receipt.Campaigns {
+		campaign := receipt.Campaigns[i]
+		campaigns[i] = &campaign
+	}
+	return campaigns, nil
+}
+
+// scoreWithCampaigns scores a receipt with the ruleset and campaigns, and
+// records the campaigns on it.
+func scoreWithCampaigns(receipt *Receipt, ruleset *Ruleset, campaigns []*Campaign) error {
+	result, err := calculateWith(receipt, ruleset, campaigns)
+	if err != nil {
+		return err
+	}
+	receipt.RulesetVersion = result.RulesetVersion
+	receipt.Points = result.Total
+	receipt.PointsBreakdown = result.Rules
+	receipt.Campaigns = nil
+	for _, campaign := range campaigns {
+		receipt.Campaigns = append(receipt.Campaigns, *campaign)
+	}
+	return nil
+}
+
+// calculateWith runs the ruleset's rules and the campaigns.
+func calculateWith(receipt *Receipt, ruleset *Ruleset, campaigns []*Campaign) (PointsResult, error) {
+	calculator := ruleset.Calculator()
+	for _, campaign := range campaigns {
+		if err := calculator.AddCampaign(campaign); err != nil {
+			return PointsResult{}, err
+		}
+	}
+
+	result := calculator.Calculate(receipt)
+	result.RulesetVersion = ruleset.Version
+	return result, nil
+}
+
+// reuseItemIds gives items the IDs of matching previous items. An item
+// matches a previous item with the same description and price, or failing
+// that one with the same description. Each previous ID is reused at most
+// once.
+func reuseItemIds(items, previous []Item) {
+	used := make([]bool, len(previous))
+	matched := make([]bool, len(items))
+
+	match := func(same func(a, b Item) bool) {
+		for i := range items {
+			if matched[i] {
+				continue
+			}
+			for j := range previous {
+				if !used[j] && same(items[i], previous[j]) {
+					items[i].Id = previous[j].Id
+					used[j] = true
+					matched[i] = true
+					break
+				}
+			}
+		}
+	}
+
+	match(func(a, b Item) bool {
+		return a.ShortDescription == b.ShortDescription && a.Price.Equal(b.Price)
+	})
+	match(func(a, b Item) bool {
+		return a.ShortDescription == b.ShortDescription
+	})
+}
+
+// fingerprintOf identifies the contents of a submitted receipt, so that a
+// retry can be told apart from a different receipt sent with the same key.
+func fingerprintOf(receiptDTO CreateReceiptDTO) (string, error) {
+	data, err := json.Marshal(receiptDTO)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
 }
@@ -0,0 +1,475 @@
+package receipt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRepository is a minimal in-memory Repository for service tests.
+type fakeRepository struct {
+	mu       sync.Mutex
+	receipts map[string]*Receipt
+}
+
+func newFakeRepository() *fakeRepository {
+	return &fakeRepository{receipts: make(map[string]*Receipt)}
+}
+
+func (f *fakeRepository) Create(receipt *Receipt) (*Receipt, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.receipts[receipt.Id.String()]; ok {
+		return nil, fmt.Errorf("receipt %s: %w", receipt.Id, ErrConflict)
+	}
+	receipt.Version = 1
+	f.receipts[receipt.Id.String()] = receipt
+	return receipt, nil
+}
+
+func (f *fakeRepository) Get(id string) (*Receipt, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	receipt, ok := f.receipts[id]
+	if !ok {
+		return nil, fmt.Errorf("receipt %s: %w", id, ErrNotFound)
+	}
+	return receipt, nil
+}
+
+func (f *fakeRepository) Update(id string, receipt *Receipt) (*Receipt, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	existing, ok := f.receipts[id]
+	if !ok {
+		return nil, fmt.Errorf("receipt %s: %w", id, ErrNotFound)
+	}
+	if receipt.Version != 0 && receipt.Version != existing.Version {
+		return nil, &VersionConflictError{Id: id, Expected: receipt.Version, Actual: existing.Version}
+	}
+	receipt.Version = existing.Version + 1
+	f.receipts[id] = receipt
+	return receipt, nil
+}
+
+func (f *fakeRepository) Delete(id string, version int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	existing, ok := f.receipts[id]
+	if !ok {
+		return fmt.Errorf("receipt %s: %w", id, ErrNotFound)
+	}
+	if version != 0 && version != existing.Version {
+		return &VersionConflictError{Id: id, Expected: version, Actual: existing.Version}
+	}
+	delete(f.receipts, id)
+	return nil
+}
+
+func (f *fakeRepository) List(query ListQuery) (*Page, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	receipts := make([]*Receipt, 0, len(f.receipts))
+	for _, receipt := range f.receipts {
+		receipts = append(receipts, receipt)
+	}
+	return Paginate(receipts, query)
+}
+
+func newServiceTestDTO() CreateReceiptDTO {
+	return CreateReceiptDTO{
+		Retailer:     "Target",
+		PurchaseDate: "2022-01-01",
+		PurchaseTime: "13:01",
+		Items: []CreateItemDTO{
+			{
+				ShortDescription: "Mountain Dew 12PK",
+				Price:            "6.49",
+			},
+			{
+				ShortDescription: "Emils Cheese Pizza",
+				Price:            "12.25",
+			},
+		},
+		Total: "18.74",
+	}
+}
+
+func TestService_Score(t *testing.T) {
+	repo := newFakeRepository()
+	service := NewService(repo)
+
+	result, err := service.Score(context.Background(), newServiceTestDTO())
+	if err != nil {
+		t.Fatalf("Score() error = %v", err)
+	}
+
+	if len(repo.receipts) != 0 {
+		t.Errorf("Score() stored %v receipts, want 0", len(repo.receipts))
+	}
+
+	created, err := service.Create(context.Background(), newServiceTestDTO())
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	if result.Total != created.Points {
+		t.Errorf("Score() total = %v, want %v", result.Total, created.Points)
+	}
+
+	if len(result.Rules) != len(created.PointsBreakdown) {
+		t.Errorf("Score() rules = %v, want %v", len(result.Rules), len(created.PointsBreakdown))
+	}
+
+	invalid := newServiceTestDTO()
+	invalid.Total = "1.00"
+	if _, err := service.Score(context.Background(), invalid); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("Score() error = %v, want ErrInvalidInput", err)
+	}
+}
+
+func TestService_Rescore(t *testing.T) {
+	v2, err := ParseRuleset(strings.NewReader(`{"version": "v2", "effectiveFrom": "2999-01-01T00:00:00Z", "rules": [{"type": "expression", "params": {"expression": "points = 100"}}]}`))
+	if err != nil {
+		t.Fatalf("ParseRuleset() error = %v", err)
+	}
+	registry, err := NewRulesetRegistry(DefaultRuleset(), v2)
+	if err != nil {
+		t.Fatalf("NewRulesetRegistry() error = %v", err)
+	}
+
+	repo := newFakeRepository()
+	service := NewService(repo, WithRulesets(registry))
+
+	created, err := service.Create(context.Background(), newServiceTestDTO())
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if created.RulesetVersion != DefaultRulesetVersion {
+		t.Errorf("Create() ruleset version = %q, want %q", created.RulesetVersion, DefaultRulesetVersion)
+	}
+	originalPoints := created.Points
+
+	rescored, err := service.Rescore(context.Background(), created.Id.String(), "v2")
+	if err != nil {
+		t.Fatalf("Rescore() error = %v", err)
+	}
+	if rescored.RulesetVersion != "v2" || rescored.Points != 100 {
+		t.Errorf("Rescore() = %v points under %q, want 100 under v2", rescored.Points, rescored.RulesetVersion)
+	}
+
+	stored, _ := repo.Get(created.Id.String())
+	if stored.Points != 100 {
+		t.Errorf("Rescore() stored %v points, want 100", stored.Points)
+	}
+
+	if _, err := service.Rescore(context.Background(), created.Id.String(), DefaultRulesetVersion); err != nil {
+		t.Fatalf("Rescore() error = %v", err)
+	}
+	again, err := service.Rescore(context.Background(), created.Id.String(), "")
+	if err != nil {
+		t.Fatalf("Rescore() error = %v", err)
+	}
+	if again.RulesetVersion != DefaultRulesetVersion || again.Points != originalPoints {
+		t.Errorf("Rescore() = %v points under %q, want %v under %q", again.Points, again.RulesetVersion, originalPoints, DefaultRulesetVersion)
+	}
+
+	if _, err := service.Rescore(context.Background(), created.Id.String(), "v9"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Rescore() error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestService_Update(t *testing.T) {
+	service := NewService(newFakeRepository())
+	created, err := service.Create(context.Background(), newServiceTestDTO())
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	id := created.Id.String()
+
+	tests := []struct {
+		name        string
+		version     int64
+		wantVersion int64
+		wantErr     bool
+	}{
+		{"current version", 1, 2, false},
+		{"stale version", 1, 0, true},
+		{"no version", 0, 3, false},
+		{"future version", 9, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			updated, err := service.Update(context.Background(), id, newServiceTestDTO(), tt.version)
+			if tt.wantErr {
+				var conflict *VersionConflictError
+				if !errors.As(err, &conflict) || !errors.Is(err, ErrConflict) {
+					t.Fatalf("Update() error = %v, want a VersionConflictError", err)
+				}
+				if conflict.Expected != tt.version {
+					t.Errorf("Update() conflict expected version = %d, want %d", conflict.Expected, tt.version)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Update() error = %v", err)
+			}
+			if updated.Version != tt.wantVersion || updated.Id != created.Id {
+				t.Errorf("Update() = version %d of %v, want version %d of %v", updated.Version, updated.Id, tt.wantVersion, created.Id)
+			}
+		})
+	}
+}
+
+func TestReuseItemIds(t *testing.T) {
+	previous := []Item{
+		{Id: uuid.New(), ShortDescription: "Milk", Price: decimal.RequireFromString("2.00")},
+		{Id: uuid.New(), ShortDescription: "Bread", Price: decimal.RequireFromString("3.00")},
+		{Id: uuid.New(), ShortDescription: "Milk", Price: decimal.RequireFromString("2.50")},
+	}
+
+	items := []Item{
+		{Id: uuid.New(), ShortDescription: "Milk", Price: decimal.RequireFromString("2.50")},
+		{Id: uuid.New(), ShortDescription: "Bread", Price: decimal.RequireFromString("3.50")},
+		{Id: uuid.New(), ShortDescription: "Milk", Price: decimal.RequireFromString("2.25")},
+		{Id: uuid.New(), ShortDescription: "Eggs", Price: decimal.RequireFromString("4.00")},
+	}
+	eggsId := items[3].Id
+
+	reuseItemIds(items, previous)
+
+	want := []uuid.UUID{previous[2].Id, previous[1].Id, previous[0].Id, eggsId}
+	for i, item := range items {
+		if item.Id != want[i] {
+			t.Errorf("reuseItemIds() item %d id = %v, want %v", i, item.Id, want[i])
+		}
+	}
+}
+
+func TestService_CreateBatch(t *testing.T) {
+	repo := newFakeRepository()
+	service := NewService(repo, WithBatchLimits(5, 2))
+
+	invalid := newServiceTestDTO()
+	invalid.Retailer = ""
+	batch := []CreateReceiptDTO{newServiceTestDTO(), invalid, newServiceTestDTO(), newServiceTestDTO()}
+
+	results, err := service.CreateBatch(context.Background(), batch)
+	if err != nil {
+		t.Fatalf("CreateBatch() error = %v", err)
+	}
+
+	if len(results) != len(batch) {
+		t.Fatalf("CreateBatch() results = %v, want %v", len(results), len(batch))
+	}
+
+	for i, result := range results {
+		if i == 1 {
+			if !errors.Is(result.Err, ErrInvalidInput) {
+				t.Errorf("CreateBatch() result %d error = %v, want ErrInvalidInput", i, result.Err)
+			}
+			continue
+		}
+		if result.Err != nil {
+			t.Errorf("CreateBatch() result %d error = %v", i, result.Err)
+			continue
+		}
+		if _, err := repo.Get(result.Receipt.Id.String()); err != nil {
+			t.Errorf("CreateBatch() result %d was not stored: %v", i, err)
+		}
+	}
+
+	tooLarge := make([]CreateReceiptDTO, 6)
+	if _, err := service.CreateBatch(context.Background(), tooLarge); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("CreateBatch() error = %v, want ErrInvalidInput", err)
+	}
+}
+
+func TestService_Export(t *testing.T) {
+	repo := newFakeRepository()
+	service := NewService(repo)
+
+	want := MaxListLimit + 3
+	for range want {
+		if _, err := service.Create(context.Background(), newServiceTestDTO()); err != nil {
+			t.Fatalf("Create() error = %v", err)
+		}
+	}
+
+	seen := make(map[uuid.UUID]bool)
+	err := service.Export(context.Background(), ListQuery{Limit: 1}, func(receipt *Receipt) error {
+		seen[receipt.Id] = true
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Export() error = %v", err)
+	}
+
+	if len(seen) != want {
+		t.Errorf("Export() receipts = %v, want %v", len(seen), want)
+	}
+}
+
+type fakeIdempotencyStore struct {
+	mu      sync.Mutex
+	records map[string]IdempotencyRecord
+}
+
+func (f *fakeIdempotencyStore) Reserve(record IdempotencyRecord) (*IdempotencyRecord, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if existing, ok := f.records[record.Key]; ok {
+		return &existing, nil
+	}
+	f.records[record.Key] = record
+	return nil, nil
+}
+
+func (f *fakeIdempotencyStore) Takeover(stale IdempotencyRecord, record IdempotencyRecord) (*IdempotencyRecord, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if existing, ok := f.records[record.Key]; ok && existing != stale {
+		return &existing, nil
+	}
+	f.records[record.Key] = record
+	return nil, nil
+}
+
+func (f *fakeIdempotencyStore) Complete(key string, receiptId string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	record, ok := f.records[key]
+	if !ok {
+		return ErrNotFound
+	}
+	if record.PendingId != receiptId {
+		return ErrConflict
+	}
+	record.ReceiptId = receiptId
+	f.records[key] = record
+	return nil
+}
+
+func (f *fakeIdempotencyStore) Release(record IdempotencyRecord) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.records[record.Key] == record {
+		delete(f.records, record.Key)
+	}
+	return nil
+}
+
+func TestService_CreateIdempotent(t *testing.T) {
+	repo := newFakeRepository()
+	service := NewService(repo, WithIdempotencyStore(&fakeIdempotencyStore{records: make(map[string]IdempotencyRecord)}))
+	ctx := context.Background()
+
+	id, replayed, err := service.CreateIdempotent(ctx, "key-1", newServiceTestDTO())
+	if err != nil || replayed {
+		t.Fatalf("CreateIdempotent() = %v, %v, %v, want a new receipt", id, replayed, err)
+	}
+
+	retryId, replayed, err := service.CreateIdempotent(ctx, "key-1", newServiceTestDTO())
+	if err != nil || !replayed || retryId != id {
+		t.Errorf("CreateIdempotent() retry = %v, %v, %v, want %v, true, nil", retryId, replayed, err, id)
+	}
+
+	different := newServiceTestDTO()
+	different.Retailer = "Walgreens"
+	if _, _, err := service.CreateIdempotent(ctx, "key-1", different); !errors.Is(err, ErrIdempotencyKeyReused) {
+		t.Errorf("CreateIdempotent() error = %v, want ErrIdempotencyKeyReused", err)
+	}
+
+	invalid := newServiceTestDTO()
+	invalid.Total = "1.00"
+	if _, _, err := service.CreateIdempotent(ctx, "key-2", invalid); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("CreateIdempotent() error = %v, want ErrInvalidInput", err)
+	}
+	if _, _, err := service.CreateIdempotent(ctx, "key-2", newServiceTestDTO()); err != nil {
+		t.Errorf("CreateIdempotent() after a failed attempt error = %v", err)
+	}
+
+	if len(repo.receipts) != 2 {
+		t.Errorf("CreateIdempotent() stored %v receipts, want 2", len(repo.receipts))
+	}
+}
+
+// TestService_CreateIdempotentLease retries keys left reserved by requests
+// that stopped before completing them.
+func TestService_CreateIdempotentLease(t *testing.T) {
+	fingerprint, err := fingerprintOf(newServiceTestDTO())
+	if err != nil {
+		t.Fatalf("fingerprintOf() error = %v", err)
+	}
+	pendingId := uuid.New().String()
+	expired := time.Now().Add(-2 * IdempotencyLease)
+
+	tests := []struct {
+		name string
+		// reserved is when the key was reserved, and created whether the
+		// request that reserved it created its receipt.
+		reserved     time.Time
+		created      bool
+		wantId       string
+		wantReplayed bool
+		wantErr      error
+	}{
+		{name: "in progress", reserved: time.Now(), wantErr: ErrIdempotencyKeyInUse},
+		{name: "stopped before creating", reserved: expired, wantId: pendingId},
+		{name: "stopped after creating", reserved: expired, created: true, wantId: pendingId, wantReplayed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newFakeRepository()
+			store := &fakeIdempotencyStore{records: map[string]IdempotencyRecord{
+				"key": {Key: "key", Fingerprint: fingerprint, PendingId: pendingId, CreatedAt: tt.reserved},
+			}}
+			service := NewService(repo, WithIdempotencyStore(store))
+			if tt.created {
+				repo.receipts[pendingId] = &Receipt{Id: uuid.MustParse(pendingId)}
+			}
+
+			id, replayed, err := service.CreateIdempotent(context.Background(), "key", newServiceTestDTO())
+			if !errors.Is(err, tt.wantErr) || id != tt.wantId || replayed != tt.wantReplayed {
+				t.Fatalf("CreateIdempotent() = %q, %t, %v, want %q, %t, %v", id, replayed, err, tt.wantId, tt.wantReplayed, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if len(repo.receipts) != 1 {
+				t.Errorf("CreateIdempotent() stored %v receipts, want 1", len(repo.receipts))
+			}
+			if got := store.records["key"].ReceiptId; got != pendingId {
+				t.Errorf("CreateIdempotent() completed the key with %q, want %q", got, pendingId)
+			}
+		})
+	}
+}
+
+func TestService_CreateIdempotentConcurrent(t *testing.T) {
+	repo := newFakeRepository()
+	service := NewService(repo, WithIdempotencyStore(&fakeIdempotencyStore{records: make(map[string]IdempotencyRecord)}))
+
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _, err := service.CreateIdempotent(context.Background(), "key", newServiceTestDTO())
+			if err != nil && !errors.Is(err, ErrIdempotencyKeyInUse) {
+				t.Errorf("CreateIdempotent() error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(repo.receipts) != 1 {
+		t.Errorf("CreateIdempotent() stored %v receipts, want 1", len(repo.receipts))
+	}
+}
@@ -0,0 +1,213 @@
+package receipt
+
+import (
+	"context"
+	"sort"
+)
+
+const (
+	// SimulationTopReceipts is how many receipts a simulation lists as the
+	// top gainers and the top losers.
+	SimulationTopReceipts = 10
+	// MaxSimulationErrors bounds how many receipt errors a simulation
+	// records.
+	MaxSimulationErrors = 100
+)
+
+var (
+	// pointsHistogramBounds split receipts' points into 0 or less, 1 to 9,
+	// 10 to 24, and so on up to 1000 or more.
+	pointsHistogramBounds = []int64{1, 10, 25, 50, 100, 250, 500, 1000}
+	// deltaHistogramBounds split changes in points into -100 or less, -99
+	// to -10, -9 to -1, 0, 1 to 9, 10 to 99 and 100 or more.
+	deltaHistogramBounds = []int64{-99, -9, 0, 1, 10, 100}
+)
+
+// Simulation is what scoring stored receipts with a candidate ruleset would
+// change. Before is the points the receipts have now, After those the
+// candidate would award them together with the campaigns recorded on them,
+// so that only the ruleset's changes show.
+type Simulation struct {
+	RulesetVersion string
+	Receipts       int
+	Changed        int
+	Failed         int
+	PointsBefore   int64
+	PointsAfter    int64
+	// PointsBeforeHistogram and PointsAfterHistogram count the receipts by
+	// points, and DeltaHistogram by the change in their points.
+	PointsBeforeHistogram Histogram
+	PointsAfterHistogram  Histogram
+	DeltaHistogram        Histogram
+	// TopGainers lists the receipts gaining the most points, most first,
+	// and TopLosers those losing the most. Neither lists unchanged
+	// receipts.
+	TopGainers []PointsChange
+	TopLosers  []PointsChange
+	// Rules sums the points each rule awards before and after, largest
+	// change first.
+	Rules  []RuleContribution
+	Errors []ReceiptError
+}
+
+// Histogram counts values in the buckets its bounds split them into:
+// Counts[0] counts the values below Bounds[0], Counts[i] those from
+// Bounds[i-1] up to Bounds[i], and the last count those from the last
+// bound up.
+type Histogram struct {
+	Bounds []int64
+	Counts []int
+}
+
+func newHistogram(bounds []int64) Histogram {
+	return Histogram{Bounds: bounds, Counts: make([]int, len(bounds)+1)}
+}
+
+func (h *Histogram) add(value int64) {
+	i := sort.Search(len(h.Bounds), func(i int) bool { return value < h.Bounds[i] })
+	h.Counts[i]++
+}
+
+// RuleContribution is the points a rule awards the simulated receipts
+// before and after. Rules are told apart by their description and
+// campaign, so a rule whose description changes counts as a rule removed
+// and another added. Receipts counts the receipts the rule awards points
+// to, or takes points from.
+type RuleContribution struct {
+	Description    string
+	Campaign       string
+	PointsBefore   int64
+	PointsAfter    int64
+	ReceiptsBefore int
+	ReceiptsAfter  int
+}
+
+type ruleKey struct {
+	description string
+	campaign    string
+}
+
+// Simulate scores the stored receipts matching the query's filters with a
+// candidate ruleset, which need not be registered, and reports how their
+// points would change. Nothing is stored. The receipts are read a page at
+// a time, so memory use does not grow with their number.
+func (s *Service) Simulate(ctx context.Context, ruleset *Ruleset, query ListQuery) (*Simulation, error) {
+	simulation := &Simulation{
+		RulesetVersion:        ruleset.Version,
+		PointsBeforeHistogram: newHistogram(pointsHistogramBounds),
+		PointsAfterHistogram:  newHistogram(pointsHistogramBounds),
+		DeltaHistogram:        newHistogram(deltaHistogramBounds),
+	}
+	rules := make(map[ruleKey]*RuleContribution)
+
+	query.SortBy = SortByCreatedAt
+	query.Order = SortAscending
+	err := s.Export(ctx, query, func(receipt *Receipt) error {
+		var result PointsResult
+		campaigns, err := s.recordedCampaigns(receipt)
+		if err == nil {
+			result, err = calculateWith(receipt, ruleset, campaigns)
+		}
+		if err != nil {
+			simulation.Failed++
+			if len(simulation.Errors) < MaxSimulationErrors {
+				simulation.Errors = append(simulation.Errors, ReceiptError{ReceiptId: receipt.Id.String(), Error: err.Error()})
+			}
+			return nil
+		}
+		simulation.add(receipt, result)
+		addContributions(rules, receipt.PointsBreakdown, func(c *RuleContribution, points int64) {
+			c.PointsBefore += points
+			c.ReceiptsBefore++
+		})
+		addContributions(rules, result.Rules, func(c *RuleContribution, points int64) {
+			c.PointsAfter += points
+			c.ReceiptsAfter++
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	simulation.Rules = make([]RuleContribution, 0, len(rules))
+	for _, contribution := range rules {
+		simulation.Rules = append(simulation.Rules, *contribution)
+	}
+	sort.Slice(simulation.Rules, func(i, j int) bool {
+		a, b := simulation.Rules[i], simulation.Rules[j]
+		if da, db := abs(a.PointsAfter-a.PointsBefore), abs(b.PointsAfter-b.PointsBefore); da != db {
+			return da > db
+		}
+		if a.Campaign != b.Campaign {
+			return a.Campaign < b.Campaign
+		}
+		return a.Description < b.Description
+	})
+	return simulation, nil
+}
+
+// add records a receipt's points before and after.
+func (s *Simulation) add(receipt *Receipt, result PointsResult) {
+	before, after := receipt.Points, result.Total
+	s.Receipts++
+	s.PointsBefore += before
+	s.PointsAfter += after
+	s.PointsBeforeHistogram.add(before)
+	s.PointsAfterHistogram.add(after)
+	s.DeltaHistogram.add(after - before)
+	if after == before {
+		return
+	}
+
+	s.Changed++
+	change := PointsChange{ReceiptId: receipt.Id.String(), Before: before, After: after}
+	if after > before {
+		s.TopGainers = insertTop(s.TopGainers, change, func(a, b PointsChange) bool {
+			return a.After-a.Before > b.After-b.Before
+		})
+	} else {
+		s.TopLosers = insertTop(s.TopLosers, change, func(a, b PointsChange) bool {
+			return a.After-a.Before < b.After-b.Before
+		})
+	}
+}
+
+// insertTop inserts change into top, which is ordered by better, keeping
+// at most SimulationTopReceipts changes. Of equal changes, the first one
+// inserted ranks first.
+func insertTop(top []PointsChange, change PointsChange, better func(a, b PointsChange) bool) []PointsChange {
+	i := sort.Search(len(top), func(i int) bool { return better(change, top[i]) })
+	if i == SimulationTopReceipts {
+		return top
+	}
+	if len(top) < SimulationTopReceipts {
+		top = append(top, PointsChange{})
+	}
+	copy(top[i+1:], top[i:])
+	top[i] = change
+	return top
+}
+
+// addContributions adds the rules that award or take points in a
+// breakdown to their contributions.
+func addContributions(rules map[ruleKey]*RuleContribution, breakdown []RuleResult, add func(*RuleContribution, int64)) {
+	for _, rule := range breakdown {
+		key := ruleKey{description: rule.Description, campaign: rule.Campaign}
+		contribution, ok := rules[key]
+		if !ok {
+			contribution = &RuleContribution{Description: rule.Description, Campaign: rule.Campaign}
+			rules[key] = contribution
+		}
+		if rule.Points != 0 {
+			add(contribution, rule.Points)
+		}
+	}
+}
+
+func abs(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,208 @@
+package receipt
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestService_Simulate(t *testing.T) {
+	repo := newFakeRepository()
+	service := NewService(repo)
+	// The default ruleset awards the test receipts 13 points besides one
+	// per character of their retailer.
+	receipts := createRescoreTestReceipts(t, service, "Target", "Walgreens", "CVS")
+
+	candidate, err := ParseRuleset(strings.NewReader(`{"version": "draft", "rules": [{"type": "retailerCharacterBonus"}, {"type": "multiplier", "params": {"factor": "4"}}]}`))
+	if err != nil {
+		t.Fatalf("ParseRuleset() error = %v", err)
+	}
+
+	simulation, err := service.Simulate(context.Background(), candidate, ListQuery{})
+	if err != nil {
+		t.Fatalf("Simulate() error = %v", err)
+	}
+
+	if simulation.RulesetVersion != "draft" || simulation.Receipts != 3 || simulation.Changed != 3 || simulation.Failed != 0 {
+		t.Errorf("Simulate() = %+v", simulation)
+	}
+	if simulation.PointsBefore != 57 || simulation.PointsAfter != 72 {
+		t.Errorf("Simulate() points = %v -> %v, want 57 -> 72", simulation.PointsBefore, simulation.PointsAfter)
+	}
+
+	wantGainers := []PointsChange{
+		{ReceiptId: receipts[1].Id.String(), Before: 22, After: 36},
+		{ReceiptId: receipts[0].Id.String(), Before: 19, After: 24},
+	}
+	if !reflect.DeepEqual(simulation.TopGainers, wantGainers) {
+		t.Errorf("Simulate() top gainers = %v, want %v", simulation.TopGainers, wantGainers)
+	}
+	wantLosers := []PointsChange{{ReceiptId: receipts[2].Id.String(), Before: 16, After: 12}}
+	if !reflect.DeepEqual(simulation.TopLosers, wantLosers) {
+		t.Errorf("Simulate() top losers = %v, want %v", simulation.TopLosers, wantLosers)
+	}
+
+	histograms := map[string]struct {
+		got  []int
+		want []int
+	}{
+		"points before": {simulation.PointsBeforeHistogram.Counts, []int{0, 0, 3, 0, 0, 0, 0, 0, 0}},
+		"points after":  {simulation.PointsAfterHistogram.Counts, []int{0, 0, 2, 1, 0, 0, 0, 0, 0}},
+		"delta":         {simulation.DeltaHistogram.Counts, []int{0, 0, 1, 0, 1, 1, 0}},
+	}
+	for name, h := range histograms {
+		if !reflect.DeepEqual(h.got, h.want) {
+			t.Errorf("Simulate() %s histogram = %v, want %v", name, h.got, h.want)
+		}
+	}
+
+	contributions := make(map[string]RuleContribution)
+	for _, rule := range simulation.Rules {
+		contributions[rule.Description] = rule
+	}
+	retailerRule := contributions["One point for every alphanumeric character in the retailer name"]
+	if retailerRule.PointsBefore != 18 || retailerRule.PointsAfter != 18 || retailerRule.ReceiptsBefore != 3 || retailerRule.ReceiptsAfter != 3 {
+		t.Errorf("Simulate() retailer rule = %+v", retailerRule)
+	}
+	pairRule := contributions["5 points for every two items on the receipt"]
+	if pairRule.PointsBefore != 15 || pairRule.PointsAfter != 0 || pairRule.ReceiptsAfter != 0 {
+		t.Errorf("Simulate() item pair rule = %+v", pairRule)
+	}
+	if first := simulation.Rules[0]; first.PointsAfter-first.PointsBefore != 54 {
+		t.Errorf("Simulate() first rule = %+v, want the multiplier's change of 54", first)
+	}
+
+	for _, receipt := range receipts {
+		stored, err := repo.Get(receipt.Id.String())
+		if err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+		if stored.RulesetVersion != DefaultRulesetVersion {
+			t.Errorf("Simulate() stored ruleset version %v for receipt %s", stored.RulesetVersion, receipt.Id)
+		}
+	}
+
+	filtered, err := service.Simulate(context.Background(), candidate, ListQuery{Retailer: "Target"})
+	if err != nil {
+		t.Fatalf("Simulate() error = %v", err)
+	}
+	if filtered.Receipts != 1 || filtered.PointsBefore != 19 || filtered.PointsAfter != 24 {
+		t.Errorf("Simulate() filtered = %+v", filtered)
+	}
+}
+
+// TestService_SimulateRecordedCampaigns simulates the ruleset a receipt
+// was scored with after the campaign that scored it changed, and checks
+// that the simulation shows no change.
+func TestService_SimulateRecordedCampaigns(t *testing.T) {
+	ctx := context.Background()
+	service := NewService(newFakeRepository(), WithCampaigns(newFakeCampaignRepository()))
+
+	dto := CampaignDTO{
+		Name:     "Target week",
+		StartsAt: "2022-01-01T00:00:00Z",
+		EndsAt:   "2022-01-08T00:00:00Z",
+		Rules:    []RuleSpec{{Type: "expression", Params: json.RawMessage(`{"expression": "points = 7"}`)}},
+	}
+	campaign, err := service.CreateCampaign(ctx, dto)
+	if err != nil {
+		t.Fatalf("CreateCampaign() error = %v", err)
+	}
+	created, err := service.Create(ctx, newServiceTestDTO())
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	dto.Rules = []RuleSpec{{Type: "expression", Params: json.RawMessage(`{"expression": "points = 20"}`)}}
+	if _, err := service.UpdateCampaign(ctx, campaign.Id.String(), dto); err != nil {
+		t.Fatalf("UpdateCampaign() error = %v", err)
+	}
+	simulation, err := service.Simulate(ctx, DefaultRuleset(), ListQuery{})
+	if err != nil {
+		t.Fatalf("Simulate() error = %v", err)
+	}
+	if simulation.Changed != 0 || simulation.PointsBefore != created.Points || simulation.PointsAfter != created.Points {
+		t.Errorf("Simulate() after UpdateCampaign() = %d changed, %d -> %d points, want none changed, %d points",
+			simulation.Changed, simulation.PointsBefore, simulation.PointsAfter, created.Points)
+	}
+	for _, rule := range simulation.Rules {
+		if rule.Campaign == "Target week" && (rule.PointsBefore != 7 || rule.PointsAfter != 7) {
+			t.Errorf("Simulate() campaign rule = %+v, want 7 points before and after", rule)
+		}
+	}
+
+	if err := service.DeleteCampaign(ctx, campaign.Id.String()); err != nil {
+		t.Fatalf("DeleteCampaign() error = %v", err)
+	}
+	simulation, err = service.Simulate(ctx, DefaultRuleset(), ListQuery{})
+	if err != nil {
+		t.Fatalf("Simulate() error = %v", err)
+	}
+	if simulation.Changed != 0 || simulation.PointsAfter != created.Points {
+		t.Errorf("Simulate() after DeleteCampaign() = %d changed, %d points after, want none changed, %d points", simulation.Changed, simulation.PointsAfter, created.Points)
+	}
+}
+
+func TestInsertTop(t *testing.T) {
+	gains := func(a, b PointsChange) bool { return a.After-a.Before > b.After-b.Before }
+
+	var top []PointsChange
+	for i := range SimulationTopReceipts + 5 {
+		top = insertTop(top, PointsChange{ReceiptId: string(rune('a' + i)), After: int64(i % 7)}, gains)
+	}
+
+	if len(top) != SimulationTopReceipts {
+		t.Fatalf("insertTop() kept %d changes, want %d", len(top), SimulationTopReceipts)
+	}
+	var got []string
+	for _, change := range top {
+		got = append(got, change.ReceiptId)
+	}
+	// Gains of 6 to 2, the first inserted first among equals.
+	want := []string{"g", "n", "f", "m", "e", "l", "d", "k", "c", "j"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("insertTop() = %v, want %v", got, want)
+	}
+}
+
+func TestNewSimulationResponseDTO_Histograms(t *testing.T) {
+	h := newHistogram(deltaHistogramBounds)
+	for _, value := range []int64{-250, -99, -10, -9, -1, 0, 1, 9, 10, 99, 100} {
+		h.add(value)
+	}
+
+	got := newHistogramDTO(h)
+	want := []struct {
+		min, max *int64
+		count    int
+	}{
+		{nil, ptr(int64(-100)), 1},
+		{ptr(int64(-99)), ptr(int64(-10)), 2},
+		{ptr(int64(-9)), ptr(int64(-1)), 2},
+		{ptr(int64(0)), ptr(int64(0)), 1},
+		{ptr(int64(1)), ptr(int64(9)), 2},
+		{ptr(int64(10)), ptr(int64(99)), 2},
+		{ptr(int64(100)), nil, 1},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("newHistogramDTO() = %d buckets, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if !reflect.DeepEqual(got[i].Min, want[i].min) || !reflect.DeepEqual(got[i].Max, want[i].max) || got[i].Count != want[i].count {
+			t.Errorf("bucket %d = %v..%v: %d, want %v..%v: %d", i, deref(got[i].Min), deref(got[i].Max), got[i].Count, deref(want[i].min), deref(want[i].max), want[i].count)
+		}
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func deref(p *int64) any {
+	if p == nil {
+		return nil
+	}
+	return *p
+}
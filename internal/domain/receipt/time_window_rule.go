@@ -0,0 +1,208 @@
+package receipt
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TimeWindowRule awards Points to receipts purchased within a time window
+// made of any of:
+//
+//   - From and To, times of day written as HH:MM. A purchase qualifies from
+//     From, inclusive, to To, exclusive. Either may be left out, meaning
+//     from midnight or until midnight, and a window with To earlier than
+//     From runs past midnight, such as 22:00 to 02:00.
+//   - Days, the days of the week, such as "Saturday".
+//   - DateFrom and DateTo, dates written as YYYY-MM-DD, both inclusive.
+//     Either may be left out.
+//   - Holidays, the name of a holiday calendar: only its holidays qualify.
+//     ExceptHolidays, also a calendar, excludes its holidays.
+//
+// A purchase qualifies when it falls within every part of the window that
+// is set. Days, dates and holidays are those of the purchase's date, even
+// for the part of a window past midnight.
+type TimeWindowRule struct {
+	Name           string   `json:"description"`
+	Points         int64    `json:"points"`
+	From           string   `json:"from"`
+	To             string   `json:"to"`
+	Days           []string `json:"days"`
+	DateFrom       string   `json:"dateFrom"`
+	DateTo         string   `json:"dateTo"`
+	Holidays       string   `json:"holidays"`
+	ExceptHolidays string   `json:"exceptHolidays"`
+
+	from, to       int
+	days           map[time.Weekday]bool
+	holidays       *HolidayCalendar
+	exceptHolidays *HolidayCalendar
+}
+
+func (r *TimeWindowRule) Calculate(receipt *Receipt) int64 {
+	if r.contains(receipt.PurchaseDateTime) {
+		return r.Points
+	}
+	return 0
+}
+
+func (r *TimeWindowRule) contains(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	if r.from < r.to && (minute < r.from || minute >= r.to) {
+		return false
+	}
+	if r.from > r.to && minute < r.from && minute >= r.to {
+		return false
+	}
+	if len(r.days) > 0 && !r.days[t.Weekday()] {
+		return false
+	}
+
+	date := t.Format("2006-01-02")
+	if r.DateFrom != "" && date < r.DateFrom {
+		return false
+	}
+	if r.DateTo != "" && date > r.DateTo {
+		return false
+	}
+
+	if r.holidays != nil {
+		if _, ok := r.holidays.Holiday(t); !ok {
+			return false
+		}
+	}
+	if r.exceptHolidays != nil {
+		if _, ok := r.exceptHolidays.Holiday(t); ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *TimeWindowRule) Description() string {
+	if r.Name != "" {
+		return r.Name
+	}
+
+	parts := []string{fmt.Sprintf("%d points for purchases", r.Points)}
+	switch {
+	case r.From != "" && r.To != "":
+		parts = append(parts, fmt.Sprintf("from %s to %s", r.From, r.To))
+	case r.From != "":
+		parts = append(parts, "from "+r.From)
+	case r.To != "":
+		parts = append(parts, "before "+r.To)
+	}
+	if len(r.Days) > 0 {
+		parts = append(parts, "on "+joinWords(r.Days))
+	}
+	switch {
+	case r.DateFrom != "" && r.DateTo != "":
+		parts = append(parts, fmt.Sprintf("between %s and %s", r.DateFrom, r.DateTo))
+	case r.DateFrom != "":
+		parts = append(parts, "since "+r.DateFrom)
+	case r.DateTo != "":
+		parts = append(parts, "until "+r.DateTo)
+	}
+	if r.Holidays != "" {
+		parts = append(parts, fmt.Sprintf("on %s holidays", r.Holidays))
+	}
+	if r.ExceptHolidays != "" {
+		parts = append(parts, fmt.Sprintf("except on %s holidays", r.ExceptHolidays))
+	}
+	return strings.Join(parts, " ")
+}
+
+func (r *TimeWindowRule) Inputs(receipt *Receipt) map[string]string {
+	t := receipt.PurchaseDateTime
+	inputs := map[string]string{
+		"purchaseDate": t.Format("2006-01-02"),
+		"purchaseTime": t.Format("15:04"),
+	}
+	if len(r.days) > 0 {
+		inputs["weekday"] = t.Weekday().String()
+	}
+	for _, calendar := range []*HolidayCalendar{r.holidays, r.exceptHolidays} {
+		if calendar == nil {
+			continue
+		}
+		if holiday, ok := calendar.Holiday(t); ok {
+			inputs["holiday"] = holiday
+		}
+	}
+	return inputs
+}
+
+// Validate parses the window, which must be done before the rule scores a
+// receipt.
+func (r *TimeWindowRule) Validate() error {
+	var errs []error
+	if r.Points <= 0 {
+		errs = append(errs, errors.New("points is required and must be positive"))
+	}
+
+	var err error
+	r.from, r.to = 0, 0
+	if r.From != "" {
+		if r.from, err = parseClock("from", r.From); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if r.To != "" {
+		if r.to, err = parseClock("to", r.To); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if r.From != "" && r.To != "" && r.from == r.to {
+		errs = append(errs, errors.New("from and to must differ"))
+	}
+
+	r.days = make(map[time.Weekday]bool, len(r.Days))
+	for _, day := range r.Days {
+		weekday, ok := parseWeekday(day)
+		if !ok {
+			errs = append(errs, fmt.Errorf("days: unknown day %q, expected Monday to Sunday", day))
+			continue
+		}
+		r.days[weekday] = true
+	}
+
+	for _, date := range []struct{ name, value string }{{"dateFrom", r.DateFrom}, {"dateTo", r.DateTo}} {
+		if _, err := time.Parse("2006-01-02", date.value); date.value != "" && err != nil {
+			errs = append(errs, fmt.Errorf("%s must be written as YYYY-MM-DD, got %q", date.name, date.value))
+		}
+	}
+	if r.DateFrom != "" && r.DateTo != "" && r.DateFrom > r.DateTo {
+		errs = append(errs, errors.New("dateFrom must not be later than dateTo"))
+	}
+
+	if r.Holidays != "" {
+		if r.holidays, err = lookupHolidayCalendar(r.Holidays); err != nil {
+			errs = append(errs, fmt.Errorf("holidays: %w", err))
+		}
+	}
+	if r.ExceptHolidays != "" {
+		if r.exceptHolidays, err = lookupHolidayCalendar(r.ExceptHolidays); err != nil {
+			errs = append(errs, fmt.Errorf("exceptHolidays: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func parseWeekday(name string) (time.Weekday, bool) {
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		if strings.EqualFold(day.String(), name) {
+			return day, true
+		}
+	}
+	return 0, false
+}
+
+// joinWords joins words as "a, b and c".
+func joinWords(words []string) string {
+	if len(words) == 1 {
+		return words[0]
+	}
+	return strings.Join(words[:len(words)-1], ", ") + " and " + words[len(words)-1]
+}
@@ -0,0 +1,120 @@
+package receipt
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setTestHolidayCalendars makes a "us" calendar with Independence Day 2025
+// available for the duration of the test.
+func setTestHolidayCalendars(t *testing.T) {
+	t.Helper()
+	calendars, err := ParseHolidayCalendars(strings.NewReader(`{"us": {"2025-07-04": "Independence Day"}}`))
+	if err != nil {
+		t.Fatalf("ParseHolidayCalendars() error = %v", err)
+	}
+	SetHolidayCalendars(calendars)
+	t.Cleanup(func() { SetHolidayCalendars(nil) })
+}
+
+func TestTimeWindowRule_Calculate(t *testing.T) {
+	setTestHolidayCalendars(t)
+
+	// 2025-07-04 is a Friday.
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2025, time.July, day, hour, minute, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		name      string
+		params    string
+		purchased time.Time
+		want      int64
+	}{
+		{name: "in happy hour", params: `{"points": 20, "from": "16:30", "to": "18:00"}`, purchased: at(3, 16, 30), want: 20},
+		{name: "before happy hour", params: `{"points": 20, "from": "16:30", "to": "18:00"}`, purchased: at(3, 16, 29), want: 0},
+		{name: "at the end of happy hour", params: `{"points": 20, "from": "16:30", "to": "18:00"}`, purchased: at(3, 18, 0), want: 0},
+		{name: "past midnight", params: `{"points": 20, "from": "22:00", "to": "02:00"}`, purchased: at(3, 1, 59), want: 20},
+		{name: "outside a window past midnight", params: `{"points": 20, "from": "22:00", "to": "02:00"}`, purchased: at(3, 12, 0), want: 0},
+		{name: "from only", params: `{"points": 20, "from": "22:00"}`, purchased: at(3, 23, 59), want: 20},
+		{name: "to only", params: `{"points": 20, "to": "09:00"}`, purchased: at(3, 9, 0), want: 0},
+		{name: "weekend", params: `{"points": 5, "days": ["Saturday", "sunday"]}`, purchased: at(5, 12, 0), want: 5},
+		{name: "weekday", params: `{"points": 5, "days": ["Saturday", "sunday"]}`, purchased: at(4, 12, 0), want: 0},
+		{name: "last day of a date range", params: `{"points": 5, "dateFrom": "2025-07-01", "dateTo": "2025-07-04"}`, purchased: at(4, 23, 59), want: 5},
+		{name: "after a date range", params: `{"points": 5, "dateFrom": "2025-07-01", "dateTo": "2025-07-04"}`, purchased: at(5, 0, 0), want: 0},
+		{name: "holiday", params: `{"points": 50, "holidays": "us"}`, purchased: at(4, 12, 0), want: 50},
+		{name: "not a holiday", params: `{"points": 50, "holidays": "us"}`, purchased: at(3, 12, 0), want: 0},
+		{name: "weekday except holidays", params: `{"points": 5, "days": ["Friday"], "exceptHolidays": "us"}`, purchased: at(4, 12, 0), want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := NewRule(RuleSpec{Type: "timeWindow", Params: json.RawMessage(tt.params)})
+			if err != nil {
+				t.Fatalf("NewRule() error = %v", err)
+			}
+			if got := rule.Calculate(&Receipt{PurchaseDateTime: tt.purchased}); got != tt.want {
+				t.Errorf("Calculate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTimeWindowRule_Validate(t *testing.T) {
+	setTestHolidayCalendars(t)
+
+	tests := []struct {
+		name    string
+		params  string
+		wantErr string
+	}{
+		{name: "missing points", params: `{"from": "16:30"}`, wantErr: "points is required"},
+		{name: "invalid time", params: `{"points": 5, "from": "4:30pm"}`, wantErr: `from must be a time written as HH:MM, got "4:30pm"`},
+		{name: "empty window", params: `{"points": 5, "from": "16:30", "to": "16:30"}`, wantErr: "from and to must differ"},
+		{name: "unknown day", params: `{"points": 5, "days": ["Caturday"]}`, wantErr: `unknown day "Caturday"`},
+		{name: "invalid date", params: `{"points": 5, "dateTo": "2025-13-01"}`, wantErr: "dateTo must be written as YYYY-MM-DD"},
+		{name: "reversed dates", params: `{"points": 5, "dateFrom": "2025-07-04", "dateTo": "2025-07-01"}`, wantErr: "dateFrom must not be later than dateTo"},
+		{name: "unknown calendar", params: `{"points": 5, "holidays": "uk"}`, wantErr: `holidays: unknown holiday calendar "uk", expected one of [us]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewRule(RuleSpec{Type: "timeWindow", Params: json.RawMessage(tt.params)})
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("NewRule() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	// An unknown day is not taken for Sunday, the zero weekday.
+	rule := &TimeWindowRule{Points: 5, Days: []string{"Caturday", "Monday"}}
+	if err := rule.Validate(); err == nil {
+		t.Fatalf("Validate() error = nil, want an unknown day")
+	}
+	if rule.days[time.Sunday] || !rule.days[time.Monday] {
+		t.Errorf("Validate() days = %v, want only Monday", rule.days)
+	}
+}
+
+func TestTimeWindowRule_Description(t *testing.T) {
+	setTestHolidayCalendars(t)
+
+	rule, err := NewRule(RuleSpec{Type: "timeWindow", Params: json.RawMessage(`{"points": 20, "from": "16:30", "to": "18:00", "days": ["Saturday", "Sunday"], "exceptHolidays": "us"}`)})
+	if err != nil {
+		t.Fatalf("NewRule() error = %v", err)
+	}
+	want := "20 points for purchases from 16:30 to 18:00 on Saturday and Sunday except on us holidays"
+	if got := rule.Description(); got != want {
+		t.Errorf("Description() = %q, want %q", got, want)
+	}
+}
+
+func TestLoadHolidayCalendars_Config(t *testing.T) {
+	calendars, err := LoadHolidayCalendars("../../../config/holidays.json")
+	if err != nil {
+		t.Fatalf("LoadHolidayCalendars() error = %v", err)
+	}
+	holiday, ok := calendars["us"].Holiday(time.Date(2025, time.December, 25, 12, 0, 0, 0, time.UTC))
+	if !ok || holiday != "Christmas Day" {
+		t.Errorf("Holiday() = %q, %v, want Christmas Day", holiday, ok)
+	}
+}
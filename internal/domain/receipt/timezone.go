@@ -0,0 +1,130 @@
+package receipt
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	// Embed the time zone database, so that receipts are scored the same
+	// whatever the host's zoneinfo.
+	_ "time/tzdata"
+)
+
+// maxUTCOffset bounds UTC offsets, which range from -12:00 to +14:00 in
+// practice.
+const maxUTCOffset = 14 * time.Hour
+
+var utcOffsetPattern = regexp.MustCompile(`^([+-])(\d{2}):?(\d{2})$`)
+
+// ParseTimeZone parses an IANA time zone name, such as "America/Chicago",
+// or a UTC offset, such as "+05:30", "-0800" or "Z".
+func ParseTimeZone(name string) (*time.Location, error) {
+	if name == "Z" || name == "UTC" {
+		return time.UTC, nil
+	}
+
+	if m := utcOffsetPattern.FindStringSubmatch(name); m != nil {
+		hours, _ := strconv.Atoi(m[2])
+		minutes, _ := strconv.Atoi(m[3])
+		offset := time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute
+		if minutes >= 60 || offset > maxUTCOffset {
+			return nil, fmt.Errorf("%w: time zone offset %q is out of range", ErrInvalidInput, name)
+		}
+		if m[1] == "-" {
+			offset = -offset
+		}
+		return time.FixedZone(fmt.Sprintf("%s%s:%s", m[1], m[2], m[3]), int(offset.Seconds())), nil
+	}
+
+	// LoadLocation also accepts "Local" and file paths, neither of which
+	// names the same zone everywhere.
+	if name == "" || name == "Local" || strings.HasPrefix(name, "/") || strings.Contains(name, "..") {
+		return nil, fmt.Errorf("%w: unknown time zone %q", ErrInvalidInput, name)
+	}
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("%w: unknown time zone %q", ErrInvalidInput, name)
+	}
+	return location, nil
+}
+
+// localTime returns the instant a wall clock shows a date and time in a
+// location. A time skipped by a daylight saving change is an error. A time
+// that occurs twice, when clocks are turned back, resolves to the first
+// occurrence, the one with the earlier offset.
+func localTime(year int, month time.Month, day, hour, minute int, location *time.Location) (time.Time, error) {
+	wall := time.Date(year, month, day, hour, minute, 0, 0, time.UTC)
+
+	// The offsets in effect a day around the wall time cover any change
+	// that day.
+	var candidates []time.Time
+	for _, around := range []time.Time{wall.Add(-24 * time.Hour), wall.Add(24 * time.Hour)} {
+		_, offset := around.In(location).Zone()
+		candidate := wall.Add(-time.Duration(offset) * time.Second).In(location)
+		if sameWallClock(candidate, wall) && (len(candidates) == 0 || !candidate.Equal(candidates[0])) {
+			candidates = append(candidates, candidate)
+		}
+	}
+
+	switch len(candidates) {
+	case 0:
+		return time.Time{}, fmt.Errorf("%w: %s does not exist in %s, where clocks were turned forward", ErrInvalidInput, wall.Format("2006-01-02 15:04"), location)
+	case 1:
+		return candidates[0], nil
+	default:
+		if candidates[1].Before(candidates[0]) {
+			return candidates[1], nil
+		}
+		return candidates[0], nil
+	}
+}
+
+func sameWallClock(t, wall time.Time) bool {
+	return t.Year() == wall.Year() && t.YearDay() == wall.YearDay() && t.Hour() == wall.Hour() && t.Minute() == wall.Minute()
+}
+
+// WithTimeZones sets the time zone of the receipts that do not give their
+// own: the zone of their retailer in retailers, whose names match ignoring
+// case, or else defaultZone. Without it, they are in UTC.
+func WithTimeZones(defaultZone *time.Location, retailers map[string]*time.Location) Option {
+	return func(s *Service) {
+		if defaultZone != nil {
+			s.timeZone = defaultZone
+		}
+		s.retailerTimeZones = make(map[string]*time.Location, len(retailers))
+		for retailer, zone := range retailers {
+			s.retailerTimeZones[strings.ToLower(strings.TrimSpace(retailer))] = zone
+		}
+	}
+}
+
+// ParseRetailerTimeZones parses a list of retailers and their time zones
+// written as "Target=America/Chicago,Walgreens=America/New_York".
+func ParseRetailerTimeZones(list string) (map[string]*time.Location, error) {
+	zones := make(map[string]*time.Location)
+	for _, entry := range strings.Split(list, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		retailer, name, ok := strings.Cut(entry, "=")
+		if !ok || strings.TrimSpace(retailer) == "" {
+			return nil, fmt.Errorf("%w: %q must be written as retailer=zone", ErrInvalidInput, entry)
+		}
+		zone, err := ParseTimeZone(strings.TrimSpace(name))
+		if err != nil {
+			return nil, fmt.Errorf("retailer %s: %w", strings.TrimSpace(retailer), err)
+		}
+		zones[strings.TrimSpace(retailer)] = zone
+	}
+	return zones, nil
+}
+
+// timeZoneFor returns the time zone of a receipt from a retailer that does
+// not give its own.
+func (s *Service) timeZoneFor(retailer string) *time.Location {
+	if zone, ok := s.retailerTimeZones[strings.ToLower(strings.TrimSpace(retailer))]; ok {
+		return zone
+	}
+	return s.timeZone
+}
@@ -0,0 +1,165 @@
+package receipt
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTimeZone(t *testing.T) {
+	tests := []struct {
+		name       string
+		wantName   string
+		wantOffset int
+		wantErr    bool
+	}{
+		{name: "America/Chicago", wantName: "America/Chicago"},
+		{name: "UTC", wantName: "UTC"},
+		{name: "Z", wantName: "UTC"},
+		{name: "+05:30", wantName: "+05:30", wantOffset: 5*3600 + 30*60},
+		{name: "-0800", wantName: "-08:00", wantOffset: -8 * 3600},
+		{name: "+15:00", wantErr: true},
+		{name: "+05:75", wantErr: true},
+		{name: "Local", wantErr: true},
+		{name: "/etc/localtime", wantErr: true},
+		{name: "Mars/Olympus_Mons", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			zone, err := ParseTimeZone(tt.name)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidInput) {
+					t.Errorf("ParseTimeZone() error = %v, want ErrInvalidInput", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseTimeZone() error = %v", err)
+			}
+			if zone.String() != tt.wantName {
+				t.Errorf("ParseTimeZone() = %v, want %v", zone, tt.wantName)
+			}
+			if tt.wantOffset != 0 {
+				if _, offset := time.Date(2024, time.January, 1, 0, 0, 0, 0, zone).Zone(); offset != tt.wantOffset {
+					t.Errorf("ParseTimeZone() offset = %v, want %v", offset, tt.wantOffset)
+				}
+			}
+		})
+	}
+}
+
+func TestLocalTime(t *testing.T) {
+	newYork, err := ParseTimeZone("America/New_York")
+	if err != nil {
+		t.Fatalf("ParseTimeZone() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		date    time.Time
+		want    string
+		wantErr string
+	}{
+		{
+			name: "standard time",
+			date: time.Date(2024, time.January, 15, 13, 1, 0, 0, time.UTC),
+			want: "2024-01-15T18:01:00Z",
+		},
+		{
+			name: "daylight saving time",
+			date: time.Date(2024, time.July, 15, 13, 1, 0, 0, time.UTC),
+			want: "2024-07-15T17:01:00Z",
+		},
+		{
+			name:    "skipped when clocks are turned forward",
+			date:    time.Date(2024, time.March, 10, 2, 30, 0, 0, time.UTC),
+			wantErr: "2024-03-10 02:30 does not exist in America/New_York",
+		},
+		{
+			name: "just after clocks are turned forward",
+			date: time.Date(2024, time.March, 10, 3, 0, 0, 0, time.UTC),
+			want: "2024-03-10T07:00:00Z",
+		},
+		{
+			name: "repeated when clocks are turned back",
+			date: time.Date(2024, time.November, 3, 1, 30, 0, 0, time.UTC),
+			want: "2024-11-03T05:30:00Z",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := localTime(tt.date.Year(), tt.date.Month(), tt.date.Day(), tt.date.Hour(), tt.date.Minute(), newYork)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) || !errors.Is(err, ErrInvalidInput) {
+					t.Errorf("localTime() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("localTime() error = %v", err)
+			}
+			if got.UTC().Format(time.RFC3339) != tt.want {
+				t.Errorf("localTime() = %v, want %v", got.UTC().Format(time.RFC3339), tt.want)
+			}
+			if got.Hour() != tt.date.Hour() || got.Minute() != tt.date.Minute() {
+				t.Errorf("localTime() wall clock = %v, want %v", got.Format("15:04"), tt.date.Format("15:04"))
+			}
+		})
+	}
+}
+
+func TestService_TimeZones(t *testing.T) {
+	chicago, _ := ParseTimeZone("America/Chicago")
+	tokyo, _ := ParseTimeZone("Asia/Tokyo")
+	service := NewService(newFakeRepository(), WithTimeZones(chicago, map[string]*time.Location{"target": tokyo}))
+
+	tests := []struct {
+		name     string
+		retailer string
+		timeZone string
+		wantZone string
+	}{
+		{name: "receipt zone", retailer: "Target", timeZone: "+05:30", wantZone: "+05:30"},
+		{name: "retailer zone", retailer: "Target", wantZone: "Asia/Tokyo"},
+		{name: "default zone", retailer: "Walgreens", wantZone: "America/Chicago"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dto := newServiceTestDTO()
+			dto.Retailer = tt.retailer
+			dto.TimeZone = tt.timeZone
+			receipt, err := service.Create(context.Background(), dto)
+			if err != nil {
+				t.Fatalf("Create() error = %v", err)
+			}
+			if receipt.TimeZone != tt.wantZone {
+				t.Errorf("Create() time zone = %v, want %v", receipt.TimeZone, tt.wantZone)
+			}
+			if got := receipt.PurchaseDateTime.Format("2006-01-02 15:04"); got != dto.PurchaseDate+" "+dto.PurchaseTime {
+				t.Errorf("Create() wall clock = %v, want %v %v", got, dto.PurchaseDate, dto.PurchaseTime)
+			}
+		})
+	}
+
+	dto := newServiceTestDTO()
+	dto.TimeZone = "Nowhere/Special"
+	if _, err := service.Create(context.Background(), dto); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("Create() error = %v, want ErrInvalidInput", err)
+	}
+}
+
+func TestParseRetailerTimeZones(t *testing.T) {
+	zones, err := ParseRetailerTimeZones("Target=America/Chicago, Walgreens = -05:00")
+	if err != nil {
+		t.Fatalf("ParseRetailerTimeZones() error = %v", err)
+	}
+	if zones["Target"].String() != "America/Chicago" || zones["Walgreens"].String() != "-05:00" {
+		t.Errorf("ParseRetailerTimeZones() = %v", zones)
+	}
+
+	if _, err := ParseRetailerTimeZones("Target"); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("ParseRetailerTimeZones() error = %v, want ErrInvalidInput", err)
+	}
+}
@@ -0,0 +1,101 @@
+package boltdb
+
+import (
+	"errors"
+	"fmt"
+	bolt "go.etcd.io/bbolt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// lockTimeout is how long Open waits for another process to release the
+// data file before giving up.
+var lockTimeout = 5 * time.Second
+
+// DefaultCompactTxSize is how many bytes Compact copies per transaction.
+const DefaultCompactTxSize = 64 << 20
+
+// DB is a single-file key-value store. Each write transaction is committed
+// with the file synced, so a crash leaves it as of the last commit.
+type DB struct {
+	*bolt.DB
+}
+
+// Open opens the data file at path, creating it if it does not exist.
+// Only one process may have a data file open at a time.
+func Open(path string) (*DB, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: lockTimeout})
+	if err != nil {
+		if errors.Is(err, bolt.ErrTimeout) {
+			return nil, fmt.Errorf("%s is in use by another process", path)
+		}
+		return nil, err
+	}
+	return &DB{DB: db}, nil
+}
+
+// Compaction reports the size of a data file before and after Compact.
+type Compaction struct {
+	SizeBefore int64
+	SizeAfter  int64
+}
+
+// Compact rewrites the data file at path without the free pages deletes
+// and updates leave behind, txSize bytes per transaction. The file is
+// written to a temporary file beside it, then renamed over it, so it is
+// left as it was if compaction fails. The file must not be open.
+func Compact(path string, txSize int64) (*Compaction, error) {
+	before, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	src, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: lockTimeout, ReadOnly: true})
+	if err != nil {
+		if errors.Is(err, bolt.ErrTimeout) {
+			return nil, fmt.Errorf("%s is in use by another process", path)
+		}
+		return nil, err
+	}
+	defer src.Close()
+
+	tmp := path + ".compact"
+	os.Remove(tmp)
+	dst, err := bolt.Open(tmp, before.Mode().Perm(), &bolt.Options{Timeout: lockTimeout})
+	if err != nil {
+		return nil, err
+	}
+	if err := bolt.Compact(dst, src, txSize); err != nil {
+		dst.Close()
+		os.Remove(tmp)
+		return nil, fmt.Errorf("compacting %s: %w", path, err)
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+
+	after, err := os.Stat(tmp)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+	if err := syncDir(filepath.Dir(path)); err != nil {
+		return nil, err
+	}
+	return &Compaction{SizeBefore: before.Size(), SizeAfter: after.Size()}, nil
+}
+
+// syncDir syncs a directory, so that a rename in it survives a crash.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
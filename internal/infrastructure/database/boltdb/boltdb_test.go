@@ -0,0 +1,78 @@
+package boltdb
+
+import (
+	"fmt"
+	bolt "go.etcd.io/bbolt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCompact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.bolt")
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	bucket := []byte("values")
+	value := make([]byte, 1024)
+	err = db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket(bucket)
+		if err != nil {
+			return err
+		}
+		for i := range 2000 {
+			if err := b.Put([]byte(fmt.Sprintf("key-%04d", i)), value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucket)
+		for i := 10; i < 2000; i++ {
+			if err := b.Delete([]byte(fmt.Sprintf("key-%04d", i))); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+
+	defer func(timeout time.Duration) { lockTimeout = timeout }(lockTimeout)
+	lockTimeout = 100 * time.Millisecond
+	if _, err := Compact(path, DefaultCompactTxSize); err == nil {
+		t.Errorf("Compact() of an open file error = nil, want an error")
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	compaction, err := Compact(path, DefaultCompactTxSize)
+	if err != nil {
+		t.Fatalf("Compact() error = %v", err)
+	}
+	if compaction.SizeAfter >= compaction.SizeBefore {
+		t.Errorf("Compact() size %d -> %d, want it smaller", compaction.SizeBefore, compaction.SizeAfter)
+	}
+
+	db, err = Open(path)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer db.Close()
+	err = db.View(func(tx *bolt.Tx) error {
+		if n := tx.Bucket(bucket).Stats().KeyN; n != 10 {
+			t.Errorf("Compact() kept %d keys, want 10", n)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("View() error = %v", err)
+	}
+}
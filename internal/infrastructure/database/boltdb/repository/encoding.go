@@ -0,0 +1,203 @@
+package repository
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"receipt-processor/internal/domain/receipt"
+	"time"
+)
+
+// receiptFormat is the version of the receipt encoding, stored as the first
+// byte of every value so that a later format can still read older ones.
+const receiptFormat byte = 1
+
+// receiptRecord is the stored form of a receipt. Its JSON field names are
+// fixed here rather than taken from receipt.Receipt, so renaming a domain
+// field does not change the data file.
+type receiptRecord struct {
+	Id              string           `json:"id"`
+	Retailer        string           `json:"retailer"`
+	PurchasedAt     time.Time        `json:"purchasedAt"`
+	TimeZone        string           `json:"timeZone,omitempty"`
+	Items           []itemRecord     `json:"items"`
+	Total           string           `json:"total"`
+	Points          int64            `json:"points"`
+	RulesetVersion  string           `json:"rulesetVersion,omitempty"`
+	PointsBreakdown []resultRecord   `json:"pointsBreakdown,omitempty"`
+	Campaigns       []campaignRecord `json:"campaigns,omitempty"`
+	CreatedAt       time.Time        `json:"createdAt"`
+	Version         int64            `json:"version,omitempty"`
+}
+
+type itemRecord struct {
+	Id               string `json:"id"`
+	ShortDescription string `json:"shortDescription"`
+	Price            string `json:"price"`
+}
+
+type resultRecord struct {
+	Description string            `json:"description"`
+	Points      int64             `json:"points"`
+	ExactPoints string            `json:"exactPoints"`
+	Inputs      map[string]string `json:"inputs,omitempty"`
+	Campaign    string            `json:"campaign,omitempty"`
+	Group       string            `json:"group,omitempty"`
+	Excluded    bool              `json:"excluded,omitempty"`
+}
+
+// campaignRecord is a campaign as it was when it scored a receipt.
+type campaignRecord struct {
+	Id         string             `json:"id"`
+	Revision   int64              `json:"revision"`
+	Name       string             `json:"name"`
+	StartsAt   time.Time          `json:"startsAt"`
+	EndsAt     time.Time          `json:"endsAt"`
+	Retailers  []string           `json:"retailers,omitempty"`
+	Rules      []receipt.RuleSpec `json:"rules,omitempty"`
+	Multiplier string             `json:"multiplier"`
+}
+
+func encodeReceipt(rec *receipt.Receipt) ([]byte, error) {
+	record := receiptRecord{
+		Id:             rec.Id.String(),
+		Retailer:       rec.Retailer,
+		PurchasedAt:    rec.PurchaseDateTime,
+		TimeZone:       rec.TimeZone,
+		Items:          make([]itemRecord, len(rec.Items)),
+		Total:          formatDecimal(rec.Total),
+		Points:         rec.Points,
+		RulesetVersion: rec.RulesetVersion,
+		CreatedAt:      rec.CreatedAt,
+		Version:        rec.Version,
+	}
+	for i, item := range rec.Items {
+		record.Items[i] = itemRecord{
+			Id:               item.Id.String(),
+			ShortDescription: item.ShortDescription,
+			Price:            formatDecimal(item.Price),
+		}
+	}
+	for _, result := range rec.PointsBreakdown {
+		record.PointsBreakdown = append(record.PointsBreakdown, resultRecord{
+			Description: result.Description,
+			Points:      result.Points,
+			ExactPoints: formatDecimal(result.ExactPoints),
+			Inputs:      result.Inputs,
+			Campaign:    result.Campaign,
+			Group:       result.Group,
+			Excluded:    result.Excluded,
+		})
+	}
+
+	for _, campaign := range rec.Campaigns {
+		record.Campaigns = append(record.Campaigns, campaignRecord{
+			Id:         campaign.Id.String(),
+			Revision:   campaign.Revision,
+			Name:       campaign.Name,
+			StartsAt:   campaign.StartsAt,
+			EndsAt:     campaign.EndsAt,
+			Retailers:  campaign.Retailers,
+			Rules:      campaign.Rules,
+			Multiplier: campaign.Multiplier.String(),
+		})
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return nil, fmt.Errorf("receipt %s: %w", rec.Id, err)
+	}
+	return append([]byte{receiptFormat}, data...), nil
+}
+
+func decodeReceipt(data []byte) (*receipt.Receipt, error) {
+	if len(data) == 0 || data[0] != receiptFormat {
+		return nil, fmt.Errorf("unknown receipt format")
+	}
+	var record receiptRecord
+	if err := json.Unmarshal(data[1:], &record); err != nil {
+		return nil, err
+	}
+
+	id, err := uuid.Parse(record.Id)
+	if err != nil {
+		return nil, fmt.Errorf("receipt %s: %w", record.Id, err)
+	}
+	rec := &receipt.Receipt{
+		Id:               id,
+		Retailer:         record.Retailer,
+		PurchaseDateTime: record.PurchasedAt,
+		TimeZone:         record.TimeZone,
+		Points:           record.Points,
+		RulesetVersion:   record.RulesetVersion,
+		CreatedAt:        record.CreatedAt,
+		Version:          max(record.Version, 1),
+	}
+	// JSON keeps the UTC offset of the purchase time but not its zone, whose
+	// wall clock the rules read.
+	if record.TimeZone != "" {
+		zone, err := receipt.ParseTimeZone(record.TimeZone)
+		if err != nil {
+			return nil, fmt.Errorf("receipt %s: %w", record.Id, err)
+		}
+		rec.PurchaseDateTime = rec.PurchaseDateTime.In(zone)
+	}
+	if rec.Total, err = decimal.NewFromString(record.Total); err != nil {
+		return nil, fmt.Errorf("receipt %s: total: %w", record.Id, err)
+	}
+
+	for _, item := range record.Items {
+		itemId, err := uuid.Parse(item.Id)
+		if err != nil {
+			return nil, fmt.Errorf("receipt %s: item %s: %w", record.Id, item.Id, err)
+		}
+		price, err := decimal.NewFromString(item.Price)
+		if err != nil {
+			return nil, fmt.Errorf("receipt %s: item %s: %w", record.Id, item.Id, err)
+		}
+		rec.Items = append(rec.Items, receipt.Item{Id: itemId, ShortDescription: item.ShortDescription, Price: price})
+	}
+	for _, result := range record.PointsBreakdown {
+		exact, err := decimal.NewFromString(result.ExactPoints)
+		if err != nil {
+			return nil, fmt.Errorf("receipt %s: points breakdown: %w", record.Id, err)
+		}
+		rec.PointsBreakdown = append(rec.PointsBreakdown, receipt.RuleResult{
+			Description: result.Description,
+			Points:      result.Points,
+			ExactPoints: exact,
+			Inputs:      result.Inputs,
+			Campaign:    result.Campaign,
+			Group:       result.Group,
+			Excluded:    result.Excluded,
+		})
+	}
+	for _, campaign := range record.Campaigns {
+		campaignId, err := uuid.Parse(campaign.Id)
+		if err != nil {
+			return nil, fmt.Errorf("receipt %s: campaign %s: %w", record.Id, campaign.Id, err)
+		}
+		multiplier, err := decimal.NewFromString(campaign.Multiplier)
+		if err != nil {
+			return nil, fmt.Errorf("receipt %s: campaign %s: %w", record.Id, campaign.Id, err)
+		}
+		rec.Campaigns = append(rec.Campaigns, receipt.Campaign{
+			Id:         campaignId,
+			Revision:   campaign.Revision,
+			Name:       campaign.Name,
+			StartsAt:   campaign.StartsAt,
+			EndsAt:     campaign.EndsAt,
+			Retailers:  campaign.Retailers,
+			Rules:      campaign.Rules,
+			Multiplier: multiplier,
+		})
+	}
+	return rec, nil
+}
+
+// formatDecimal formats an amount with as many decimal places as it has, so
+// that 1.00 is read back as 1.00 rather than 1.
+func formatDecimal(d decimal.Decimal) string {
+	return d.StringFixed(max(0, -d.Exponent()))
+}
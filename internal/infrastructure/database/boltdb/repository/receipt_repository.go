@@ -0,0 +1,525 @@
+package repository
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	bolt "go.etcd.io/bbolt"
+	"math"
+	"receipt-processor/internal/domain/receipt"
+	"receipt-processor/internal/infrastructure/database/boltdb"
+	"time"
+)
+
+var _ receipt.Repository = (*ReceiptRepository)(nil)
+
+// The buckets of receipts and their secondary indexes. Index keys end with
+// the 16 bytes of the receipt ID and have empty values.
+var (
+	receiptsBucket   = []byte("receipts")
+	byCreatedBucket  = []byte("receipts_by_created_at")
+	byRetailerBucket = []byte("receipts_by_retailer")
+	byPurchaseBucket = []byte("receipts_by_purchase_time")
+	byTotalBucket    = []byte("receipts_by_total")
+	byPointsBucket   = []byte("receipts_by_points")
+)
+
+// maxFilteredPages bounds how many pages of receipts a listing reads
+// through the index of a filter on a field other than its sort field. With
+// more, it walks the sort field's index from its cursor instead, checking
+// the filter as it goes, so that paging through them reads each receipt
+// once rather than once a page.
+const maxFilteredPages = 10
+
+// ReceiptRepository keeps receipts in a boltdb data file, indexed by every
+// field a listing can sort by. Every change, with its index entries, is
+// one transaction.
+type ReceiptRepository struct {
+	db *boltdb.DB
+}
+
+// NewReceiptRepository creates the buckets of receipts and their indexes
+// in the data file, if it does not have them yet.
+func NewReceiptRepository(db *boltdb.DB) (*ReceiptRepository, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{receiptsBucket, byCreatedBucket, byRetailerBucket, byPurchaseBucket, byTotalBucket, byPointsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return fmt.Errorf("creating bucket %s: %w", name, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &ReceiptRepository{
+		db: db,
+	}, nil
+}
+
+func (r *ReceiptRepository) Create(rec *receipt.Receipt) (*receipt.Receipt, error) {
+	rec.Version = 1
+	data, err := encodeReceipt(rec)
+	if err != nil {
+		return nil, err
+	}
+
+	err = r.update(func(b *buckets) error {
+		if b.receipts.Get(rec.Id[:]) != nil {
+			return fmt.Errorf("receipt %s: %w", rec.Id, receipt.ErrConflict)
+		}
+		return b.put(rec, data)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+func (r *ReceiptRepository) Get(id string) (*receipt.Receipt, error) {
+	key, err := uuid.Parse(id)
+	if err != nil {
+		return nil, fmt.Errorf("receipt %s: %w", id, receipt.ErrNotFound)
+	}
+
+	var rec *receipt.Receipt
+	err = r.view(func(b *buckets) error {
+		rec, err = b.get(key)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if rec == nil {
+		return nil, fmt.Errorf("receipt %s: %w", id, receipt.ErrNotFound)
+	}
+	return rec, nil
+}
+
+func (r *ReceiptRepository) Update(id string, rec *receipt.Receipt) (*receipt.Receipt, error) {
+	expected := rec.Version
+	err := r.update(func(b *buckets) error {
+		// Bolt serializes write transactions, so the version read here is
+		// still the stored one when the receipt is put.
+		existing, err := b.lookup(id)
+		if err != nil {
+			return err
+		}
+		if expected != 0 && expected != existing.Version {
+			return &receipt.VersionConflictError{Id: id, Expected: expected, Actual: existing.Version}
+		}
+
+		rec.Version = existing.Version + 1
+		data, err := encodeReceipt(rec)
+		if err != nil {
+			return err
+		}
+		if err := b.remove(id); err != nil {
+			return err
+		}
+		return b.put(rec, data)
+	})
+	if err != nil {
+		rec.Version = expected
+		return nil, err
+	}
+	return rec, nil
+}
+
+func (r *ReceiptRepository) Delete(id string, version int64) error {
+	return r.update(func(b *buckets) error {
+		if version != 0 {
+			existing, err := b.lookup(id)
+			if err != nil {
+				return err
+			}
+			if version != existing.Version {
+				return &receipt.VersionConflictError{Id: id, Expected: version, Actual: existing.Version}
+			}
+		}
+		return b.remove(id)
+	})
+}
+
+// List walks the index of the query's sort field from its cursor, and
+// stops once it has read one receipt past the page. Filters on the sort
+// field bound the walk, and others are checked as it goes, unless one of
+// them narrows the query to at most maxFilteredPages pages of receipts,
+// which are then read through its index, and sorted and paged in memory.
+func (r *ReceiptRepository) List(query receipt.ListQuery) (*receipt.Page, error) {
+	if err := query.Normalize(); err != nil {
+		return nil, err
+	}
+	position, err := query.Position()
+	if err != nil {
+		return nil, err
+	}
+
+	page := &receipt.Page{}
+	err = r.view(func(b *buckets) error {
+		receipts, ok, err := b.filtered(query)
+		if err != nil {
+			return err
+		}
+		if ok {
+			page, err = receipt.Paginate(receipts, query)
+			return err
+		}
+		page, err = b.walk(query, position)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+func (r *ReceiptRepository) view(fn func(b *buckets) error) error {
+	return wrapError(r.db.View(func(tx *bolt.Tx) error {
+		return fn(bucketsOf(tx))
+	}))
+}
+
+func (r *ReceiptRepository) update(fn func(b *buckets) error) error {
+	return wrapError(r.db.Update(func(tx *bolt.Tx) error {
+		return fn(bucketsOf(tx))
+	}))
+}
+
+// buckets are the buckets of a transaction.
+type buckets struct {
+	receipts   *bolt.Bucket
+	byCreated  *bolt.Bucket
+	byRetailer *bolt.Bucket
+	byPurchase *bolt.Bucket
+	byTotal    *bolt.Bucket
+	byPoints   *bolt.Bucket
+}
+
+// bucketsOf returns the buckets of a transaction, which
+// NewReceiptRepository created.
+func bucketsOf(tx *bolt.Tx) *buckets {
+	return &buckets{
+		receipts:   tx.Bucket(receiptsBucket),
+		byCreated:  tx.Bucket(byCreatedBucket),
+		byRetailer: tx.Bucket(byRetailerBucket),
+		byPurchase: tx.Bucket(byPurchaseBucket),
+		byTotal:    tx.Bucket(byTotalBucket),
+		byPoints:   tx.Bucket(byPointsBucket),
+	}
+}
+
+// index returns the index of a field, the key of a receipt in it, and
+// the range of keys the query's filter on the field narrows it to.
+func (b *buckets) index(field receipt.SortField, query receipt.ListQuery) (*bolt.Bucket, func(*receipt.Receipt) []byte, keyRange) {
+	var bounds keyRange
+	switch field {
+	case receipt.SortByRetailer:
+		if query.Retailer != "" {
+			// Every key of the retailer starts with its name and a NUL byte.
+			bounds.from = append([]byte(query.Retailer), 0)
+			bounds.to = append([]byte(query.Retailer), 1)
+		}
+		return b.byRetailer, func(rec *receipt.Receipt) []byte {
+			return retailerKey(rec.Retailer, rec.Id)
+		}, bounds
+	case receipt.SortByPurchaseDateTime:
+		if query.PurchasedFrom != nil {
+			bounds.from = timeKey(*query.PurchasedFrom, uuid.Nil)[:timeKeySize]
+		}
+		if query.PurchasedTo != nil {
+			bounds.to = timeKey(*query.PurchasedTo, uuid.Nil)[:timeKeySize]
+		}
+		return b.byPurchase, func(rec *receipt.Receipt) []byte {
+			return timeKey(rec.PurchaseDateTime, rec.Id)
+		}, bounds
+	case receipt.SortByTotal:
+		if query.MinTotal != nil {
+			bounds.from = intKey(query.MinTotal.Shift(2).Ceil().IntPart(), uuid.Nil)[:8]
+		}
+		if query.MaxTotal != nil {
+			bounds.to = intKeyAbove(query.MaxTotal.Shift(2).Floor().IntPart())
+		}
+		return b.byTotal, func(rec *receipt.Receipt) []byte {
+			return intKey(cents(rec.Total), rec.Id)
+		}, bounds
+	case receipt.SortByPoints:
+		if query.MinPoints != nil {
+			bounds.from = intKey(*query.MinPoints, uuid.Nil)[:8]
+		}
+		if query.MaxPoints != nil {
+			bounds.to = intKeyAbove(*query.MaxPoints)
+		}
+		return b.byPoints, func(rec *receipt.Receipt) []byte {
+			return intKey(rec.Points, rec.Id)
+		}, bounds
+	default:
+		return b.byCreated, func(rec *receipt.Receipt) []byte {
+			return timeKey(rec.CreatedAt, rec.Id)
+		}, bounds
+	}
+}
+
+// filtered returns the receipts that the narrowest index of a filter on a
+// field other than the sort field narrows the query to, if there are at
+// most maxFilteredPages pages of them.
+func (b *buckets) filtered(query receipt.ListQuery) ([]*receipt.Receipt, bool, error) {
+	filters := map[receipt.SortField]bool{
+		receipt.SortByRetailer:         query.Retailer != "",
+		receipt.SortByPurchaseDateTime: query.PurchasedFrom != nil || query.PurchasedTo != nil,
+		receipt.SortByTotal:            query.MinTotal != nil || query.MaxTotal != nil,
+		receipt.SortByPoints:           query.MinPoints != nil || query.MaxPoints != nil,
+	}
+
+	limit := maxFilteredPages * query.Limit
+	var narrowest *bolt.Bucket
+	var narrowestRange keyRange
+	narrowestCount := limit + 1
+	for field, filtered := range filters {
+		if !filtered || field == query.SortBy {
+			continue
+		}
+		index, _, bounds := b.index(field, query)
+		// Counting stops once the filter is known to be too wide.
+		count := 0
+		bounds.scan(index, false, func([]byte) bool {
+			count++
+			return count < narrowestCount
+		})
+		if count < narrowestCount {
+			narrowest, narrowestRange, narrowestCount = index, bounds, count
+		}
+	}
+	if narrowest == nil {
+		return nil, false, nil
+	}
+
+	var receipts []*receipt.Receipt
+	var err error
+	narrowestRange.scan(narrowest, false, func(key []byte) bool {
+		var rec *receipt.Receipt
+		if rec, err = b.indexed(key); err != nil {
+			return false
+		}
+		receipts = append(receipts, rec)
+		return true
+	})
+	return receipts, err == nil, err
+}
+
+// walk reads a page of receipts from the index of the query's sort field,
+// starting past the query's position.
+func (b *buckets) walk(query receipt.ListQuery, position *receipt.Receipt) (*receipt.Page, error) {
+	index, key, bounds := b.index(query.SortBy, query)
+	descending := query.Order == receipt.SortDescending
+	if position != nil {
+		// The walk starts after the position, or before it when descending.
+		after := key(position)
+		if descending && (bounds.to == nil || bytes.Compare(after, bounds.to) < 0) {
+			bounds.to = after
+		}
+		if !descending && (bounds.from == nil || bytes.Compare(after, bounds.from) > 0) {
+			bounds.from = append(after, 0)
+		}
+	}
+
+	var receipts []*receipt.Receipt
+	var err error
+	bounds.scan(index, descending, func(key []byte) bool {
+		var rec *receipt.Receipt
+		if rec, err = b.indexed(key); err != nil {
+			return false
+		}
+		if query.Matches(rec) {
+			receipts = append(receipts, rec)
+		}
+		return len(receipts) <= query.Limit
+	})
+	if err != nil {
+		return nil, err
+	}
+	return query.Page(receipts), nil
+}
+
+// indexed returns the receipt an index key ends with the ID of.
+func (b *buckets) indexed(key []byte) (*receipt.Receipt, error) {
+	id := uuid.UUID(key[len(key)-len(uuid.Nil):])
+	rec, err := b.get(id)
+	if err != nil {
+		return nil, err
+	}
+	if rec == nil {
+		return nil, fmt.Errorf("index entry for missing receipt %s", id)
+	}
+	return rec, nil
+}
+
+// indexEntry is the key of a receipt in an index.
+type indexEntry struct {
+	index *bolt.Bucket
+	key   []byte
+}
+
+// entries returns the index entries of a receipt.
+func (b *buckets) entries(rec *receipt.Receipt) []indexEntry {
+	return []indexEntry{
+		{b.byCreated, timeKey(rec.CreatedAt, rec.Id)},
+		{b.byRetailer, retailerKey(rec.Retailer, rec.Id)},
+		{b.byPurchase, timeKey(rec.PurchaseDateTime, rec.Id)},
+		{b.byTotal, intKey(cents(rec.Total), rec.Id)},
+		{b.byPoints, intKey(rec.Points, rec.Id)},
+	}
+}
+
+// get returns the receipt stored under id, or nil if there is none.
+func (b *buckets) get(id uuid.UUID) (*receipt.Receipt, error) {
+	data := b.receipts.Get(id[:])
+	if data == nil {
+		return nil, nil
+	}
+	rec, err := decodeReceipt(data)
+	if err != nil {
+		return nil, fmt.Errorf("receipt %s: %w", id, err)
+	}
+	return rec, nil
+}
+
+// put stores the encoded receipt and its index entries.
+func (b *buckets) put(rec *receipt.Receipt, data []byte) error {
+	if err := b.receipts.Put(rec.Id[:], data); err != nil {
+		return err
+	}
+	for _, entry := range b.entries(rec) {
+		if err := entry.index.Put(entry.key, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lookup returns the receipt stored under id, or an error matching
+// receipt.ErrNotFound if there is none.
+func (b *buckets) lookup(id string) (*receipt.Receipt, error) {
+	key, err := uuid.Parse(id)
+	if err != nil {
+		return nil, fmt.Errorf("receipt %s: %w", id, receipt.ErrNotFound)
+	}
+	rec, err := b.get(key)
+	if err != nil {
+		return nil, err
+	}
+	if rec == nil {
+		return nil, fmt.Errorf("receipt %s: %w", id, receipt.ErrNotFound)
+	}
+	return rec, nil
+}
+
+// remove deletes the receipt stored under id and its index entries.
+func (b *buckets) remove(id string) error {
+	rec, err := b.lookup(id)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range b.entries(rec) {
+		if err := entry.index.Delete(entry.key); err != nil {
+			return err
+		}
+	}
+	return b.receipts.Delete(rec.Id[:])
+}
+
+// keyRange is the keys of an index from from, inclusive, to to, exclusive.
+// A nil end is open.
+type keyRange struct {
+	from, to []byte
+}
+
+// scan calls fn with the keys of index in the range, in order or, if
+// descending, in reverse, until fn returns false.
+func (r keyRange) scan(index *bolt.Bucket, descending bool, fn func(key []byte) bool) {
+	c := index.Cursor()
+	if descending {
+		k, _ := c.Last()
+		if r.to != nil {
+			// Seek finds the first key at or past the end; the one before it
+			// is the last in the range.
+			if k, _ = c.Seek(r.to); k == nil {
+				k, _ = c.Last()
+			} else {
+				k, _ = c.Prev()
+			}
+		}
+		for ; k != nil && (r.from == nil || bytes.Compare(k, r.from) >= 0); k, _ = c.Prev() {
+			if !fn(k) {
+				return
+			}
+		}
+		return
+	}
+
+	k, _ := c.First()
+	if r.from != nil {
+		k, _ = c.Seek(r.from)
+	}
+	for ; k != nil && (r.to == nil || bytes.Compare(k, r.to) < 0); k, _ = c.Next() {
+		if !fn(k) {
+			return
+		}
+	}
+}
+
+// retailerKey is the retailer, a NUL byte and the receipt ID.
+func retailerKey(retailer string, id uuid.UUID) []byte {
+	key := make([]byte, 0, len(retailer)+1+len(id))
+	key = append(key, retailer...)
+	key = append(key, 0)
+	return append(key, id[:]...)
+}
+
+// timeKeySize is the length of a timeKey before the receipt ID.
+const timeKeySize = 12
+
+// timeKey is an instant, as its seconds since the Unix epoch the way
+// intKey writes them and then its nanoseconds big endian, and the receipt
+// ID. Unlike nanoseconds since the epoch, which run out in 2262, it holds
+// any instant a receipt can have.
+func timeKey(t time.Time, id uuid.UUID) []byte {
+	key := binary.BigEndian.AppendUint64(make([]byte, 0, timeKeySize+len(id)), uint64(t.Unix())^1<<63)
+	key = binary.BigEndian.AppendUint32(key, uint32(t.Nanosecond()))
+	return append(key, id[:]...)
+}
+
+// intKey is a number, big endian with the sign bit flipped so that the
+// keys sort in numeric order, and the receipt ID.
+func intKey(n int64, id uuid.UUID) []byte {
+	key := binary.BigEndian.AppendUint64(make([]byte, 0, 8+len(id)), uint64(n)^1<<63)
+	return append(key, id[:]...)
+}
+
+// intKeyAbove returns the first key past those of n, or nil if there are
+// none.
+func intKeyAbove(n int64) []byte {
+	if n == math.MaxInt64 {
+		return nil
+	}
+	return intKey(n+1, uuid.Nil)[:8]
+}
+
+// cents returns a total in cents. Totals are validated to have whole
+// cents, so the index orders them as their values do.
+func cents(total decimal.Decimal) int64 {
+	return total.Shift(2).IntPart()
+}
+
+// wrapError reports storage errors as receipt.ErrUnavailable and passes
+// domain errors through.
+func wrapError(err error) error {
+	if err == nil || errors.Is(err, receipt.ErrNotFound) || errors.Is(err, receipt.ErrConflict) {
+		return err
+	}
+	return fmt.Errorf("%w: %w", receipt.ErrUnavailable, err)
+}
@@ -0,0 +1,314 @@
+package memdb
+
+import (
+	"slices"
+	"sort"
+)
+
+// btreeDegree is the minimum number of children of an inner node other
+// than the root. Nodes hold between btreeDegree-1 and 2*btreeDegree-1
+// items.
+const btreeDegree = 32
+
+const (
+	btreeMinItems = btreeDegree - 1
+	btreeMaxItems = 2*btreeDegree - 1
+)
+
+// btree is a B-tree of distinct items that counts the items under each
+// node, so that the rank of an item, and the items between two ranks, are
+// found in logarithmic time. It is not safe for concurrent use.
+type btree[T any] struct {
+	compare func(a, b T) int
+	root    *bnode[T]
+}
+
+type bnode[T any] struct {
+	items    []T
+	children []*bnode[T]
+	// size is the number of items in the subtree.
+	size int
+}
+
+func newBtree[T any](compare func(a, b T) int) *btree[T] {
+	return &btree[T]{compare: compare}
+}
+
+// len returns the number of items in the tree.
+func (t *btree[T]) len() int {
+	if t.root == nil {
+		return 0
+	}
+	return t.root.size
+}
+
+// insert adds item to the tree, or replaces the item equal to it.
+func (t *btree[T]) insert(item T) {
+	if t.root == nil {
+		t.root = &bnode[T]{items: []T{item}, size: 1}
+		return
+	}
+	if len(t.root.items) >= btreeMaxItems {
+		left := t.root
+		mid, right := left.split(btreeMaxItems / 2)
+		t.root = &bnode[T]{
+			items:    []T{mid},
+			children: []*bnode[T]{left, right},
+			size:     left.size + right.size + 1,
+		}
+	}
+	t.root.insert(item, t.compare)
+}
+
+// delete removes the item equal to item, and reports whether there was
+// one.
+func (t *btree[T]) delete(item T) bool {
+	if t.root == nil {
+		return false
+	}
+	removed := t.root.remove(item, t.compare)
+	if len(t.root.items) == 0 {
+		if len(t.root.children) > 0 {
+			t.root = t.root.children[0]
+		} else {
+			t.root = nil
+		}
+	}
+	return removed
+}
+
+// rank returns the number of items before returns true for, which must
+// be true for every item up to some point in the tree's order and false
+// after it.
+func (t *btree[T]) rank(before func(item T) bool) int {
+	rank := 0
+	for n := t.root; n != nil; {
+		i := sort.Search(len(n.items), func(j int) bool { return !before(n.items[j]) })
+		rank += i
+		if len(n.children) == 0 {
+			break
+		}
+		for _, child := range n.children[:i] {
+			rank += child.size
+		}
+		n = n.children[i]
+	}
+	return rank
+}
+
+// ascend calls fn with the items ranked from start up to but not
+// including end, in order, until fn returns false.
+func (t *btree[T]) ascend(start, end int, fn func(item T) bool) {
+	if t.root != nil && start < end {
+		t.root.ascend(start, end, fn)
+	}
+}
+
+// descend calls fn with the items ranked from start up to but not
+// including end, in reverse order, until fn returns false.
+func (t *btree[T]) descend(start, end int, fn func(item T) bool) {
+	if t.root != nil && start < end {
+		t.root.descend(start, end, fn)
+	}
+}
+
+// find returns where item is or would be among the node's items.
+func (n *bnode[T]) find(item T, compare func(a, b T) int) (int, bool) {
+	i := sort.Search(len(n.items), func(j int) bool { return compare(n.items[j], item) >= 0 })
+	return i, i < len(n.items) && compare(n.items[i], item) == 0
+}
+
+// split moves the items after the ith, and the children after them, to a
+// new node, and returns the ith item and the new node.
+func (n *bnode[T]) split(i int) (T, *bnode[T]) {
+	item := n.items[i]
+	right := &bnode[T]{items: append([]T(nil), n.items[i+1:]...)}
+	clear(n.items[i:])
+	n.items = n.items[:i]
+	if len(n.children) > 0 {
+		right.children = append([]*bnode[T](nil), n.children[i+1:]...)
+		clear(n.children[i+1:])
+		n.children = n.children[:i+1]
+	}
+	n.size = n.count()
+	right.size = right.count()
+	return item, right
+}
+
+// count returns the number of items in the subtree from the sizes of the
+// node's children.
+func (n *bnode[T]) count() int {
+	size := len(n.items)
+	for _, child := range n.children {
+		size += child.size
+	}
+	return size
+}
+
+// insert adds item to the subtree of a node that is not full, and reports
+// whether it was added rather than replacing an equal one.
+func (n *bnode[T]) insert(item T, compare func(a, b T) int) bool {
+	i, found := n.find(item, compare)
+	if found {
+		n.items[i] = item
+		return false
+	}
+	if len(n.children) == 0 {
+		n.items = slices.Insert(n.items, i, item)
+		n.size++
+		return true
+	}
+
+	if len(n.children[i].items) >= btreeMaxItems {
+		mid, right := n.children[i].split(btreeMaxItems / 2)
+		n.items = slices.Insert(n.items, i, mid)
+		n.children = slices.Insert(n.children, i+1, right)
+		switch c := compare(item, mid); {
+		case c > 0:
+			i++
+		case c == 0:
+			n.items[i] = item
+			return false
+		}
+	}
+	added := n.children[i].insert(item, compare)
+	if added {
+		n.size++
+	}
+	return added
+}
+
+// remove removes the item equal to item from the subtree, and reports
+// whether there was one. The node has more than the minimum number of
+// items, unless it is the root.
+func (n *bnode[T]) remove(item T, compare func(a, b T) int) bool {
+	i, found := n.find(item, compare)
+	if len(n.children) == 0 {
+		if !found {
+			return false
+		}
+		n.items = slices.Delete(n.items, i, i+1)
+		n.size--
+		return true
+	}
+
+	// Make sure the child to descend into can give up an item.
+	if len(n.children[i].items) <= btreeMinItems {
+		n.grow(i)
+		return n.remove(item, compare)
+	}
+
+	child := n.children[i]
+	if found {
+		// Replace the item with the greatest one before it, which is
+		// the last of the child's subtree.
+		last := child
+		for len(last.children) > 0 {
+			last = last.children[len(last.children)-1]
+		}
+		n.items[i] = last.items[len(last.items)-1]
+		child.remove(n.items[i], compare)
+		n.size--
+		return true
+	}
+	if child.remove(item, compare) {
+		n.size--
+		return true
+	}
+	return false
+}
+
+// grow gives the ith child, which has the minimum number of items, one
+// more, by taking one from a sibling through the node, or else by merging
+// it with a sibling.
+func (n *bnode[T]) grow(i int) {
+	switch {
+	case i > 0 && len(n.children[i-1].items) > btreeMinItems:
+		child, left := n.children[i], n.children[i-1]
+		last := len(left.items) - 1
+		child.items = slices.Insert(child.items, 0, n.items[i-1])
+		n.items[i-1] = left.items[last]
+		left.items = slices.Delete(left.items, last, last+1)
+		moved := 1
+		if len(left.children) > 0 {
+			lastChild := left.children[last+1]
+			left.children = slices.Delete(left.children, last+1, last+2)
+			child.children = slices.Insert(child.children, 0, lastChild)
+			moved += lastChild.size
+		}
+		left.size -= moved
+		child.size += moved
+
+	case i < len(n.items) && len(n.children[i+1].items) > btreeMinItems:
+		child, right := n.children[i], n.children[i+1]
+		child.items = append(child.items, n.items[i])
+		n.items[i] = right.items[0]
+		right.items = slices.Delete(right.items, 0, 1)
+		moved := 1
+		if len(right.children) > 0 {
+			firstChild := right.children[0]
+			right.children = slices.Delete(right.children, 0, 1)
+			child.children = append(child.children, firstChild)
+			moved += firstChild.size
+		}
+		right.size -= moved
+		child.size += moved
+
+	default:
+		if i >= len(n.items) {
+			i--
+		}
+		child, right := n.children[i], n.children[i+1]
+		child.items = append(child.items, n.items[i])
+		child.items = append(child.items, right.items...)
+		child.children = append(child.children, right.children...)
+		child.size += 1 + right.size
+		n.items = slices.Delete(n.items, i, i+1)
+		n.children = slices.Delete(n.children, i+1, i+2)
+	}
+}
+
+// ascend calls fn with the items of the subtree ranked from start up to
+// end within it, in order, and reports false once fn has returned false.
+func (n *bnode[T]) ascend(start, end int, fn func(item T) bool) bool {
+	rank := 0
+	for i := 0; i <= len(n.items) && rank < end; i++ {
+		if len(n.children) > 0 {
+			child := n.children[i]
+			if start < rank+child.size && !child.ascend(start-rank, end-rank, fn) {
+				return false
+			}
+			rank += child.size
+		}
+		if i < len(n.items) {
+			if rank >= start && rank < end && !fn(n.items[i]) {
+				return false
+			}
+			rank++
+		}
+	}
+	return true
+}
+
+// descend calls fn with the items of the subtree ranked from start up to
+// end within it, in reverse order, and reports false once fn has returned
+// false.
+func (n *bnode[T]) descend(start, end int, fn func(item T) bool) bool {
+	rank := n.size
+	for i := len(n.items); i >= 0 && rank > start; i-- {
+		if len(n.children) > 0 {
+			child := n.children[i]
+			rank -= child.size
+			if end > rank && !child.descend(start-rank, end-rank, fn) {
+				return false
+			}
+		}
+		if i > 0 {
+			rank--
+			if rank >= start && rank < end && !fn(n.items[i-1]) {
+				return false
+			}
+		}
+	}
+	return true
+}
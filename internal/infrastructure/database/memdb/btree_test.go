@@ -0,0 +1,126 @@
+package memdb
+
+import (
+	"cmp"
+	"math/rand/v2"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+// TestBtree inserts and deletes enough items in random order to split and
+// merge nodes at several levels, and checks the tree against a sorted
+// slice after each round.
+func TestBtree(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	tree := newBtree(cmp.Compare[int])
+	var want []int
+
+	check := func(t *testing.T) {
+		t.Helper()
+		if tree.len() != len(want) {
+			t.Fatalf("len() = %d, want %d", tree.len(), len(want))
+		}
+		if tree.root != nil {
+			checkNode(t, tree.root, true)
+		}
+		for _, target := range []int{-1, 0, 5000, 9999, 10000} {
+			wantRank, _ := slices.BinarySearch(want, target)
+			if got := tree.rank(func(item int) bool { return item < target }); got != wantRank {
+				t.Errorf("rank(< %d) = %d, want %d", target, got, wantRank)
+			}
+		}
+
+		start, end := len(want)/5, len(want)*4/5
+		var got []int
+		tree.ascend(start, end, func(item int) bool {
+			got = append(got, item)
+			return true
+		})
+		if !slices.Equal(got, want[start:end]) {
+			t.Errorf("ascend(%d, %d) = %v, want %v", start, end, got, want[start:end])
+		}
+
+		got = nil
+		tree.descend(start, end, func(item int) bool {
+			got = append(got, item)
+			return len(got) < 10
+		})
+		reversed := slices.Clone(want[max(start, end-10):end])
+		slices.Reverse(reversed)
+		if len(reversed) == 0 {
+			reversed = nil
+		}
+		if !reflect.DeepEqual(got, reversed) {
+			t.Errorf("descend(%d, %d) stopped after 10 = %v, want %v", start, end, got, reversed)
+		}
+	}
+
+	for round := range 6 {
+		// Grow the tree in the first rounds and shrink it in the last.
+		inserts, deletes := 4000, 1000
+		if round >= 3 {
+			inserts, deletes = 500, 4000
+		}
+		for range inserts {
+			item := rng.IntN(10000)
+			tree.insert(item)
+			if at, found := slices.BinarySearch(want, item); !found {
+				want = slices.Insert(want, at, item)
+			}
+		}
+		for range deletes {
+			item := rng.IntN(10000)
+			at, found := slices.BinarySearch(want, item)
+			if got := tree.delete(item); got != found {
+				t.Fatalf("delete(%d) = %t, want %t", item, got, found)
+			}
+			if found {
+				want = slices.Delete(want, at, at+1)
+			}
+		}
+		check(t)
+	}
+
+	for len(want) > 0 {
+		if !tree.delete(want[0]) {
+			t.Fatalf("delete(%d) = false, want true", want[0])
+		}
+		want = want[1:]
+	}
+	check(t)
+	if tree.root != nil {
+		t.Errorf("root of an empty tree = %+v, want nil", tree.root)
+	}
+}
+
+// checkNode checks that the node's subtree is balanced, counted and no
+// fuller or emptier than a B-tree's nodes may be, and returns its height.
+func checkNode(t *testing.T, n *bnode[int], root bool) int {
+	t.Helper()
+	if len(n.items) > btreeMaxItems || !root && len(n.items) < btreeMinItems {
+		t.Fatalf("node holds %d items, want %d to %d", len(n.items), btreeMinItems, btreeMaxItems)
+	}
+	if !slices.IsSorted(n.items) {
+		t.Fatalf("node items %v are not sorted", n.items)
+	}
+	if len(n.children) == 0 {
+		if n.size != len(n.items) {
+			t.Fatalf("leaf size = %d, want %d", n.size, len(n.items))
+		}
+		return 1
+	}
+	if len(n.children) != len(n.items)+1 {
+		t.Fatalf("node with %d items has %d children", len(n.items), len(n.children))
+	}
+	height := checkNode(t, n.children[0], false)
+	for _, child := range n.children[1:] {
+		if h := checkNode(t, child, false); h != height {
+			t.Fatalf("children of heights %d and %d", height, h)
+		}
+	}
+	if n.size != n.count() {
+		t.Fatalf("node size = %d, want %d", n.size, n.count())
+	}
+	return height + 1
+}
@@ -0,0 +1,168 @@
+package memdb
+
+import "cmp"
+
+// indexer is the part of an Index the DB keeps up to date. Its methods are
+// called with the DB's mu held.
+type indexer[K Key, V any] interface {
+	add(key K, value V)
+	remove(key K)
+}
+
+// Index is a secondary index of a DB: the values ordered by an index key
+// derived from each value, and then by their key. It is kept up to date by
+// Set, Delete and replay, and can be queried for an exact index key or a
+// range of them. The entries are kept in a counted B-tree, so a write
+// updates it, and a range is found and counted, in logarithmic time.
+type Index[K Key, V any, I any] struct {
+	db      *DB[K, V]
+	key     func(V) (I, bool)
+	compare func(a, b I) int
+	entries *btree[indexEntry[K, I]]
+	// indexed holds the index key each value was indexed under, since a
+	// value may have been changed in place by the time it is replaced.
+	indexed map[K]I
+}
+
+type indexEntry[K Key, I any] struct {
+	index I
+	key   K
+}
+
+// NewIndex declares an index of the values of db. key derives the index
+// key of a value, or reports false to leave the value out of the index,
+// and compare orders index keys, as cmp.Compare and time.Time.Compare do.
+// Values already in db are indexed straight away.
+func NewIndex[K Key, V any, I any](db *DB[K, V], key func(V) (I, bool), compare func(a, b I) int) *Index[K, V, I] {
+	index := &Index[K, V, I]{
+		db:      db,
+		key:     key,
+		compare: compare,
+		indexed: make(map[K]I),
+	}
+	index.entries = newBtree(index.compareEntries)
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for k, value := range db.store {
+		index.add(k, value)
+	}
+	db.indexes = append(db.indexes, index)
+	return index
+}
+
+// Position is where a Scan resumes: just past the value stored under Key,
+// indexed under Index.
+type Position[K Key, I any] struct {
+	Index I
+	Key   K
+}
+
+// Equal returns the values whose index key equals value, ordered by key.
+func (x *Index[K, V, I]) Equal(value I) []V {
+	return x.Range(&value, &value, true)
+}
+
+// Range returns the values whose index key is at least from and, if
+// inclusive, at most to, or otherwise less than to, in index order. Nil
+// bounds are open.
+func (x *Index[K, V, I]) Range(from, to *I, inclusive bool) []V {
+	var values []V
+	x.Scan(from, to, inclusive, nil, false, func(_ K, value V) bool {
+		values = append(values, value)
+		return true
+	})
+	return values
+}
+
+// Scan calls fn with the values in the range Range takes, in index order
+// or, if descending, in reverse, until fn returns false. A non-nil after
+// skips the values up to and including it in the order of the scan, so
+// that a scan can resume where the last one stopped. The DB is read-locked
+// for the duration of the call, so fn must not write to it.
+func (x *Index[K, V, I]) Scan(from, to *I, inclusive bool, after *Position[K, I], descending bool, fn func(key K, value V) bool) {
+	x.db.mu.RLock()
+	defer x.db.mu.RUnlock()
+
+	start, end := x.bounds(from, to, inclusive)
+	if after != nil {
+		position := indexEntry[K, I]{index: after.Index, key: after.Key}
+		if descending {
+			end = min(end, x.entries.rank(func(e indexEntry[K, I]) bool {
+				return x.compareEntries(e, position) < 0
+			}))
+		} else {
+			start = max(start, x.entries.rank(func(e indexEntry[K, I]) bool {
+				return x.compareEntries(e, position) <= 0
+			}))
+		}
+	}
+
+	visit := func(e indexEntry[K, I]) bool {
+		return fn(e.key, x.db.store[e.key])
+	}
+	if descending {
+		x.entries.descend(start, end, visit)
+	} else {
+		x.entries.ascend(start, end, visit)
+	}
+}
+
+// Count returns the number of values in the range Range takes, without
+// reading them.
+func (x *Index[K, V, I]) Count(from, to *I, inclusive bool) int {
+	x.db.mu.RLock()
+	defer x.db.mu.RUnlock()
+	start, end := x.bounds(from, to, inclusive)
+	return end - start
+}
+
+// bounds returns the entries in the range Range takes, as the rank of
+// the first and one past the last. The caller must hold the DB's mu.
+func (x *Index[K, V, I]) bounds(from, to *I, inclusive bool) (start, end int) {
+	if from != nil {
+		start = x.entries.rank(func(e indexEntry[K, I]) bool {
+			return x.compare(e.index, *from) < 0
+		})
+	}
+	end = x.entries.len()
+	if to != nil {
+		end = x.entries.rank(func(e indexEntry[K, I]) bool {
+			c := x.compare(e.index, *to)
+			return c < 0 || c == 0 && inclusive
+		})
+	}
+	return start, max(start, end)
+}
+
+// Len returns the number of values in the index.
+func (x *Index[K, V, I]) Len() int {
+	x.db.mu.RLock()
+	defer x.db.mu.RUnlock()
+	return x.entries.len()
+}
+
+func (x *Index[K, V, I]) add(key K, value V) {
+	i, ok := x.key(value)
+	if !ok {
+		return
+	}
+	x.entries.insert(indexEntry[K, I]{index: i, key: key})
+	x.indexed[key] = i
+}
+
+func (x *Index[K, V, I]) remove(key K) {
+	i, ok := x.indexed[key]
+	if !ok {
+		return
+	}
+	delete(x.indexed, key)
+	x.entries.delete(indexEntry[K, I]{index: i, key: key})
+}
+
+func (x *Index[K, V, I]) compareEntries(a, b indexEntry[K, I]) int {
+	if c := x.compare(a.index, b.index); c != 0 {
+		return c
+	}
+	return cmp.Compare(a.key, b.key)
+}
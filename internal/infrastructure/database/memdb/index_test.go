@@ -0,0 +1,129 @@
+package memdb
+
+import (
+	"cmp"
+	"reflect"
+	"testing"
+)
+
+type item struct {
+	Name  string
+	Price int
+}
+
+func TestIndex(t *testing.T) {
+	dir := t.TempDir()
+	db, err := New[string, *item](WithPersistence(dir, JSONCodec[*item]{}))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	for _, it := range []*item{{"a", 5}, {"b", 1}, {"c", 5}, {"d", 9}, {"e", 3}} {
+		if err := db.Set(it.Name, it); err != nil {
+			t.Fatalf("Set() error = %v", err)
+		}
+	}
+	// Changing a value in place and then setting it moves it in the index.
+	a, _ := db.Get("a")
+	a.Price = 2
+	if err := db.Set("a", a); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if err := db.Delete("d"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	// The index is built from the replayed log, and from values set after
+	// it is declared.
+	db, err = New[string, *item](WithPersistence(dir, JSONCodec[*item]{}))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer db.Close()
+	byPrice := NewIndex(db, func(it *item) (int, bool) {
+		return it.Price, it.Price > 0
+	}, cmp.Compare[int])
+	if err := db.Set("f", &item{"f", 5}); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if err := db.Set("free", &item{"free", 0}); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+
+	ptr := func(i int) *int { return &i }
+	tests := []struct {
+		name      string
+		from, to  *int
+		inclusive bool
+		want      []string
+	}{
+		{"all", nil, nil, false, []string{"b", "a", "e", "c", "f"}},
+		{"from", ptr(3), nil, false, []string{"e", "c", "f"}},
+		{"to exclusive", nil, ptr(5), false, []string{"b", "a", "e"}},
+		{"to inclusive", nil, ptr(5), true, []string{"b", "a", "e", "c", "f"}},
+		{"between", ptr(2), ptr(3), true, []string{"a", "e"}},
+		{"empty", ptr(6), ptr(9), true, nil},
+		{"reversed", ptr(5), ptr(1), true, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := names(byPrice.Range(tt.from, tt.to, tt.inclusive)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Range() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	for _, tt := range tests {
+		if got := byPrice.Count(tt.from, tt.to, tt.inclusive); got != len(tt.want) {
+			t.Errorf("Count() of %s = %d, want %d", tt.name, got, len(tt.want))
+		}
+	}
+
+	scans := []struct {
+		name       string
+		from, to   *int
+		after      *Position[string, int]
+		descending bool
+		limit      int
+		want       []string
+	}{
+		{"all", nil, nil, nil, false, 0, []string{"b", "a", "e", "c", "f"}},
+		{"descending", nil, nil, nil, true, 0, []string{"f", "c", "e", "a", "b"}},
+		{"stopped", nil, nil, nil, false, 2, []string{"b", "a"}},
+		{"after", nil, nil, &Position[string, int]{5, "c"}, false, 0, []string{"f"}},
+		{"after descending", nil, nil, &Position[string, int]{5, "c"}, true, 0, []string{"e", "a", "b"}},
+		{"after a value gone", nil, nil, &Position[string, int]{4, "d"}, false, 0, []string{"c", "f"}},
+		{"after before the range", ptr(3), nil, &Position[string, int]{1, "b"}, false, 0, []string{"e", "c", "f"}},
+		{"after in the range", ptr(3), ptr(5), &Position[string, int]{3, "e"}, false, 0, []string{"c", "f"}},
+		{"after past the range descending", nil, ptr(3), &Position[string, int]{9, "d"}, true, 0, []string{"e", "a", "b"}},
+	}
+	for _, tt := range scans {
+		t.Run("scan "+tt.name, func(t *testing.T) {
+			var got []string
+			byPrice.Scan(tt.from, tt.to, true, tt.after, tt.descending, func(key string, it *item) bool {
+				got = append(got, key)
+				return tt.limit == 0 || len(got) < tt.limit
+			})
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Scan() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if got, want := names(byPrice.Equal(5)), []string{"c", "f"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Equal() = %v, want %v", got, want)
+	}
+	if got := byPrice.Len(); got != 5 {
+		t.Errorf("Len() = %d, want 5", got)
+	}
+}
+
+func names(items []*item) []string {
+	var names []string
+	for _, it := range items {
+		names = append(names, it.Name)
+	}
+	return names
+}
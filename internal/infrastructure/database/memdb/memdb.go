@@ -1,40 +1,240 @@
 package memdb
 
 import (
+	"cmp"
+	"errors"
+	"fmt"
+	"os"
 	"sync"
+	"time"
 )
 
-type DB struct {
+var (
+	// ErrClosed is returned by the writes to a closed DB.
+	ErrClosed = errors.New("memdb: closed")
+	// ErrTxDone is returned by the writes and Commit of a transaction
+	// already committed or rolled back.
+	ErrTxDone = errors.New("memdb: transaction already committed or rolled back")
+)
+
+// Key constrains the keys of a DB to types that sort, which persistent
+// DBs can also write out and read back.
+type Key interface {
+	cmp.Ordered
+}
+
+// DB is a map of keys to values held in memory, with the secondary indexes
+// declared on it kept up to date. Every value has a version, which starts
+// at 1 and grows with every write of its key, deletes included. Rather
+// than remember the version of every deleted key, the DB keeps the highest
+// version a delete left, and a key without a value has that version, so
+// that a value stored under it again does not take a version an earlier
+// value had. A DB opened with WithPersistence
+// also writes every change to an append-only log, and replays its last
+// snapshot and the log when it is opened again.
+type DB[K Key, V any] struct {
 	mu    sync.RWMutex
-	store map[string]interface{}
+	store map[K]V
+	// versions holds the version of every stored value.
+	versions map[K]uint64
+	// deleted is the highest version a delete has left a key at.
+	deleted uint64
+	indexes []indexer[K, V]
+	closed  bool
+
+	// The fields below are only set for a persistent DB.
+	options options
+	codec   Codec[V]
+	log     *os.File
+	// size is the length of the log's whole records.
+	size int64
+	// dirty is set when the log has writes that are not synced.
+	dirty bool
+	// records counts the log's records since the last snapshot.
+	records  int
+	recovery Recovery
+	stopSync chan struct{}
+	syncDone chan struct{}
+}
+
+type options struct {
+	dir string
+	// codec is a Codec of the DB's values.
+	codec         any
+	sync          SyncMode
+	syncInterval  time.Duration
+	snapshotEvery int
 }
 
-func New() *DB {
-	return &DB{
-		store: make(map[string]interface{}),
+type Option func(*options)
+
+// WithPersistence keeps the DB in dir, encoding its values with codec.
+// Every DB needs a directory of its own.
+func WithPersistence[V any](dir string, codec Codec[V]) Option {
+	return func(o *options) {
+		o.dir = dir
+		o.codec = codec
 	}
 }
 
-func (d *DB) Get(key string) (interface{}, error) {
+// WithSync sets when a persistent DB syncs its log to disk. The interval
+// only applies to SyncPeriodically. The default is SyncEveryWrite.
+func WithSync(mode SyncMode, interval time.Duration) Option {
+	return func(o *options) {
+		o.sync = mode
+		o.syncInterval = interval
+	}
+}
+
+// WithSnapshotEvery makes a persistent DB write a snapshot, and start a new
+// log, once the log holds this many records. 0 leaves it to Snapshot. The
+// default is DefaultSnapshotEvery.
+func WithSnapshotEvery(records int) Option {
+	return func(o *options) {
+		o.snapshotEvery = records
+	}
+}
+
+// New returns an empty DB, or with WithPersistence, the DB stored in its
+// directory.
+func New[K Key, V any](opts ...Option) (*DB[K, V], error) {
+	d := &DB[K, V]{
+		store:    make(map[K]V),
+		versions: make(map[K]uint64),
+		options: options{
+			sync:          SyncEveryWrite,
+			syncInterval:  DefaultSyncInterval,
+			snapshotEvery: DefaultSnapshotEvery,
+		},
+	}
+	for _, opt := range opts {
+		opt(&d.options)
+	}
+	if d.options.dir == "" {
+		return d, nil
+	}
+
+	codec, ok := d.options.codec.(Codec[V])
+	if !ok {
+		var value V
+		return nil, fmt.Errorf("memdb: persistence needs a codec of %T values, got %T", value, d.options.codec)
+	}
+	d.codec = codec
+	if err := d.open(); err != nil {
+		return nil, fmt.Errorf("memdb %s: %w", d.options.dir, err)
+	}
+	return d, nil
+}
+
+// Get returns the value stored under key, and whether there is one.
+func (d *DB[K, V]) Get(key K) (V, bool) {
+	value, _, ok := d.GetVersion(key)
+	return value, ok
+}
+
+// GetVersion returns the value stored under key and its version. A key
+// without a value has the highest version a delete has left any key at,
+// or 0 if nothing has been deleted.
+func (d *DB[K, V]) GetVersion(key K) (V, uint64, bool) {
 	d.mu.RLock()
 	defer d.mu.RUnlock()
-	return d.store[key], nil
+	value, ok := d.store[key]
+	return value, d.version(key), ok
 }
 
-func (d *DB) Set(key string, value interface{}) error {
-	d.mu.Lock()
-	defer d.mu.Unlock()
+// Set stores a value under key, whatever its version.
+func (d *DB[K, V]) Set(key K, value V) error {
+	return d.Update(func(tx *Tx[K, V]) error {
+		return tx.Set(key, value)
+	})
+}
+
+// Delete deletes the value stored under key, if there is one.
+func (d *DB[K, V]) Delete(key K) error {
+	return d.Update(func(tx *Tx[K, V]) error {
+		return tx.Delete(key)
+	})
+}
+
+// Len returns the number of stored values.
+func (d *DB[K, V]) Len() int {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return len(d.store)
+}
+
+// ForEach calls fn for every stored key and value, in no particular order,
+// until fn returns false. The store is read-locked for the duration of the
+// call, so fn must not write to the DB.
+func (d *DB[K, V]) ForEach(fn func(key K, value V) bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	for key, value := range d.store {
+		if !fn(key, value) {
+			break
+		}
+	}
+}
+
+// set stores a value at a version and updates the indexes. The caller must
+// hold mu.
+func (d *DB[K, V]) set(key K, value V, version uint64) {
+	for _, index := range d.indexes {
+		index.remove(key)
+	}
 	d.store[key] = value
-	return nil
+	d.versions[key] = version
+	for _, index := range d.indexes {
+		index.add(key, value)
+	}
 }
 
-func (d *DB) Delete(key string) error {
-	d.mu.Lock()
-	defer d.mu.Unlock()
+// delete removes a value and its index entries, leaving its key at a
+// version. The caller must hold mu.
+func (d *DB[K, V]) delete(key K, version uint64) {
+	for _, index := range d.indexes {
+		index.remove(key)
+	}
 	delete(d.store, key)
-	return nil
+	delete(d.versions, key)
+	d.deleted = max(d.deleted, version)
+}
+
+// version returns the version of key. The caller must hold mu.
+func (d *DB[K, V]) version(key K) uint64 {
+	if version, ok := d.versions[key]; ok {
+		return version
+	}
+	return d.deleted
 }
 
-func (d *DB) Close() error {
-	return nil
+// Close syncs and seals the log of a persistent DB. Writes to a closed DB
+// fail with ErrClosed; reads still work.
+func (d *DB[K, V]) Close() error {
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return nil
+	}
+	d.closed = true
+	d.mu.Unlock()
+
+	if d.log == nil {
+		return nil
+	}
+	if d.stopSync != nil {
+		close(d.stopSync)
+		<-d.syncDone
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	err := d.append(encodeRecord(opSeal, "", nil))
+	if syncErr := d.log.Sync(); err == nil {
+		err = syncErr
+	}
+	if closeErr := d.log.Close(); err == nil {
+		err = closeErr
+	}
+	return err
 }
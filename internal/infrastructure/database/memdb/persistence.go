@@ -0,0 +1,561 @@
+package memdb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// A persistent DB keeps two files in its directory: a snapshot of every
+// key and value, and a log of the changes since. Both are sequences of
+// records:
+//
+//	length  uint32, big endian, of the payload
+//	crc     uint32, big endian, CRC-32C of the payload
+//	payload op byte, key length uvarint, key, value
+//
+// The value of a put record starts with the version of the key as a
+// uvarint, and the value of a delete record is the version it leaves the
+// key at. A snapshot holds put records only, and keeps the highest version
+// deleted keys were left at in its end record. The value of a transaction
+// record is the records of its writes, one after
+// another, so that a crash keeps all of them or none.
+//
+// A change is appended to the log before it is applied in memory. A crash
+// may leave the last record of the log torn; opening the DB truncates the
+// log at the first record that is incomplete or fails its checksum, so
+// that the writes before it survive. A snapshot is written to a temporary
+// file and renamed into place, so it is either whole or missing, and ends
+// with a record counting its entries.
+
+const (
+	logFile      = "log"
+	snapshotFile = "snapshot"
+
+	logMagic      = "MDBLOG01"
+	snapshotMagic = "MDBSNP01"
+
+	recordHeaderSize = 8
+	// maxRecordSize bounds the payload of a record, so that a corrupt
+	// length cannot make the DB allocate without bound.
+	maxRecordSize = 64 << 20
+
+	// DefaultSnapshotEvery is how many log records a persistent DB writes
+	// before it snapshots.
+	DefaultSnapshotEvery = 10000
+	// DefaultSyncInterval is how often SyncPeriodically syncs.
+	DefaultSyncInterval = time.Second
+)
+
+type op byte
+
+// The values are stored on disk, so an op keeps its value once it has one.
+const (
+	opDelete op = 2
+	// opSeal ends the log of a DB closed cleanly.
+	opSeal op = 3
+	// opEnd ends a snapshot; its value is the number of records before it
+	// and the highest version deleted keys were left at, as uvarints.
+	opEnd op = 4
+	opPut op = 5
+	opTx  op = 6
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// errTorn marks a record that was not completely written.
+var errTorn = errors.New("torn record")
+
+// SyncMode is when a persistent DB syncs its log to disk. Every write
+// reaches the operating system before it returns, so only a crash of the
+// machine, not of the process, can lose the writes not yet synced.
+type SyncMode int
+
+const (
+	// SyncEveryWrite syncs before every write returns.
+	SyncEveryWrite SyncMode = iota
+	// SyncPeriodically syncs at an interval, losing at most an interval of
+	// writes.
+	SyncPeriodically
+	// SyncNever leaves syncing to the operating system.
+	SyncNever
+)
+
+// ParseSyncMode parses "always", "periodic" or "never".
+func ParseSyncMode(name string) (SyncMode, error) {
+	switch name {
+	case "always":
+		return SyncEveryWrite, nil
+	case "periodic":
+		return SyncPeriodically, nil
+	case "never":
+		return SyncNever, nil
+	}
+	return 0, fmt.Errorf("unknown sync mode %q, expected always, periodic or never", name)
+}
+
+// Recovery describes what opening a persistent DB found on disk.
+type Recovery struct {
+	// SnapshotEntries is the number of entries in the snapshot.
+	SnapshotEntries int
+	// LogRecords is the number of log records replayed on top of it.
+	LogRecords int
+	// TruncatedBytes is the size of the torn records cut off the log.
+	TruncatedBytes int64
+	// Sealed reports that the DB was closed cleanly.
+	Sealed bool
+}
+
+// Recovery returns what opening the DB found on disk.
+func (d *DB[K, V]) Recovery() Recovery {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.recovery
+}
+
+// open loads the snapshot and replays the log, then opens the log for
+// appending.
+func (d *DB[K, V]) open() error {
+	if err := os.MkdirAll(d.options.dir, 0o755); err != nil {
+		return err
+	}
+	if err := d.loadSnapshot(); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(d.path(logFile), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	if err := d.replay(f); err != nil {
+		f.Close()
+		return err
+	}
+	d.log = f
+
+	if d.options.sync == SyncPeriodically && d.options.syncInterval > 0 {
+		d.stopSync = make(chan struct{})
+		d.syncDone = make(chan struct{})
+		go d.syncPeriodically()
+	}
+	return nil
+}
+
+func (d *DB[K, V]) path(name string) string {
+	return filepath.Join(d.options.dir, name)
+}
+
+func (d *DB[K, V]) loadSnapshot() error {
+	f, err := os.Open(d.path(snapshotFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	if err := readMagic(r, snapshotMagic); err != nil {
+		return fmt.Errorf("snapshot: %w", err)
+	}
+	for records := 0; ; records++ {
+		record, _, err := readRecord(r)
+		if err != nil {
+			// The snapshot was renamed into place whole, so anything
+			// wrong with it is corruption rather than a crash.
+			return fmt.Errorf("snapshot: record %d: %w", records, err)
+		}
+		switch record.op {
+		case opEnd:
+			count, n := binary.Uvarint(record.value)
+			if n <= 0 || count != uint64(records) {
+				return fmt.Errorf("snapshot: has %d records, its end record says otherwise", records)
+			}
+			deleted, m := binary.Uvarint(record.value[n:])
+			if m <= 0 {
+				return fmt.Errorf("snapshot: end record: bad version")
+			}
+			d.deleted = deleted
+			return nil
+		case opPut:
+			d.recovery.SnapshotEntries++
+		default:
+			return fmt.Errorf("snapshot: record %d: unexpected record type %d", records, record.op)
+		}
+		if err := d.apply(record); err != nil {
+			return fmt.Errorf("snapshot: %w", err)
+		}
+	}
+}
+
+// replay applies the log's records and truncates the log after the last
+// whole one.
+func (d *DB[K, V]) replay(f *os.File) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() < int64(len(logMagic)) {
+		// The log was being created.
+		return d.resetLog(f)
+	}
+
+	r := bufio.NewReader(f)
+	offset := int64(len(logMagic))
+	if err := readMagic(r, logMagic); err != nil {
+		return fmt.Errorf("log: %w", err)
+	}
+
+	for {
+		record, size, err := readRecord(r)
+		if err == io.EOF {
+			break
+		}
+		if errors.Is(err, errTorn) {
+			d.recovery.TruncatedBytes = info.Size() - offset
+			if err := f.Truncate(offset); err != nil {
+				return err
+			}
+			if err := f.Sync(); err != nil {
+				return err
+			}
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		offset += size
+		d.recovery.LogRecords++
+		d.recovery.Sealed = record.op == opSeal
+		switch record.op {
+		case opPut, opDelete, opTx:
+			if err := d.apply(record); err != nil {
+				return fmt.Errorf("log: %w", err)
+			}
+			d.records++
+		case opSeal:
+		default:
+			return fmt.Errorf("log: record %d: unexpected record type %d", d.recovery.LogRecords, record.op)
+		}
+	}
+
+	d.size = offset
+	_, err = f.Seek(offset, io.SeekStart)
+	return err
+}
+
+func (d *DB[K, V]) resetLog(f *os.File) error {
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := f.WriteAt([]byte(logMagic), 0); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	d.size = int64(len(logMagic))
+	_, err := f.Seek(d.size, io.SeekStart)
+	return err
+}
+
+// apply applies a put, delete or transaction record to the store.
+func (d *DB[K, V]) apply(r record) error {
+	if r.op == opTx {
+		return d.applyTx(r.value)
+	}
+
+	key, err := parseKey[K](r.key)
+	if err != nil {
+		return err
+	}
+	if r.op != opPut && r.op != opDelete {
+		return fmt.Errorf("%s: unexpected record type %d", r.key, r.op)
+	}
+	version, n := binary.Uvarint(r.value)
+	if n <= 0 {
+		return fmt.Errorf("%s: bad version", r.key)
+	}
+	if r.op == opDelete {
+		d.delete(key, version)
+		return nil
+	}
+
+	value, err := d.codec.Decode(r.value[n:])
+	if err != nil {
+		return fmt.Errorf("decoding %s: %w", r.key, err)
+	}
+	d.set(key, value, version)
+	return nil
+}
+
+// applyTx applies the records of a transaction.
+func (d *DB[K, V]) applyTx(data []byte) error {
+	r := bytes.NewReader(data)
+	for {
+		record, _, err := readRecord(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			// The transaction record passed its checksum, so a bad
+			// record inside it is corruption rather than a crash.
+			return fmt.Errorf("transaction: %v", err)
+		}
+		if record.op == opTx {
+			return fmt.Errorf("transaction: nested transaction")
+		}
+		if err := d.apply(record); err != nil {
+			return fmt.Errorf("transaction: %w", err)
+		}
+	}
+}
+
+// appendRecords writes the records of a transaction to the log, as a
+// transaction record unless there is only one. The caller must hold mu.
+func (d *DB[K, V]) appendRecords(records [][]byte) error {
+	if len(records) == 1 {
+		return d.append(records[0])
+	}
+	return d.append(encodeRecord(opTx, "", bytes.Join(records, nil)))
+}
+
+// append writes an encoded record to the log, syncing it if the sync mode
+// says so. The caller must hold mu.
+func (d *DB[K, V]) append(data []byte) error {
+	if _, err := d.log.Write(data); err != nil {
+		return d.rollback(fmt.Errorf("memdb: writing log: %w", err))
+	}
+	if d.options.sync == SyncEveryWrite {
+		if err := d.log.Sync(); err != nil {
+			return d.rollback(fmt.Errorf("memdb: syncing log: %w", err))
+		}
+	} else {
+		d.dirty = true
+	}
+	d.size += int64(len(data))
+	d.records++
+	return nil
+}
+
+// rollback cuts a record that failed off the log, so that a write reported
+// as failed is not replayed, and the records after it are not lost behind
+// a torn one.
+func (d *DB[K, V]) rollback(cause error) error {
+	if err := d.log.Truncate(d.size); err != nil {
+		return fmt.Errorf("%w (cutting it off the log: %v)", cause, err)
+	}
+	if _, err := d.log.Seek(d.size, io.SeekStart); err != nil {
+		return fmt.Errorf("%w (cutting it off the log: %v)", cause, err)
+	}
+	return cause
+}
+
+func (d *DB[K, V]) syncLog() error {
+	if err := d.log.Sync(); err != nil {
+		return fmt.Errorf("memdb: syncing log: %w", err)
+	}
+	d.dirty = false
+	return nil
+}
+
+func (d *DB[K, V]) syncPeriodically() {
+	defer close(d.syncDone)
+	ticker := time.NewTicker(d.options.syncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.stopSync:
+			return
+		case <-ticker.C:
+			d.mu.Lock()
+			if d.dirty && !d.closed {
+				// A failed sync is retried on the next tick and by Close.
+				_ = d.syncLog()
+			}
+			d.mu.Unlock()
+		}
+	}
+}
+
+// maybeSnapshot snapshots once the log is long enough. The write that
+// triggered it is already in the log, so a failed snapshot is only logged
+// and retried on the next write. The caller must hold mu.
+func (d *DB[K, V]) maybeSnapshot() {
+	if d.log == nil || d.options.snapshotEvery <= 0 || d.records < d.options.snapshotEvery {
+		return
+	}
+	if err := d.snapshot(); err != nil {
+		log.Printf("memdb %s: %v", d.options.dir, err)
+	}
+}
+
+// Snapshot writes every entry of a persistent DB to a new snapshot and
+// starts a new log. Writes wait until it is done.
+func (d *DB[K, V]) Snapshot() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return ErrClosed
+	}
+	if d.log == nil {
+		return nil
+	}
+	return d.snapshot()
+}
+
+// snapshot writes the snapshot, then empties the log. A crash in between
+// replays the old log on top of the new snapshot, which changes nothing,
+// since every record holds the whole value of its key. The caller must
+// hold mu.
+func (d *DB[K, V]) snapshot() error {
+	tmp := d.path(snapshotFile + ".tmp")
+	f, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("memdb: snapshot: %w", err)
+	}
+	defer os.Remove(tmp)
+
+	if err := d.writeSnapshot(f); err != nil {
+		f.Close()
+		return fmt.Errorf("memdb: snapshot: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("memdb: snapshot: %w", err)
+	}
+	if err := os.Rename(tmp, d.path(snapshotFile)); err != nil {
+		return fmt.Errorf("memdb: snapshot: %w", err)
+	}
+	if err := syncDir(d.options.dir); err != nil {
+		return fmt.Errorf("memdb: snapshot: %w", err)
+	}
+
+	if err := d.resetLog(d.log); err != nil {
+		return fmt.Errorf("memdb: snapshot: starting a new log: %w", err)
+	}
+	d.records = 0
+	return nil
+}
+
+func (d *DB[K, V]) writeSnapshot(f *os.File) error {
+	w := bufio.NewWriter(f)
+	if _, err := w.WriteString(snapshotMagic); err != nil {
+		return err
+	}
+	for key, value := range d.store {
+		data, err := d.codec.Encode(value)
+		if err != nil {
+			return fmt.Errorf("encoding %v: %w", key, err)
+		}
+		if _, err := w.Write(encodePut(formatKey(key), d.versions[key], data)); err != nil {
+			return err
+		}
+	}
+	end := binary.AppendUvarint(nil, uint64(len(d.store)))
+	end = binary.AppendUvarint(end, d.deleted)
+	if _, err := w.Write(encodeRecord(opEnd, "", end)); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// syncDir syncs a directory, so that a file renamed into it stays there
+// after a crash.
+func syncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
+
+type record struct {
+	op    op
+	key   string
+	value []byte
+}
+
+func encodeRecord(op op, key string, value []byte) []byte {
+	payload := make([]byte, 0, 1+binary.MaxVarintLen64+len(key)+len(value))
+	payload = append(payload, byte(op))
+	payload = binary.AppendUvarint(payload, uint64(len(key)))
+	payload = append(payload, key...)
+	payload = append(payload, value...)
+
+	buf := make([]byte, recordHeaderSize, recordHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(buf[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(buf[4:8], crc32.Checksum(payload, crcTable))
+	return append(buf, payload...)
+}
+
+// encodePut encodes the put of a value at a version.
+func encodePut(key string, version uint64, data []byte) []byte {
+	value := binary.AppendUvarint(make([]byte, 0, binary.MaxVarintLen64+len(data)), version)
+	return encodeRecord(opPut, key, append(value, data...))
+}
+
+// encodeDelete encodes the delete of a key, leaving it at a version.
+func encodeDelete(key string, version uint64) []byte {
+	return encodeRecord(opDelete, key, binary.AppendUvarint(nil, version))
+}
+
+// readRecord reads the next record and its size on disk. It returns io.EOF
+// at the end of the input, and an error wrapping errTorn for a record that
+// is incomplete or fails its checksum.
+func readRecord(r io.Reader) (record, int64, error) {
+	var header [recordHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		if err == io.EOF {
+			return record{}, 0, io.EOF
+		}
+		return record{}, 0, fmt.Errorf("%w: %w", errTorn, err)
+	}
+
+	length := binary.BigEndian.Uint32(header[0:4])
+	if length == 0 || length > maxRecordSize {
+		return record{}, 0, fmt.Errorf("%w: bad length %d", errTorn, length)
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return record{}, 0, fmt.Errorf("%w: %w", errTorn, err)
+	}
+	if crc32.Checksum(payload, crcTable) != binary.BigEndian.Uint32(header[4:8]) {
+		return record{}, 0, fmt.Errorf("%w: checksum mismatch", errTorn)
+	}
+
+	keyLength, n := binary.Uvarint(payload[1:])
+	if n <= 0 || uint64(len(payload)-1-n) < keyLength {
+		return record{}, 0, fmt.Errorf("%w: bad key length", errTorn)
+	}
+	start := 1 + n
+	return record{
+		op:    op(payload[0]),
+		key:   string(payload[start : start+int(keyLength)]),
+		value: payload[start+int(keyLength):],
+	}, int64(recordHeaderSize) + int64(length), nil
+}
+
+func readMagic(r io.Reader, magic string) error {
+	buf := make([]byte, len(magic))
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return fmt.Errorf("missing header: %w", err)
+	}
+	if string(buf) != magic {
+		return fmt.Errorf("not a memdb file: header %q", buf)
+	}
+	return nil
+}
@@ -0,0 +1,277 @@
+package memdb
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func openPersistent(t *testing.T, dir string) *DB[string, int] {
+	t.Helper()
+	db, err := New[string, int](WithPersistence(dir, JSONCodec[int]{}))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	return db
+}
+
+func fileSize(t *testing.T, path string) int64 {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	return info.Size()
+}
+
+func TestPersistence_Replay(t *testing.T) {
+	dir := t.TempDir()
+	db := openPersistent(t, dir)
+	for _, write := range []func() error{
+		func() error { return db.Set("a", 1) },
+		func() error { return db.Set("a", 2) },
+		func() error { return db.Set("b", 1) },
+		func() error { return db.Delete("b") },
+		func() error { return db.Set("c", 1) },
+	} {
+		if err := write(); err != nil {
+			t.Fatalf("write error = %v", err)
+		}
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	// Reads still work once the DB is closed, and writes fail.
+	if value, ok := db.Get("a"); value != 2 || !ok {
+		t.Errorf("Get() after Close() = %d, %t, want 2, true", value, ok)
+	}
+	if err := db.Set("a", 3); !errors.Is(err, ErrClosed) {
+		t.Errorf("Set() after Close() error = %v, want ErrClosed", err)
+	}
+	if err := db.Delete("a"); !errors.Is(err, ErrClosed) {
+		t.Errorf("Delete() after Close() error = %v, want ErrClosed", err)
+	}
+	if err := db.Snapshot(); !errors.Is(err, ErrClosed) {
+		t.Errorf("Snapshot() after Close() error = %v, want ErrClosed", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Errorf("Close() again error = %v", err)
+	}
+
+	db = openPersistent(t, dir)
+	want := Recovery{LogRecords: 6, Sealed: true}
+	if got := db.Recovery(); got != want {
+		t.Errorf("Recovery() = %+v, want %+v", got, want)
+	}
+	for _, tt := range []struct {
+		key     string
+		value   int
+		version uint64
+		ok      bool
+	}{
+		{"a", 2, 2, true},
+		{"b", 0, 2, false},
+		// c is stored after b's delete, so it starts above it.
+		{"c", 1, 3, true},
+	} {
+		if value, version, ok := db.GetVersion(tt.key); value != tt.value || version != tt.version || ok != tt.ok {
+			t.Errorf("GetVersion(%q) = %d, %d, %t, want %d, %d, %t", tt.key, value, version, ok, tt.value, tt.version, tt.ok)
+		}
+	}
+
+	// A DB stopped without Close, as by a crash, leaves its log unsealed.
+	if err := db.Set("a", 3); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	db = openPersistent(t, dir)
+	defer db.Close()
+	if db.Recovery().Sealed {
+		t.Errorf("Recovery() = %+v, want a log not sealed after a crash", db.Recovery())
+	}
+	if value, version, _ := db.GetVersion("a"); value != 3 || version != 3 {
+		t.Errorf("GetVersion() = %d, %d, want 3, 3", value, version)
+	}
+}
+
+func TestPersistence_Truncation(t *testing.T) {
+	tests := []struct {
+		name string
+		// tear damages the log, whose last record is size bytes long, as a
+		// crash would, and returns how many bytes opening it should cut
+		// off.
+		tear func(t *testing.T, path string, size int64) int64
+		// records is the number of whole records left in the log, and lost
+		// whether the last write went with the torn record.
+		records int
+		lost    bool
+	}{
+		{
+			name: "partial header",
+			tear: func(t *testing.T, path string, size int64) int64 {
+				f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+				if err != nil {
+					t.Fatalf("OpenFile() error = %v", err)
+				}
+				defer f.Close()
+				if _, err := f.Write([]byte{0, 0, 0}); err != nil {
+					t.Fatalf("Write() error = %v", err)
+				}
+				return 3
+			},
+			records: 2,
+		},
+		{
+			name: "partial payload",
+			tear: func(t *testing.T, path string, size int64) int64 {
+				if err := os.Truncate(path, fileSize(t, path)-2); err != nil {
+					t.Fatalf("Truncate() error = %v", err)
+				}
+				return size - 2
+			},
+			records: 1,
+			lost:    true,
+		},
+		{
+			name: "bad checksum",
+			tear: func(t *testing.T, path string, size int64) int64 {
+				data, err := os.ReadFile(path)
+				if err != nil {
+					t.Fatalf("ReadFile() error = %v", err)
+				}
+				data[len(data)-1] ^= 0xff
+				if err := os.WriteFile(path, data, 0o644); err != nil {
+					t.Fatalf("WriteFile() error = %v", err)
+				}
+				return size
+			},
+			records: 1,
+			lost:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, logFile)
+			db := openPersistent(t, dir)
+			if err := db.Set("a", 1); err != nil {
+				t.Fatalf("Set() error = %v", err)
+			}
+			before := fileSize(t, path)
+			if err := db.Set("b", 1); err != nil {
+				t.Fatalf("Set() error = %v", err)
+			}
+			truncated := tt.tear(t, path, fileSize(t, path)-before)
+
+			db = openPersistent(t, dir)
+			want := Recovery{LogRecords: tt.records, TruncatedBytes: truncated}
+			if got := db.Recovery(); got != want {
+				t.Errorf("Recovery() = %+v, want %+v", got, want)
+			}
+
+			// The log goes on after the last whole record.
+			if err := db.Set("c", 1); err != nil {
+				t.Fatalf("Set() error = %v", err)
+			}
+			if err := db.Close(); err != nil {
+				t.Fatalf("Close() error = %v", err)
+			}
+			db = openPersistent(t, dir)
+			defer db.Close()
+			if got := db.Recovery(); got.TruncatedBytes != 0 || !got.Sealed {
+				t.Errorf("Recovery() after reopening = %+v, want a sealed log with nothing truncated", got)
+			}
+			for _, key := range []string{"a", "c"} {
+				if _, ok := db.Get(key); !ok {
+					t.Errorf("Get(%q) found nothing, want the write kept", key)
+				}
+			}
+			if _, ok := db.Get("b"); ok == tt.lost {
+				t.Errorf("Get(%q) found = %t, want %t", "b", ok, !tt.lost)
+			}
+		})
+	}
+}
+
+func TestPersistence_CrashDuringSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, logFile)
+	db := openPersistent(t, dir)
+	db.Set("a", 1)
+	db.Set("a", 2)
+	db.Set("b", 1)
+	db.Delete("b")
+	db.Set("c", 1)
+
+	// Keep the log as it was before the snapshot, and put it back after,
+	// as a crash between renaming the snapshot into place and starting a
+	// new log would leave it.
+	log, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	if err := db.Snapshot(); err != nil {
+		t.Fatalf("Snapshot() error = %v", err)
+	}
+	if err := os.WriteFile(path, log, 0o644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	db = openPersistent(t, dir)
+	defer db.Close()
+	want := Recovery{SnapshotEntries: 2, LogRecords: 5}
+	if got := db.Recovery(); got != want {
+		t.Errorf("Recovery() = %+v, want %+v", got, want)
+	}
+	if db.Len() != 2 {
+		t.Errorf("Len() = %d, want 2", db.Len())
+	}
+	for _, tt := range []struct {
+		key     string
+		value   int
+		version uint64
+		ok      bool
+	}{
+		{"a", 2, 2, true},
+		{"b", 0, 2, false},
+		// c is stored after b's delete, so it starts above it.
+		{"c", 1, 3, true},
+	} {
+		if value, version, ok := db.GetVersion(tt.key); value != tt.value || version != tt.version || ok != tt.ok {
+			t.Errorf("GetVersion(%q) = %d, %d, %t, want %d, %d, %t", tt.key, value, version, ok, tt.value, tt.version, tt.ok)
+		}
+	}
+}
+
+// TestPersistence_SnapshotOfDeletedKeys checks that a snapshot holds no
+// record of the keys deleted before it, only how high their versions went.
+func TestPersistence_SnapshotOfDeletedKeys(t *testing.T) {
+	dir := t.TempDir()
+	db := openPersistent(t, dir)
+	for _, key := range []string{"a", "b", "c"} {
+		db.Set(key, 1)
+		db.Set(key, 2)
+		db.Delete(key)
+	}
+	if err := db.Snapshot(); err != nil {
+		t.Fatalf("Snapshot() error = %v", err)
+	}
+	db.Close()
+
+	// The snapshot is its header and end record alone. Each key started
+	// above the deletes before it, so c's delete left it at 9.
+	end := encodeRecord(opEnd, "", []byte{0, 9})
+	if got, want := fileSize(t, filepath.Join(dir, snapshotFile)), int64(len(snapshotMagic)+len(end)); got != want {
+		t.Errorf("snapshot size = %d, want %d", got, want)
+	}
+
+	db = openPersistent(t, dir)
+	defer db.Close()
+	if err := db.Set("a", 1); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if _, version, _ := db.GetVersion("a"); version != 10 {
+		t.Errorf("GetVersion() after storing it again = %d, want 10", version)
+	}
+}
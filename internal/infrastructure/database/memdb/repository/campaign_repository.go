@@ -0,0 +1,81 @@
+package repository
+
+import (
+	"fmt"
+	"receipt-processor/internal/domain/receipt"
+	"receipt-processor/internal/infrastructure/database/memdb"
+	"sort"
+)
+
+var _ receipt.CampaignRepository = (*CampaignRepository)(nil)
+
+// CampaignRepository keeps campaigns in a memdb.DB of their own.
+type CampaignRepository struct {
+	db *memdb.DB[string, *receipt.Campaign]
+}
+
+func NewCampaignRepository(db *memdb.DB[string, *receipt.Campaign]) *CampaignRepository {
+	return &CampaignRepository{
+		db: db,
+	}
+}
+
+func (r *CampaignRepository) Create(campaign *receipt.Campaign) error {
+	id := campaign.Id.String()
+	err := update(r.db, func(tx *memdb.Tx[string, *receipt.Campaign]) error {
+		if _, ok := tx.Get(id); ok {
+			return fmt.Errorf("campaign %s: %w", campaign.Id, receipt.ErrConflict)
+		}
+		return tx.Set(id, campaign)
+	})
+	return wrapError(err)
+}
+
+func (r *CampaignRepository) Get(id string) (*receipt.Campaign, error) {
+	campaign, ok := r.db.Get(id)
+	if !ok {
+		return nil, fmt.Errorf("campaign %s: %w", id, receipt.ErrNotFound)
+	}
+	return campaign, nil
+}
+
+func (r *CampaignRepository) Update(campaign *receipt.Campaign, revision int64) error {
+	id := campaign.Id.String()
+	err := update(r.db, func(tx *memdb.Tx[string, *receipt.Campaign]) error {
+		stored, ok := tx.Get(id)
+		if !ok {
+			return fmt.Errorf("campaign %s: %w", id, receipt.ErrNotFound)
+		}
+		if stored.Revision != revision {
+			return fmt.Errorf("campaign %s: %w: it is at revision %d, not %d", id, receipt.ErrConflict, stored.Revision, revision)
+		}
+		return tx.Set(id, campaign)
+	})
+	return wrapError(err)
+}
+
+func (r *CampaignRepository) Delete(id string) error {
+	err := update(r.db, func(tx *memdb.Tx[string, *receipt.Campaign]) error {
+		if _, ok := tx.Get(id); !ok {
+			return fmt.Errorf("campaign %s: %w", id, receipt.ErrNotFound)
+		}
+		return tx.Delete(id)
+	})
+	return wrapError(err)
+}
+
+func (r *CampaignRepository) List() ([]*receipt.Campaign, error) {
+	var campaigns []*receipt.Campaign
+	r.db.ForEach(func(_ string, campaign *receipt.Campaign) bool {
+		campaigns = append(campaigns, campaign)
+		return true
+	})
+
+	sort.Slice(campaigns, func(i, j int) bool {
+		if !campaigns[i].StartsAt.Equal(campaigns[j].StartsAt) {
+			return campaigns[i].StartsAt.Before(campaigns[j].StartsAt)
+		}
+		return campaigns[i].Id.String() < campaigns[j].Id.String()
+	})
+	return campaigns, nil
+}
@@ -0,0 +1,70 @@
+package repository
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	"receipt-processor/internal/domain/receipt"
+	"receipt-processor/internal/infrastructure/database/memdb"
+	"testing"
+	"time"
+)
+
+func TestCampaignRepository(t *testing.T) {
+	db, err := memdb.New[string, *receipt.Campaign]()
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	repo := NewCampaignRepository(db)
+
+	start := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	later := &receipt.Campaign{Id: uuid.New(), Name: "later", StartsAt: start.AddDate(0, 1, 0)}
+	earlier := &receipt.Campaign{Id: uuid.New(), Name: "earlier", StartsAt: start}
+	for _, campaign := range []*receipt.Campaign{later, earlier} {
+		if err := repo.Create(campaign); err != nil {
+			t.Fatalf("Create() error = %v", err)
+		}
+	}
+	if err := repo.Create(later); !errors.Is(err, receipt.ErrConflict) {
+		t.Errorf("Create() again error = %v, want ErrConflict", err)
+	}
+
+	campaigns, err := repo.List()
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(campaigns) != 2 || campaigns[0] != earlier || campaigns[1] != later {
+		t.Errorf("List() = %v, want the campaigns by start time", campaigns)
+	}
+
+	renamed := *earlier
+	renamed.Name = "renamed"
+	renamed.Revision = earlier.Revision + 1
+	if err := repo.Update(&renamed, earlier.Revision); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if got, _ := repo.Get(earlier.Id.String()); got.Name != "renamed" {
+		t.Errorf("Get() after Update() name = %q, want %q", got.Name, "renamed")
+	}
+	stale := *earlier
+	stale.Name = "stale"
+	stale.Revision = earlier.Revision + 1
+	if err := repo.Update(&stale, earlier.Revision); !errors.Is(err, receipt.ErrConflict) {
+		t.Errorf("Update() of a revision since replaced error = %v, want ErrConflict", err)
+	}
+	if got, _ := repo.Get(earlier.Id.String()); got.Name != "renamed" {
+		t.Errorf("Get() after a conflicting Update() name = %q, want %q", got.Name, "renamed")
+	}
+
+	if err := repo.Delete(earlier.Id.String()); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, err := repo.Get(earlier.Id.String()); !errors.Is(err, receipt.ErrNotFound) {
+		t.Errorf("Get() after Delete() error = %v, want ErrNotFound", err)
+	}
+	if err := repo.Update(&renamed, renamed.Revision); !errors.Is(err, receipt.ErrNotFound) {
+		t.Errorf("Update() after Delete() error = %v, want ErrNotFound", err)
+	}
+	if err := repo.Delete(earlier.Id.String()); !errors.Is(err, receipt.ErrNotFound) {
+		t.Errorf("Delete() again error = %v, want ErrNotFound", err)
+	}
+}
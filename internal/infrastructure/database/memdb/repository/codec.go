@@ -0,0 +1,36 @@
+package repository
+
+import (
+	"receipt-processor/internal/domain/receipt"
+	"receipt-processor/internal/infrastructure/database/memdb"
+)
+
+// The codecs of the values each repository keeps, for persistent DBs.
+var (
+	ReceiptCodec     memdb.Codec[*receipt.Receipt]           = receiptCodec{}
+	IdempotencyCodec memdb.Codec[*receipt.IdempotencyRecord] = memdb.JSONCodec[*receipt.IdempotencyRecord]{}
+	RescoreJobCodec  memdb.Codec[*receipt.RescoreJob]        = memdb.JSONCodec[*receipt.RescoreJob]{}
+	CampaignCodec    memdb.Codec[*receipt.Campaign]          = memdb.JSONCodec[*receipt.Campaign]{}
+)
+
+// receiptCodec stores receipts as JSON, which keeps the UTC offset of the
+// purchase time but not its time zone, so the zone is restored from the
+// receipt's TimeZone.
+type receiptCodec struct {
+	memdb.JSONCodec[*receipt.Receipt]
+}
+
+func (c receiptCodec) Decode(data []byte) (*receipt.Receipt, error) {
+	rec, err := c.JSONCodec.Decode(data)
+	if err != nil {
+		return nil, err
+	}
+	if rec.TimeZone != "" {
+		zone, err := receipt.ParseTimeZone(rec.TimeZone)
+		if err != nil {
+			return nil, err
+		}
+		rec.PurchaseDateTime = rec.PurchaseDateTime.In(zone)
+	}
+	return rec, nil
+}
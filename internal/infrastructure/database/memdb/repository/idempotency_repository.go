@@ -0,0 +1,146 @@
+package repository
+
+import (
+	"fmt"
+	"receipt-processor/internal/domain/receipt"
+	"receipt-processor/internal/infrastructure/database/memdb"
+	"sync/atomic"
+	"time"
+)
+
+var _ receipt.IdempotencyStore = (*IdempotencyRepository)(nil)
+
+// IdempotencyRepository keeps idempotency records in a memdb.DB of their
+// own. Records older than the retention window are ignored and swept out
+// as new keys are reserved.
+type IdempotencyRepository struct {
+	db        *memdb.DB[string, *receipt.IdempotencyRecord]
+	retention time.Duration
+	// lastSweep is when the last sweep started, in Unix nanoseconds.
+	lastSweep atomic.Int64
+}
+
+func NewIdempotencyRepository(db *memdb.DB[string, *receipt.IdempotencyRecord], retention time.Duration) *IdempotencyRepository {
+	r := &IdempotencyRepository{
+		db:        db,
+		retention: retention,
+	}
+	r.lastSweep.Store(time.Now().UnixNano())
+	return r
+}
+
+func (r *IdempotencyRepository) Reserve(record receipt.IdempotencyRecord) (*receipt.IdempotencyRecord, error) {
+	if err := r.sweep(); err != nil {
+		return nil, err
+	}
+
+	var found *receipt.IdempotencyRecord
+	err := update(r.db, func(tx *memdb.Tx[string, *receipt.IdempotencyRecord]) error {
+		found = nil
+		if existing := r.find(tx, record.Key); existing != nil {
+			copied := *existing
+			found = &copied
+			return nil
+		}
+		return tx.Set(record.Key, &record)
+	})
+	if err != nil {
+		return nil, wrapError(err)
+	}
+	return found, nil
+}
+
+func (r *IdempotencyRepository) Takeover(stale receipt.IdempotencyRecord, record receipt.IdempotencyRecord) (*receipt.IdempotencyRecord, error) {
+	var found *receipt.IdempotencyRecord
+	err := update(r.db, func(tx *memdb.Tx[string, *receipt.IdempotencyRecord]) error {
+		found = nil
+		if existing := r.find(tx, record.Key); existing != nil && !sameRecord(*existing, stale) {
+			copied := *existing
+			found = &copied
+			return nil
+		}
+		return tx.Set(record.Key, &record)
+	})
+	if err != nil {
+		return nil, wrapError(err)
+	}
+	return found, nil
+}
+
+func (r *IdempotencyRepository) Complete(key string, receiptId string) error {
+	err := update(r.db, func(tx *memdb.Tx[string, *receipt.IdempotencyRecord]) error {
+		existing := r.find(tx, key)
+		if existing == nil {
+			return fmt.Errorf("idempotency key %q: %w", key, receipt.ErrNotFound)
+		}
+		if existing.PendingId != receiptId {
+			return fmt.Errorf("idempotency key %q is pending receipt %s: %w", key, existing.PendingId, receipt.ErrConflict)
+		}
+		completed := *existing
+		completed.ReceiptId = receiptId
+		return tx.Set(key, &completed)
+	})
+	return wrapError(err)
+}
+
+func (r *IdempotencyRepository) Release(record receipt.IdempotencyRecord) error {
+	err := update(r.db, func(tx *memdb.Tx[string, *receipt.IdempotencyRecord]) error {
+		if existing := r.find(tx, record.Key); existing != nil && sameRecord(*existing, record) {
+			return tx.Delete(record.Key)
+		}
+		return nil
+	})
+	return wrapError(err)
+}
+
+// find returns the live record for key as tx sees it, or nil if there is
+// none.
+func (r *IdempotencyRepository) find(tx *memdb.Tx[string, *receipt.IdempotencyRecord], key string) *receipt.IdempotencyRecord {
+	record, ok := tx.Get(key)
+	if !ok || r.expired(record) {
+		return nil
+	}
+	return record
+}
+
+// sameRecord reports whether a and b are the same reservation, which
+// unlike == does not depend on how their times were decoded.
+func sameRecord(a, b receipt.IdempotencyRecord) bool {
+	return a.Key == b.Key && a.Fingerprint == b.Fingerprint && a.ReceiptId == b.ReceiptId &&
+		a.PendingId == b.PendingId && a.CreatedAt.Equal(b.CreatedAt)
+}
+
+func (r *IdempotencyRepository) expired(record *receipt.IdempotencyRecord) bool {
+	return time.Since(record.CreatedAt) > r.retention
+}
+
+// sweep deletes expired records, at most once per half retention window.
+// A record reserved again since it was found expired is kept.
+func (r *IdempotencyRepository) sweep() error {
+	last := r.lastSweep.Load()
+	now := time.Now()
+	if now.Sub(time.Unix(0, last)) < r.retention/2 || !r.lastSweep.CompareAndSwap(last, now.UnixNano()) {
+		return nil
+	}
+
+	var expired []string
+	r.db.ForEach(func(key string, record *receipt.IdempotencyRecord) bool {
+		if r.expired(record) {
+			expired = append(expired, key)
+		}
+		return true
+	})
+
+	for _, key := range expired {
+		err := update(r.db, func(tx *memdb.Tx[string, *receipt.IdempotencyRecord]) error {
+			if record, ok := tx.Get(key); ok && r.expired(record) {
+				return tx.Delete(key)
+			}
+			return nil
+		})
+		if err != nil {
+			return wrapError(err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package repository
+
+import (
+	"errors"
+	"receipt-processor/internal/domain/receipt"
+	"receipt-processor/internal/infrastructure/database/memdb"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestIdempotencyRepository_Reserve(t *testing.T) {
+	db, err := memdb.New[string, *receipt.IdempotencyRecord]()
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	repo := NewIdempotencyRepository(db, time.Hour)
+
+	// Of many requests reserving the same key at once, only one gets it.
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	reserved := 0
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			existing, err := repo.Reserve(receipt.IdempotencyRecord{Key: "key", Fingerprint: "a", PendingId: "receipt", CreatedAt: time.Now()})
+			if err != nil {
+				t.Errorf("Reserve() error = %v", err)
+				return
+			}
+			if existing == nil {
+				mu.Lock()
+				reserved++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if reserved != 1 {
+		t.Errorf("Reserve() reserved the key %d times, want once", reserved)
+	}
+
+	if err := repo.Complete("key", "other"); !errors.Is(err, receipt.ErrConflict) {
+		t.Errorf("Complete() with another receipt error = %v, want ErrConflict", err)
+	}
+	if err := repo.Complete("key", "receipt"); err != nil {
+		t.Fatalf("Complete() error = %v", err)
+	}
+	existing, err := repo.Reserve(receipt.IdempotencyRecord{Key: "key", Fingerprint: "a", CreatedAt: time.Now()})
+	if err != nil || existing == nil || existing.ReceiptId != "receipt" {
+		t.Errorf("Reserve() of a completed key = %+v, %v, want its receipt", existing, err)
+	}
+	if err := repo.Complete("other", "receipt"); !errors.Is(err, receipt.ErrNotFound) {
+		t.Errorf("Complete() of a key never reserved error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestIdempotencyRepository_Takeover(t *testing.T) {
+	db, err := memdb.New[string, *receipt.IdempotencyRecord]()
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	repo := NewIdempotencyRepository(db, time.Hour)
+
+	stale := receipt.IdempotencyRecord{Key: "key", Fingerprint: "a", PendingId: "receipt", CreatedAt: time.Now().Add(-time.Minute)}
+	if _, err := repo.Reserve(stale); err != nil {
+		t.Fatalf("Reserve() error = %v", err)
+	}
+
+	// Of two requests taking over the same stale record, only the first
+	// gets it, and the second is given the record the first stored.
+	first := stale
+	first.CreatedAt = time.Now()
+	if existing, err := repo.Takeover(stale, first); err != nil || existing != nil {
+		t.Fatalf("Takeover() = %+v, %v, want the key taken over", existing, err)
+	}
+	second := stale
+	second.CreatedAt = time.Now().Add(time.Second)
+	existing, err := repo.Takeover(stale, second)
+	if err != nil || existing == nil || !existing.CreatedAt.Equal(first.CreatedAt) {
+		t.Errorf("Takeover() of a record taken over = %+v, %v, want %+v", existing, err, first)
+	}
+
+	// A record released meanwhile is simply reserved.
+	if err := repo.Release(first); err != nil {
+		t.Fatalf("Release() error = %v", err)
+	}
+	if existing, err := repo.Takeover(stale, second); err != nil || existing != nil {
+		t.Errorf("Takeover() of a released key = %+v, %v, want the key reserved", existing, err)
+	}
+}
+
+// TestIdempotencyRepository_ReleaseAfterTakeover checks that a request
+// whose key was taken over while it was stalled cannot release the key
+// from under the request that took it over.
+func TestIdempotencyRepository_ReleaseAfterTakeover(t *testing.T) {
+	db, err := memdb.New[string, *receipt.IdempotencyRecord]()
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	repo := NewIdempotencyRepository(db, time.Hour)
+
+	a := receipt.IdempotencyRecord{Key: "key", Fingerprint: "a", PendingId: "receipt", CreatedAt: time.Now().Add(-2 * receipt.IdempotencyLease)}
+	if existing, err := repo.Reserve(a); err != nil || existing != nil {
+		t.Fatalf("Reserve() = %+v, %v, want the key reserved", existing, err)
+	}
+	b := a
+	b.CreatedAt = time.Now()
+	if existing, err := repo.Takeover(a, b); err != nil || existing != nil {
+		t.Fatalf("Takeover() = %+v, %v, want the key taken over", existing, err)
+	}
+
+	// A fails and releases its reservation, which is no longer the key's.
+	if err := repo.Release(a); err != nil {
+		t.Fatalf("Release() error = %v", err)
+	}
+	c := a
+	c.CreatedAt = time.Now()
+	existing, err := repo.Reserve(c)
+	if err != nil || existing == nil || !existing.CreatedAt.Equal(b.CreatedAt) {
+		t.Fatalf("Reserve() after a stale Release() = %+v, %v, want %+v", existing, err, b)
+	}
+
+	// B releases its own reservation, and the key is free again.
+	if err := repo.Release(b); err != nil {
+		t.Fatalf("Release() error = %v", err)
+	}
+	if existing, err := repo.Reserve(c); err != nil || existing != nil {
+		t.Errorf("Reserve() after Release() = %+v, %v, want the key reserved", existing, err)
+	}
+}
@@ -1,50 +1,259 @@
 package repository
 
 import (
+	"cmp"
+	"errors"
+	"fmt"
+	"github.com/shopspring/decimal"
 	"receipt-processor/internal/domain/receipt"
 	"receipt-processor/internal/infrastructure/database/memdb"
+	"time"
 )
 
 var _ receipt.Repository = (*ReceiptRepository)(nil)
 
+// maxFilteredPages bounds how many pages of receipts a listing reads
+// through the index of a filter on a field other than its sort field. With
+// more, it walks the sort field's index from its cursor instead, checking
+// the filter as it goes, so that paging through them reads each receipt
+// once rather than once a page.
+const maxFilteredPages = 10
+
+// ReceiptRepository keeps receipts in a memdb.DB, indexed by every field a
+// listing can sort by. A receipt's Version is the version of its key in the
+// DB.
 type ReceiptRepository struct {
-	db *memdb.DB
+	db             *memdb.DB[string, *receipt.Receipt]
+	byCreatedAt    *memdb.Index[string, *receipt.Receipt, time.Time]
+	byRetailer     *memdb.Index[string, *receipt.Receipt, string]
+	byPurchaseTime *memdb.Index[string, *receipt.Receipt, time.Time]
+	byTotal        *memdb.Index[string, *receipt.Receipt, decimal.Decimal]
+	byPoints       *memdb.Index[string, *receipt.Receipt, int64]
 }
 
-func NewReceiptRepository(db *memdb.DB) *ReceiptRepository {
+func NewReceiptRepository(db *memdb.DB[string, *receipt.Receipt]) *ReceiptRepository {
 	return &ReceiptRepository{
 		db: db,
+		byCreatedAt: memdb.NewIndex(db, func(rec *receipt.Receipt) (time.Time, bool) {
+			return rec.CreatedAt, true
+		}, time.Time.Compare),
+		byRetailer: memdb.NewIndex(db, func(rec *receipt.Receipt) (string, bool) {
+			return rec.Retailer, true
+		}, cmp.Compare[string]),
+		byPurchaseTime: memdb.NewIndex(db, func(rec *receipt.Receipt) (time.Time, bool) {
+			return rec.PurchaseDateTime, true
+		}, time.Time.Compare),
+		byTotal: memdb.NewIndex(db, func(rec *receipt.Receipt) (decimal.Decimal, bool) {
+			return rec.Total, true
+		}, decimal.Decimal.Cmp),
+		byPoints: memdb.NewIndex(db, func(rec *receipt.Receipt) (int64, bool) {
+			return rec.Points, true
+		}, cmp.Compare[int64]),
 	}
 }
 
-func (r *ReceiptRepository) Create(receipt *receipt.Receipt) (*receipt.Receipt, error) {
-	err := r.db.Set(receipt.Id.String(), receipt)
+func (r *ReceiptRepository) Create(rec *receipt.Receipt) (*receipt.Receipt, error) {
+	id := rec.Id.String()
+	err := r.db.Update(func(tx *memdb.Tx[string, *receipt.Receipt]) error {
+		_, version, ok := tx.GetVersion(id)
+		if ok {
+			return fmt.Errorf("receipt %s: %w", rec.Id, receipt.ErrConflict)
+		}
+		rec.Version = int64(version) + 1
+		return tx.Set(id, rec)
+	})
+	if errors.Is(err, memdb.ErrConflict) {
+		return nil, fmt.Errorf("receipt %s: %w", rec.Id, receipt.ErrConflict)
+	}
 	if err != nil {
-		return nil, err
+		return nil, wrapError(err)
 	}
-	return receipt, nil
+	return rec, nil
 }
 
 func (r *ReceiptRepository) Get(id string) (*receipt.Receipt, error) {
-	rec, err := r.db.Get(id)
+	rec, ok := r.db.Get(id)
+	if !ok {
+		return nil, fmt.Errorf("receipt %s: %w", id, receipt.ErrNotFound)
+	}
+	return rec, nil
+}
+
+func (r *ReceiptRepository) Update(id string, rec *receipt.Receipt) (*receipt.Receipt, error) {
+	expected := rec.Version
+	err := r.db.Update(func(tx *memdb.Tx[string, *receipt.Receipt]) error {
+		_, version, ok := tx.GetVersion(id)
+		if !ok {
+			return fmt.Errorf("receipt %s: %w", id, receipt.ErrNotFound)
+		}
+		if expected != 0 && uint64(expected) != version {
+			return &receipt.VersionConflictError{Id: id, Expected: expected, Actual: int64(version)}
+		}
+		rec.Version = int64(version) + 1
+		return tx.Set(id, rec)
+	})
 	if err != nil {
-		return nil, err
+		rec.Version = expected
+		var conflict *memdb.ConflictError
+		if errors.As(err, &conflict) {
+			return nil, &receipt.VersionConflictError{Id: id, Expected: int64(conflict.Read), Actual: int64(conflict.Stored)}
+		}
+		return nil, wrapError(err)
+	}
+	return rec, nil
+}
+
+func (r *ReceiptRepository) Delete(id string, version int64) error {
+	err := r.db.Update(func(tx *memdb.Tx[string, *receipt.Receipt]) error {
+		_, stored, ok := tx.GetVersion(id)
+		if !ok {
+			return fmt.Errorf("receipt %s: %w", id, receipt.ErrNotFound)
+		}
+		if version != 0 && uint64(version) != stored {
+			return &receipt.VersionConflictError{Id: id, Expected: version, Actual: int64(stored)}
+		}
+		return tx.Delete(id)
+	})
+	var conflict *memdb.ConflictError
+	if errors.As(err, &conflict) {
+		return &receipt.VersionConflictError{Id: id, Expected: int64(conflict.Read), Actual: int64(conflict.Stored)}
 	}
-	return rec.(*receipt.Receipt), nil
+	return wrapError(err)
 }
 
-func (r *ReceiptRepository) Update(id string, receipt *receipt.Receipt) (*receipt.Receipt, error) {
-	err := r.db.Set(id, receipt)
+// List walks the index of the query's sort field from its cursor, and
+// stops once it has read one receipt past the page. Filters on the sort
+// field bound the walk, and others are checked as it goes, unless one of
+// them narrows the query to at most maxFilteredPages pages of receipts,
+// which are then read through its index, and sorted and paged in memory.
+func (r *ReceiptRepository) List(query receipt.ListQuery) (*receipt.Page, error) {
+	if err := query.Normalize(); err != nil {
+		return nil, err
+	}
+	position, err := query.Position()
 	if err != nil {
 		return nil, err
 	}
-	return receipt, nil
+
+	if receipts, ok := r.filtered(query); ok {
+		return receipt.Paginate(receipts, query)
+	}
+
+	switch query.SortBy {
+	case receipt.SortByRetailer:
+		var retailer *string
+		if query.Retailer != "" {
+			retailer = &query.Retailer
+		}
+		return walk(r.byRetailer, query, position, func(rec *receipt.Receipt) string {
+			return rec.Retailer
+		}, retailer, retailer, true), nil
+	case receipt.SortByPurchaseDateTime:
+		return walk(r.byPurchaseTime, query, position, func(rec *receipt.Receipt) time.Time {
+			return rec.PurchaseDateTime
+		}, query.PurchasedFrom, query.PurchasedTo, false), nil
+	case receipt.SortByTotal:
+		return walk(r.byTotal, query, position, func(rec *receipt.Receipt) decimal.Decimal {
+			return rec.Total
+		}, query.MinTotal, query.MaxTotal, true), nil
+	case receipt.SortByPoints:
+		return walk(r.byPoints, query, position, func(rec *receipt.Receipt) int64 {
+			return rec.Points
+		}, query.MinPoints, query.MaxPoints, true), nil
+	default:
+		return walk(r.byCreatedAt, query, position, func(rec *receipt.Receipt) time.Time {
+			return rec.CreatedAt
+		}, nil, nil, false), nil
+	}
 }
 
-func (r *ReceiptRepository) Delete(id string) error {
-	err := r.db.Delete(id)
-	if err != nil {
+// filtered returns the receipts that the narrowest index of a filter on a
+// field other than the sort field narrows the query to, if there are at
+// most maxFilteredPages pages of them.
+func (r *ReceiptRepository) filtered(query receipt.ListQuery) ([]*receipt.Receipt, bool) {
+	var filters []filter
+	if query.Retailer != "" && query.SortBy != receipt.SortByRetailer {
+		filters = append(filters, newFilter(r.byRetailer, &query.Retailer, &query.Retailer, true))
+	}
+	if (query.PurchasedFrom != nil || query.PurchasedTo != nil) && query.SortBy != receipt.SortByPurchaseDateTime {
+		filters = append(filters, newFilter(r.byPurchaseTime, query.PurchasedFrom, query.PurchasedTo, false))
+	}
+	if (query.MinTotal != nil || query.MaxTotal != nil) && query.SortBy != receipt.SortByTotal {
+		filters = append(filters, newFilter(r.byTotal, query.MinTotal, query.MaxTotal, true))
+	}
+	if (query.MinPoints != nil || query.MaxPoints != nil) && query.SortBy != receipt.SortByPoints {
+		filters = append(filters, newFilter(r.byPoints, query.MinPoints, query.MaxPoints, true))
+	}
+
+	var narrowest *filter
+	for i, f := range filters {
+		if f.count <= maxFilteredPages*query.Limit && (narrowest == nil || f.count < narrowest.count) {
+			narrowest = &filters[i]
+		}
+	}
+	if narrowest == nil {
+		return nil, false
+	}
+	return narrowest.read(), true
+}
+
+// filter is the range of an index a filter of a listing narrows it to.
+type filter struct {
+	count int
+	read  func() []*receipt.Receipt
+}
+
+func newFilter[I any](index *memdb.Index[string, *receipt.Receipt, I], from, to *I, inclusive bool) filter {
+	return filter{
+		count: index.Count(from, to, inclusive),
+		read: func() []*receipt.Receipt {
+			return index.Range(from, to, inclusive)
+		},
+	}
+}
+
+// walk reads a page of receipts from the index of the query's sort field,
+// whose index key key derives, starting past the query's position and
+// within the range of index keys from and to bound.
+func walk[I any](index *memdb.Index[string, *receipt.Receipt, I], query receipt.ListQuery, position *receipt.Receipt, key func(*receipt.Receipt) I, from, to *I, inclusive bool) *receipt.Page {
+	var after *memdb.Position[string, I]
+	if position != nil {
+		after = &memdb.Position[string, I]{Index: key(position), Key: position.Id.String()}
+	}
+
+	var receipts []*receipt.Receipt
+	index.Scan(from, to, inclusive, after, query.Order == receipt.SortDescending, func(_ string, rec *receipt.Receipt) bool {
+		if query.Matches(rec) {
+			receipts = append(receipts, rec)
+		}
+		return len(receipts) <= query.Limit
+	})
+	return query.Page(receipts)
+}
+
+// wrapError reports memdb errors as receipt.ErrUnavailable and passes
+// domain errors through.
+func wrapError(err error) error {
+	if err == nil || errors.Is(err, receipt.ErrNotFound) || errors.Is(err, receipt.ErrConflict) {
 		return err
 	}
-	return nil
+	return fmt.Errorf("%w: %w", receipt.ErrUnavailable, err)
+}
+
+// maxAttempts bounds how many times update runs a transaction that keeps
+// conflicting with concurrent writes.
+const maxAttempts = 10
+
+// update runs fn in a transaction of db, and runs it again if a key it read
+// was written by someone else before it committed, so that the checks fn
+// makes hold for what it writes. It is for writes that do not carry the
+// version their caller read, and so have nothing to conflict with.
+func update[K memdb.Key, V any](db *memdb.DB[K, V], fn func(tx *memdb.Tx[K, V]) error) error {
+	var err error
+	for range maxAttempts {
+		if err = db.Update(fn); !errors.Is(err, memdb.ErrConflict) {
+			return err
+		}
+	}
+	return err
 }
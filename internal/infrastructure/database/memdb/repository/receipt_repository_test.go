@@ -0,0 +1,211 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"receipt-processor/internal/domain/receipt"
+	"receipt-processor/internal/infrastructure/database/memdb"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// newTestRepository returns a repository on an empty DB held in memory.
+func newTestRepository(t *testing.T) *ReceiptRepository {
+	t.Helper()
+	db, err := memdb.New[string, *receipt.Receipt]()
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	return NewReceiptRepository(db)
+}
+
+func newTestReceipt(retailer string, purchased time.Time, total string, points int64) *receipt.Receipt {
+	price := decimal.RequireFromString(total)
+	return &receipt.Receipt{
+		Id:               uuid.New(),
+		Retailer:         retailer,
+		PurchaseDateTime: purchased,
+		TimeZone:         purchased.Location().String(),
+		Items: []receipt.Item{
+			{Id: uuid.New(), ShortDescription: "Mountain Dew 12PK", Price: price.Sub(decimal.New(100, -2))},
+			{Id: uuid.New(), ShortDescription: "Gum", Price: decimal.New(100, -2)},
+		},
+		Total:          price,
+		Points:         points,
+		RulesetVersion: "v1",
+		CreatedAt:      time.Now().UTC(),
+	}
+}
+
+func TestReceiptRepository_CRUD(t *testing.T) {
+	repo := newTestRepository(t)
+	rec := newTestReceipt("Target", time.Date(2022, time.January, 1, 13, 1, 0, 0, time.UTC), "35.35", 28)
+
+	if _, err := repo.Create(rec); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if rec.Version != 1 {
+		t.Errorf("Create() version = %d, want 1", rec.Version)
+	}
+	if _, err := repo.Create(rec); !errors.Is(err, receipt.ErrConflict) {
+		t.Errorf("Create() again error = %v, want ErrConflict", err)
+	}
+
+	updated := *rec
+	updated.Points = 40
+	if _, err := repo.Update(rec.Id.String(), &updated); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if got, _ := repo.Get(rec.Id.String()); got.Points != 40 || got.Version != 2 {
+		t.Errorf("Get() after Update() = %d points at version %d, want 40 at 2", got.Points, got.Version)
+	}
+
+	// An update or delete based on a version that is no longer stored is
+	// rejected.
+	stale := updated
+	stale.Version = 1
+	stale.Points = 0
+	var conflict *receipt.VersionConflictError
+	if _, err := repo.Update(rec.Id.String(), &stale); !errors.As(err, &conflict) || conflict.Actual != 2 {
+		t.Errorf("Update() of version 1 error = %v, want a VersionConflictError at version 2", err)
+	}
+	if stale.Version != 1 {
+		t.Errorf("Update() that failed left version %d, want 1", stale.Version)
+	}
+	if err := repo.Delete(rec.Id.String(), 1); !errors.As(err, &conflict) || conflict.Actual != 2 {
+		t.Errorf("Delete() of version 1 error = %v, want a VersionConflictError at version 2", err)
+	}
+	if got, _ := repo.Get(rec.Id.String()); got == nil || got.Points != 40 {
+		t.Errorf("Get() after conflicting writes = %+v, want the update kept", got)
+	}
+
+	if err := repo.Delete(rec.Id.String(), 2); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, err := repo.Get(rec.Id.String()); !errors.Is(err, receipt.ErrNotFound) {
+		t.Errorf("Get() after Delete() error = %v, want ErrNotFound", err)
+	}
+	if err := repo.Delete(rec.Id.String(), 0); !errors.Is(err, receipt.ErrNotFound) {
+		t.Errorf("Delete() again error = %v, want ErrNotFound", err)
+	}
+	if _, err := repo.Update(rec.Id.String(), &updated); !errors.Is(err, receipt.ErrNotFound) {
+		t.Errorf("Update() after Delete() error = %v, want ErrNotFound", err)
+	}
+
+	// A receipt created again under a deleted id does not take a version
+	// the deleted one had.
+	again := newTestReceipt("Target", rec.PurchaseDateTime, "35.35", 28)
+	again.Id = rec.Id
+	if _, err := repo.Create(again); err != nil {
+		t.Fatalf("Create() after Delete() error = %v", err)
+	}
+	if again.Version != 4 {
+		t.Errorf("Create() after Delete() version = %d, want 4", again.Version)
+	}
+}
+
+// TestReceiptRepository_List pages through the receipts in every order and
+// with filters, walking the index of the sort field or reading the
+// receipts a filter's index narrows the listing to, and expects the pages
+// receipt.Paginate returns for the same receipts.
+func TestReceiptRepository_List(t *testing.T) {
+	repo := newTestRepository(t)
+
+	retailers := []string{"Target", "Walgreens", "target", "CVS", "Ábaco"}
+	// Stores in these zones make purchases from 09:00 to 21:00 in UTC on
+	// different local dates.
+	var zones []*time.Location
+	for _, name := range []string{"UTC", "America/Chicago", "Asia/Tokyo"} {
+		zone, err := receipt.ParseTimeZone(name)
+		if err != nil {
+			t.Fatalf("ParseTimeZone() error = %v", err)
+		}
+		zones = append(zones, zone)
+	}
+	var receipts []*receipt.Receipt
+	start := time.Date(2024, time.January, 1, 9, 0, 0, 0, time.UTC)
+	for i := range 40 {
+		rec := newTestReceipt(
+			retailers[i%len(retailers)],
+			start.Add(time.Duration(i%13)*time.Hour).In(zones[i%len(zones)]),
+			fmt.Sprintf("%d.%02d", 2+i%7, i*7%100),
+			int64(i%9*5),
+		)
+		rec.CreatedAt = start.Add(time.Duration(i%17) * time.Minute)
+		if _, err := repo.Create(rec); err != nil {
+			t.Fatalf("Create() error = %v", err)
+		}
+		receipts = append(receipts, rec)
+	}
+
+	from := start.Add(2 * time.Hour)
+	to := start.Add(10 * time.Hour)
+	minTotal := decimal.RequireFromString("3.005")
+	maxTotal := decimal.RequireFromString("6.5")
+	minPoints, maxPoints := int64(10), int64(30)
+	filters := map[string]receipt.ListQuery{
+		"no filters":                 {},
+		"retailer":                   {Retailer: "Target"},
+		"purchase time":              {PurchasedFrom: &from, PurchasedTo: &to},
+		"purchase date":              {PurchaseDateFrom: "2024-01-01", PurchaseDateTo: "2024-01-01"},
+		"purchase date from":         {PurchaseDateFrom: "2024-01-02"},
+		"purchased from":             {PurchasedFrom: &from},
+		"purchased to":               {PurchasedTo: &to},
+		"retailer and purchase time": {Retailer: "target", PurchasedFrom: &from, PurchasedTo: &to},
+		"total":                      {MinTotal: &minTotal, MaxTotal: &maxTotal},
+		"points":                     {MinPoints: &minPoints, MaxPoints: &maxPoints},
+	}
+	fields := []receipt.SortField{receipt.SortByCreatedAt, receipt.SortByPurchaseDateTime, receipt.SortByRetailer, receipt.SortByTotal, receipt.SortByPoints}
+
+	// With a limit of 7, every filter narrows the listing to few enough
+	// pages to be read through its index; with 1, most do not.
+	for _, limit := range []int{1, 7} {
+		for name, filter := range filters {
+			for _, field := range fields {
+				for _, order := range []receipt.SortOrder{receipt.SortAscending, receipt.SortDescending} {
+					t.Run(fmt.Sprintf("%s by %s %s, %d a page", name, field, order, limit), func(t *testing.T) {
+						query := filter
+						query.SortBy = field
+						query.Order = order
+						query.Limit = limit
+
+						want := pageThrough(t, query, func(q receipt.ListQuery) (*receipt.Page, error) {
+							return receipt.Paginate(receipts, q)
+						})
+						got := pageThrough(t, query, repo.List)
+						if !reflect.DeepEqual(got, want) {
+							t.Errorf("List() = %v, want %v", got, want)
+						}
+					})
+				}
+			}
+		}
+	}
+
+	if _, err := repo.List(receipt.ListQuery{Cursor: "not a cursor"}); !errors.Is(err, receipt.ErrInvalidCursor) {
+		t.Errorf("List() error = %v, want ErrInvalidCursor", err)
+	}
+}
+
+// pageThrough returns the IDs of every receipt a listing returns, page
+// after page.
+func pageThrough(t *testing.T, query receipt.ListQuery, list func(receipt.ListQuery) (*receipt.Page, error)) []string {
+	t.Helper()
+	var ids []string
+	for {
+		page, err := list(query)
+		if err != nil {
+			t.Fatalf("List() error = %v", err)
+		}
+		for _, rec := range page.Receipts {
+			ids = append(ids, rec.Id.String())
+		}
+		if page.NextCursor == "" {
+			return ids
+		}
+		query.Cursor = page.NextCursor
+	}
+}
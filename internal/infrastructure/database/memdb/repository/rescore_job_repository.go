@@ -0,0 +1,73 @@
+package repository
+
+import (
+	"fmt"
+	"receipt-processor/internal/domain/receipt"
+	"receipt-processor/internal/infrastructure/database/memdb"
+	"sort"
+)
+
+var _ receipt.RescoreJobRepository = (*RescoreJobRepository)(nil)
+
+// RescoreJobRepository keeps rescore jobs in a memdb.DB of their own. Jobs
+// are copied in and out, so that a running job can be read while it is
+// being updated.
+type RescoreJobRepository struct {
+	db *memdb.DB[string, *receipt.RescoreJob]
+}
+
+func NewRescoreJobRepository(db *memdb.DB[string, *receipt.RescoreJob]) *RescoreJobRepository {
+	return &RescoreJobRepository{
+		db: db,
+	}
+}
+
+func (r *RescoreJobRepository) Create(job *receipt.RescoreJob) error {
+	stored := copyJob(job)
+	err := update(r.db, func(tx *memdb.Tx[string, *receipt.RescoreJob]) error {
+		if _, ok := tx.Get(job.Id); ok {
+			return fmt.Errorf("rescore job %s: %w", job.Id, receipt.ErrConflict)
+		}
+		return tx.Set(job.Id, stored)
+	})
+	return wrapError(err)
+}
+
+func (r *RescoreJobRepository) Get(id string) (*receipt.RescoreJob, error) {
+	job, ok := r.db.Get(id)
+	if !ok {
+		return nil, fmt.Errorf("rescore job %s: %w", id, receipt.ErrNotFound)
+	}
+	return copyJob(job), nil
+}
+
+func (r *RescoreJobRepository) Update(job *receipt.RescoreJob) error {
+	stored := copyJob(job)
+	err := update(r.db, func(tx *memdb.Tx[string, *receipt.RescoreJob]) error {
+		if _, ok := tx.Get(job.Id); !ok {
+			return fmt.Errorf("rescore job %s: %w", job.Id, receipt.ErrNotFound)
+		}
+		return tx.Set(job.Id, stored)
+	})
+	return wrapError(err)
+}
+
+func (r *RescoreJobRepository) List() ([]*receipt.RescoreJob, error) {
+	var jobs []*receipt.RescoreJob
+	r.db.ForEach(func(_ string, job *receipt.RescoreJob) bool {
+		jobs = append(jobs, copyJob(job))
+		return true
+	})
+
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].CreatedAt.After(jobs[j].CreatedAt)
+	})
+	return jobs, nil
+}
+
+func copyJob(job *receipt.RescoreJob) *receipt.RescoreJob {
+	c := *job
+	c.Changes = append([]receipt.PointsChange(nil), job.Changes...)
+	c.Errors = append([]receipt.ReceiptError(nil), job.Errors...)
+	return &c
+}
@@ -0,0 +1,61 @@
+package repository
+
+import (
+	"errors"
+	"receipt-processor/internal/domain/receipt"
+	"receipt-processor/internal/infrastructure/database/memdb"
+	"testing"
+	"time"
+)
+
+func TestRescoreJobRepository(t *testing.T) {
+	db, err := memdb.New[string, *receipt.RescoreJob]()
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	repo := NewRescoreJobRepository(db)
+
+	start := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	first := &receipt.RescoreJob{Id: "first", Status: receipt.JobRunning, CreatedAt: start}
+	second := &receipt.RescoreJob{Id: "second", Status: receipt.JobRunning, CreatedAt: start.Add(time.Minute)}
+	for _, job := range []*receipt.RescoreJob{first, second} {
+		if err := repo.Create(job); err != nil {
+			t.Fatalf("Create() error = %v", err)
+		}
+	}
+	if err := repo.Create(first); !errors.Is(err, receipt.ErrConflict) {
+		t.Errorf("Create() again error = %v, want ErrConflict", err)
+	}
+
+	// Jobs are copied in and out, so changing one the repository returned,
+	// or one it was given, does not change what it stores until Update.
+	first.Changes = append(first.Changes, receipt.PointsChange{ReceiptId: "receipt", Before: 1, After: 2})
+	got, err := repo.Get("first")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if len(got.Changes) != 0 {
+		t.Errorf("Get() changes = %v, want none before Update()", got.Changes)
+	}
+	got.Processed = 10
+	if again, _ := repo.Get("first"); again.Processed != 0 {
+		t.Errorf("Get() processed = %d, want 0 before Update()", again.Processed)
+	}
+	if err := repo.Update(first); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if got, _ := repo.Get("first"); len(got.Changes) != 1 {
+		t.Errorf("Get() after Update() changes = %v, want 1", got.Changes)
+	}
+	if err := repo.Update(&receipt.RescoreJob{Id: "missing"}); !errors.Is(err, receipt.ErrNotFound) {
+		t.Errorf("Update() of a missing job error = %v, want ErrNotFound", err)
+	}
+
+	jobs, err := repo.List()
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(jobs) != 2 || jobs[0].Id != "second" || jobs[1].Id != "first" {
+		t.Errorf("List() = %v, want the newest job first", jobs)
+	}
+}
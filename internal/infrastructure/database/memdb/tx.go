@@ -0,0 +1,189 @@
+package memdb
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrConflict is matched by a ConflictError.
+var ErrConflict = errors.New("memdb: conflict")
+
+// ConflictError reports that a transaction read a key that was written by
+// someone else before the transaction committed. It matches ErrConflict.
+type ConflictError struct {
+	Key string
+	// Read is the version the transaction read, and Stored the version
+	// stored when it tried to commit. 0 means there was no value.
+	Read   uint64
+	Stored uint64
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("memdb: conflict on %s: read version %d, now at version %d", e.Key, e.Read, e.Stored)
+}
+
+func (e *ConflictError) Unwrap() error {
+	return ErrConflict
+}
+
+// Tx is a transaction: a set of writes applied together, or not at all,
+// on Commit. Its reads see its own writes. It is optimistic: it takes no
+// locks until Commit, which fails with a *ConflictError if any key it read
+// has been written since. A Tx must not be used from several goroutines at
+// once.
+type Tx[K Key, V any] struct {
+	db *DB[K, V]
+	// reads holds the version of every key the transaction read, as it was
+	// when it first read it.
+	reads  map[K]uint64
+	writes map[K]txWrite[V]
+	// order is the order the keys were first written in, which the log
+	// keeps.
+	order []K
+	done  bool
+}
+
+type txWrite[V any] struct {
+	value   V
+	deleted bool
+}
+
+// Begin starts a transaction.
+func (d *DB[K, V]) Begin() *Tx[K, V] {
+	return &Tx[K, V]{
+		db:     d,
+		reads:  make(map[K]uint64),
+		writes: make(map[K]txWrite[V]),
+	}
+}
+
+// Update runs fn in a transaction, and commits it if fn returns nil or
+// rolls it back otherwise.
+func (d *DB[K, V]) Update(fn func(tx *Tx[K, V]) error) error {
+	tx := d.Begin()
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Get returns the value of key as the transaction sees it, and whether
+// there is one.
+func (tx *Tx[K, V]) Get(key K) (V, bool) {
+	value, _, ok := tx.GetVersion(key)
+	return value, ok
+}
+
+// GetVersion returns the value of key as the transaction sees it, and the
+// version stored when the transaction first read it. Commit fails if the
+// key has been written since.
+func (tx *Tx[K, V]) GetVersion(key K) (V, uint64, bool) {
+	d := tx.db
+	d.mu.RLock()
+	value, ok := d.store[key]
+	version := d.version(key)
+	d.mu.RUnlock()
+
+	if read, seen := tx.reads[key]; seen {
+		version = read
+	} else {
+		tx.reads[key] = version
+	}
+	if write, written := tx.writes[key]; written {
+		return write.value, version, !write.deleted
+	}
+	return value, version, ok
+}
+
+// Set stores a value under key when the transaction commits.
+func (tx *Tx[K, V]) Set(key K, value V) error {
+	return tx.write(key, txWrite[V]{value: value})
+}
+
+// Delete deletes the value of key, if there is one, when the transaction
+// commits.
+func (tx *Tx[K, V]) Delete(key K) error {
+	return tx.write(key, txWrite[V]{deleted: true})
+}
+
+func (tx *Tx[K, V]) write(key K, write txWrite[V]) error {
+	if tx.done {
+		return ErrTxDone
+	}
+	if _, written := tx.writes[key]; !written {
+		tx.order = append(tx.order, key)
+	}
+	tx.writes[key] = write
+	return nil
+}
+
+// Commit checks that no key the transaction read has been written since,
+// then applies its writes, each advancing the version of its key. A
+// persistent DB logs them as one record, so that they survive a crash
+// together or not at all.
+func (tx *Tx[K, V]) Commit() error {
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.done = true
+
+	d := tx.db
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return ErrClosed
+	}
+	for key, read := range tx.reads {
+		if stored := d.version(key); stored != read {
+			return &ConflictError{Key: formatKey(key), Read: read, Stored: stored}
+		}
+	}
+
+	// Deleting a key that has no value changes nothing.
+	keys := make([]K, 0, len(tx.order))
+	for _, key := range tx.order {
+		if _, ok := d.store[key]; ok || !tx.writes[key].deleted {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+
+	if d.log != nil {
+		records := make([][]byte, len(keys))
+		for i, key := range keys {
+			write := tx.writes[key]
+			if write.deleted {
+				records[i] = encodeDelete(formatKey(key), d.version(key)+1)
+				continue
+			}
+			data, err := d.codec.Encode(write.value)
+			if err != nil {
+				return fmt.Errorf("memdb: encoding %v: %w", key, err)
+			}
+			records[i] = encodePut(formatKey(key), d.version(key)+1, data)
+		}
+		if err := d.appendRecords(records); err != nil {
+			return err
+		}
+	}
+
+	for _, key := range keys {
+		if write := tx.writes[key]; write.deleted {
+			d.delete(key, d.version(key)+1)
+		} else {
+			d.set(key, write.value, d.version(key)+1)
+		}
+	}
+	d.maybeSnapshot()
+	return nil
+}
+
+// Rollback discards the transaction's writes. It does nothing once the
+// transaction has been committed.
+func (tx *Tx[K, V]) Rollback() {
+	tx.done = true
+	tx.writes = nil
+}
@@ -0,0 +1,218 @@
+package memdb
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTx(t *testing.T) {
+	db, err := New[string, int]()
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if err := db.Set("a", 1); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+
+	tx := db.Begin()
+	if value, version, ok := tx.GetVersion("a"); value != 1 || version != 1 || !ok {
+		t.Errorf("GetVersion() = %d, %d, %t, want 1, 1, true", value, version, ok)
+	}
+	tx.Set("a", 2)
+	tx.Set("b", 3)
+	tx.Delete("c")
+	if value, ok := tx.Get("a"); value != 2 || !ok {
+		t.Errorf("Get() in the transaction = %d, %t, want its own write 2, true", value, ok)
+	}
+	if value, _ := db.Get("a"); value != 1 {
+		t.Errorf("Get() outside the transaction = %d, want 1 until it commits", value)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit() error = %v", err)
+	}
+	if value, version, _ := db.GetVersion("a"); value != 2 || version != 2 {
+		t.Errorf("GetVersion() after Commit() = %d, %d, want 2, 2", value, version)
+	}
+	if err := tx.Commit(); !errors.Is(err, ErrTxDone) {
+		t.Errorf("Commit() again error = %v, want ErrTxDone", err)
+	}
+
+	// A key read by a transaction and written by someone else before it
+	// commits is a conflict, and none of the transaction's writes apply.
+	tx = db.Begin()
+	tx.Get("a")
+	tx.Set("a", 10)
+	tx.Set("d", 10)
+	if err := db.Set("a", 5); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	var conflict *ConflictError
+	if err := tx.Commit(); !errors.As(err, &conflict) || !errors.Is(err, ErrConflict) {
+		t.Fatalf("Commit() error = %v, want a ConflictError", err)
+	}
+	if conflict.Key != "a" || conflict.Read != 2 || conflict.Stored != 3 {
+		t.Errorf("Commit() conflict = %+v, want a read at 2, stored at 3", conflict)
+	}
+	if _, ok := db.Get("d"); ok {
+		t.Errorf("Get() of a write of the conflicting transaction found it")
+	}
+
+	// Keys only written are not checked.
+	tx = db.Begin()
+	tx.Set("a", 6)
+	if err := db.Set("a", 7); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Errorf("Commit() of a blind write error = %v", err)
+	}
+
+	tx = db.Begin()
+	tx.Set("e", 1)
+	tx.Rollback()
+	if err := tx.Commit(); !errors.Is(err, ErrTxDone) {
+		t.Errorf("Commit() after Rollback() error = %v, want ErrTxDone", err)
+	}
+	if _, ok := db.Get("e"); ok {
+		t.Errorf("Get() of a rolled back write found it")
+	}
+}
+
+func TestTx_DeleteAndRecreate(t *testing.T) {
+	dir := t.TempDir()
+	open := func() *DB[string, int] {
+		t.Helper()
+		db, err := New[string, int](WithPersistence(dir, JSONCodec[int]{}))
+		if err != nil {
+			t.Fatalf("New() error = %v", err)
+		}
+		return db
+	}
+
+	db := open()
+	if err := db.Set("a", 1); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+
+	// A key deleted and stored again under the same value does not go back
+	// to the version a transaction read.
+	tx := db.Begin()
+	tx.Get("a")
+	tx.Set("a", 2)
+	if err := db.Delete("a"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if value, version, ok := db.GetVersion("a"); value != 0 || version != 2 || ok {
+		t.Errorf("GetVersion() after Delete() = %d, %d, %t, want 0, 2, false", value, version, ok)
+	}
+	if err := db.Set("a", 1); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	var conflict *ConflictError
+	if err := tx.Commit(); !errors.As(err, &conflict) {
+		t.Fatalf("Commit() error = %v, want a ConflictError", err)
+	}
+	if conflict.Read != 1 || conflict.Stored != 3 {
+		t.Errorf("Commit() conflict = %+v, want a read at 1, stored at 3", conflict)
+	}
+
+	// The version of a deleted key survives a snapshot and reopening.
+	if err := db.Delete("a"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if err := db.Snapshot(); err != nil {
+		t.Fatalf("Snapshot() error = %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	db = open()
+	defer db.Close()
+	if value, version, ok := db.GetVersion("a"); value != 0 || version != 4 || ok {
+		t.Errorf("GetVersion() after reopening = %d, %d, %t, want 0, 4, false", value, version, ok)
+	}
+	if err := db.Set("a", 1); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if _, version, _ := db.GetVersion("a"); version != 5 {
+		t.Errorf("GetVersion() after storing it again = %d, want 5", version)
+	}
+}
+
+func TestTx_Persistence(t *testing.T) {
+	dir := t.TempDir()
+	open := func() *DB[string, int] {
+		t.Helper()
+		db, err := New[string, int](WithPersistence(dir, JSONCodec[int]{}))
+		if err != nil {
+			t.Fatalf("New() error = %v", err)
+		}
+		return db
+	}
+
+	db := open()
+	db.Set("a", 1)
+	db.Set("a", 2)
+	err := db.Update(func(tx *Tx[string, int]) error {
+		tx.Set("a", 3)
+		tx.Set("b", 1)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if err := db.Snapshot(); err != nil {
+		t.Fatalf("Snapshot() error = %v", err)
+	}
+	err = db.Update(func(tx *Tx[string, int]) error {
+		tx.Set("b", 2)
+		tx.Delete("a")
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	db.Set("c", 1)
+	// Stop without closing, and tear the last transaction's record, as a
+	// crash while it was being written would.
+	err = db.Update(func(tx *Tx[string, int]) error {
+		tx.Set("c", 2)
+		tx.Set("d", 1)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	path := filepath.Join(dir, logFile)
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if err := os.Truncate(path, info.Size()-3); err != nil {
+		t.Fatalf("Truncate() error = %v", err)
+	}
+
+	db = open()
+	defer db.Close()
+	if db.Recovery().TruncatedBytes == 0 {
+		t.Errorf("Recovery() = %+v, want the torn record truncated", db.Recovery())
+	}
+	for _, tt := range []struct {
+		key     string
+		value   int
+		version uint64
+		ok      bool
+	}{
+		{"a", 0, 4, false},
+		{"b", 2, 2, true},
+		// c and d are after a's delete, so they start above it.
+		{"c", 1, 5, true},
+		{"d", 0, 4, false},
+	} {
+		if value, version, ok := db.GetVersion(tt.key); value != tt.value || version != tt.version || ok != tt.ok {
+			t.Errorf("GetVersion(%q) = %d, %d, %t, want %d, %d, %t", tt.key, value, version, ok, tt.value, tt.version, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package sqldb
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Dialect covers what the repositories' SQL needs that databases write
+// differently. Everything else sticks to SQL that SQLite and Postgres
+// share.
+type Dialect interface {
+	// Placeholder returns the parameter placeholder for the n-th argument,
+	// counting from 1.
+	Placeholder(n int) string
+	// Binary returns a text expression that compares and sorts byte by
+	// byte, as Go compares strings, whatever the database's collation.
+	Binary(expr string) string
+}
+
+var (
+	SQLite   Dialect = sqlite{}
+	Postgres Dialect = postgres{}
+)
+
+// DialectFor returns the dialect of a database/sql driver name.
+func DialectFor(driver string) (Dialect, error) {
+	switch driver {
+	case "sqlite":
+		return SQLite, nil
+	case "postgres", "pgx":
+		return Postgres, nil
+	}
+	return nil, fmt.Errorf("unsupported database driver %q, expected sqlite, postgres or pgx", driver)
+}
+
+type sqlite struct{}
+
+func (sqlite) Placeholder(int) string {
+	return "?"
+}
+
+// Binary returns expr as is, since SQLite compares text byte by byte
+// unless told otherwise.
+func (sqlite) Binary(expr string) string {
+	return expr
+}
+
+type postgres struct{}
+
+func (postgres) Placeholder(n int) string {
+	return "$" + strconv.Itoa(n)
+}
+
+func (postgres) Binary(expr string) string {
+	return expr + ` COLLATE "C"`
+}
@@ -0,0 +1,30 @@
+CREATE TABLE receipts (
+    id               VARCHAR(36) PRIMARY KEY,
+    retailer         TEXT NOT NULL,
+    -- Times are Unix nanoseconds, which sort and compare the same way in
+    -- every database.
+    purchased_at     BIGINT NOT NULL,
+    time_zone        TEXT NOT NULL,
+    total_cents      BIGINT NOT NULL,
+    points           BIGINT NOT NULL,
+    ruleset_version  TEXT NOT NULL,
+    -- The points breakdown is only ever read whole, so it is kept as JSON.
+    points_breakdown TEXT NOT NULL,
+    created_at       BIGINT NOT NULL
+);
+
+CREATE INDEX receipts_created_at ON receipts (created_at, id);
+CREATE INDEX receipts_purchased_at ON receipts (purchased_at, id);
+CREATE INDEX receipts_retailer ON receipts (retailer, id);
+CREATE INDEX receipts_total ON receipts (total_cents, id);
+CREATE INDEX receipts_points ON receipts (points, id);
+
+CREATE TABLE items (
+    id                VARCHAR(36) PRIMARY KEY,
+    receipt_id        VARCHAR(36) NOT NULL REFERENCES receipts (id) ON DELETE CASCADE,
+    position          INTEGER NOT NULL,
+    short_description TEXT NOT NULL,
+    price_cents       BIGINT NOT NULL
+);
+
+CREATE INDEX items_receipt_id ON items (receipt_id, position);
@@ -0,0 +1,3 @@
+-- version counts the writes of a receipt, for updates conditional on the
+-- version the caller read. Existing receipts start at 1.
+ALTER TABLE receipts ADD COLUMN version BIGINT NOT NULL DEFAULT 1;
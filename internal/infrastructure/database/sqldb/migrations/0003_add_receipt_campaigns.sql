@@ -0,0 +1,3 @@
+-- campaigns holds the campaigns that scored a receipt, as they were then,
+-- as JSON. Receipts scored before it existed have none recorded.
+ALTER TABLE receipts ADD COLUMN campaigns TEXT NOT NULL DEFAULT '[]';
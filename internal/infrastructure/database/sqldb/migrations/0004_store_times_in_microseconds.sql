@@ -0,0 +1,4 @@
+-- Unix nanoseconds only reach the years 1678 to 2262, while a receipt may
+-- be dated anywhere in 0000 to 9999. Times are Unix microseconds from here
+-- on, which reach far past both.
+UPDATE receipts SET purchased_at = purchased_at / 1000, created_at = created_at / 1000;
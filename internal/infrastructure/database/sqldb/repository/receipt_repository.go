@@ -0,0 +1,566 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"receipt-processor/internal/domain/receipt"
+	"receipt-processor/internal/infrastructure/database/sqldb"
+	"strings"
+	"time"
+)
+
+var _ receipt.Repository = (*ReceiptRepository)(nil)
+
+// ReceiptRepository keeps receipts in the receipts and items tables, and
+// filters, sorts and pages them in the database.
+type ReceiptRepository struct {
+	db *sqldb.DB
+}
+
+func NewReceiptRepository(db *sqldb.DB) *ReceiptRepository {
+	return &ReceiptRepository{
+		db: db,
+	}
+}
+
+const receiptColumns = `id, retailer, purchased_at, time_zone, total_cents, points, ruleset_version, points_breakdown, campaigns, created_at, version`
+
+// sortColumns are the columns holding each sort field.
+var sortColumns = map[receipt.SortField]string{
+	receipt.SortByCreatedAt:        "created_at",
+	receipt.SortByPurchaseDateTime: "purchased_at",
+	receipt.SortByRetailer:         "retailer",
+	receipt.SortByTotal:            "total_cents",
+	receipt.SortByPoints:           "points",
+}
+
+func (r *ReceiptRepository) Create(rec *receipt.Receipt) (*receipt.Receipt, error) {
+	row, err := newReceiptRow(rec)
+	if err != nil {
+		return nil, err
+	}
+	row.version = 1
+
+	err = r.inTx(func(tx *sql.Tx) error {
+		q := r.query()
+		q.printf(`INSERT INTO receipts (%s) VALUES (%s)`, receiptColumns, q.args(row.values()...))
+		if _, err := tx.Exec(q.String(), q.params...); err != nil {
+			return err
+		}
+		return r.insertItems(tx, rec)
+	})
+	if err != nil {
+		// Checking after the fact keeps to SQL every database shares,
+		// rather than matching each driver's unique violation error.
+		if existing, findErr := r.find(rec.Id.String()); findErr == nil && existing != nil {
+			return nil, fmt.Errorf("receipt %s: %w", rec.Id, receipt.ErrConflict)
+		}
+		return nil, wrapError(err)
+	}
+	rec.Version = row.version
+	truncateTimes(rec)
+	return rec, nil
+}
+
+func (r *ReceiptRepository) Get(id string) (*receipt.Receipt, error) {
+	rec, err := r.find(id)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", receipt.ErrUnavailable, err)
+	}
+	if rec == nil {
+		return nil, fmt.Errorf("receipt %s: %w", id, receipt.ErrNotFound)
+	}
+	return rec, nil
+}
+
+func (r *ReceiptRepository) Update(id string, rec *receipt.Receipt) (*receipt.Receipt, error) {
+	row, err := newReceiptRow(rec)
+	if err != nil {
+		return nil, err
+	}
+
+	var version int64
+	err = r.inTx(func(tx *sql.Tx) error {
+		// Reading the version in the transaction that writes it keeps a
+		// concurrent update from slipping in between.
+		q := r.query()
+		q.printf(`SELECT version FROM receipts WHERE id = %s`, q.arg(id))
+		if err := tx.QueryRow(q.String(), q.params...).Scan(&version); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return fmt.Errorf("receipt %s: %w", id, receipt.ErrNotFound)
+			}
+			return err
+		}
+		if rec.Version != 0 && rec.Version != version {
+			return &receipt.VersionConflictError{Id: id, Expected: rec.Version, Actual: version}
+		}
+
+		q = r.query()
+		q.printf(`UPDATE receipts SET retailer = %s, purchased_at = %s, time_zone = %s, total_cents = %s, points = %s, ruleset_version = %s, points_breakdown = %s, campaigns = %s, created_at = %s, version = version + 1 WHERE id = %s AND version = %s`,
+			q.arg(row.retailer), q.arg(row.purchasedAt), q.arg(row.timeZone), q.arg(row.totalCents), q.arg(row.points),
+			q.arg(row.rulesetVersion), q.arg(row.pointsBreakdown), q.arg(row.campaigns), q.arg(row.createdAt), q.arg(id), q.arg(version))
+		if err := execOne(tx, q, id); err != nil {
+			if errors.Is(err, receipt.ErrNotFound) {
+				// The receipt was written after it was read.
+				return &receipt.VersionConflictError{Id: id, Expected: version}
+			}
+			return err
+		}
+		if err := r.deleteItems(tx, id); err != nil {
+			return err
+		}
+		return r.insertItems(tx, rec)
+	})
+	if err != nil {
+		return nil, wrapError(err)
+	}
+	rec.Version = version + 1
+	truncateTimes(rec)
+	return rec, nil
+}
+
+func (r *ReceiptRepository) Delete(id string, version int64) error {
+	err := r.inTx(func(tx *sql.Tx) error {
+		if err := r.deleteItems(tx, id); err != nil {
+			return err
+		}
+		q := r.query()
+		if version == 0 {
+			q.printf(`DELETE FROM receipts WHERE id = %s`, q.arg(id))
+			return execOne(tx, q, id)
+		}
+
+		q.printf(`DELETE FROM receipts WHERE id = %s AND version = %s`, q.arg(id), q.arg(version))
+		err := execOne(tx, q, id)
+		if !errors.Is(err, receipt.ErrNotFound) {
+			return err
+		}
+		// Nothing was deleted: tell a receipt at another version from one
+		// that is gone.
+		var stored int64
+		q = r.query()
+		q.printf(`SELECT version FROM receipts WHERE id = %s`, q.arg(id))
+		if err := tx.QueryRow(q.String(), q.params...).Scan(&stored); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return fmt.Errorf("receipt %s: %w", id, receipt.ErrNotFound)
+			}
+			return err
+		}
+		return &receipt.VersionConflictError{Id: id, Expected: version, Actual: stored}
+	})
+	return wrapError(err)
+}
+
+func (r *ReceiptRepository) List(query receipt.ListQuery) (*receipt.Page, error) {
+	if err := query.Normalize(); err != nil {
+		return nil, err
+	}
+	position, err := query.Position()
+	if err != nil {
+		return nil, err
+	}
+
+	// The purchase date filter compares the date where each receipt was
+	// made, which the table does not hold; the purchase time bounds
+	// Normalize derives from it narrow the rows read, and the rest are
+	// dropped here, reading on past them until the page is full.
+	var receipts []*receipt.Receipt
+	for {
+		batch, err := r.listBatch(query, position)
+		if err != nil {
+			return nil, err
+		}
+		for _, rec := range batch {
+			if query.Matches(rec) {
+				receipts = append(receipts, rec)
+			}
+		}
+		if len(receipts) > query.Limit || len(batch) <= query.Limit {
+			break
+		}
+		position = batch[len(batch)-1]
+	}
+
+	return query.Page(receipts), nil
+}
+
+// listBatch returns up to one more than the query's limit of the receipts
+// matching its filters other than the purchase date, in its order after
+// position.
+func (r *ReceiptRepository) listBatch(query receipt.ListQuery, position *receipt.Receipt) ([]*receipt.Receipt, error) {
+	q := r.query()
+	q.printf(`SELECT %s FROM receipts WHERE 1 = 1`, receiptColumns)
+	if query.Retailer != "" {
+		q.printf(` AND retailer = %s`, q.arg(query.Retailer))
+	}
+	if query.PurchasedFrom != nil {
+		q.printf(` AND purchased_at >= %s`, q.arg(query.PurchasedFrom.UnixMicro()))
+	}
+	if query.PurchasedTo != nil {
+		q.printf(` AND purchased_at < %s`, q.arg(query.PurchasedTo.UnixMicro()))
+	}
+	if query.MinTotal != nil {
+		q.printf(` AND total_cents >= %s`, q.arg(query.MinTotal.Shift(2).Ceil().IntPart()))
+	}
+	if query.MaxTotal != nil {
+		q.printf(` AND total_cents <= %s`, q.arg(query.MaxTotal.Shift(2).Floor().IntPart()))
+	}
+	if query.MinPoints != nil {
+		q.printf(` AND points >= %s`, q.arg(*query.MinPoints))
+	}
+	if query.MaxPoints != nil {
+		q.printf(` AND points <= %s`, q.arg(*query.MaxPoints))
+	}
+
+	column := sortColumns[query.SortBy]
+	sortExpr, idExpr := column, r.db.Dialect.Binary("id")
+	if query.SortBy == receipt.SortByRetailer {
+		sortExpr = r.db.Dialect.Binary(column)
+	}
+	direction, after := "ASC", ">"
+	if query.Order == receipt.SortDescending {
+		direction, after = "DESC", "<"
+	}
+	if position != nil {
+		key, err := sortKey(query.SortBy, position)
+		if err != nil {
+			return nil, err
+		}
+		q.printf(` AND (%[1]s %[2]s %[3]s OR (%[1]s = %[4]s AND %[5]s %[2]s %[6]s))`,
+			sortExpr, after, q.arg(key), q.arg(key), idExpr, q.arg(position.Id.String()))
+	}
+	q.printf(` ORDER BY %s %s, %s %s LIMIT %d`, sortExpr, direction, idExpr, direction, query.Limit+1)
+
+	receipts, err := r.queryReceipts(q)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", receipt.ErrUnavailable, err)
+	}
+	return receipts, nil
+}
+
+// find returns the receipt stored under id, or nil if there is none.
+func (r *ReceiptRepository) find(id string) (*receipt.Receipt, error) {
+	q := r.query()
+	q.printf(`SELECT %s FROM receipts WHERE id = %s`, receiptColumns, q.arg(id))
+	receipts, err := r.queryReceipts(q)
+	if err != nil || len(receipts) == 0 {
+		return nil, err
+	}
+	return receipts[0], nil
+}
+
+// queryReceipts runs a query selecting receiptColumns and loads the items
+// of the receipts it returns, in one read-only transaction, so that a
+// receipt written meanwhile is not read with the items of another version.
+func (r *ReceiptRepository) queryReceipts(q *query) ([]*receipt.Receipt, error) {
+	var receipts []*receipt.Receipt
+	err := r.inReadTx(func(tx *sql.Tx) error {
+		var err error
+		receipts, err = r.readReceipts(tx, q)
+		return err
+	})
+	return receipts, err
+}
+
+func (r *ReceiptRepository) readReceipts(tx *sql.Tx, q *query) ([]*receipt.Receipt, error) {
+	rows, err := tx.Query(q.String(), q.params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var receipts []*receipt.Receipt
+	byId := make(map[string]*receipt.Receipt)
+	for rows.Next() {
+		var row receiptRow
+		if err := rows.Scan(row.pointers()...); err != nil {
+			return nil, err
+		}
+		rec, err := row.toReceipt()
+		if err != nil {
+			return nil, err
+		}
+		receipts = append(receipts, rec)
+		byId[row.id] = rec
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(receipts) == 0 {
+		return receipts, nil
+	}
+
+	items := r.query()
+	ids := make([]any, len(receipts))
+	for i, rec := range receipts {
+		ids[i] = rec.Id.String()
+	}
+	items.printf(`SELECT receipt_id, id, short_description, price_cents FROM items WHERE receipt_id IN (%s) ORDER BY receipt_id, position`, items.args(ids...))
+	itemRows, err := tx.Query(items.String(), items.params...)
+	if err != nil {
+		return nil, err
+	}
+	defer itemRows.Close()
+
+	for itemRows.Next() {
+		var (
+			receiptId, id, description string
+			priceCents                 int64
+		)
+		if err := itemRows.Scan(&receiptId, &id, &description, &priceCents); err != nil {
+			return nil, err
+		}
+		itemId, err := uuid.Parse(id)
+		if err != nil {
+			return nil, fmt.Errorf("item %s: %w", id, err)
+		}
+		rec := byId[receiptId]
+		rec.Items = append(rec.Items, receipt.Item{
+			Id:               itemId,
+			ShortDescription: description,
+			Price:            decimal.New(priceCents, -2),
+		})
+	}
+	return receipts, itemRows.Err()
+}
+
+func (r *ReceiptRepository) insertItems(tx *sql.Tx, rec *receipt.Receipt) error {
+	for i, item := range rec.Items {
+		cents, err := toCents(item.Price)
+		if err != nil {
+			return err
+		}
+		q := r.query()
+		q.printf(`INSERT INTO items (id, receipt_id, position, short_description, price_cents) VALUES (%s)`,
+			q.args(item.Id.String(), rec.Id.String(), i, item.ShortDescription, cents))
+		if _, err := tx.Exec(q.String(), q.params...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *ReceiptRepository) deleteItems(tx *sql.Tx, receiptId string) error {
+	q := r.query()
+	q.printf(`DELETE FROM items WHERE receipt_id = %s`, q.arg(receiptId))
+	_, err := tx.Exec(q.String(), q.params...)
+	return err
+}
+
+func (r *ReceiptRepository) inTx(fn func(tx *sql.Tx) error) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// readTxOptions make the statements of a read see one snapshot of the
+// database; a transaction in postgres only does at repeatable read.
+var readTxOptions = &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true}
+
+func (r *ReceiptRepository) inReadTx(fn func(tx *sql.Tx) error) error {
+	tx, err := r.db.BeginTx(context.Background(), readTxOptions)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *ReceiptRepository) query() *query {
+	return &query{dialect: r.db.Dialect}
+}
+
+// execOne runs a statement that must change the receipt with the id.
+func execOne(tx *sql.Tx, q *query, id string) error {
+	result, err := tx.Exec(q.String(), q.params...)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("receipt %s: %w", id, receipt.ErrNotFound)
+	}
+	return nil
+}
+
+// wrapError reports database errors as receipt.ErrUnavailable and passes
+// domain errors through.
+func wrapError(err error) error {
+	if err == nil || errors.Is(err, receipt.ErrNotFound) || errors.Is(err, receipt.ErrInvalidInput) || errors.Is(err, receipt.ErrConflict) {
+		return err
+	}
+	return fmt.Errorf("%w: %w", receipt.ErrUnavailable, err)
+}
+
+// sortKey returns the value of the sort column at a cursor position.
+func sortKey(field receipt.SortField, position *receipt.Receipt) (any, error) {
+	switch field {
+	case receipt.SortByPurchaseDateTime:
+		return position.PurchaseDateTime.UnixMicro(), nil
+	case receipt.SortByRetailer:
+		return position.Retailer, nil
+	case receipt.SortByTotal:
+		return toCents(position.Total)
+	case receipt.SortByPoints:
+		return position.Points, nil
+	default:
+		return position.CreatedAt.UnixMicro(), nil
+	}
+}
+
+// truncateTimes truncates a stored receipt's times to the microseconds the
+// table holds, so that it is returned as it will be read back.
+func truncateTimes(rec *receipt.Receipt) {
+	rec.PurchaseDateTime = rec.PurchaseDateTime.Truncate(time.Microsecond)
+	rec.CreatedAt = rec.CreatedAt.Truncate(time.Microsecond)
+}
+
+// toCents converts an amount to the whole cents it is stored as.
+func toCents(amount decimal.Decimal) (int64, error) {
+	cents := amount.Shift(2)
+	if !cents.IsInteger() {
+		return 0, fmt.Errorf("%w: amount %s has fractions of a cent", receipt.ErrInvalidInput, amount)
+	}
+	return cents.IntPart(), nil
+}
+
+// receiptRow is a row of the receipts table.
+type receiptRow struct {
+	id              string
+	retailer        string
+	purchasedAt     int64
+	timeZone        string
+	totalCents      int64
+	points          int64
+	rulesetVersion  string
+	pointsBreakdown string
+	campaigns       string
+	createdAt       int64
+	version         int64
+}
+
+func newReceiptRow(rec *receipt.Receipt) (*receiptRow, error) {
+	totalCents, err := toCents(rec.Total)
+	if err != nil {
+		return nil, err
+	}
+	breakdown, err := json.Marshal(rec.PointsBreakdown)
+	if err != nil {
+		return nil, fmt.Errorf("receipt %s: points breakdown: %w", rec.Id, err)
+	}
+	campaigns, err := json.Marshal(rec.Campaigns)
+	if err != nil {
+		return nil, fmt.Errorf("receipt %s: campaigns: %w", rec.Id, err)
+	}
+	return &receiptRow{
+		id:              rec.Id.String(),
+		retailer:        rec.Retailer,
+		purchasedAt:     rec.PurchaseDateTime.UnixMicro(),
+		timeZone:        rec.TimeZone,
+		totalCents:      totalCents,
+		points:          rec.Points,
+		rulesetVersion:  rec.RulesetVersion,
+		pointsBreakdown: string(breakdown),
+		campaigns:       string(campaigns),
+		createdAt:       rec.CreatedAt.UnixMicro(),
+		version:         rec.Version,
+	}, nil
+}
+
+// values returns the row's values in the order of receiptColumns.
+func (r *receiptRow) values() []any {
+	return []any{r.id, r.retailer, r.purchasedAt, r.timeZone, r.totalCents, r.points, r.rulesetVersion, r.pointsBreakdown, r.campaigns, r.createdAt, r.version}
+}
+
+// pointers returns pointers to the row's fields in the order of
+// receiptColumns, for Scan.
+func (r *receiptRow) pointers() []any {
+	return []any{&r.id, &r.retailer, &r.purchasedAt, &r.timeZone, &r.totalCents, &r.points, &r.rulesetVersion, &r.pointsBreakdown, &r.campaigns, &r.createdAt, &r.version}
+}
+
+func (r *receiptRow) toReceipt() (*receipt.Receipt, error) {
+	id, err := uuid.Parse(r.id)
+	if err != nil {
+		return nil, fmt.Errorf("receipt %s: %w", r.id, err)
+	}
+
+	// The row keeps the instant of the purchase; its wall clock, which the
+	// rules read, is that of the receipt's time zone.
+	purchasedAt := time.UnixMicro(r.purchasedAt).UTC()
+	if r.timeZone != "" {
+		zone, err := receipt.ParseTimeZone(r.timeZone)
+		if err != nil {
+			return nil, fmt.Errorf("receipt %s: %w", r.id, err)
+		}
+		purchasedAt = purchasedAt.In(zone)
+	}
+
+	rec := &receipt.Receipt{
+		Id:               id,
+		Retailer:         r.retailer,
+		PurchaseDateTime: purchasedAt,
+		TimeZone:         r.timeZone,
+		Total:            decimal.New(r.totalCents, -2),
+		Points:           r.points,
+		RulesetVersion:   r.rulesetVersion,
+		CreatedAt:        time.UnixMicro(r.createdAt).UTC(),
+		Version:          r.version,
+	}
+	if err := json.Unmarshal([]byte(r.pointsBreakdown), &rec.PointsBreakdown); err != nil {
+		return nil, fmt.Errorf("receipt %s: points breakdown: %w", r.id, err)
+	}
+	if err := json.Unmarshal([]byte(r.campaigns), &rec.Campaigns); err != nil {
+		return nil, fmt.Errorf("receipt %s: campaigns: %w", r.id, err)
+	}
+	return rec, nil
+}
+
+// query builds a statement and its parameters, numbering placeholders as
+// the dialect wants.
+type query struct {
+	dialect sqldb.Dialect
+	sql     strings.Builder
+	params  []any
+}
+
+func (q *query) printf(format string, args ...any) {
+	fmt.Fprintf(&q.sql, format, args...)
+}
+
+// arg adds a parameter and returns its placeholder.
+func (q *query) arg(value any) string {
+	q.params = append(q.params, value)
+	return q.dialect.Placeholder(len(q.params))
+}
+
+// args adds parameters and returns their placeholders separated by commas.
+func (q *query) args(values ...any) string {
+	placeholders := make([]string, len(values))
+	for i, value := range values {
+		placeholders[i] = q.arg(value)
+	}
+	return strings.Join(placeholders, ", ")
+}
+
+func (q *query) String() string {
+	return q.sql.String()
+}
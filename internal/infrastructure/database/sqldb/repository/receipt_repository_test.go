@@ -0,0 +1,281 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	_ "modernc.org/sqlite"
+	"path/filepath"
+	"receipt-processor/internal/domain/receipt"
+	"receipt-processor/internal/infrastructure/database/sqldb"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// newTestRepository returns a repository on a fresh SQLite database with
+// the migrations applied.
+func newTestRepository(t *testing.T) *ReceiptRepository {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "receipts.db")
+	db, err := sqldb.Open("sqlite", "file:"+path+"?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)")
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if _, err := db.Migrate(context.Background()); err != nil {
+		t.Fatalf("Migrate() error = %v", err)
+	}
+	return NewReceiptRepository(db)
+}
+
+func newTestReceipt(retailer string, purchased time.Time, total string, points int64) *receipt.Receipt {
+	price := decimal.RequireFromString(total)
+	return &receipt.Receipt{
+		Id:               uuid.New(),
+		Retailer:         retailer,
+		PurchaseDateTime: purchased,
+		TimeZone:         purchased.Location().String(),
+		Items: []receipt.Item{
+			{Id: uuid.New(), ShortDescription: "Mountain Dew 12PK", Price: price.Sub(decimal.New(100, -2))},
+			{Id: uuid.New(), ShortDescription: "Gum", Price: decimal.New(100, -2)},
+		},
+		Total:          price,
+		Points:         points,
+		RulesetVersion: "v1",
+		PointsBreakdown: []receipt.RuleResult{
+			{Description: "Retailer", Points: points, ExactPoints: decimal.NewFromInt(points), Inputs: map[string]string{"retailer": retailer}},
+		},
+		Campaigns: []receipt.Campaign{{
+			Id:         uuid.New(),
+			Revision:   2,
+			Name:       "New Year",
+			StartsAt:   time.Date(2022, time.January, 1, 0, 0, 0, 0, time.UTC),
+			EndsAt:     time.Date(2022, time.February, 1, 0, 0, 0, 0, time.UTC),
+			Retailers:  []string{"Target"},
+			Rules:      []receipt.RuleSpec{{Type: "wholeNumberTotalBonus", Params: json.RawMessage(`{"points":5}`)}},
+			Multiplier: decimal.NewFromInt(2),
+		}},
+		CreatedAt: time.Now().UTC(),
+	}
+}
+
+func TestReceiptRepository_CRUD(t *testing.T) {
+	repo := newTestRepository(t)
+	chicago, err := receipt.ParseTimeZone("America/Chicago")
+	if err != nil {
+		t.Fatalf("ParseTimeZone() error = %v", err)
+	}
+	rec := newTestReceipt("Target", time.Date(2022, time.January, 1, 13, 1, 0, 0, chicago), "35.35", 28)
+
+	if _, err := repo.Create(rec); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if _, err := repo.Create(rec); !errors.Is(err, receipt.ErrConflict) {
+		t.Errorf("Create() again error = %v, want ErrConflict", err)
+	}
+
+	got, err := repo.Get(rec.Id.String())
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, rec) {
+		t.Errorf("Get() = %+v, want %+v", got, rec)
+	}
+	if got.PurchaseDateTime.Format("2006-01-02 15:04 MST") != "2022-01-01 13:01 CST" {
+		t.Errorf("Get() purchase time = %v, want the wall clock in America/Chicago", got.PurchaseDateTime)
+	}
+
+	updated := *rec
+	updated.Items = []receipt.Item{{Id: uuid.New(), ShortDescription: "Pizza", Price: decimal.RequireFromString("12.25")}}
+	updated.Total = decimal.RequireFromString("12.25")
+	updated.Points = 40
+	if _, err := repo.Update(rec.Id.String(), &updated); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	got, err = repo.Get(rec.Id.String())
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, &updated) {
+		t.Errorf("Get() after Update() = %+v, want %+v", got, &updated)
+	}
+	if got.Version != 2 {
+		t.Errorf("Get() after Update() version = %d, want 2", got.Version)
+	}
+
+	// An update based on a version that is no longer stored is rejected.
+	stale := updated
+	stale.Version = 1
+	stale.Points = 0
+	var conflict *receipt.VersionConflictError
+	if _, err := repo.Update(rec.Id.String(), &stale); !errors.As(err, &conflict) || conflict.Actual != 2 {
+		t.Errorf("Update() of version 1 error = %v, want a VersionConflictError at version 2", err)
+	}
+	if got, _ := repo.Get(rec.Id.String()); got.Points != updated.Points {
+		t.Errorf("Get() after a conflicting Update() points = %d, want %d", got.Points, updated.Points)
+	}
+
+	if err := repo.Delete(rec.Id.String(), 1); !errors.As(err, &conflict) || conflict.Actual != 2 {
+		t.Errorf("Delete() of version 1 error = %v, want a VersionConflictError at version 2", err)
+	}
+	if err := repo.Delete(rec.Id.String(), 2); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, err := repo.Get(rec.Id.String()); !errors.Is(err, receipt.ErrNotFound) {
+		t.Errorf("Get() after Delete() error = %v, want ErrNotFound", err)
+	}
+	if err := repo.Delete(rec.Id.String(), 0); !errors.Is(err, receipt.ErrNotFound) {
+		t.Errorf("Delete() again error = %v, want ErrNotFound", err)
+	}
+	if _, err := repo.Update(rec.Id.String(), &updated); !errors.Is(err, receipt.ErrNotFound) {
+		t.Errorf("Update() after Delete() error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestReceiptRepository_CreateRejectsFractionalCents(t *testing.T) {
+	repo := newTestRepository(t)
+	rec := newTestReceipt("Target", time.Date(2022, time.January, 1, 13, 1, 0, 0, time.UTC), "35.355", 28)
+
+	if _, err := repo.Create(rec); !errors.Is(err, receipt.ErrInvalidInput) {
+		t.Errorf("Create() error = %v, want ErrInvalidInput", err)
+	}
+}
+
+// TestReceiptRepository_DistantDates stores receipts dated at the ends of
+// the years a receipt may have, which Unix nanoseconds cannot hold, and
+// expects them back, in order.
+func TestReceiptRepository_DistantDates(t *testing.T) {
+	repo := newTestRepository(t)
+	dates := []time.Time{
+		time.Date(0, time.January, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(1600, time.June, 1, 12, 0, 0, 0, time.UTC),
+		time.Date(2022, time.January, 1, 13, 1, 0, 0, time.UTC),
+		time.Date(9999, time.December, 31, 23, 59, 0, 0, time.UTC),
+	}
+	for _, date := range dates {
+		rec := newTestReceipt("Target", date, "35.35", 28)
+		if _, err := repo.Create(rec); err != nil {
+			t.Fatalf("Create() error = %v", err)
+		}
+		got, err := repo.Get(rec.Id.String())
+		if err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+		if !got.PurchaseDateTime.Equal(date) {
+			t.Errorf("Get() purchase time = %v, want %v", got.PurchaseDateTime, date)
+		}
+	}
+
+	page, err := repo.List(receipt.ListQuery{SortBy: receipt.SortByPurchaseDateTime, Limit: len(dates)})
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(page.Receipts) != len(dates) {
+		t.Fatalf("List() returned %d receipts, want %d", len(page.Receipts), len(dates))
+	}
+	for i, rec := range page.Receipts {
+		if !rec.PurchaseDateTime.Equal(dates[i]) {
+			t.Errorf("List() receipt %d purchase time = %v, want %v", i, rec.PurchaseDateTime, dates[i])
+		}
+	}
+}
+
+// TestReceiptRepository_List pages through the receipts in every order and
+// with filters, and expects the pages receipt.Paginate returns for the
+// same receipts in memory.
+func TestReceiptRepository_List(t *testing.T) {
+	repo := newTestRepository(t)
+
+	retailers := []string{"Target", "Walgreens", "target", "CVS", "Ábaco"}
+	// Stores in these zones make purchases from 09:00 to 21:00 in UTC on
+	// different local dates.
+	var zones []*time.Location
+	for _, name := range []string{"UTC", "America/Chicago", "Asia/Tokyo"} {
+		zone, err := receipt.ParseTimeZone(name)
+		if err != nil {
+			t.Fatalf("ParseTimeZone() error = %v", err)
+		}
+		zones = append(zones, zone)
+	}
+	var receipts []*receipt.Receipt
+	start := time.Date(2024, time.January, 1, 9, 0, 0, 0, time.UTC)
+	for i := range 40 {
+		rec := newTestReceipt(
+			retailers[i%len(retailers)],
+			start.Add(time.Duration(i%13)*time.Hour).In(zones[i%len(zones)]),
+			fmt.Sprintf("%d.%02d", 2+i%7, i*7%100),
+			int64(i%9*5),
+		)
+		rec.CreatedAt = start.Add(time.Duration(i%17) * time.Minute)
+		if _, err := repo.Create(rec); err != nil {
+			t.Fatalf("Create() error = %v", err)
+		}
+		receipts = append(receipts, rec)
+	}
+
+	from := start.Add(2 * time.Hour)
+	to := start.Add(10 * time.Hour)
+	minTotal := decimal.RequireFromString("3.005")
+	maxTotal := decimal.RequireFromString("6.5")
+	minPoints, maxPoints := int64(10), int64(30)
+	filters := map[string]receipt.ListQuery{
+		"no filters":         {},
+		"retailer":           {Retailer: "Target"},
+		"purchase time":      {PurchasedFrom: &from, PurchasedTo: &to},
+		"purchase date":      {PurchaseDateFrom: "2024-01-01", PurchaseDateTo: "2024-01-01"},
+		"purchase date from": {PurchaseDateFrom: "2024-01-02"},
+		"total":              {MinTotal: &minTotal, MaxTotal: &maxTotal},
+		"points":             {MinPoints: &minPoints, MaxPoints: &maxPoints},
+	}
+	fields := []receipt.SortField{receipt.SortByCreatedAt, receipt.SortByPurchaseDateTime, receipt.SortByRetailer, receipt.SortByTotal, receipt.SortByPoints}
+
+	for name, filter := range filters {
+		for _, field := range fields {
+			for _, order := range []receipt.SortOrder{receipt.SortAscending, receipt.SortDescending} {
+				t.Run(fmt.Sprintf("%s by %s %s", name, field, order), func(t *testing.T) {
+					query := filter
+					query.SortBy = field
+					query.Order = order
+					query.Limit = 7
+
+					want := pageThrough(t, query, func(q receipt.ListQuery) (*receipt.Page, error) {
+						return receipt.Paginate(receipts, q)
+					})
+					got := pageThrough(t, query, repo.List)
+					if !reflect.DeepEqual(got, want) {
+						t.Errorf("List() = %v, want %v", got, want)
+					}
+				})
+			}
+		}
+	}
+
+	if _, err := repo.List(receipt.ListQuery{Cursor: "not a cursor"}); !errors.Is(err, receipt.ErrInvalidCursor) {
+		t.Errorf("List() error = %v, want ErrInvalidCursor", err)
+	}
+}
+
+// pageThrough returns the IDs of every receipt a listing returns, page
+// after page.
+func pageThrough(t *testing.T, query receipt.ListQuery, list func(receipt.ListQuery) (*receipt.Page, error)) []string {
+	t.Helper()
+	var ids []string
+	for {
+		page, err := list(query)
+		if err != nil {
+			t.Fatalf("List() error = %v", err)
+		}
+		for _, rec := range page.Receipts {
+			ids = append(ids, rec.Id.String())
+		}
+		if page.NextCursor == "" {
+			return ids
+		}
+		query.Cursor = page.NextCursor
+	}
+}
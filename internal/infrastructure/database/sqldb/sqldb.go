@@ -0,0 +1,145 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// DB is a database/sql database and the dialect of SQL it speaks.
+type DB struct {
+	*sql.DB
+	Dialect Dialect
+}
+
+// Open opens a database with a registered database/sql driver. The
+// driver's package must be imported by the program.
+func Open(driver, dsn string) (*DB, error) {
+	dialect, err := DialectFor(driver)
+	if err != nil {
+		return nil, err
+	}
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &DB{DB: db, Dialect: dialect}, nil
+}
+
+// Migration is a schema change, applied once, in order of Version.
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// Migrations returns the migrations embedded in the program, from the
+// files in migrations/ named like 0001_create_receipts.sql.
+func Migrations() ([]Migration, error) {
+	files, err := fs.Glob(migrations, "migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	var all []Migration
+	seen := make(map[int]string)
+	for _, file := range files {
+		name := strings.TrimSuffix(strings.TrimPrefix(file, "migrations/"), ".sql")
+		prefix, _, _ := strings.Cut(name, "_")
+		version, err := strconv.Atoi(prefix)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration %s: name must start with a positive version number", file)
+		}
+		if other, ok := seen[version]; ok {
+			return nil, fmt.Errorf("migrations %s and %s have the same version", other, name)
+		}
+		seen[version] = name
+
+		data, err := migrations.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, Migration{Version: version, Name: name, SQL: string(data)})
+	}
+
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].Version < all[j].Version
+	})
+	return all, nil
+}
+
+// Migrate applies the migrations the database has not had yet, each in a
+// transaction of its own, and returns their names. Applied versions are
+// recorded in schema_migrations.
+func (db *DB) Migrate(ctx context.Context) ([]string, error) {
+	all, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		applied_at BIGINT NOT NULL
+	)`); err != nil {
+		return nil, fmt.Errorf("creating schema_migrations: %w", err)
+	}
+
+	applied := make(map[int]bool)
+	rows, err := db.QueryContext(ctx, `SELECT version FROM schema_migrations`)
+	if err != nil {
+		return nil, fmt.Errorf("reading schema_migrations: %w", err)
+	}
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		applied[version] = true
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, migration := range all {
+		if applied[migration.Version] {
+			continue
+		}
+		if err := db.apply(ctx, migration); err != nil {
+			return names, fmt.Errorf("migration %s: %w", migration.Name, err)
+		}
+		names = append(names, migration.Name)
+	}
+	return names, nil
+}
+
+func (db *DB) apply(ctx context.Context, migration Migration) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, migration.SQL); err != nil {
+		return err
+	}
+	insert := fmt.Sprintf(`INSERT INTO schema_migrations (version, applied_at) VALUES (%s, %s)`, db.Dialect.Placeholder(1), db.Dialect.Placeholder(2))
+	if _, err := tx.ExecContext(ctx, insert, migration.Version, time.Now().UnixNano()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,38 @@
+package sqldb
+
+import (
+	"context"
+	_ "modernc.org/sqlite"
+	"path/filepath"
+	"testing"
+)
+
+func TestDB_Migrate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "receipts.db")
+	db, err := Open("sqlite", "file:"+path)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer db.Close()
+
+	migrations, err := Migrations()
+	if err != nil {
+		t.Fatalf("Migrations() error = %v", err)
+	}
+
+	applied, err := db.Migrate(context.Background())
+	if err != nil {
+		t.Fatalf("Migrate() error = %v", err)
+	}
+	if len(applied) != len(migrations) {
+		t.Errorf("Migrate() applied %v, want all %d migrations", applied, len(migrations))
+	}
+
+	applied, err = db.Migrate(context.Background())
+	if err != nil {
+		t.Fatalf("Migrate() again error = %v", err)
+	}
+	if len(applied) != 0 {
+		t.Errorf("Migrate() again applied %v, want none", applied)
+	}
+}
@@ -0,0 +1,100 @@
+package handler
+
+import (
+	"net/http"
+	"receipt-processor/internal/domain/receipt"
+)
+
+// AdminHandler serves the operations that act on many receipts at once.
+type AdminHandler struct {
+	receiptService *receipt.Service
+}
+
+func NewAdminHandler(receiptService *receipt.Service) *AdminHandler {
+	return &AdminHandler{
+		receiptService: receiptService,
+	}
+}
+
+func (h *AdminHandler) StartRescoreJob(w http.ResponseWriter, r *http.Request) {
+	var jobDTO receipt.CreateRescoreJobDTO
+	if err := decodeStrict(r, &jobDTO); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	query, err := jobDTO.ToListQuery()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	job, err := h.receiptService.StartRescoreJob(r.Context(), jobDTO.RulesetVersion, query)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Location", "/admin/jobs/"+job.Id)
+	writeJSON(w, receipt.NewRescoreJobResponseDTO(job), http.StatusAccepted)
+}
+
+func (h *AdminHandler) ListJobs(w http.ResponseWriter, r *http.Request) {
+	jobs, err := h.receiptService.ListRescoreJobs(r.Context())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, receipt.NewRescoreJobListResponseDTO(jobs), http.StatusOK)
+}
+
+func (h *AdminHandler) GetJob(w http.ResponseWriter, r *http.Request) {
+	job, err := h.receiptService.GetRescoreJob(r.Context(), r.PathValue("id"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, receipt.NewRescoreJobResponseDTO(job), http.StatusOK)
+}
+
+func (h *AdminHandler) CancelJob(w http.ResponseWriter, r *http.Request) {
+	job, err := h.receiptService.CancelRescoreJob(r.Context(), r.PathValue("id"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, receipt.NewRescoreJobResponseDTO(job), http.StatusOK)
+}
+
+// SimulateRuleset reports how a candidate ruleset would change the points
+// of the stored receipts, without changing them.
+func (h *AdminHandler) SimulateRuleset(w http.ResponseWriter, r *http.Request) {
+	var simulationDTO receipt.SimulateRulesetDTO
+	if err := decodeStrict(r, &simulationDTO); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	ruleset, err := simulationDTO.ToRuleset()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	query, err := simulationDTO.ToListQuery()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	simulation, err := h.receiptService.Simulate(r.Context(), ruleset, query)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, receipt.NewSimulationResponseDTO(simulation), http.StatusOK)
+}
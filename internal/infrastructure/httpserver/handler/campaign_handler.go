@@ -0,0 +1,86 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"receipt-processor/internal/domain/receipt"
+)
+
+type CampaignHandler struct {
+	receiptService *receipt.Service
+}
+
+func NewCampaignHandler(receiptService *receipt.Service) *CampaignHandler {
+	return &CampaignHandler{
+		receiptService: receiptService,
+	}
+}
+
+func (h *CampaignHandler) CreateCampaign(w http.ResponseWriter, r *http.Request) {
+	var campaignDTO receipt.CampaignDTO
+	if err := decodeStrict(r, &campaignDTO); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	campaign, err := h.receiptService.CreateCampaign(r.Context(), campaignDTO)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Location", "/campaigns/"+campaign.Id.String())
+	writeJSON(w, receipt.NewCampaignResponseDTO(campaign), http.StatusCreated)
+}
+
+func (h *CampaignHandler) ListCampaigns(w http.ResponseWriter, r *http.Request) {
+	campaigns, err := h.receiptService.ListCampaigns(r.Context())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, receipt.NewCampaignListResponseDTO(campaigns), http.StatusOK)
+}
+
+func (h *CampaignHandler) GetCampaign(w http.ResponseWriter, r *http.Request) {
+	campaign, err := h.receiptService.GetCampaign(r.Context(), r.PathValue("id"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, receipt.NewCampaignResponseDTO(campaign), http.StatusOK)
+}
+
+func (h *CampaignHandler) UpdateCampaign(w http.ResponseWriter, r *http.Request) {
+	var campaignDTO receipt.CampaignDTO
+	if err := decodeStrict(r, &campaignDTO); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	campaign, err := h.receiptService.UpdateCampaign(r.Context(), r.PathValue("id"), campaignDTO)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, receipt.NewCampaignResponseDTO(campaign), http.StatusOK)
+}
+
+func (h *CampaignHandler) DeleteCampaign(w http.ResponseWriter, r *http.Request) {
+	if err := h.receiptService.DeleteCampaign(r.Context(), r.PathValue("id")); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// decodeStrict decodes a JSON request body, rejecting unknown fields.
+func decodeStrict(r *http.Request, v interface{}) error {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(v)
+}
@@ -1,12 +1,44 @@
 package handler
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"log"
 	"net/http"
 	"receipt-processor/internal/domain/receipt"
 )
 
+// maxImportLineSize bounds the memory used to read one NDJSON line.
+const maxImportLineSize = 1 << 20
+
+// maxBatchEntrySize is how many bytes of a batch body each receipt the
+// batch may hold is allowed, which bounds the body as a whole.
+const maxBatchEntrySize = 64 << 10
+
+type batchResponse struct {
+	Succeeded int                  `json:"succeeded"`
+	Failed    int                  `json:"failed"`
+	Results   []batchEntryResponse `json:"results"`
+}
+
+type batchEntryResponse struct {
+	Index  int      `json:"index"`
+	Id     string   `json:"id,omitempty"`
+	Points *int64   `json:"points,omitempty"`
+	Error  *problem `json:"error,omitempty"`
+}
+
+type importLineResponse struct {
+	Line   int      `json:"line"`
+	Id     string   `json:"id,omitempty"`
+	Points *int64   `json:"points,omitempty"`
+	Error  *problem `json:"error,omitempty"`
+}
+
 type ReceiptHandler struct {
 	receiptService *receipt.Service
 }
@@ -20,18 +52,26 @@ func NewReceiptHandler(receiptService *receipt.Service) *ReceiptHandler {
 func (h *ReceiptHandler) CreateReceipt(w http.ResponseWriter, r *http.Request) {
 	var receiptDTO receipt.CreateReceiptDTO
 	if err := json.NewDecoder(r.Body).Decode(&receiptDTO); err != nil {
-		writeJSONError(w, err, http.StatusBadRequest)
+		writeProblem(w, r, http.StatusBadRequest, err)
 		return
 	}
 
-	if err := receiptDTO.Validate(); err != nil {
-		writeJSONError(w, err, http.StatusBadRequest)
+	if key := r.Header.Get("Idempotency-Key"); key != "" {
+		id, replayed, err := h.receiptService.CreateIdempotent(r.Context(), key, receiptDTO)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		if replayed {
+			w.Header().Set("Idempotent-Replayed", "true")
+		}
+		writeJSON(w, map[string]string{"id": id}, http.StatusCreated)
 		return
 	}
 
 	newReceipt, err := h.receiptService.Create(r.Context(), receiptDTO)
 	if err != nil {
-		writeJSONError(w, err, http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
@@ -39,19 +79,331 @@ func (h *ReceiptHandler) CreateReceipt(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, response, http.StatusCreated)
 }
 
+func (h *ReceiptHandler) CreateReceiptBatch(w http.ResponseWriter, r *http.Request) {
+	maxSize := h.receiptService.MaxBatchSize()
+	body := http.MaxBytesReader(w, r.Body, int64(maxSize)*maxBatchEntrySize)
+	receiptDTOs, err := decodeBatch(body, maxSize)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeProblem(w, r, http.StatusRequestEntityTooLarge, err)
+			return
+		}
+		writeProblem(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	results, err := h.receiptService.CreateBatch(r.Context(), receiptDTOs)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	response := batchResponse{Results: make([]batchEntryResponse, len(results))}
+	for i, result := range results {
+		entry := batchEntryResponse{Index: i}
+		if result.Err != nil {
+			p := newProblem(r, statusFromError(result.Err), result.Err)
+			entry.Error = &p
+			response.Failed++
+		} else {
+			entry.Id = result.Receipt.Id.String()
+			entry.Points = &result.Receipt.Points
+			response.Succeeded++
+		}
+		response.Results[i] = entry
+	}
+
+	writeJSON(w, response, http.StatusOK)
+}
+
+// decodeBatch reads a JSON array of receipts one at a time, and stops
+// with ErrInvalidInput at the first receipt past maxSize rather than
+// reading the rest.
+func decodeBatch(body io.Reader, maxSize int) ([]receipt.CreateReceiptDTO, error) {
+	decoder := json.NewDecoder(body)
+	if token, err := decoder.Token(); err != nil {
+		return nil, err
+	} else if token != json.Delim('[') {
+		return nil, fmt.Errorf("%w: batch must be an array of receipts", receipt.ErrInvalidInput)
+	}
+
+	var receiptDTOs []receipt.CreateReceiptDTO
+	for decoder.More() {
+		if len(receiptDTOs) == maxSize {
+			return nil, fmt.Errorf("%w: batch exceeds the maximum of %d receipts", receipt.ErrInvalidInput, maxSize)
+		}
+		var receiptDTO receipt.CreateReceiptDTO
+		if err := decoder.Decode(&receiptDTO); err != nil {
+			return nil, err
+		}
+		receiptDTOs = append(receiptDTOs, receiptDTO)
+	}
+	if _, err := decoder.Token(); err != nil {
+		return nil, err
+	}
+	return receiptDTOs, nil
+}
+
+func (h *ReceiptHandler) ScoreReceipt(w http.ResponseWriter, r *http.Request) {
+	var receiptDTO receipt.CreateReceiptDTO
+	if err := json.NewDecoder(r.Body).Decode(&receiptDTO); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	result, err := h.receiptService.Score(r.Context(), receiptDTO)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, receipt.NewPointsBreakdownDTO(result), http.StatusOK)
+}
+
+func (h *ReceiptHandler) UpdateReceipt(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if id == "" {
+		writeProblem(w, r, http.StatusBadRequest, errors.New("receipt ID is required"))
+		return
+	}
+
+	version, err := versionFromIfMatch(r)
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	var receiptDTO receipt.CreateReceiptDTO
+	if err := json.NewDecoder(r.Body).Decode(&receiptDTO); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	updated, err := h.receiptService.Update(r.Context(), id, receiptDTO, version)
+	if err != nil {
+		// A conflict with the version the client read fails its
+		// precondition; one with a concurrent write is a plain conflict.
+		var conflict *receipt.VersionConflictError
+		if version != 0 && errors.As(err, &conflict) {
+			writeProblem(w, r, http.StatusPreconditionFailed, err)
+			return
+		}
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", etag(updated.Version))
+	writeJSON(w, receipt.NewReceiptResponseDTO(updated), http.StatusOK)
+}
+
+func (h *ReceiptHandler) DeleteReceipt(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if id == "" {
+		writeProblem(w, r, http.StatusBadRequest, errors.New("receipt ID is required"))
+		return
+	}
+
+	version, err := versionFromIfMatch(r)
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := h.receiptService.Delete(r.Context(), id, version); err != nil {
+		var conflict *receipt.VersionConflictError
+		if version != 0 && errors.As(err, &conflict) {
+			writeProblem(w, r, http.StatusPreconditionFailed, err)
+			return
+		}
+		writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *ReceiptHandler) ListReceipts(w http.ResponseWriter, r *http.Request) {
+	listDTO := listReceiptsDTOFromRequest(r)
+	query, err := listDTO.ToListQuery()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	page, err := h.receiptService.List(r.Context(), query)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, receipt.NewReceiptListResponseDTO(page), http.StatusOK)
+}
+
+// ImportReceipts creates one receipt per line of an NDJSON request body and
+// streams back one result line per input line as it goes.
+func (h *ReceiptHandler) ImportReceipts(w http.ResponseWriter, r *http.Request) {
+	rc := http.NewResponseController(w)
+	// Results are written while the body is still being read.
+	_ = rc.EnableFullDuplex()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+
+	scanner := bufio.NewScanner(r.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxImportLineSize)
+	encoder := json.NewEncoder(w)
+
+	line := 0
+	for scanner.Scan() {
+		line++
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+
+		result := importLineResponse{Line: line}
+		var receiptDTO receipt.CreateReceiptDTO
+		err := json.Unmarshal(data, &receiptDTO)
+		if err != nil {
+			p := newProblem(r, http.StatusBadRequest, err)
+			result.Error = &p
+		} else if rec, err := h.receiptService.Create(r.Context(), receiptDTO); err != nil {
+			p := newProblem(r, statusFromError(err), err)
+			result.Error = &p
+		} else {
+			result.Id = rec.Id.String()
+			result.Points = &rec.Points
+		}
+
+		if err := encoder.Encode(result); err != nil {
+			log.Println(err)
+			return
+		}
+		_ = rc.Flush()
+	}
+
+	if err := scanner.Err(); err != nil {
+		p := newProblem(r, http.StatusBadRequest, fmt.Errorf("line %d: %w", line+1, err))
+		if err := encoder.Encode(importLineResponse{Line: line + 1, Error: &p}); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// ExportReceipts streams every receipt matching the list filters as NDJSON.
+// Each line can be imported again with ImportReceipts.
+func (h *ReceiptHandler) ExportReceipts(w http.ResponseWriter, r *http.Request) {
+	listDTO := listReceiptsDTOFromRequest(r)
+	listDTO.Cursor = ""
+	listDTO.Limit = ""
+	query, err := listDTO.ToListQuery()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+
+	encoder := json.NewEncoder(w)
+	exported := 0
+	err = h.receiptService.Export(r.Context(), query, func(rec *receipt.Receipt) error {
+		if err := encoder.Encode(receipt.NewReceiptResponseDTO(rec)); err != nil {
+			return err
+		}
+		exported++
+		if exported%receipt.MaxListLimit == 0 {
+			return rc.Flush()
+		}
+		return nil
+	})
+	if err != nil {
+		// The status has already been sent, so all we can do is stop.
+		log.Printf("request %s: export: %v", requestIDFromContext(r.Context()), err)
+	}
+}
+
+func (h *ReceiptHandler) GetReceipt(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if id == "" {
+		writeProblem(w, r, http.StatusBadRequest, errors.New("receipt ID is required"))
+		return
+	}
+
+	rec, err := h.receiptService.Get(r.Context(), id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", etag(rec.Version))
+	writeJSON(w, receipt.NewReceiptResponseDTO(rec), http.StatusOK)
+}
+
 func (h *ReceiptHandler) GetReceiptPoints(w http.ResponseWriter, r *http.Request) {
 	id := r.PathValue("id")
 	if id == "" {
-		writeJSONError(w, errors.New("receipt ID is required"), http.StatusBadRequest)
+		writeProblem(w, r, http.StatusBadRequest, errors.New("receipt ID is required"))
 		return
 	}
 
 	points, err := h.receiptService.GetReceiptPoints(r.Context(), id)
 	if err != nil {
-		writeJSONError(w, err, http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
 	response := map[string]int64{"points": points}
 	writeJSON(w, response, http.StatusOK)
 }
+
+func (h *ReceiptHandler) GetReceiptPointsBreakdown(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if id == "" {
+		writeProblem(w, r, http.StatusBadRequest, errors.New("receipt ID is required"))
+		return
+	}
+
+	breakdown, err := h.receiptService.GetReceiptPointsBreakdown(r.Context(), id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, receipt.NewPointsBreakdownDTO(breakdown), http.StatusOK)
+}
+
+func (h *ReceiptHandler) ListRulesets(w http.ResponseWriter, r *http.Request) {
+	rulesets, active := h.receiptService.Rulesets(r.Context())
+	writeJSON(w, receipt.NewRulesetListResponseDTO(rulesets, active), http.StatusOK)
+}
+
+func (h *ReceiptHandler) GetRuleset(w http.ResponseWriter, r *http.Request) {
+	ruleset, err := h.receiptService.GetRuleset(r.Context(), r.PathValue("version"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	_, active := h.receiptService.Rulesets(r.Context())
+	writeJSON(w, receipt.NewRulesetResponseDTO(ruleset, ruleset == active), http.StatusOK)
+}
+
+func listReceiptsDTOFromRequest(r *http.Request) receipt.ListReceiptsDTO {
+	params := r.URL.Query()
+	return receipt.ListReceiptsDTO{
+		Retailer:         params.Get("retailer"),
+		PurchaseDateFrom: params.Get("purchaseDateFrom"),
+		PurchaseDateTo:   params.Get("purchaseDateTo"),
+		TotalMin:         params.Get("totalMin"),
+		TotalMax:         params.Get("totalMax"),
+		PointsMin:        params.Get("pointsMin"),
+		PointsMax:        params.Get("pointsMax"),
+		Sort:             params.Get("sort"),
+		Order:            params.Get("order"),
+		Cursor:           params.Get("cursor"),
+		Limit:            params.Get("limit"),
+	}
+}
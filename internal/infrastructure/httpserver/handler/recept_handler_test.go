@@ -0,0 +1,355 @@
+package handler
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"receipt-processor/internal/domain/receipt"
+	"receipt-processor/internal/infrastructure/database/memdb"
+	"receipt-processor/internal/infrastructure/database/memdb/repository"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testReceipt = `{"retailer": "Target", "purchaseDate": "2022-01-01", "purchaseTime": "13:01", "items": [{"shortDescription": "Mountain Dew 12PK", "price": "6.49"}], "total": "6.49"}`
+
+// interleavingRepository calls beforeUpdate once, just before the first
+// Update, as if another request wrote the receipt meanwhile.
+type interleavingRepository struct {
+	receipt.Repository
+	beforeUpdate func()
+}
+
+func (r *interleavingRepository) Update(id string, rec *receipt.Receipt) (*receipt.Receipt, error) {
+	if beforeUpdate := r.beforeUpdate; beforeUpdate != nil {
+		r.beforeUpdate = nil
+		beforeUpdate()
+	}
+	return r.Repository.Update(id, rec)
+}
+
+// newTestServer returns a server of the receipt routes, backed by a
+// service of in-memory repositories that takes batches of up to two
+// receipts.
+func newTestServer(t *testing.T) (*httptest.Server, *interleavingRepository) {
+	t.Helper()
+	receipts, err := memdb.New[string, *receipt.Receipt]()
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	idempotency, err := memdb.New[string, *receipt.IdempotencyRecord]()
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	repo := &interleavingRepository{Repository: repository.NewReceiptRepository(receipts)}
+	service := receipt.NewService(
+		repo,
+		receipt.WithBatchLimits(2, 1),
+		receipt.WithIdempotencyStore(repository.NewIdempotencyRepository(idempotency, time.Hour)),
+	)
+
+	h := NewReceiptHandler(service)
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /receipts/process", h.CreateReceipt)
+	mux.HandleFunc("POST /receipts/batch", h.CreateReceiptBatch)
+	mux.HandleFunc("POST /receipts/import", h.ImportReceipts)
+	mux.HandleFunc("GET /receipts/{id}", h.GetReceipt)
+	mux.HandleFunc("PUT /receipts/{id}", h.UpdateReceipt)
+	mux.HandleFunc("DELETE /receipts/{id}", h.DeleteReceipt)
+
+	server := httptest.NewServer(WithRequestID(mux))
+	t.Cleanup(server.Close)
+	return server, repo
+}
+
+func doRequest(t *testing.T, method, url, body string, header map[string]string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("NewRequest() error = %v", err)
+	}
+	for name, value := range header {
+		req.Header.Set(name, value)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Do() error = %v", err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func decodeBody(t *testing.T, resp *http.Response, v any) {
+	t.Helper()
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+}
+
+func createTestReceipt(t *testing.T, server *httptest.Server) string {
+	t.Helper()
+	resp := doRequest(t, http.MethodPost, server.URL+"/receipts/process", testReceipt, nil)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("POST /receipts/process status = %d, want %d", resp.StatusCode, http.StatusCreated)
+	}
+	var created map[string]string
+	decodeBody(t, resp, &created)
+	return created["id"]
+}
+
+func TestReceiptHandler_IfMatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		method  string
+		ifMatch string
+		// concurrent writes the receipt between the handler reading it
+		// and writing it.
+		concurrent bool
+		wantStatus int
+		wantETag   string
+	}{
+		{name: "update at the current version", method: http.MethodPut, ifMatch: `"1"`, wantStatus: http.StatusOK, wantETag: `"2"`},
+		{name: "update at any version", method: http.MethodPut, ifMatch: "*", wantStatus: http.StatusOK, wantETag: `"2"`},
+		{name: "update without If-Match", method: http.MethodPut, wantStatus: http.StatusOK, wantETag: `"2"`},
+		{name: "update at a stale version", method: http.MethodPut, ifMatch: `"2"`, wantStatus: http.StatusPreconditionFailed},
+		{name: "update at a version written meanwhile", method: http.MethodPut, ifMatch: `"1"`, concurrent: true, wantStatus: http.StatusPreconditionFailed},
+		{name: "update without If-Match written meanwhile", method: http.MethodPut, concurrent: true, wantStatus: http.StatusConflict},
+		{name: "update with a malformed If-Match", method: http.MethodPut, ifMatch: "1", wantStatus: http.StatusBadRequest},
+		{name: "delete at the current version", method: http.MethodDelete, ifMatch: `"1"`, wantStatus: http.StatusNoContent},
+		{name: "delete at a stale version", method: http.MethodDelete, ifMatch: `"2"`, wantStatus: http.StatusPreconditionFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, repo := newTestServer(t)
+			id := createTestReceipt(t, server)
+			if tt.concurrent {
+				repo.beforeUpdate = func() {
+					resp := doRequest(t, http.MethodPut, server.URL+"/receipts/"+id, testReceipt, nil)
+					if resp.StatusCode != http.StatusOK {
+						t.Errorf("PUT in between status = %d, want %d", resp.StatusCode, http.StatusOK)
+					}
+				}
+			}
+
+			header := map[string]string{}
+			if tt.ifMatch != "" {
+				header["If-Match"] = tt.ifMatch
+			}
+			resp := doRequest(t, tt.method, server.URL+"/receipts/"+id, testReceipt, header)
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("%s status = %d, want %d", tt.method, resp.StatusCode, tt.wantStatus)
+			}
+			if got := resp.Header.Get("ETag"); got != tt.wantETag {
+				t.Errorf("%s ETag = %q, want %q", tt.method, got, tt.wantETag)
+			}
+			if resp.StatusCode >= 400 {
+				if got := resp.Header.Get("Content-Type"); got != "application/problem+json" {
+					t.Errorf("%s Content-Type = %q, want application/problem+json", tt.method, got)
+				}
+			}
+		})
+	}
+}
+
+func TestReceiptHandler_IdempotentReplayed(t *testing.T) {
+	server, _ := newTestServer(t)
+	other := strings.Replace(testReceipt, "Target", "Walgreens", 1)
+
+	tests := []struct {
+		name         string
+		key          string
+		body         string
+		wantStatus   int
+		wantReplayed string
+	}{
+		{name: "first request", key: "key", body: testReceipt, wantStatus: http.StatusCreated},
+		{name: "retry", key: "key", body: testReceipt, wantStatus: http.StatusCreated, wantReplayed: "true"},
+		{name: "key reused for another receipt", key: "key", body: other, wantStatus: http.StatusConflict},
+		{name: "another key", key: "other", body: testReceipt, wantStatus: http.StatusCreated},
+	}
+	ids := make(map[string]string)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := doRequest(t, http.MethodPost, server.URL+"/receipts/process", tt.body, map[string]string{"Idempotency-Key": tt.key})
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("POST status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if got := resp.Header.Get("Idempotent-Replayed"); got != tt.wantReplayed {
+				t.Errorf("Idempotent-Replayed = %q, want %q", got, tt.wantReplayed)
+			}
+			if resp.StatusCode != http.StatusCreated {
+				return
+			}
+			var created map[string]string
+			decodeBody(t, resp, &created)
+			if id, ok := ids[tt.key]; ok && created["id"] != id {
+				t.Errorf("POST id = %q, want the first request's %q", created["id"], id)
+			}
+			ids[tt.key] = created["id"]
+		})
+	}
+	if ids["key"] == ids["other"] {
+		t.Errorf("POST with two keys created the same receipt %q", ids["key"])
+	}
+}
+
+func TestReceiptHandler_CreateReceiptBatch(t *testing.T) {
+	invalid := strings.Replace(testReceipt, `"6.49"}`, `"0.00"}`, 1)
+	// oversized is a single receipt larger than a body of two receipts
+	// may be.
+	oversized := `[` + strings.Replace(testReceipt, "Target", strings.Repeat("T", 2*maxBatchEntrySize), 1) + `]`
+
+	tests := []struct {
+		name          string
+		body          string
+		wantStatus    int
+		wantSucceeded int
+		wantFailed    int
+	}{
+		{name: "valid receipts", body: `[` + testReceipt + `,` + testReceipt + `]`, wantStatus: http.StatusOK, wantSucceeded: 2},
+		{name: "an invalid receipt", body: `[` + testReceipt + `,` + invalid + `]`, wantStatus: http.StatusOK, wantSucceeded: 1, wantFailed: 1},
+		{name: "more receipts than the limit", body: `[` + testReceipt + `,` + testReceipt + `,` + testReceipt + `]`, wantStatus: http.StatusBadRequest},
+		{name: "body larger than the limit", body: oversized, wantStatus: http.StatusRequestEntityTooLarge},
+		{name: "not an array", body: testReceipt, wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, _ := newTestServer(t)
+			resp := doRequest(t, http.MethodPost, server.URL+"/receipts/batch", tt.body, nil)
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("POST status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if resp.StatusCode != http.StatusOK {
+				var p problem
+				decodeBody(t, resp, &p)
+				if p.Status != tt.wantStatus || p.Type != problemTypes[tt.wantStatus] {
+					t.Errorf("POST problem = %+v, want status %d of type %q", p, tt.wantStatus, problemTypes[tt.wantStatus])
+				}
+				return
+			}
+
+			var batch batchResponse
+			decodeBody(t, resp, &batch)
+			if batch.Succeeded != tt.wantSucceeded || batch.Failed != tt.wantFailed {
+				t.Errorf("POST = %d succeeded, %d failed, want %d, %d", batch.Succeeded, batch.Failed, tt.wantSucceeded, tt.wantFailed)
+			}
+			for _, result := range batch.Results {
+				if result.Error != nil && (result.Error.Status != http.StatusBadRequest || len(result.Error.Errors) == 0) {
+					t.Errorf("POST result %d error = %+v, want 400 with field errors", result.Index, result.Error)
+				}
+			}
+		})
+	}
+}
+
+func TestReceiptHandler_ImportReceipts(t *testing.T) {
+	tooLong := strings.Replace(testReceipt, "Target", strings.Repeat("T", maxImportLineSize), 1)
+
+	tests := []struct {
+		name string
+		body string
+		// want lists for each result line whether it holds a receipt ID
+		// or the status of its error.
+		want []int
+	}{
+		{name: "valid lines", body: testReceipt + "\n\n" + testReceipt + "\n", want: []int{0, 0}},
+		{name: "malformed line", body: testReceipt + "\n{\n", want: []int{0, http.StatusBadRequest}},
+		{name: "line longer than the limit", body: testReceipt + "\n" + tooLong + "\n" + testReceipt + "\n", want: []int{0, http.StatusBadRequest}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, _ := newTestServer(t)
+			resp := doRequest(t, http.MethodPost, server.URL+"/receipts/import", tt.body, nil)
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("POST status = %d, want %d", resp.StatusCode, http.StatusOK)
+			}
+
+			var got []int
+			scanner := bufio.NewScanner(resp.Body)
+			for scanner.Scan() {
+				var result importLineResponse
+				if err := json.Unmarshal(scanner.Bytes(), &result); err != nil {
+					t.Fatalf("Unmarshal() error = %v", err)
+				}
+				switch {
+				case result.Error != nil:
+					got = append(got, result.Error.Status)
+				case result.Id == "":
+					t.Errorf("line %d result has neither an ID nor an error", result.Line)
+				default:
+					got = append(got, 0)
+				}
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("POST results = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("POST results = %v, want %v", got, tt.want)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestReceiptHandler_ProblemDetails(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		wantStatus int
+		wantErrors []fieldProblem
+	}{
+		{
+			name:       "invalid receipt",
+			method:     http.MethodPost,
+			path:       "/receipts/process",
+			body:       strings.ReplaceAll(testReceipt, `"6.49"`, `"0.00"`),
+			wantStatus: http.StatusBadRequest,
+			wantErrors: []fieldProblem{{Field: "items[0].price", Rule: "positive"}, {Field: "total", Rule: "positive"}},
+		},
+		{
+			name:       "malformed JSON",
+			method:     http.MethodPost,
+			path:       "/receipts/process",
+			body:       "{",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "unknown receipt",
+			method:     http.MethodGet,
+			path:       "/receipts/00000000-0000-0000-0000-000000000000",
+			wantStatus: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, _ := newTestServer(t)
+			resp := doRequest(t, tt.method, server.URL+tt.path, tt.body, map[string]string{requestIDHeader: "request"})
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("%s status = %d, want %d", tt.method, resp.StatusCode, tt.wantStatus)
+			}
+			if got := resp.Header.Get("Content-Type"); got != "application/problem+json" {
+				t.Errorf("%s Content-Type = %q, want application/problem+json", tt.method, got)
+			}
+
+			var p problem
+			decodeBody(t, resp, &p)
+			if p.Status != tt.wantStatus || p.Type != problemTypes[tt.wantStatus] || p.Instance != tt.path || p.RequestId != "request" {
+				t.Errorf("%s problem = %+v, want status %d of type %q for %s and request", tt.method, p, tt.wantStatus, problemTypes[tt.wantStatus], tt.path)
+			}
+			if len(p.Errors) != len(tt.wantErrors) {
+				t.Fatalf("%s problem errors = %+v, want %+v", tt.method, p.Errors, tt.wantErrors)
+			}
+			for i, want := range tt.wantErrors {
+				if got := p.Errors[i]; got.Field != want.Field || got.Rule != want.Rule || got.Message == "" {
+					t.Errorf("%s problem error %d = %+v, want %s violating %s with a message", tt.method, i, got, want.Field, want.Rule)
+				}
+			}
+		})
+	}
+}
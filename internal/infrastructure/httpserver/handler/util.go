@@ -2,10 +2,42 @@ package handler
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"receipt-processor/internal/domain/receipt"
+	"strconv"
+	"strings"
 )
 
+// problem is an RFC 7807 problem details document.
+type problem struct {
+	Type      string         `json:"type"`
+	Title     string         `json:"title"`
+	Status    int            `json:"status"`
+	Detail    string         `json:"detail,omitempty"`
+	Instance  string         `json:"instance,omitempty"`
+	RequestId string         `json:"requestId,omitempty"`
+	Errors    []fieldProblem `json:"errors,omitempty"`
+}
+
+type fieldProblem struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+var problemTypes = map[int]string{
+	http.StatusBadRequest:            "/problems/invalid-input",
+	http.StatusNotFound:              "/problems/not-found",
+	http.StatusConflict:              "/problems/conflict",
+	http.StatusPreconditionFailed:    "/problems/precondition-failed",
+	http.StatusRequestEntityTooLarge: "/problems/too-large",
+	http.StatusServiceUnavailable:    "/problems/unavailable",
+	http.StatusInternalServerError:   "/problems/internal-error",
+}
+
 func writeJSON(w http.ResponseWriter, data interface{}, status int) {
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	w.WriteHeader(status)
@@ -15,11 +47,92 @@ func writeJSON(w http.ResponseWriter, data interface{}, status int) {
 	}
 }
 
-func writeJSONError(w http.ResponseWriter, err error, status int) {
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+// etag returns the entity tag of a receipt version.
+func etag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// versionFromIfMatch returns the receipt version in the request's If-Match
+// header, or 0 if there is none or it is *.
+func versionFromIfMatch(r *http.Request) (int64, error) {
+	value := strings.TrimSpace(r.Header.Get("If-Match"))
+	if value == "" || value == "*" {
+		return 0, nil
+	}
+	unquoted, ok := strings.CutPrefix(value, `"`)
+	if ok {
+		unquoted, ok = strings.CutSuffix(unquoted, `"`)
+	}
+	version, err := strconv.ParseInt(unquoted, 10, 64)
+	if !ok || err != nil || version <= 0 {
+		return 0, fmt.Errorf("%w: If-Match must be the ETag of a receipt, such as \"3\"", receipt.ErrInvalidInput)
+	}
+	return version, nil
+}
+
+// writeError writes a domain error as a problem with the status code it
+// maps to.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	writeProblem(w, r, statusFromError(err), err)
+}
+
+// writeProblem writes err as an application/problem+json response.
+func writeProblem(w http.ResponseWriter, r *http.Request, status int, err error) {
+	p := newProblem(r, status, err)
+	w.Header().Set("Content-Type", "application/problem+json")
 	w.WriteHeader(status)
-	err = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
-	if err != nil {
+	if err := json.NewEncoder(w).Encode(p); err != nil {
 		log.Println(err)
 	}
 }
+
+// newProblem describes err as a problem. Unexpected errors are logged and
+// reported without their details.
+func newProblem(r *http.Request, status int, err error) problem {
+	p := problem{
+		Type:      problemTypes[status],
+		Title:     http.StatusText(status),
+		Status:    status,
+		Detail:    err.Error(),
+		Instance:  r.URL.Path,
+		RequestId: requestIDFromContext(r.Context()),
+	}
+	if p.Type == "" {
+		p.Type = "about:blank"
+	}
+
+	if status == http.StatusInternalServerError {
+		log.Printf("request %s: %v", p.RequestId, err)
+		p.Detail = ""
+	}
+
+	var validationErr *receipt.ValidationError
+	if errors.As(err, &validationErr) {
+		p.Detail = "The receipt failed validation"
+		p.Errors = make([]fieldProblem, len(validationErr.Violations))
+		for i, v := range validationErr.Violations {
+			p.Errors[i] = fieldProblem{
+				Field:   v.Field,
+				Rule:    v.Rule,
+				Message: v.Message,
+			}
+		}
+	}
+
+	return p
+}
+
+func statusFromError(err error) int {
+	switch {
+	case errors.Is(err, receipt.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, receipt.ErrInvalidInput):
+		return http.StatusBadRequest
+	case errors.Is(err, receipt.ErrConflict):
+		return http.StatusConflict
+	case errors.Is(err, receipt.ErrUnavailable):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
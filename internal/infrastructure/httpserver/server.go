@@ -6,12 +6,16 @@ import (
 )
 
 type Server struct {
-	receiptHandler *handler.ReceiptHandler
+	receiptHandler  *handler.ReceiptHandler
+	adminHandler    *handler.AdminHandler
+	campaignHandler *handler.CampaignHandler
 }
 
-func NewServer(receiptHandler *handler.ReceiptHandler) *Server {
+func NewServer(receiptHandler *handler.ReceiptHandler, adminHandler *handler.AdminHandler, campaignHandler *handler.CampaignHandler) *Server {
 	return &Server{
-		receiptHandler: receiptHandler,
+		receiptHandler:  receiptHandler,
+		adminHandler:    adminHandler,
+		campaignHandler: campaignHandler,
 	}
 }
 
@@ -19,12 +23,39 @@ func (s *Server) SetupRoutes() *http.ServeMux {
 	mux := http.NewServeMux()
 
 	mux.HandleFunc("POST /receipts/process", s.receiptHandler.CreateReceipt)
+	mux.HandleFunc("POST /receipts/batch", s.receiptHandler.CreateReceiptBatch)
+	mux.HandleFunc("POST /receipts/score", s.receiptHandler.ScoreReceipt)
+	mux.HandleFunc("POST /receipts/import", s.receiptHandler.ImportReceipts)
+	mux.HandleFunc("GET /receipts", s.receiptHandler.ListReceipts)
+	mux.HandleFunc("GET /receipts/export", s.receiptHandler.ExportReceipts)
+	mux.HandleFunc("GET /receipts/{id}", s.receiptHandler.GetReceipt)
+	mux.HandleFunc("PUT /receipts/{id}", s.receiptHandler.UpdateReceipt)
+	mux.HandleFunc("DELETE /receipts/{id}", s.receiptHandler.DeleteReceipt)
 	mux.HandleFunc("GET /receipts/{id}/points", s.receiptHandler.GetReceiptPoints)
+	mux.HandleFunc("GET /receipts/{id}/points/breakdown", s.receiptHandler.GetReceiptPointsBreakdown)
+	mux.HandleFunc("GET /rulesets", s.receiptHandler.ListRulesets)
+	mux.HandleFunc("GET /rulesets/{version}", s.receiptHandler.GetRuleset)
+	mux.HandleFunc("POST /campaigns", s.campaignHandler.CreateCampaign)
+	mux.HandleFunc("GET /campaigns", s.campaignHandler.ListCampaigns)
+	mux.HandleFunc("GET /campaigns/{id}", s.campaignHandler.GetCampaign)
+	mux.HandleFunc("PUT /campaigns/{id}", s.campaignHandler.UpdateCampaign)
+	mux.HandleFunc("DELETE /campaigns/{id}", s.campaignHandler.DeleteCampaign)
+	mux.HandleFunc("POST /admin/jobs/rescore", s.adminHandler.StartRescoreJob)
+	mux.HandleFunc("GET /admin/jobs", s.adminHandler.ListJobs)
+	mux.HandleFunc("GET /admin/jobs/{id}", s.adminHandler.GetJob)
+	mux.HandleFunc("POST /admin/jobs/{id}/cancel", s.adminHandler.CancelJob)
+	mux.HandleFunc("POST /admin/rulesets/simulate", s.adminHandler.SimulateRuleset)
 
 	return mux
 }
 
-func (s *Server) Start(port string) error {
-	mux := s.SetupRoutes()
-	return http.ListenAndServe(port, mux)
+// Handler returns the routes wrapped in the server's middleware.
+func (s *Server) Handler() http.Handler {
+	return handler.WithRequestID(s.SetupRoutes())
+}
+
+// HTTPServer returns a server of the routes listening on addr, which stops
+// gracefully on Shutdown.
+func (s *Server) HTTPServer(addr string) *http.Server {
+	return &http.Server{Addr: addr, Handler: s.Handler()}
 }